@@ -5,10 +5,21 @@ import LineItemDetail from './pages/LineItemDetail';
 import Reports from './pages/Reports';
 import AdUnits from './pages/AdUnits';
 import TargetingKeys from './pages/TargetingKeys';
+import Login from './pages/Login';
+import { getToken, logout } from './api';
 
 function App() {
   const location = useLocation();
 
+  if (!getToken()) {
+    return <Login />;
+  }
+
+  async function handleLogout() {
+    await logout().catch(() => {});
+    window.location.reload();
+  }
+
   const navItems = [
     { path: '/', label: 'Campaigns' },
     { path: '/ad-units', label: 'Ad Units' },
@@ -41,6 +52,14 @@ function App() {
                 ))}
               </div>
             </div>
+            <div className="flex items-center">
+              <button
+                onClick={handleLogout}
+                className="text-sm font-medium text-gray-500 hover:text-gray-700"
+              >
+                Sign out
+              </button>
+            </div>
           </div>
         </div>
       </nav>
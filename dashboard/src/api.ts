@@ -81,14 +81,47 @@ interface DailyStats {
   viewable: number;
 }
 
+interface User {
+  id: number;
+  email: string;
+  name: string;
+  role: string;
+  status: string;
+  last_login_at?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+// Session token, kept across reloads
+const TOKEN_KEY = 'token';
+
+export function getToken(): string | null {
+  return localStorage.getItem(TOKEN_KEY);
+}
+
+function authHeaders(): Record<string, string> {
+  const token = getToken();
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+// An expired or revoked session sends the user back to the sign-in page
+function checkSession(response: Response) {
+  if (response.status === 401 && getToken()) {
+    localStorage.removeItem(TOKEN_KEY);
+    window.location.reload();
+  }
+}
+
 async function fetchAPI<T>(endpoint: string, options?: RequestInit): Promise<T> {
   const response = await fetch(`${API_URL}${endpoint}`, {
     ...options,
     headers: {
       'Content-Type': 'application/json',
+      ...authHeaders(),
       ...options?.headers,
     },
   });
+  checkSession(response);
 
   if (!response.ok) {
     const error = await response.json().catch(() => ({ error: 'Request failed' }));
@@ -102,6 +135,37 @@ async function fetchAPI<T>(endpoint: string, options?: RequestInit): Promise<T>
   return response.json();
 }
 
+// Auth
+export async function login(email: string, password: string): Promise<User> {
+  const res = await fetchAPI<{ token: string; expires_at: string; user: User }>('/api/auth/login', {
+    method: 'POST',
+    body: JSON.stringify({ email, password }),
+  });
+  localStorage.setItem(TOKEN_KEY, res.token);
+  return res.user;
+}
+
+export async function logout(): Promise<void> {
+  try {
+    await fetchAPI<void>('/api/auth/logout', { method: 'POST' });
+  } finally {
+    localStorage.removeItem(TOKEN_KEY);
+  }
+}
+
+export async function getMe(): Promise<{ user: User; permissions: string[] }> {
+  return fetchAPI('/api/auth/me');
+}
+
+// URL of a GET endpoint that carries the session token, for links and
+// downloads that can't send headers
+export function authURL(endpoint: string): string {
+  const token = getToken();
+  if (!token) return `${API_URL}${endpoint}`;
+  const sep = endpoint.includes('?') ? '&' : '?';
+  return `${API_URL}${endpoint}${sep}access_token=${encodeURIComponent(token)}`;
+}
+
 // Campaigns
 export async function getCampaigns(): Promise<Campaign[]> {
   return fetchAPI<Campaign[]>('/api/campaigns');
@@ -195,9 +259,11 @@ export async function createCreative(data: {
   image_url: string;
   click_url: string;
 }): Promise<Creative> {
+  // New creatives go straight to the review queue; they serve once approved
+  // and activated
   return fetchAPI<Creative>('/api/creatives', {
     method: 'POST',
-    body: JSON.stringify(data),
+    body: JSON.stringify({ status: 'pending_review', ...data }),
   });
 }
 
@@ -309,8 +375,10 @@ export async function uploadImage(file: File): Promise<UploadResponse> {
 
   const response = await fetch(`${API_URL}/api/uploads`, {
     method: 'POST',
+    headers: authHeaders(),
     body: formData,
   });
+  checkSession(response);
 
   if (!response.ok) {
     const error = await response.json().catch(() => ({ error: 'Upload failed' }));
@@ -392,4 +460,4 @@ export async function deleteTargetingKey(key: string): Promise<void> {
   return fetchAPI<void>(`/api/targeting-keys/${encodeURIComponent(key)}`, { method: 'DELETE' });
 }
 
-export type { Campaign, LineItem, TargetingRule, Creative, ReportSummary, DailyStats, AdUnit, TargetingKey, CreativeSize };
+export type { Campaign, LineItem, TargetingRule, Creative, ReportSummary, DailyStats, AdUnit, TargetingKey, CreativeSize, User };
@@ -19,6 +19,7 @@ import {
   getLineItemReport,
   getAdUnits,
   getCreativeSizes,
+  authURL,
   type ReportSummary,
   type DailyStats,
   type AdUnit,
@@ -110,8 +111,7 @@ export default function Reports() {
       format,
       group_by: groupBy,
     });
-    const apiUrl = import.meta.env.VITE_API_URL || '';
-    window.open(`${apiUrl}/api/reports/export?${params}`, '_blank');
+    window.open(authURL(`/api/reports/export?${params}`), '_blank');
   }
 
   if (loading) {
@@ -98,7 +98,9 @@ class BannerView @JvmOverloads constructor(
         coroutineScope.launch {
             try {
                 val ad = fetchAd()
-                if (ad != null) {
+                if (ad != null && ad.imageUrl.isNullOrEmpty()) {
+                    handleUnfilled(ad)
+                } else if (ad != null) {
                     displayAd(ad)
                 } else {
                     listener?.onAdFailedToLoad("No ad available")
@@ -145,9 +147,22 @@ class BannerView @JvmOverloads constructor(
         adResponse.ads.firstOrNull()
     }
 
+    /**
+     * Handle a slot the server could not fill: collapse the view if the ad unit
+     * asks for it, then report the reason to the listener
+     */
+    private fun handleUnfilled(ad: AdResult) {
+        currentAd = null
+        if (ad.fallback == "collapse") {
+            visibility = View.GONE
+        }
+        listener?.onAdFailedToLoad(ad.reason ?: "No ad available")
+    }
+
     private suspend fun displayAd(ad: AdResult) {
         currentAd = ad
         hasTrackedViewable = false
+        visibility = View.VISIBLE
 
         // Load image
         val bitmap = loadImage(ad.imageUrl)
@@ -320,7 +335,10 @@ internal data class AdResult(
     val height: Int,
     @SerializedName("image_url") val imageUrl: String,
     @SerializedName("click_url") val clickUrl: String,
-    val tracking: Tracking
+    val tracking: Tracking,
+    val fallback: String? = null,
+    val reason: String? = null,
+    @SerializedName("passback_url") val passbackUrl: String? = null
 )
 
 internal data class Tracking(
@@ -58,6 +58,8 @@ public class BannerView: UIView {
                 guard let self = self else { return }
 
                 switch result {
+                case .success(let ad) where ad.imageUrl.isEmpty:
+                    self.handleUnfilled(ad)
                 case .success(let ad):
                     self.displayAd(ad)
                 case .failure(let error):
@@ -117,10 +119,21 @@ public class BannerView: UIView {
         }.resume()
     }
 
+    /// Handle a slot the server could not fill: collapse the view if the ad unit
+    /// asks for it, then report the reason to the delegate
+    private func handleUnfilled(_ ad: AdResult) {
+        currentAd = nil
+        if ad.fallback == "collapse" {
+            isHidden = true
+        }
+        delegate?.adDidFailToLoad(self, error: MIMSError.unfilled(reason: ad.reason ?? ""))
+    }
+
     private func displayAd(_ ad: AdResult) {
         currentAd = ad
         hasTrackedViewable = false
         visibleTime = 0
+        isHidden = false
 
         loadImage(from: ad.imageUrl) { [weak self] image in
             DispatchQueue.main.async {
@@ -240,6 +253,7 @@ public enum MIMSError: Error, LocalizedError {
     case noData
     case noAdsAvailable
     case imageLoadFailed
+    case unfilled(reason: String)
 
     public var errorDescription: String? {
         switch self {
@@ -253,6 +267,8 @@ public enum MIMSError: Error, LocalizedError {
             return "No ads available"
         case .imageLoadFailed:
             return "Failed to load ad image"
+        case .unfilled(let reason):
+            return reason.isEmpty ? "No ads available" : "No ads available (\(reason))"
         }
     }
 }
@@ -292,6 +308,9 @@ struct AdResult: Decodable {
     let imageUrl: String
     let clickUrl: String
     let tracking: Tracking
+    let fallback: String?
+    let reason: String?
+    let passbackUrl: String?
 
     enum CodingKeys: String, CodingKey {
         case slotId = "slot_id"
@@ -301,7 +320,8 @@ struct AdResult: Decodable {
         case width, height
         case imageUrl = "image_url"
         case clickUrl = "click_url"
-        case tracking
+        case tracking, fallback, reason
+        case passbackUrl = "passback_url"
     }
 }
 
@@ -0,0 +1,61 @@
+-- ClickHouse analytics schema (optional sink, see CLICKHOUSE_URL)
+
+CREATE DATABASE IF NOT EXISTS mimsads;
+
+-- Raw tracking events, written by the server alongside Postgres
+CREATE TABLE IF NOT EXISTS mimsads.events (
+    network_id UInt32 DEFAULT 1,
+    event_type LowCardinality(String),
+    impression_id String,
+    line_item_id UInt32,
+    creative_id UInt32,
+    user_id String,
+    country LowCardinality(String),
+    platform LowCardinality(String),
+    ad_unit LowCardinality(String),
+    section LowCardinality(String),
+    targeting Map(String, String),
+    created_at DateTime('UTC')
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(created_at)
+ORDER BY (network_id, event_type, line_item_id, created_at);
+
+-- The request's key-values; report queries on a targeting key read them
+-- from the raw events
+ALTER TABLE mimsads.events ADD COLUMN IF NOT EXISTS targeting Map(String, String) AFTER section;
+
+-- Hourly counts by network, line item, creative, ad unit, country, section
+-- and platform. Every report shape (summary, daily, hour of day, key-value,
+-- line item, export) is a GROUP BY over this table. Parts are summed on merge,
+-- so queries must still sum().
+CREATE TABLE IF NOT EXISTS mimsads.events_hourly (
+    hour DateTime('UTC'),
+    network_id UInt32,
+    line_item_id UInt32,
+    creative_id UInt32,
+    ad_unit LowCardinality(String),
+    country LowCardinality(String),
+    section LowCardinality(String),
+    platform LowCardinality(String),
+    impressions UInt64,
+    clicks UInt64,
+    viewable UInt64
+) ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS mimsads.events_hourly_mv TO mimsads.events_hourly AS
+SELECT
+    toStartOfHour(created_at) AS hour,
+    network_id,
+    line_item_id,
+    creative_id,
+    ad_unit,
+    country,
+    section,
+    platform,
+    countIf(event_type = 'impression') AS impressions,
+    countIf(event_type = 'click') AS clicks,
+    countIf(event_type = 'viewable') AS viewable
+FROM mimsads.events
+GROUP BY hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform;
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/api"
+	"github.com/mims/ad-manager/internal/auth"
+)
+
+// handlers are the admin API's handlers
+type handlers struct {
+	auth             *api.AuthHandler
+	admin            *api.AdminHandler
+	networks         *api.NetworksHandler
+	reports          *api.ReportsHandler
+	live             *api.LiveHandler
+	alerts           *api.AlertsHandler
+	audit            *api.AuditHandler
+	scheduledReports *api.ScheduledReportsHandler
+	forecast         *api.ForecastHandler
+	upload           *api.UploadHandler
+}
+
+// registerAPIRoutes wires the admin API under r. Everything but signing in
+// needs a session or an API key, and each route but the session ones a
+// permission of the user's role or the key's scopes. The handlers don't
+// check permissions themselves, so every such route must start with
+// api.Require.
+func registerAPIRoutes(r fiber.Router, h handlers) {
+	r.Post("/auth/login", h.auth.Login)
+	r.Use(h.auth.RequireAuth())
+	r.Post("/auth/logout", h.auth.Logout)
+	r.Get("/auth/me", h.auth.Me)
+
+	// Networks
+	r.Get("/networks", api.Require(auth.NetworksManage), h.networks.ListNetworks)
+	r.Post("/networks", api.Require(auth.NetworksManage), h.networks.CreateNetwork)
+	r.Get("/networks/:id", api.Require(auth.NetworksManage), h.networks.GetNetwork)
+	r.Put("/networks/:id", api.Require(auth.NetworksManage), h.networks.UpdateNetwork)
+
+	// Users
+	r.Get("/users", api.Require(auth.UsersManage), h.auth.ListUsers)
+	r.Post("/users", api.Require(auth.UsersManage), h.auth.CreateUser)
+	r.Put("/users/:id", api.Require(auth.UsersManage), h.auth.UpdateUser)
+	r.Delete("/users/:id", api.Require(auth.UsersManage), h.auth.DeleteUser)
+
+	// API keys
+	r.Get("/api-keys", api.Require(auth.UsersManage), h.auth.ListAPIKeys)
+	r.Post("/api-keys", api.Require(auth.UsersManage), h.auth.CreateAPIKey)
+	r.Delete("/api-keys/:id", api.Require(auth.UsersManage), h.auth.RevokeAPIKey)
+
+	// Advertisers and agencies
+	r.Get("/advertisers", api.Require(auth.CampaignsRead), h.admin.ListAdvertisers)
+	r.Post("/advertisers", api.Require(auth.CampaignsWrite), h.admin.CreateAdvertiser)
+	r.Get("/advertisers/:id", api.Require(auth.CampaignsRead), h.admin.GetAdvertiser)
+	r.Put("/advertisers/:id", api.Require(auth.CampaignsWrite), h.admin.UpdateAdvertiser)
+	r.Delete("/advertisers/:id", api.Require(auth.CampaignsWrite), h.admin.DeleteAdvertiser)
+	r.Get("/agencies", api.Require(auth.CampaignsRead), h.admin.ListAgencies)
+	r.Post("/agencies", api.Require(auth.CampaignsWrite), h.admin.CreateAgency)
+	r.Get("/agencies/:id", api.Require(auth.CampaignsRead), h.admin.GetAgency)
+	r.Put("/agencies/:id", api.Require(auth.CampaignsWrite), h.admin.UpdateAgency)
+	r.Delete("/agencies/:id", api.Require(auth.CampaignsWrite), h.admin.DeleteAgency)
+
+	// Campaigns
+	r.Get("/campaigns", api.Require(auth.CampaignsRead), h.admin.ListCampaigns)
+	r.Post("/campaigns", api.Require(auth.CampaignsWrite), h.admin.CreateCampaign)
+	r.Get("/campaigns/:id", api.Require(auth.CampaignsRead), h.admin.GetCampaign)
+	r.Put("/campaigns/:id", api.Require(auth.CampaignsWrite), h.admin.UpdateCampaign)
+	r.Delete("/campaigns/:id", api.Require(auth.CampaignsWrite), h.admin.DeleteCampaign)
+
+	// Line Items
+	r.Get("/campaigns/:id/line-items", api.Require(auth.CampaignsRead), h.admin.ListLineItems)
+	r.Post("/line-items", api.Require(auth.CampaignsWrite), h.admin.CreateLineItem)
+	r.Get("/line-items/:id", api.Require(auth.CampaignsRead), h.admin.GetLineItem)
+	r.Put("/line-items/:id", api.Require(auth.CampaignsWrite), h.admin.UpdateLineItem)
+	r.Delete("/line-items/:id", api.Require(auth.CampaignsWrite), h.admin.DeleteLineItem)
+
+	// Targeting Rules
+	r.Get("/line-items/:id/targeting", api.Require(auth.CampaignsRead), h.admin.GetTargetingRules)
+	r.Post("/line-items/:id/targeting", api.Require(auth.CampaignsWrite), h.admin.SetTargetingRules)
+
+	// Dayparting
+	r.Get("/line-items/:id/dayparts", api.Require(auth.CampaignsRead), h.admin.GetDayparts)
+	r.Post("/line-items/:id/dayparts", api.Require(auth.CampaignsWrite), h.admin.SetDayparts)
+
+	// Creatives
+	r.Get("/line-items/:id/creatives", api.Require(auth.CreativesRead), h.admin.ListCreatives)
+	r.Post("/creatives", api.Require(auth.CreativesWrite), h.admin.CreateCreative)
+	r.Get("/creatives/review-queue", api.Require(auth.CreativesReview), h.admin.ListCreativeReviewQueue)
+	r.Post("/creatives/:id/approve", api.Require(auth.CreativesReview), h.admin.ApproveCreative)
+	r.Post("/creatives/:id/reject", api.Require(auth.CreativesReview), h.admin.RejectCreative)
+	r.Get("/creatives/:id", api.Require(auth.CreativesRead), h.admin.GetCreative)
+	r.Put("/creatives/:id", api.Require(auth.CreativesWrite), h.admin.UpdateCreative)
+	r.Delete("/creatives/:id", api.Require(auth.CreativesWrite), h.admin.DeleteCreative)
+
+	// Ad Units
+	r.Get("/ad-units", api.Require(auth.InventoryRead), h.admin.ListAdUnits)
+	r.Post("/ad-units", api.Require(auth.InventoryWrite), h.admin.CreateAdUnit)
+	r.Get("/ad-units/:id", api.Require(auth.InventoryRead), h.admin.GetAdUnit)
+	r.Put("/ad-units/:id", api.Require(auth.InventoryWrite), h.admin.UpdateAdUnit)
+	r.Delete("/ad-units/:id", api.Require(auth.InventoryWrite), h.admin.DeleteAdUnit)
+
+	// Placements
+	r.Get("/placements", api.Require(auth.InventoryRead), h.admin.ListPlacements)
+	r.Post("/placements", api.Require(auth.InventoryWrite), h.admin.CreatePlacement)
+	r.Get("/placements/:id", api.Require(auth.InventoryRead), h.admin.GetPlacement)
+	r.Put("/placements/:id", api.Require(auth.InventoryWrite), h.admin.UpdatePlacement)
+	r.Delete("/placements/:id", api.Require(auth.InventoryWrite), h.admin.DeletePlacement)
+
+	// Line Item Ad Unit Targeting
+	r.Get("/line-items/:id/ad-units", api.Require(auth.CampaignsRead), h.admin.GetLineItemAdUnits)
+	r.Post("/line-items/:id/ad-units", api.Require(auth.CampaignsWrite), h.admin.SetLineItemAdUnits)
+	r.Get("/line-items/:id/placements", api.Require(auth.CampaignsRead), h.admin.GetLineItemPlacements)
+	r.Post("/line-items/:id/placements", api.Require(auth.CampaignsWrite), h.admin.SetLineItemPlacements)
+
+	// Targeting Keys (for auto-suggest)
+	r.Get("/targeting-keys", api.Require(auth.InventoryRead), h.admin.ListTargetingKeys)
+	r.Get("/targeting-keys/:key", api.Require(auth.InventoryRead), h.admin.GetTargetingKeyValues)
+	r.Post("/targeting-keys/:key", api.Require(auth.InventoryWrite), h.admin.AddTargetingKeyValues)
+	r.Put("/targeting-keys/:key", api.Require(auth.InventoryWrite), h.admin.UpdateTargetingKeyValues)
+	r.Delete("/targeting-keys/:key", api.Require(auth.InventoryWrite), h.admin.DeleteTargetingKey)
+
+	// Reports
+	r.Get("/reports/summary", api.Require(auth.ReportsRead), h.reports.GetSummary)
+	r.Get("/reports/campaigns/:id", api.Require(auth.ReportsRead), h.reports.GetCampaignReport)
+	r.Get("/reports/daily", api.Require(auth.ReportsRead), h.reports.GetDailyReport)
+	r.Get("/reports/hourly", api.Require(auth.ReportsRead), h.reports.GetHourlyReport)
+	r.Get("/reports/fill-rate", api.Require(auth.ReportsRead), h.reports.GetFillRateReport)
+	r.Get("/reports/ad-units", api.Require(auth.ReportsRead), h.reports.GetAdUnitReport)
+	r.Get("/reports/request-keys", api.Require(auth.ReportsRead), h.reports.GetRequestKeysReport)
+	r.Get("/reports/keyvalue", api.Require(auth.ReportsRead), h.reports.GetKeyValueReport)
+	r.Get("/reports/lineitems", api.Require(auth.ReportsRead), h.reports.GetLineItemReport)
+	r.Get("/reports/reach", api.Require(auth.ReportsRead), h.reports.GetReachReport)
+	r.Get("/reports/frequency", api.Require(auth.ReportsRead), h.reports.GetFrequencyReport)
+	r.Get("/reports/creative-sizes", api.Require(auth.ReportsRead), h.reports.GetCreativeSizes)
+	r.Get("/reports/export", api.Require(auth.ReportsRead), h.reports.ExportReport)
+	r.Get("/reports/export/jobs/:id", api.Require(auth.ReportsRead), h.reports.GetExportJob)
+	r.Get("/reports/export/jobs/:id/download", api.Require(auth.ReportsRead), h.reports.DownloadExportJob)
+	r.Post("/reports/query", api.Require(auth.ReportsRead), h.reports.QueryReport)
+
+	// Live delivery stats
+	r.Get("/live", api.Require(auth.ReportsRead), h.live.StreamLive)
+	r.Get("/live/snapshot", api.Require(auth.ReportsRead), h.live.GetLiveSnapshot)
+
+	// Delivery alerts
+	r.Get("/alerts", api.Require(auth.AlertsRead), h.alerts.ListAlerts)
+	r.Get("/alerts/:id", api.Require(auth.AlertsRead), h.alerts.GetAlert)
+	r.Post("/alerts/:id/acknowledge", api.Require(auth.AlertsWrite), h.alerts.AcknowledgeAlert)
+	r.Get("/alert-webhooks", api.Require(auth.AlertsRead), h.alerts.ListAlertWebhooks)
+	r.Post("/alert-webhooks", api.Require(auth.AlertsWrite), h.alerts.CreateAlertWebhook)
+	r.Put("/alert-webhooks/:id", api.Require(auth.AlertsWrite), h.alerts.UpdateAlertWebhook)
+	r.Delete("/alert-webhooks/:id", api.Require(auth.AlertsWrite), h.alerts.DeleteAlertWebhook)
+
+	// Audit log
+	r.Get("/audit", api.Require(auth.AuditRead), h.audit.ListAudit)
+	r.Get("/audit/:type/:id", api.Require(auth.AuditRead), h.audit.GetEntityHistory)
+
+	// Scheduled Reports
+	r.Get("/scheduled-reports", api.Require(auth.ReportsRead), h.scheduledReports.ListScheduledReports)
+	r.Post("/scheduled-reports", api.Require(auth.ReportsWrite), h.scheduledReports.CreateScheduledReport)
+	r.Get("/scheduled-reports/:id", api.Require(auth.ReportsRead), h.scheduledReports.GetScheduledReport)
+	r.Put("/scheduled-reports/:id", api.Require(auth.ReportsWrite), h.scheduledReports.UpdateScheduledReport)
+	r.Delete("/scheduled-reports/:id", api.Require(auth.ReportsWrite), h.scheduledReports.DeleteScheduledReport)
+	r.Post("/scheduled-reports/:id/run", api.Require(auth.ReportsWrite), h.scheduledReports.RunScheduledReport)
+	r.Get("/scheduled-reports/:id/runs", api.Require(auth.ReportsRead), h.scheduledReports.ListScheduledReportRuns)
+
+	// Forecasting
+	r.Post("/forecast/availability", api.Require(auth.ForecastRead), h.forecast.GetAvailability)
+
+	// Uploads
+	r.Post("/uploads", api.Require(auth.CreativesWrite), h.upload.UploadImage)
+	r.Get("/uploads", api.Require(auth.CreativesRead), h.upload.ListUploads)
+	r.Delete("/uploads/:filename", api.Require(auth.CreativesWrite), h.upload.DeleteUpload)
+}
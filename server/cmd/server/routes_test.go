@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/api"
+	"github.com/mims/ad-manager/internal/auth"
+)
+
+// TestAPIRoutesRequirePermission checks that every admin API route but the
+// session ones starts with api.Require, since the handlers don't check
+// permissions themselves
+func TestAPIRoutesRequirePermission(t *testing.T) {
+	app := fiber.New()
+	registerAPIRoutes(app.Group("/api"), handlers{})
+
+	// Every Require middleware is the same closure, with the same code
+	require := reflect.ValueOf(api.Require(auth.CampaignsRead)).Pointer()
+	sessionRoutes := map[string]bool{
+		"POST /api/auth/login":  true,
+		"POST /api/auth/logout": true,
+		"GET /api/auth/me":      true,
+	}
+
+	n := 0
+	for _, route := range app.GetRoutes(true) {
+		// Fiber adds a HEAD route for each GET
+		if route.Method == fiber.MethodHead || !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		n++
+		name := route.Method + " " + route.Path
+		if sessionRoutes[name] {
+			continue
+		}
+		if len(route.Handlers) < 2 || reflect.ValueOf(route.Handlers[0]).Pointer() != require {
+			t.Errorf("%s doesn't start with api.Require", name)
+		}
+	}
+	if n < len(sessionRoutes)+1 {
+		t.Fatalf("found %d API routes, want the whole admin API", n)
+	}
+}
@@ -1,12 +1,16 @@
 package api
 
 import (
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/mims/ad-manager/internal/inventory"
 	"github.com/mims/ad-manager/internal/models"
 	"github.com/mims/ad-manager/internal/storage"
+	"github.com/mims/ad-manager/internal/targeting"
 )
 
 // AdminHandler handles admin API requests
@@ -22,9 +26,13 @@ func NewAdminHandler(store *storage.PostgresStore, cache *storage.InMemoryCache)
 
 // Campaign handlers
 
-// ListCampaigns returns all campaigns
+// ListCampaigns returns all campaigns, or an advertiser's or agency's with
+// ?advertiser_id= or ?agency_id=
 func (h *AdminHandler) ListCampaigns(c *fiber.Ctx) error {
-	campaigns, err := h.store.ListCampaigns(c.Context())
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
+	agencyID, _ := strconv.Atoi(c.Query("agency_id"))
+
+	campaigns, err := h.store.ListCampaigns(c.Context(), networkID(c), advertiserID, agencyID)
 	if err != nil {
 		return NewInternalError("Failed to list campaigns")
 	}
@@ -41,7 +49,7 @@ func (h *AdminHandler) GetCampaign(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid campaign ID")
 	}
 
-	campaign, err := h.store.GetCampaign(c.Context(), id)
+	campaign, err := h.store.GetCampaign(c.Context(), networkID(c), id)
 	if err != nil {
 		return NewInternalError("Failed to get campaign")
 	}
@@ -62,8 +70,24 @@ func (h *AdminHandler) CreateCampaign(c *fiber.Ctx) error {
 	if req.Name == "" {
 		return NewBadRequest("Name is required")
 	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return NewBadRequest("Invalid timezone")
+		}
+	}
+	if err := h.checkCampaignCompanies(c.Context(), networkID(c), req.AdvertiserID, req.AgencyID); err != nil {
+		return err
+	}
 
-	campaign, err := h.store.CreateCampaign(c.Context(), &req)
+	var campaign *models.Campaign
+	err := h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		campaign, err = tx.CreateCampaign(c.Context(), networkID(c), &req)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditCampaign, strconv.Itoa(campaign.ID), models.AuditCreate, nil, campaign)
+	})
 	if err != nil {
 		return NewInternalError("Failed to create campaign")
 	}
@@ -85,8 +109,39 @@ func (h *AdminHandler) UpdateCampaign(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return NewBadRequest("Invalid timezone")
+		}
+	}
+	advertiserID, agencyID := 0, 0
+	if req.AdvertiserID != nil {
+		advertiserID = *req.AdvertiserID
+	}
+	if req.AgencyID != nil {
+		agencyID = *req.AgencyID
+	}
+	if err := h.checkCampaignCompanies(c.Context(), networkID(c), advertiserID, agencyID); err != nil {
+		return err
+	}
 
-	campaign, err := h.store.UpdateCampaign(c.Context(), id, &req)
+	before, err := h.store.GetCampaign(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update campaign")
+	}
+	if before == nil {
+		return NewNotFound("Campaign not found")
+	}
+
+	var campaign *models.Campaign
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		campaign, err = tx.UpdateCampaign(c.Context(), networkID(c), id, &req)
+		if err != nil || campaign == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditCampaign, strconv.Itoa(id), models.AuditUpdate, before, campaign)
+	})
 	if err != nil {
 		return NewInternalError("Failed to update campaign")
 	}
@@ -107,7 +162,21 @@ func (h *AdminHandler) DeleteCampaign(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid campaign ID")
 	}
 
-	if err := h.store.DeleteCampaign(c.Context(), id); err != nil {
+	before, err := h.store.GetCampaign(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete campaign")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteCampaign(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return recordAudit(c, tx, models.AuditCampaign, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
 		return NewInternalError("Failed to delete campaign")
 	}
 
@@ -126,7 +195,7 @@ func (h *AdminHandler) ListLineItems(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid campaign ID")
 	}
 
-	items, err := h.store.ListLineItems(c.Context(), campaignID)
+	items, err := h.store.ListLineItems(c.Context(), networkID(c), campaignID)
 	if err != nil {
 		return NewInternalError("Failed to list line items")
 	}
@@ -143,7 +212,7 @@ func (h *AdminHandler) GetLineItem(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid line item ID")
 	}
 
-	item, err := h.store.GetLineItem(c.Context(), id)
+	item, err := h.store.GetLineItem(c.Context(), networkID(c), id)
 	if err != nil {
 		return NewInternalError("Failed to get line item")
 	}
@@ -158,14 +227,44 @@ func (h *AdminHandler) GetLineItem(c *fiber.Ctx) error {
 	}
 
 	// Load creatives
-	creatives, err := h.store.ListCreatives(c.Context(), id)
+	creatives, err := h.store.ListCreatives(c.Context(), networkID(c), id)
 	if err == nil {
 		item.Creatives = creatives
 	}
 
+	// Load dayparting schedule
+	dayparts, err := h.store.GetDayparts(c.Context(), id)
+	if err == nil {
+		item.Dayparts = dayparts
+	}
+
+	// Load ad unit targets and placements
+	targets, err := h.store.GetLineItemAdUnits(c.Context(), id)
+	if err == nil {
+		item.AdUnitTargets = targets
+	}
+	placements, err := h.store.GetLineItemPlacements(c.Context(), id)
+	if err == nil {
+		item.PlacementIDs = placements
+	}
+
 	return c.JSON(item)
 }
 
+// checkLineItem returns a not found error unless the line item exists in the
+// caller's network. Targeting rules, dayparts and ad unit links belong to
+// their line item, so their handlers check it first.
+func (h *AdminHandler) checkLineItem(c *fiber.Ctx, lineItemID int) error {
+	item, err := h.store.GetLineItem(c.Context(), networkID(c), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get line item")
+	}
+	if item == nil {
+		return NewNotFound("Line item not found")
+	}
+	return nil
+}
+
 // CreateLineItem creates a new line item
 func (h *AdminHandler) CreateLineItem(c *fiber.Ctx) error {
 	var req models.CreateLineItemRequest
@@ -179,11 +278,37 @@ func (h *AdminHandler) CreateLineItem(c *fiber.Ctx) error {
 	if req.CampaignID == 0 {
 		return NewBadRequest("Campaign ID is required")
 	}
+	if !models.IsValidRoadblockMode(req.RoadblockMode) {
+		return NewBadRequest("Invalid roadblock mode")
+	}
+	if req.CreativeRotation != "" && !models.IsValidCreativeRotation(req.CreativeRotation) {
+		return NewBadRequest("Invalid creative rotation")
+	}
+	if req.LineType != "" && req.LineType != models.LineTypeStandard && req.LineType != models.LineTypeHouse {
+		return NewBadRequest("Invalid line type")
+	}
+	if req.StartDate != nil && req.EndDate != nil && !req.EndDate.After(*req.StartDate) {
+		return NewBadRequest("End date must be after start date")
+	}
+	if req.ImpressionGoal < 0 {
+		return NewBadRequest("Impression goal cannot be negative")
+	}
 
-	item, err := h.store.CreateLineItem(c.Context(), &req)
+	var item *models.LineItem
+	err := h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		item, err = tx.CreateLineItem(c.Context(), networkID(c), &req)
+		if err != nil || item == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(item.ID), models.AuditCreate, nil, item)
+	})
 	if err != nil {
 		return NewInternalError("Failed to create line item")
 	}
+	if item == nil {
+		return NewBadRequest("Campaign " + strconv.Itoa(req.CampaignID) + " does not exist")
+	}
 
 	// Refresh cache
 	h.cache.Refresh(c.Context(), h.store)
@@ -202,8 +327,55 @@ func (h *AdminHandler) UpdateLineItem(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
+	if req.RoadblockMode != nil && !models.IsValidRoadblockMode(*req.RoadblockMode) {
+		return NewBadRequest("Invalid roadblock mode")
+	}
+	if req.CreativeRotation != "" && !models.IsValidCreativeRotation(req.CreativeRotation) {
+		return NewBadRequest("Invalid creative rotation")
+	}
+	if req.LineType != "" && req.LineType != models.LineTypeStandard && req.LineType != models.LineTypeHouse {
+		return NewBadRequest("Invalid line type")
+	}
+	if (req.StartDate != nil && req.ClearStartDate) || (req.EndDate != nil && req.ClearEndDate) {
+		return NewBadRequest("A flight date cannot be both set and cleared")
+	}
+	if req.ImpressionGoal != nil && *req.ImpressionGoal < 0 {
+		return NewBadRequest("Impression goal cannot be negative")
+	}
 
-	item, err := h.store.UpdateLineItem(c.Context(), id, &req)
+	before, err := h.store.GetLineItem(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update line item")
+	}
+	if before == nil {
+		return NewNotFound("Line item not found")
+	}
+
+	// Check the flight the update leaves, not just the dates it sends
+	startDate, endDate := before.StartDate, before.EndDate
+	if req.StartDate != nil {
+		startDate = req.StartDate
+	} else if req.ClearStartDate {
+		startDate = nil
+	}
+	if req.EndDate != nil {
+		endDate = req.EndDate
+	} else if req.ClearEndDate {
+		endDate = nil
+	}
+	if startDate != nil && endDate != nil && !endDate.After(*startDate) {
+		return NewBadRequest("End date must be after start date")
+	}
+
+	var item *models.LineItem
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		item, err = tx.UpdateLineItem(c.Context(), networkID(c), id, &req)
+		if err != nil || item == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(id), models.AuditUpdate, before, item)
+	})
 	if err != nil {
 		return NewInternalError("Failed to update line item")
 	}
@@ -224,7 +396,21 @@ func (h *AdminHandler) DeleteLineItem(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid line item ID")
 	}
 
-	if err := h.store.DeleteLineItem(c.Context(), id); err != nil {
+	before, err := h.store.GetLineItem(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete line item")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteLineItem(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
 		return NewInternalError("Failed to delete line item")
 	}
 
@@ -242,6 +428,9 @@ func (h *AdminHandler) GetTargetingRules(c *fiber.Ctx) error {
 	if err != nil {
 		return NewBadRequest("Invalid line item ID")
 	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
 
 	rules, err := h.store.GetTargetingRules(c.Context(), lineItemID)
 	if err != nil {
@@ -259,31 +448,119 @@ func (h *AdminHandler) SetTargetingRules(c *fiber.Ctx) error {
 	if err != nil {
 		return NewBadRequest("Invalid line item ID")
 	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
 
 	var req models.SetTargetingRulesRequest
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
 
-	if err := h.store.SetTargetingRules(c.Context(), lineItemID, req.Rules); err != nil {
+	before, err := h.store.GetTargetingRules(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to set targeting rules")
+	}
+
+	var rules []models.TargetingRule
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.SetTargetingRules(c.Context(), lineItemID, req.Rules); err != nil {
+			return err
+		}
+		var err error
+		rules, err = tx.GetTargetingRules(c.Context(), lineItemID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(lineItemID), models.AuditSetTargeting,
+			targetingSnapshot(before), targetingSnapshot(rules))
+	})
+	if err != nil {
 		return NewInternalError("Failed to set targeting rules")
 	}
 
 	// Auto-save key-values to targeting_keys table for future auto-suggest
 	for _, rule := range req.Rules {
-		h.store.AddTargetingKeyValues(c.Context(), rule.Key, rule.Values)
+		h.store.AddTargetingKeyValues(c.Context(), networkID(c), rule.Key, rule.Values)
 	}
 
 	// Refresh cache
 	h.cache.Refresh(c.Context(), h.store)
 
-	// Return updated rules
-	rules, err := h.store.GetTargetingRules(c.Context(), lineItemID)
+	return c.JSON(rules)
+}
+
+// Daypart handlers
+
+// GetDayparts returns the dayparting schedule for a line item
+func (h *AdminHandler) GetDayparts(c *fiber.Ctx) error {
+	lineItemID, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return NewInternalError("Failed to get targeting rules")
+		return NewBadRequest("Invalid line item ID")
+	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
 	}
 
-	return c.JSON(rules)
+	dayparts, err := h.store.GetDayparts(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get dayparts")
+	}
+	if dayparts == nil {
+		dayparts = []models.Daypart{}
+	}
+	return c.JSON(dayparts)
+}
+
+// SetDayparts replaces the dayparting schedule for a line item.
+// An empty list removes the schedule so the line item serves at all hours.
+func (h *AdminHandler) SetDayparts(c *fiber.Ctx) error {
+	lineItemID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid line item ID")
+	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
+
+	var req models.SetDaypartsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if err := targeting.ValidateDayparts(req.Dayparts); err != nil {
+		return NewBadRequest(err.Error())
+	}
+
+	before, err := h.store.GetDayparts(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to set dayparts")
+	}
+
+	var dayparts []models.Daypart
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.SetDayparts(c.Context(), lineItemID, req.Dayparts); err != nil {
+			return err
+		}
+		var err error
+		dayparts, err = tx.GetDayparts(c.Context(), lineItemID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(lineItemID), models.AuditSetDayparts,
+			daypartsSnapshot(before), daypartsSnapshot(dayparts))
+	})
+	if err != nil {
+		return NewInternalError("Failed to set dayparts")
+	}
+	if dayparts == nil {
+		dayparts = []models.Daypart{}
+	}
+
+	// Refresh cache
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.JSON(dayparts)
 }
 
 // Creative handlers
@@ -295,7 +572,7 @@ func (h *AdminHandler) ListCreatives(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid line item ID")
 	}
 
-	creatives, err := h.store.ListCreatives(c.Context(), lineItemID)
+	creatives, err := h.store.ListCreatives(c.Context(), networkID(c), lineItemID)
 	if err != nil {
 		return NewInternalError("Failed to list creatives")
 	}
@@ -312,7 +589,7 @@ func (h *AdminHandler) GetCreative(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid creative ID")
 	}
 
-	creative, err := h.store.GetCreative(c.Context(), id)
+	creative, err := h.store.GetCreative(c.Context(), networkID(c), id)
 	if err != nil {
 		return NewInternalError("Failed to get creative")
 	}
@@ -342,11 +619,25 @@ func (h *AdminHandler) CreateCreative(c *fiber.Ctx) error {
 	if req.ClickURL == "" {
 		return NewBadRequest("Click URL is required")
 	}
+	if req.Status != "" && req.Status != models.CreativeDraft && req.Status != models.CreativePendingReview {
+		return NewBadRequest("New creatives start as draft or pending_review; they serve once approved and activated")
+	}
 
-	creative, err := h.store.CreateCreative(c.Context(), &req)
+	var creative *models.Creative
+	err := h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		creative, err = tx.CreateCreative(c.Context(), networkID(c), &req)
+		if err != nil || creative == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditCreative, strconv.Itoa(creative.ID), models.AuditCreate, nil, creative)
+	})
 	if err != nil {
 		return NewInternalError("Failed to create creative")
 	}
+	if creative == nil {
+		return NewBadRequest("Line item " + strconv.Itoa(req.LineItemID) + " does not exist")
+	}
 
 	// Refresh cache
 	h.cache.Refresh(c.Context(), h.store)
@@ -354,7 +645,10 @@ func (h *AdminHandler) CreateCreative(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(creative)
 }
 
-// UpdateCreative updates a creative
+// UpdateCreative updates a creative. A status change must be an allowed
+// transition; approving and rejecting go through the review endpoints.
+// Changing the size or URLs of an approved, active or paused creative sends
+// it back for review.
 func (h *AdminHandler) UpdateCreative(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -365,15 +659,71 @@ func (h *AdminHandler) UpdateCreative(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
+	if req.Status != "" && !models.IsValidCreativeStatus(req.Status) {
+		return NewBadRequest("Invalid creative status")
+	}
+	if req.Status == models.CreativeApproved || req.Status == models.CreativeRejected {
+		return NewBadRequest("Creatives are approved and rejected through the review endpoints")
+	}
 
-	creative, err := h.store.UpdateCreative(c.Context(), id, &req)
+	before, err := h.store.GetCreative(c.Context(), networkID(c), id)
 	if err != nil {
 		return NewInternalError("Failed to update creative")
 	}
-	if creative == nil {
+	if before == nil {
 		return NewNotFound("Creative not found")
 	}
 
+	status := req.Status
+	if status == before.Status {
+		status = ""
+	}
+	contentChanged := (req.Width > 0 && req.Width != before.Width) ||
+		(req.Height > 0 && req.Height != before.Height) ||
+		(req.ImageURL != "" && req.ImageURL != before.ImageURL) ||
+		(req.ClickURL != "" && req.ClickURL != before.ClickURL)
+	if contentChanged && models.IsReviewed(before.Status) {
+		switch status {
+		case "":
+			status = models.CreativePendingReview
+		case models.CreativePendingReview, models.CreativeArchived:
+		default:
+			return NewBadRequest("Changing an approved creative's size or URLs sends it back for review")
+		}
+	}
+	if before.Status == models.CreativeArchived && (contentChanged || req.Name != "" || req.Weight > 0) {
+		return NewBadRequest("Archived creatives cannot be changed")
+	}
+	if status != "" && !models.CanTransitionCreative(before.Status, status) {
+		return NewBadRequest("Cannot change creative status from " + before.Status + " to " + status)
+	}
+
+	// The checks above hold only while the status is still before's, so
+	// the update is applied only if it is
+	req.Status = status
+	var creative *models.Creative
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		creative, err = tx.UpdateCreative(c.Context(), networkID(c), id, before.Status, &req)
+		if err != nil || creative == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditCreative, strconv.Itoa(id), models.AuditUpdate, before, creative)
+	})
+	if err != nil {
+		return NewInternalError("Failed to update creative")
+	}
+	if creative == nil {
+		current, err := h.store.GetCreative(c.Context(), networkID(c), id)
+		if err != nil {
+			return NewInternalError("Failed to update creative")
+		}
+		if current == nil {
+			return NewNotFound("Creative not found")
+		}
+		return NewBadRequest("Creative status changed during the update; reload it and try again")
+	}
+
 	// Refresh cache
 	h.cache.Refresh(c.Context(), h.store)
 
@@ -387,7 +737,21 @@ func (h *AdminHandler) DeleteCreative(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid creative ID")
 	}
 
-	if err := h.store.DeleteCreative(c.Context(), id); err != nil {
+	before, err := h.store.GetCreative(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete creative")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteCreative(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return recordAudit(c, tx, models.AuditCreative, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
 		return NewInternalError("Failed to delete creative")
 	}
 
@@ -401,7 +765,7 @@ func (h *AdminHandler) DeleteCreative(c *fiber.Ctx) error {
 
 // ListAdUnits returns all ad units
 func (h *AdminHandler) ListAdUnits(c *fiber.Ctx) error {
-	units, err := h.store.ListAdUnits(c.Context())
+	units, err := h.store.ListAdUnits(c.Context(), networkID(c))
 	if err != nil {
 		return NewInternalError("Failed to list ad units")
 	}
@@ -418,7 +782,7 @@ func (h *AdminHandler) GetAdUnit(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid ad unit ID")
 	}
 
-	unit, err := h.store.GetAdUnit(c.Context(), id)
+	unit, err := h.store.GetAdUnit(c.Context(), networkID(c), id)
 	if err != nil {
 		return NewInternalError("Failed to get ad unit")
 	}
@@ -442,12 +806,34 @@ func (h *AdminHandler) CreateAdUnit(c *fiber.Ctx) error {
 	if req.Name == "" {
 		return NewBadRequest("Name is required")
 	}
-
-	unit, err := h.store.CreateAdUnit(c.Context(), &req)
+	if !models.IsValidFallbackMode(req.FallbackMode) {
+		return NewBadRequest("Invalid fallback mode")
+	}
+	if req.ParentID != nil {
+		parent, err := h.store.GetAdUnit(c.Context(), networkID(c), *req.ParentID)
+		if err != nil {
+			return NewInternalError("Failed to create ad unit")
+		}
+		if parent == nil {
+			return NewBadRequest("Parent ad unit not found")
+		}
+	}
+
+	var unit *models.AdUnit
+	err := h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		unit, err = tx.CreateAdUnit(c.Context(), networkID(c), &req)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAdUnit, strconv.Itoa(unit.ID), models.AuditCreate, nil, unit)
+	})
 	if err != nil {
 		return NewInternalError("Failed to create ad unit")
 	}
 
+	h.cache.Refresh(c.Context(), h.store)
+
 	return c.Status(fiber.StatusCreated).JSON(unit)
 }
 
@@ -462,8 +848,32 @@ func (h *AdminHandler) UpdateAdUnit(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
+	if req.FallbackMode != nil && !models.IsValidFallbackMode(*req.FallbackMode) {
+		return NewBadRequest("Invalid fallback mode")
+	}
 
-	unit, err := h.store.UpdateAdUnit(c.Context(), id, &req)
+	before, err := h.store.GetAdUnit(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update ad unit")
+	}
+	if before == nil {
+		return NewNotFound("Ad unit not found")
+	}
+	if req.ParentID != nil && *req.ParentID != 0 {
+		if err := h.checkAdUnitParent(c, id, *req.ParentID); err != nil {
+			return err
+		}
+	}
+
+	var unit *models.AdUnit
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		unit, err = tx.UpdateAdUnit(c.Context(), networkID(c), id, &req)
+		if err != nil || unit == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAdUnit, strconv.Itoa(id), models.AuditUpdate, before, unit)
+	})
 	if err != nil {
 		return NewInternalError("Failed to update ad unit")
 	}
@@ -471,70 +881,173 @@ func (h *AdminHandler) UpdateAdUnit(c *fiber.Ctx) error {
 		return NewNotFound("Ad unit not found")
 	}
 
+	h.cache.Refresh(c.Context(), h.store)
+
 	return c.JSON(unit)
 }
 
-// DeleteAdUnit deletes an ad unit
+// checkAdUnitParent returns a bad request error unless parentID exists in the
+// caller's network and moving the ad unit under it keeps the hierarchy a tree
+func (h *AdminHandler) checkAdUnitParent(c *fiber.Ctx, id, parentID int) error {
+	if parentID == id {
+		return NewBadRequest("An ad unit cannot be its own parent")
+	}
+	units, err := h.store.ListAdUnits(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to update ad unit")
+	}
+	tree := inventory.NewTree(units)
+	if _, ok := tree.Get(parentID); !ok {
+		return NewBadRequest("Parent ad unit not found")
+	}
+	if tree.IsDescendant(parentID, id) {
+		return NewBadRequest("An ad unit cannot be moved below its own descendant")
+	}
+	return nil
+}
+
+// DeleteAdUnit deletes an ad unit. Ad units with children must have them
+// moved or deleted first.
 func (h *AdminHandler) DeleteAdUnit(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return NewBadRequest("Invalid ad unit ID")
 	}
 
-	if err := h.store.DeleteAdUnit(c.Context(), id); err != nil {
+	before, err := h.store.GetAdUnit(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete ad unit")
+	}
+	if before != nil {
+		children, err := h.store.CountAdUnitChildren(c.Context(), id)
+		if err != nil {
+			return NewInternalError("Failed to delete ad unit")
+		}
+		if children > 0 {
+			return NewBadRequest("Ad unit has child ad units; move or delete them first")
+		}
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteAdUnit(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return recordAudit(c, tx, models.AuditAdUnit, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
 		return NewInternalError("Failed to delete ad unit")
 	}
+	if before != nil {
+		h.cache.Refresh(c.Context(), h.store)
+	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-// GetLineItemAdUnits returns ad units for a line item
+// GetLineItemAdUnits returns the ad units a line item targets and excludes.
+// ad_unit_ids lists the targeted ones, as before ad units had children.
 func (h *AdminHandler) GetLineItemAdUnits(c *fiber.Ctx) error {
 	lineItemID, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return NewBadRequest("Invalid line item ID")
 	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
 
-	ids, err := h.store.GetLineItemAdUnits(c.Context(), lineItemID)
+	targets, err := h.store.GetLineItemAdUnits(c.Context(), lineItemID)
 	if err != nil {
 		return NewInternalError("Failed to get line item ad units")
 	}
-	if ids == nil {
-		ids = []int{}
-	}
 
-	return c.JSON(fiber.Map{"ad_unit_ids": ids})
+	return c.JSON(lineItemAdUnitsResponse(targets))
 }
 
-// SetLineItemAdUnits sets ad units for a line item
+// lineItemAdUnitsResponse is the JSON form of a line item's ad unit targets
+func lineItemAdUnitsResponse(targets []models.AdUnitTarget) fiber.Map {
+	ids := []int{}
+	for _, t := range targets {
+		if !t.Excluded {
+			ids = append(ids, t.AdUnitID)
+		}
+	}
+	if targets == nil {
+		targets = []models.AdUnitTarget{}
+	}
+	return fiber.Map{"ad_unit_ids": ids, "targets": targets}
+}
+
+// SetLineItemAdUnits sets the ad units a line item targets and excludes.
+// Plain ad_unit_ids are targeted without their descendants.
 func (h *AdminHandler) SetLineItemAdUnits(c *fiber.Ctx) error {
 	lineItemID, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return NewBadRequest("Invalid line item ID")
 	}
-
-	var req struct {
-		AdUnitIDs []int `json:"ad_unit_ids"`
+	item, err := h.store.GetLineItem(c.Context(), networkID(c), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get line item")
 	}
+	if item == nil {
+		return NewNotFound("Line item not found")
+	}
+
+	var req models.SetLineItemAdUnitsRequest
 	if err := c.BodyParser(&req); err != nil {
 		return NewBadRequest("Invalid request body")
 	}
 
-	if err := h.store.SetLineItemAdUnits(c.Context(), lineItemID, req.AdUnitIDs); err != nil {
+	targets := append([]models.AdUnitTarget{}, req.Targets...)
+	for _, id := range req.AdUnitIDs {
+		targets = append(targets, models.AdUnitTarget{AdUnitID: id})
+	}
+	// Only the line item's own network's ad units can be targeted
+	units, err := h.store.ListAdUnits(c.Context(), item.NetworkID)
+	if err != nil {
+		return NewInternalError("Failed to set line item ad units")
+	}
+	tree := inventory.NewTree(units)
+	seen := make(map[int]bool)
+	for _, t := range targets {
+		if _, ok := tree.Get(t.AdUnitID); !ok {
+			return NewBadRequest(fmt.Sprintf("Ad unit %d not found", t.AdUnitID))
+		}
+		if seen[t.AdUnitID] {
+			return NewBadRequest(fmt.Sprintf("Ad unit %d is listed more than once", t.AdUnitID))
+		}
+		seen[t.AdUnitID] = true
+	}
+
+	before, err := h.store.GetLineItemAdUnits(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to set line item ad units")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.SetLineItemAdUnits(c.Context(), lineItemID, targets); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(lineItemID), models.AuditSetAdUnits,
+			adUnitsSnapshot(before), adUnitsSnapshot(targets))
+	})
+	if err != nil {
 		return NewInternalError("Failed to set line item ad units")
 	}
 
 	// Refresh cache
 	h.cache.Refresh(c.Context(), h.store)
 
-	return c.JSON(fiber.Map{"ad_unit_ids": req.AdUnitIDs})
+	return c.JSON(lineItemAdUnitsResponse(targets))
 }
 
 // Targeting Keys handlers
 
 // ListTargetingKeys returns all targeting keys
 func (h *AdminHandler) ListTargetingKeys(c *fiber.Ctx) error {
-	keys, err := h.store.ListTargetingKeys(c.Context())
+	keys, err := h.store.ListTargetingKeys(c.Context(), networkID(c))
 	if err != nil {
 		return NewInternalError("Failed to list targeting keys")
 	}
@@ -551,7 +1064,7 @@ func (h *AdminHandler) GetTargetingKeyValues(c *fiber.Ctx) error {
 		return NewBadRequest("Key is required")
 	}
 
-	targetingKey, err := h.store.GetTargetingKey(c.Context(), key)
+	targetingKey, err := h.store.GetTargetingKey(c.Context(), networkID(c), key)
 	if err != nil {
 		return NewInternalError("Failed to get targeting key")
 	}
@@ -576,7 +1089,23 @@ func (h *AdminHandler) AddTargetingKeyValues(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid request body")
 	}
 
-	targetingKey, err := h.store.UpsertTargetingKey(c.Context(), key, req.Values)
+	before, err := h.store.GetTargetingKey(c.Context(), networkID(c), key)
+	if err != nil {
+		return NewInternalError("Failed to update targeting key")
+	}
+
+	var targetingKey *models.TargetingKey
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		targetingKey, err = tx.UpsertTargetingKey(c.Context(), networkID(c), key, req.Values)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return recordAudit(c, tx, models.AuditTargetingKey, key, models.AuditCreate, nil, targetingKey)
+		}
+		return recordAudit(c, tx, models.AuditTargetingKey, key, models.AuditUpdate, before, targetingKey)
+	})
 	if err != nil {
 		return NewInternalError("Failed to update targeting key")
 	}
@@ -598,7 +1127,20 @@ func (h *AdminHandler) UpdateTargetingKeyValues(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid request body")
 	}
 
-	targetingKey, err := h.store.UpdateTargetingKeyValues(c.Context(), key, req.Values)
+	before, err := h.store.GetTargetingKey(c.Context(), networkID(c), key)
+	if err != nil {
+		return NewInternalError("Failed to update targeting key values")
+	}
+
+	var targetingKey *models.TargetingKey
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		targetingKey, err = tx.UpdateTargetingKeyValues(c.Context(), networkID(c), key, req.Values)
+		if err != nil || targetingKey == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditTargetingKey, key, models.AuditUpdate, before, targetingKey)
+	})
 	if err != nil {
 		return NewInternalError("Failed to update targeting key values")
 	}
@@ -616,7 +1158,21 @@ func (h *AdminHandler) DeleteTargetingKey(c *fiber.Ctx) error {
 		return NewBadRequest("Key is required")
 	}
 
-	if err := h.store.DeleteTargetingKey(c.Context(), key); err != nil {
+	before, err := h.store.GetTargetingKey(c.Context(), networkID(c), key)
+	if err != nil {
+		return NewInternalError("Failed to delete targeting key")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteTargetingKey(c.Context(), networkID(c), key); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return recordAudit(c, tx, models.AuditTargetingKey, key, models.AuditDelete, before, nil)
+	})
+	if err != nil {
 		return NewInternalError("Failed to delete targeting key")
 	}
 
@@ -3,14 +3,19 @@ package api
 import (
 	"fmt"
 	"math/rand"
+	"net/url"
 	"sort"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 
+	"github.com/mims/ad-manager/internal/exclusion"
 	"github.com/mims/ad-manager/internal/frequency"
+	"github.com/mims/ad-manager/internal/inventory"
+	"github.com/mims/ad-manager/internal/live"
 	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/requestlog"
 	"github.com/mims/ad-manager/internal/storage"
 	"github.com/mims/ad-manager/internal/targeting"
 )
@@ -21,25 +26,83 @@ func init() {
 
 // AdsHandler handles ad serving requests
 type AdsHandler struct {
-	store     *storage.PostgresStore
-	cache     *storage.InMemoryCache
-	freqCap   *frequency.Capper
-	matcher   *targeting.Matcher
-	serverURL string
+	store      *storage.PostgresStore
+	cache      *storage.InMemoryCache
+	freqCap    *frequency.Capper
+	exclusions *exclusion.Tracker
+	matcher    *targeting.Matcher
+	requestLog *requestlog.Logger
+	live       *live.Counter
+	serverURL  string
+	debugToken string
+	auth       *AuthHandler
 }
 
 // NewAdsHandler creates a new AdsHandler
-func NewAdsHandler(store *storage.PostgresStore, cache *storage.InMemoryCache, freqCap *frequency.Capper) *AdsHandler {
+func NewAdsHandler(store *storage.PostgresStore, cache *storage.InMemoryCache, freqCap *frequency.Capper, exclusions *exclusion.Tracker) *AdsHandler {
+	// Campaigns without a timezone daypart in their network's timezone
+	matcher := targeting.NewMatcher()
+	matcher.SetNetworkTimezones(cache.GetNetworkTimezone)
+
 	return &AdsHandler{
-		store:     store,
-		cache:     cache,
-		freqCap:   freqCap,
-		matcher:   targeting.NewMatcher(),
-		serverURL: "",
+		store:      store,
+		cache:      cache,
+		freqCap:    freqCap,
+		exclusions: exclusions,
+		matcher:    matcher,
+		serverURL:  "",
 	}
 }
 
-// GetAds handles ad requests
+// SetDebugToken sets a token that allows explain mode (?debug=1) for any
+// network, on top of signing in. Empty means none.
+func (h *AdsHandler) SetDebugToken(token string) {
+	h.debugToken = token
+}
+
+// SetAuth sets how explain mode requests are signed in
+func (h *AdsHandler) SetAuth(authHandler *AuthHandler) {
+	h.auth = authHandler
+}
+
+// SetRequestLogger sets the logger that records ad slot opportunities
+func (h *AdsHandler) SetRequestLogger(l *requestlog.Logger) {
+	h.requestLog = l
+}
+
+// SetLiveCounter sets the counter live stats are fed to
+func (h *AdsHandler) SetLiveCounter(counter *live.Counter) {
+	h.live = counter
+}
+
+// slotCandidates holds the line items eligible for a slot before selection
+type slotCandidates struct {
+	slot         models.AdSlot
+	isResponsive bool
+	adUnitSizes  [][]int
+	eligible     []models.LineItem
+	reason       string // why eligible is empty
+}
+
+// adRequestState carries serving state across the slots of one ad request
+type adRequestState struct {
+	networkID  int
+	req        *models.AdRequest
+	userID     string
+	serverURL  string
+	candidates []slotCandidates
+	filled     []*models.AdResult
+	page       *exclusion.Page
+
+	// dryRun skips every side effect (frequency caps, page exclusions,
+	// creative sequences); debug collects the explain mode trace
+	dryRun bool
+	debug  []models.SlotDebug
+}
+
+// GetAds handles ad requests. With ?debug=1 it runs in explain mode: the
+// response also lists, per slot, every line item and the stage it was
+// rejected at, and nothing is recorded or counted.
 func (h *AdsHandler) GetAds(c *fiber.Ctx) error {
 	var req models.AdRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -57,8 +120,20 @@ func (h *AdsHandler) GetAds(c *fiber.Ctx) error {
 		userID = c.Get("X-User-ID", uuid.New().String())
 	}
 
-	// Get all active line items from cache
-	lineItems := h.cache.GetActiveLineItems()
+	networkID, err := h.requestNetwork(&req)
+	if err != nil {
+		return err
+	}
+
+	debug := c.Query("debug") == "1"
+	if debug {
+		if err := h.debugAllowed(c, networkID); err != nil {
+			return err
+		}
+	}
+
+	// Get the network's active line items from cache
+	lineItems := h.cache.GetActiveLineItems(networkID)
 
 	// Build server URL for tracking
 	protocol := "http"
@@ -68,115 +143,483 @@ func (h *AdsHandler) GetAds(c *fiber.Ctx) error {
 	host := c.Hostname()
 	serverURL := fmt.Sprintf("%s://%s", protocol, host)
 
-	var results []models.AdResult
+	// Match every slot up front so roadblocks can see all slots of the request
+	st := &adRequestState{
+		networkID:  networkID,
+		req:        &req,
+		userID:     userID,
+		serverURL:  serverURL,
+		candidates: make([]slotCandidates, len(req.Slots)),
+		filled:     make([]*models.AdResult, len(req.Slots)),
+		// Line items chosen for earlier slots of this page view, so that
+		// competing advertisers are never served side by side
+		page:   h.exclusions.Get(networkID, req.PageViewID),
+		dryRun: debug,
+	}
+	if debug {
+		st.debug = make([]models.SlotDebug, len(req.Slots))
+	}
+	for i, slot := range req.Slots {
+		st.candidates[i] = h.matchSlot(&req, networkID, slot, lineItems, userID)
+		if debug {
+			st.debug[i] = h.explainSlot(&req, st.candidates[i], lineItems, userID)
+		}
+	}
 
 	// Process each slot
-	for _, slot := range req.Slots {
-		isResponsive := slot.Width == 0 && slot.Height == 0 && slot.MaxWidth > 0
+	unfilled := make([]*models.AdResult, len(req.Slots))
+	for i := range st.candidates {
+		if st.filled[i] != nil {
+			// Already taken by a roadblock
+			continue
+		}
 
-		// Look up ad unit sizes for responsive filtering
-		var adUnitSizes [][]int
-		if isResponsive && slot.AdUnit != "" {
-			if adUnit := h.cache.GetAdUnitByCode(slot.AdUnit); adUnit != nil {
-				adUnitSizes = adUnit.Sizes
+		// Drop line items that conflict with ads already on the page
+		eligible := st.page.Filter(st.candidates[i].eligible)
+		reason := st.candidates[i].reason
+		if len(eligible) == 0 && reason == "" {
+			reason = models.UnfilledCompetitiveExclusion
+		}
+		for _, li := range st.candidates[i].eligible {
+			if conflict := st.page.Conflict(li); conflict != "" {
+				st.mark(i, li.ID, models.DebugStageExclusion, conflict)
 			}
 		}
 
-		// Match line items based on targeting and ad unit
-		var matched []models.LineItem
-		if isResponsive {
-			matched = h.matcher.MatchResponsive(req.Targeting, lineItems, slot.MaxWidth, adUnitSizes)
-		} else {
-			matched = h.matcher.Match(req.Targeting, lineItems, slot.Width, slot.Height)
+		// House line items are only considered once every other line item has failed
+		standard, house := splitHouseLineItems(eligible)
+		if len(standard) > 0 {
+			if _, reason = h.fillSlot(st, i, standard); reason == "" {
+				for _, li := range house {
+					st.mark(i, li.ID, models.DebugStageHouse, "house line items only serve when no standard line item fills the slot")
+				}
+				continue
+			}
+		} else if reason == "" {
+			reason = models.UnfilledNoMatch
 		}
-
-		// Filter by ad unit if provided
-		if slot.AdUnit != "" {
-			matched = h.filterByAdUnit(matched, slot.AdUnit)
+		if len(house) > 0 {
+			if slotIndexes, houseReason := h.fillSlot(st, i, house); houseReason == "" {
+				for _, j := range slotIndexes {
+					st.filled[j].Fallback = models.FallbackHouse
+					st.filled[j].Reason = reason
+				}
+				continue
+			}
 		}
 
-		// Sort by priority (highest first)
-		sort.Slice(matched, func(i, j int) bool {
-			return matched[i].Priority > matched[j].Priority
-		})
+		unfilled[i] = h.fallbackResult(networkID, st.candidates[i].slot, reason)
+	}
 
-		// Filter by frequency cap
-		var eligible []models.LineItem
-		for _, li := range matched {
-			if h.freqCap.Check(li.ID, userID, li.FrequencyCap) {
-				eligible = append(eligible, li)
+	if !st.dryRun {
+		if h.live != nil {
+			for i, sc := range st.candidates {
+				lineItemID := 0
+				if st.filled[i] != nil {
+					lineItemID = st.filled[i].LineItemID
+				}
+				h.live.RecordRequest(networkID, sc.slot.AdUnit, lineItemID)
 			}
 		}
+	}
+
+	var results []models.AdResult
+	for i := range st.filled {
+		if st.filled[i] != nil {
+			results = append(results, *st.filled[i])
+		} else if unfilled[i] != nil {
+			results = append(results, *unfilled[i])
+		}
+	}
 
-		if len(eligible) == 0 {
+	if debug {
+		return c.JSON(models.AdDebugResponse{Ads: results, Debug: st.finishDebug(unfilled)})
+	}
+
+	if h.requestLog != nil && h.requestLog.Sample() {
+		h.requestLog.Log(st.opportunities(unfilled))
+	}
+
+	return c.JSON(models.AdResponse{Ads: results})
+}
+
+// requestNetwork returns the network an ad request is for: the network of
+// its publisher ID, else the network its slots' ad units belong to, else the
+// default network. An ad unit code used by several networks needs the
+// publisher ID to tell them apart.
+func (h *AdsHandler) requestNetwork(req *models.AdRequest) (int, error) {
+	if req.PublisherID != "" {
+		networkID, ok := h.cache.GetNetworkByCode(req.PublisherID)
+		if !ok {
+			return 0, NewBadRequest("Unknown publisher_id " + req.PublisherID)
+		}
+		return networkID, nil
+	}
+
+	networkID := 0
+	for _, slot := range req.Slots {
+		if slot.AdUnit == "" {
+			continue
+		}
+		networks := h.cache.AdUnitNetworks(slot.AdUnit)
+		if len(networks) > 1 {
+			return 0, NewBadRequest("Ad unit " + slot.AdUnit + " exists in several networks, publisher_id is required")
+		}
+		if len(networks) == 0 {
 			continue
 		}
+		if networkID != 0 && networks[0] != networkID {
+			return 0, NewBadRequest("The slots' ad units belong to different networks")
+		}
+		networkID = networks[0]
+	}
+	if networkID == 0 {
+		return models.DefaultNetworkID, nil
+	}
+	return networkID, nil
+}
 
+// fillSlot selects a line item for slot i from the eligible list and serves it,
+// along with any other slots it roadblocks. Returns the filled slot indexes, or
+// the reason the slot could not be filled.
+func (h *AdsHandler) fillSlot(st *adRequestState, i int, eligible []models.LineItem) ([]int, string) {
+	reason := models.UnfilledRoadblock
+	for len(eligible) > 0 {
 		// Select line item using SOV-aware selection among same priority
 		selectedLineItem := selectWithSOV(eligible)
+		st.explainSelection(i, eligible, selectedLineItem)
 		if selectedLineItem == nil {
+			return nil, models.UnfilledSOV
+		}
+
+		slotIndexes := []int{i}
+		if selectedLineItem.RoadblockMode != models.RoadblockNone {
+			var ok bool
+			slotIndexes, ok = planRoadblock(st.candidates, st.filled, i, *selectedLineItem)
+			if !ok {
+				// All-or-none roadblock can't fill the page; try the next candidate
+				st.mark(i, selectedLineItem.ID, models.DebugStageRoadblock, "all_or_none roadblock cannot fill every slot of the request")
+				eligible = removeLineItem(eligible, selectedLineItem.ID)
+				continue
+			}
+		}
+
+		// Find the slots the line item has a creative for, without moving
+		// its creative rotation along yet
+		var served []int
+		previews := make(map[int]*models.AdResult)
+		for _, j := range slotIndexes {
+			if result := h.serveAd(st.req, st.candidates[j], *selectedLineItem, st.userID, st.serverURL, true); result != nil {
+				previews[j] = result
+				served = append(served, j)
+			}
+		}
+		if selectedLineItem.RoadblockMode == models.RoadblockAllOrNone && len(served) < len(slotIndexes) {
+			// A slot of the roadblock has no creative that fits it
+			st.mark(i, selectedLineItem.ID, models.DebugStageRoadblock, "all_or_none roadblock has no creative for every slot of the request")
+			eligible = removeLineItem(eligible, selectedLineItem.ID)
 			continue
 		}
+		if len(served) == 0 {
+			st.mark(i, selectedLineItem.ID, models.DebugStageCreative, "no creative could be selected for the slot")
+			return nil, models.UnfilledNoCreative
+		}
+
+		// Record the winner on the page view, unless a concurrent request
+		// for the same page has placed a competitor since this one started
+		if !st.dryRun {
+			if conflict := h.exclusions.Reserve(st.networkID, st.req.PageViewID, *selectedLineItem); conflict != "" {
+				eligible = removeLineItem(eligible, selectedLineItem.ID)
+				reason = models.UnfilledCompetitiveExclusion
+				continue
+			}
+		}
+
+		// Only now select the creatives for real, so a line item that lost
+		// the page view doesn't skip a creative of a sequential rotation
+		for _, j := range served {
+			st.filled[j] = previews[j]
+			if !st.dryRun {
+				st.filled[j] = h.serveAd(st.req, st.candidates[j], *selectedLineItem, st.userID, st.serverURL, false)
+			}
+		}
+		st.explainRoadblock(i, served, selectedLineItem.ID)
+
+		// Remember the winner for the remaining slots of this request
+		st.page.Add(*selectedLineItem)
+
+		// Increment frequency cap counter (once per request, even for roadblocks)
+		if !st.dryRun {
+			h.freqCap.Increment(selectedLineItem.ID, st.userID)
+		}
+
+		return served, ""
+	}
+
+	return nil, reason
+}
+
+// fallbackResult builds the result for a slot nothing could fill, using the
+// ad unit's configured fallback (passback or collapse)
+func (h *AdsHandler) fallbackResult(networkID int, slot models.AdSlot, reason string) *models.AdResult {
+	result := &models.AdResult{
+		SlotID:   slot.ID,
+		Fallback: models.FallbackNone,
+		Reason:   reason,
+	}
+
+	if slot.AdUnit == "" {
+		return result
+	}
+	adUnit := h.cache.GetAdUnitByCode(networkID, slot.AdUnit)
+	if adUnit == nil {
+		return result
+	}
+
+	switch adUnit.FallbackMode {
+	case models.FallbackPassback:
+		result.Fallback = models.FallbackPassback
+		result.PassbackURL = adUnit.PassbackURL
+		result.PassbackHTML = adUnit.PassbackHTML
+	case models.FallbackCollapse:
+		result.Fallback = models.FallbackCollapse
+	}
+	return result
+}
+
+// opportunities describes every slot of the request for the request log
+func (st *adRequestState) opportunities(unfilled []*models.AdResult) []models.AdOpportunity {
+	country := requestCountry(st.req)
+	platform := requestPlatform(st.req)
+
+	opportunities := make([]models.AdOpportunity, 0, len(st.candidates))
+	for i, sc := range st.candidates {
+		o := models.AdOpportunity{
+			NetworkID: st.networkID,
+			AdUnit:    sc.slot.AdUnit,
+			Width:     sc.slot.Width,
+			Height:    sc.slot.Height,
+			Targeting: st.req.Targeting,
+			Platform:  platform,
+			Country:   country,
+		}
+		if sc.isResponsive {
+			o.Width = sc.slot.MaxWidth
+		}
+		if result := st.filled[i]; result != nil {
+			o.Filled = true
+			o.LineItemID = result.LineItemID
+			o.Reason = result.Reason
+		} else if result := unfilled[i]; result != nil {
+			o.Reason = result.Reason
+		}
+		opportunities = append(opportunities, o)
+	}
+	return opportunities
+}
 
-		// Select creative
-		var selectedCreative *models.Creative
-		if isResponsive {
-			selectedCreative = h.matcher.SelectCreativeResponsive(*selectedLineItem, slot.MaxWidth, adUnitSizes)
+// splitHouseLineItems separates house line items from the rest, keeping order
+func splitHouseLineItems(lineItems []models.LineItem) ([]models.LineItem, []models.LineItem) {
+	var standard, house []models.LineItem
+	for _, li := range lineItems {
+		if li.LineType == models.LineTypeHouse {
+			house = append(house, li)
 		} else {
-			selectedCreative = h.matcher.SelectCreative(*selectedLineItem, slot.Width, slot.Height)
+			standard = append(standard, li)
 		}
-		if selectedCreative == nil {
-			continue
+	}
+	return standard, house
+}
+
+// matchSlot returns the line items eligible for a slot, sorted by priority
+func (h *AdsHandler) matchSlot(req *models.AdRequest, networkID int, slot models.AdSlot, lineItems []models.LineItem, userID string) slotCandidates {
+	sc := slotCandidates{
+		slot:         slot,
+		isResponsive: slot.Width == 0 && slot.Height == 0 && slot.MaxWidth > 0,
+	}
+
+	// Look up ad unit sizes for responsive filtering
+	if sc.isResponsive && slot.AdUnit != "" {
+		if adUnit := h.cache.GetAdUnitByCode(networkID, slot.AdUnit); adUnit != nil {
+			sc.adUnitSizes = adUnit.Sizes
 		}
+	}
 
+	// Match line items based on targeting and ad unit
+	var matched []models.LineItem
+	if sc.isResponsive {
+		matched = h.matcher.MatchResponsive(req.Targeting, lineItems, slot.MaxWidth, sc.adUnitSizes)
+	} else {
+		matched = h.matcher.Match(req.Targeting, lineItems, slot.Width, slot.Height)
+	}
 
-		// Generate impression ID
-		impressionID := uuid.New().String()
+	// Filter by ad unit if provided
+	if slot.AdUnit != "" {
+		matched = h.filterByAdUnit(matched, networkID, slot.AdUnit)
+	}
 
-		// Increment frequency cap counter
-		h.freqCap.Increment(selectedLineItem.ID, userID)
+	// Sort by priority (highest first)
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
 
-		// Build tracking URLs with key-value data
-		trackingBase := fmt.Sprintf("%s/v1", serverURL)
-		section := req.Targeting["section"]
-		country := req.Country
-		if country == "" || country == "unknown" {
-			if tc, ok := req.Targeting["country"]; ok && tc != "" {
-				country = tc
-			}
+	if len(matched) == 0 {
+		sc.reason = models.UnfilledNoMatch
+		return sc
+	}
+
+	// Filter by frequency cap
+	for _, li := range matched {
+		if h.freqCap.Check(li.ID, userID, li.FrequencyCap) {
+			sc.eligible = append(sc.eligible, li)
 		}
-		platform := req.Platform
-		if platform == "" || platform == "unknown" {
-			if tp, ok := req.Targeting["platform"]; ok && tp != "" {
-				platform = tp
-			}
+	}
+	if len(sc.eligible) == 0 {
+		sc.reason = models.UnfilledFrequencyCapped
+	}
+
+	return sc
+}
+
+// serveAd selects a creative from the line item for the slot and builds the
+// ad result with tracking URLs. Returns nil if no creative fits. In preview
+// mode the creative rotation is not advanced.
+func (h *AdsHandler) serveAd(req *models.AdRequest, sc slotCandidates, lineItem models.LineItem, userID, serverURL string, preview bool) *models.AdResult {
+	slot := sc.slot
+
+	// Select creative
+	var selectedCreative *models.Creative
+	switch {
+	case sc.isResponsive && preview:
+		selectedCreative = h.matcher.PreviewCreativeResponsive(lineItem, slot.MaxWidth, sc.adUnitSizes, userID)
+	case sc.isResponsive:
+		selectedCreative = h.matcher.SelectCreativeResponsive(lineItem, slot.MaxWidth, sc.adUnitSizes, userID)
+	case preview:
+		selectedCreative = h.matcher.PreviewCreative(lineItem, slot.Width, slot.Height, userID)
+	default:
+		selectedCreative = h.matcher.SelectCreative(lineItem, slot.Width, slot.Height, userID)
+	}
+	if selectedCreative == nil {
+		return nil
+	}
+
+	// Previews carry no tracking URLs, so they can never be counted
+	if preview {
+		return &models.AdResult{
+			SlotID:     slot.ID,
+			LineItemID: lineItem.ID,
+			CreativeID: selectedCreative.ID,
+			Width:      selectedCreative.Width,
+			Height:     selectedCreative.Height,
+			ImageURL:   selectedCreative.ImageURL,
+			ClickURL:   selectedCreative.ClickURL,
 		}
-		adUnit := slot.AdUnit
-		tracking := models.Tracking{
-			Impression: fmt.Sprintf("%s/imp?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s",
-				trackingBase, impressionID, selectedLineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit),
-			Viewable: fmt.Sprintf("%s/view?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s",
-				trackingBase, impressionID, selectedLineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit),
-			Click: fmt.Sprintf("%s/click?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s&url=%s",
-				trackingBase, impressionID, selectedLineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit, selectedCreative.ClickURL),
+	}
+
+	// Generate impression ID
+	impressionID := uuid.New().String()
+
+	// Build tracking URLs with key-value data
+	trackingBase := fmt.Sprintf("%s/v1", serverURL)
+	section := req.Targeting["section"]
+	country := requestCountry(req)
+	platform := requestPlatform(req)
+	adUnit := slot.AdUnit
+	kv := url.QueryEscape(encodeKeyValues(req.Targeting))
+	tracking := models.Tracking{
+		Impression: fmt.Sprintf("%s/imp?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s&kv=%s",
+			trackingBase, impressionID, lineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit, kv),
+		Viewable: fmt.Sprintf("%s/view?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s&kv=%s",
+			trackingBase, impressionID, lineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit, kv),
+		Click: fmt.Sprintf("%s/click?id=%s&li=%d&c=%d&u=%s&p=%s&co=%s&sec=%s&au=%s&kv=%s&url=%s",
+			trackingBase, impressionID, lineItem.ID, selectedCreative.ID, userID, platform, country, section, adUnit, kv, selectedCreative.ClickURL),
+	}
+
+	return &models.AdResult{
+		SlotID:       slot.ID,
+		ImpressionID: impressionID,
+		LineItemID:   lineItem.ID,
+		CreativeID:   selectedCreative.ID,
+		Width:        selectedCreative.Width,
+		Height:       selectedCreative.Height,
+		ImageURL:     selectedCreative.ImageURL,
+		ClickURL:     tracking.Click,
+		TrackingURLs: tracking,
+	}
+}
+
+// requestCountry returns the request's country, falling back to the country key-value
+func requestCountry(req *models.AdRequest) string {
+	country := req.Country
+	if country == "" || country == "unknown" {
+		if tc, ok := req.Targeting["country"]; ok && tc != "" {
+			country = tc
+		}
+	}
+	return country
+}
+
+// requestPlatform returns the request's platform, falling back to the platform key-value
+func requestPlatform(req *models.AdRequest) string {
+	platform := req.Platform
+	if platform == "" || platform == "unknown" {
+		if tp, ok := req.Targeting["platform"]; ok && tp != "" {
+			platform = tp
+		}
+	}
+	return platform
+}
+
+// planRoadblock returns the slots a roadblock line item should fill after
+// winning slot i. Compatible slots are unfilled slots where the line item is
+// eligible. For all-or-none roadblocks, ok is false unless every slot of the
+// request is compatible. For one-or-more roadblocks, other slots are only
+// taken where the line item is in that slot's highest priority tier.
+func planRoadblock(candidates []slotCandidates, filled []*models.AdResult, i int, lineItem models.LineItem) ([]int, bool) {
+	slotIndexes := []int{i}
+
+	for j := range candidates {
+		if j == i {
+			continue
+		}
+
+		compatible := filled[j] == nil && containsLineItem(candidates[j].eligible, lineItem.ID)
+		if !compatible {
+			if lineItem.RoadblockMode == models.RoadblockAllOrNone {
+				return nil, false
+			}
+			continue
 		}
 
-		result := models.AdResult{
-			SlotID:       slot.ID,
-			ImpressionID: impressionID,
-			LineItemID:   selectedLineItem.ID,
-			CreativeID:   selectedCreative.ID,
-			Width:        selectedCreative.Width,
-			Height:       selectedCreative.Height,
-			ImageURL:     selectedCreative.ImageURL,
-			ClickURL:     tracking.Click,
-			TrackingURLs: tracking,
+		if lineItem.RoadblockMode == models.RoadblockOneOrMore && candidates[j].eligible[0].Priority > lineItem.Priority {
+			continue
 		}
 
-		results = append(results, result)
+		slotIndexes = append(slotIndexes, j)
 	}
 
-	return c.JSON(models.AdResponse{Ads: results})
+	return slotIndexes, true
+}
+
+// containsLineItem checks if a line item ID is in the list
+func containsLineItem(lineItems []models.LineItem, id int) bool {
+	for _, li := range lineItems {
+		if li.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLineItem returns the list without the given line item ID
+func removeLineItem(lineItems []models.LineItem, id int) []models.LineItem {
+	var remaining []models.LineItem
+	for _, li := range lineItems {
+		if li.ID != id {
+			remaining = append(remaining, li)
+		}
+	}
+	return remaining
 }
 
 // selectWeightedRandom selects a line item using weighted random selection
@@ -304,26 +747,40 @@ func selectWithSOV(lineItems []models.LineItem) *models.LineItem {
 	return nil
 }
 
-// filterByAdUnit filters line items by ad unit code
-func (h *AdsHandler) filterByAdUnit(lineItems []models.LineItem, adUnitCode string) []models.LineItem {
+// filterByAdUnit filters line items by the code of one of the network's ad units
+func (h *AdsHandler) filterByAdUnit(lineItems []models.LineItem, networkID int, adUnitCode string) []models.LineItem {
 	// Look up ad unit ID by code from cache
-	adUnit := h.cache.GetAdUnitByCode(adUnitCode)
+	adUnit := h.cache.GetAdUnitByCode(networkID, adUnitCode)
 	if adUnit == nil {
 		// Ad unit not found, return all items (no filtering)
 		return lineItems
 	}
 
+	// Placements expand to their ad units: a line item targeting any
+	// placement that contains this ad unit may serve on it
+	placements := h.cache.GetAdUnitPlacements(adUnit.ID)
+
 	var filtered []models.LineItem
 	for _, li := range lineItems {
-		// If line item has no ad unit restrictions, it can serve everywhere
-		if len(li.AdUnitIDs) == 0 {
+		// Excluded ad units (and their descendants) never serve the line item
+		if containsID(li.ExcludedAdUnitIDs, adUnit.ID) {
+			continue
+		}
+
+		// If line item has no ad unit or placement restrictions, it can serve everywhere
+		if !inventory.HasTargets(li.AdUnitTargets) && len(li.PlacementIDs) == 0 {
 			filtered = append(filtered, li)
 			continue
 		}
 
-		// Check if this ad unit is in the line item's allowed ad units
-		for _, allowedID := range li.AdUnitIDs {
-			if allowedID == adUnit.ID {
+		// Check if this ad unit is in the line item's allowed ad units,
+		// resolved from its targeted ad units and their descendants
+		if containsID(li.AdUnitIDs, adUnit.ID) {
+			filtered = append(filtered, li)
+			continue
+		}
+		for _, id := range li.PlacementIDs {
+			if containsID(placements, id) {
 				filtered = append(filtered, li)
 				break
 			}
@@ -332,3 +789,13 @@ func (h *AdsHandler) filterByAdUnit(lineItems []models.LineItem, adUnitCode stri
 
 	return filtered
 }
+
+// containsID checks if an ID is in the list
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
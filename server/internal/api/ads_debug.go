@@ -0,0 +1,163 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// debugAllowed checks that a request may use explain mode on a network's
+// line items: with the X-Debug-Token, when one is configured, for any
+// network; otherwise signed in, or with an API key, of that network with
+// campaigns:read
+func (h *AdsHandler) debugAllowed(c *fiber.Ctx, network int) error {
+	if token := c.Get("X-Debug-Token"); token != "" && h.debugToken != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.debugToken)) != 1 {
+			return NewForbidden("Invalid X-Debug-Token")
+		}
+		return nil
+	}
+
+	if h.auth == nil {
+		return NewForbidden("Explain mode is not available")
+	}
+	if err := h.auth.authenticate(c); err != nil {
+		return err
+	}
+	if err := checkPermission(c, auth.CampaignsRead); err != nil {
+		return err
+	}
+	if networkID(c) != network {
+		return NewForbidden("Explain mode only covers your own network's ad requests")
+	}
+	return nil
+}
+
+// explainSlot runs every active line item through the matching stages for a
+// slot (size, dayparting, targeting, ad unit, frequency cap) and records the
+// first one it fails. Line items that pass are left with an empty stage for
+// the selection stages to fill in.
+func (h *AdsHandler) explainSlot(req *models.AdRequest, sc slotCandidates, lineItems []models.LineItem, userID string) models.SlotDebug {
+	slot := sc.slot
+	sd := models.SlotDebug{SlotID: slot.ID}
+
+	for _, li := range lineItems {
+		var stage, reason string
+		if sc.isResponsive {
+			stage, reason = h.matcher.ExplainResponsive(req.Targeting, li, slot.MaxWidth, sc.adUnitSizes)
+		} else {
+			stage, reason = h.matcher.Explain(req.Targeting, li, slot.Width, slot.Height)
+		}
+
+		if stage == "" && slot.AdUnit != "" && len(h.filterByAdUnit([]models.LineItem{li}, li.NetworkID, slot.AdUnit)) == 0 {
+			stage = models.DebugStageAdUnit
+			reason = fmt.Sprintf("not targeted to ad unit %q", slot.AdUnit)
+		}
+
+		if stage == "" && !h.freqCap.Check(li.ID, userID, li.FrequencyCap) {
+			stage = models.DebugStageFrequency
+			reason = fmt.Sprintf("user has seen it %d times, cap is %d per %s",
+				h.freqCap.GetCount(li.ID, userID), li.FrequencyCap, li.FrequencyCapPeriod)
+		}
+
+		sd.Candidates = append(sd.Candidates, models.CandidateDebug{
+			LineItemID: li.ID,
+			Name:       li.Name,
+			CampaignID: li.CampaignID,
+			Priority:   li.Priority,
+			Stage:      stage,
+			Reason:     reason,
+		})
+	}
+
+	sort.SliceStable(sd.Candidates, func(i, j int) bool {
+		return sd.Candidates[i].Priority > sd.Candidates[j].Priority
+	})
+
+	return sd
+}
+
+// mark records the stage a line item reached in slot i (explain mode only)
+func (st *adRequestState) mark(i, lineItemID int, stage, reason string) {
+	if st.debug == nil {
+		return
+	}
+	for k := range st.debug[i].Candidates {
+		if st.debug[i].Candidates[k].LineItemID == lineItemID {
+			st.debug[i].Candidates[k].Stage = stage
+			st.debug[i].Candidates[k].Reason = reason
+			return
+		}
+	}
+}
+
+// explainSelection records why the eligible line items other than the
+// selected one lost the slot: a lower priority tier or the SOV/weight roll
+func (st *adRequestState) explainSelection(i int, eligible []models.LineItem, selected *models.LineItem) {
+	if st.debug == nil || len(eligible) == 0 {
+		return
+	}
+
+	topPriority := eligible[0].Priority
+	for _, li := range eligible {
+		switch {
+		case selected != nil && li.ID == selected.ID:
+			continue
+		case li.Priority < topPriority:
+			st.mark(i, li.ID, models.DebugStagePriority, fmt.Sprintf("priority %d is below the top eligible priority %d", li.Priority, topPriority))
+		case selected == nil:
+			st.mark(i, li.ID, models.DebugStageSOV, "SOV roll landed in the unsold share of the priority tier")
+		default:
+			st.mark(i, li.ID, models.DebugStageSOV, fmt.Sprintf("lost the SOV/weight roll to line item %d", selected.ID))
+		}
+	}
+}
+
+// explainRoadblock records the winner of every slot it filled, and that the
+// other candidates of slots taken by a roadblock never got a chance
+func (st *adRequestState) explainRoadblock(i int, served []int, winnerID int) {
+	if st.debug == nil {
+		return
+	}
+
+	for _, j := range served {
+		if j == i {
+			st.mark(j, winnerID, models.DebugStageSelected, "")
+			continue
+		}
+		st.mark(j, winnerID, models.DebugStageSelected, fmt.Sprintf("roadblock won in slot %q", st.candidates[i].slot.ID))
+		for _, li := range st.candidates[j].eligible {
+			if li.ID != winnerID {
+				st.mark(j, li.ID, models.DebugStageRoadblock, fmt.Sprintf("slot taken by roadblock line item %d", winnerID))
+			}
+		}
+	}
+}
+
+// finishDebug fills in each slot's outcome and labels line items that passed
+// matching but were never reached by selection
+func (st *adRequestState) finishDebug(unfilled []*models.AdResult) []models.SlotDebug {
+	for i := range st.debug {
+		sd := &st.debug[i]
+		if result := st.filled[i]; result != nil {
+			sd.LineItemID = result.LineItemID
+			sd.Fallback = result.Fallback
+			sd.Reason = result.Reason
+		} else if result := unfilled[i]; result != nil {
+			sd.Fallback = result.Fallback
+			sd.Reason = result.Reason
+		}
+
+		for k := range sd.Candidates {
+			if sd.Candidates[k].Stage == "" {
+				sd.Candidates[k].Stage = models.DebugStageNotSelected
+			}
+		}
+	}
+	return st.debug
+}
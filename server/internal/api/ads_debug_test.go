@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+)
+
+func TestDebugAllowed(t *testing.T) {
+	store := newFakeAuthStore()
+	store.addSession(store.addUser(t, 2, "reporter@example.com", auth.RoleReporter, "active", "password1"), "reporter-session")
+	store.addAPIKey("mak_reader", models.APIKey{NetworkID: 2, Scopes: []string{"campaigns:read"}})
+	store.addAPIKey("mak_reports", models.APIKey{NetworkID: 2, Scopes: []string{"reports:read"}})
+
+	handler := func(debugToken string) *AdsHandler {
+		return &AdsHandler{debugToken: debugToken, auth: &AuthHandler{store: store}}
+	}
+	tests := []struct {
+		name       string
+		h          *AdsHandler
+		network    int
+		token      string
+		debugToken string
+		want       int
+	}{
+		{"no credentials", handler(""), 2, "", "", 401},
+		{"unknown session", handler(""), 2, "nope", "", 401},
+		{"user of the network", handler(""), 2, "reporter-session", "", 200},
+		{"user of another network", handler(""), 3, "reporter-session", "", 403},
+		{"API key with campaigns:read", handler(""), 2, "mak_reader", "", 200},
+		{"API key without campaigns:read", handler(""), 2, "mak_reports", "", 403},
+		{"API key of another network", handler(""), 3, "mak_reader", "", 403},
+		{"static token for any network", handler("s3cret"), 3, "", "s3cret", 200},
+		{"wrong static token", handler("s3cret"), 2, "reporter-session", "guess", 403},
+		{"static token when none is set", handler(""), 2, "", "s3cret", 401},
+		{"sign-in when a static token is set", handler("s3cret"), 2, "reporter-session", "", 200},
+		{"no auth configured", &AdsHandler{}, 2, "reporter-session", "", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+			app.Post("/v1/ads", func(c *fiber.Ctx) error {
+				if err := tt.h.debugAllowed(c, tt.network); err != nil {
+					return err
+				}
+				return c.SendString("ok")
+			})
+
+			req := httptest.NewRequest("POST", "/v1/ads?debug=1", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.debugToken != "" {
+				req.Header.Set("X-Debug-Token", tt.debugToken)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("network %d: status = %d, want %d", tt.network, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
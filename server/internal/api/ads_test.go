@@ -0,0 +1,102 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/exclusion"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/targeting"
+)
+
+func servableCreative(id, width, height int) models.Creative {
+	reviewed := time.Now()
+	return models.Creative{ID: id, Width: width, Height: height, Status: models.CreativeActive, ReviewedAt: &reviewed, Weight: 1}
+}
+
+// roadblockState is a dry-run request for a leaderboard and a companion
+// rectangle, with the same line items eligible in both slots
+func roadblockState(eligible ...models.LineItem) *adRequestState {
+	slots := []models.AdSlot{
+		{ID: "top", Width: 728, Height: 90},
+		{ID: "side", Width: 300, Height: 250},
+	}
+	st := &adRequestState{
+		networkID: models.DefaultNetworkID,
+		req:       &models.AdRequest{Slots: slots},
+		userID:    "user-1",
+		filled:    make([]*models.AdResult, len(slots)),
+		page:      exclusion.NewPage(),
+		dryRun:    true,
+	}
+	for _, slot := range slots {
+		st.candidates = append(st.candidates, slotCandidates{slot: slot, eligible: eligible})
+	}
+	return st
+}
+
+func TestFillSlotAllOrNoneNeedsEveryCreative(t *testing.T) {
+	h := &AdsHandler{matcher: targeting.NewMatcher()}
+
+	// The roadblock has no creative for the companion slot
+	roadblock := models.LineItem{ID: 1, Priority: 10, RoadblockMode: models.RoadblockAllOrNone,
+		Creatives: []models.Creative{servableCreative(11, 728, 90)}}
+	standard := models.LineItem{ID: 2, Priority: 5,
+		Creatives: []models.Creative{servableCreative(21, 728, 90), servableCreative(22, 300, 250)}}
+
+	st := roadblockState(roadblock, standard)
+	served, reason := h.fillSlot(st, 0, st.candidates[0].eligible)
+	if reason != "" {
+		t.Fatalf("fillSlot returned reason %q, want the standard line item to fill the slot", reason)
+	}
+	if len(served) != 1 || served[0] != 0 {
+		t.Errorf("served slots %v, want [0]", served)
+	}
+	if st.filled[0] == nil || st.filled[0].LineItemID != standard.ID {
+		t.Errorf("slot 0 filled with %+v, want line item %d", st.filled[0], standard.ID)
+	}
+	if st.filled[1] != nil {
+		t.Errorf("companion slot filled with line item %d, want it left for its own selection", st.filled[1].LineItemID)
+	}
+
+	// On its own the roadblock leaves the page empty rather than serve part of it
+	st = roadblockState(roadblock)
+	served, reason = h.fillSlot(st, 0, st.candidates[0].eligible)
+	if reason != models.UnfilledRoadblock || served != nil {
+		t.Errorf("fillSlot = %v, %q; want nothing served, reason %q", served, reason, models.UnfilledRoadblock)
+	}
+	for j, result := range st.filled {
+		if result != nil {
+			t.Errorf("slot %d filled with line item %d", j, result.LineItemID)
+		}
+	}
+}
+
+func TestFillSlotAllOrNoneFillsEverySlot(t *testing.T) {
+	h := &AdsHandler{matcher: targeting.NewMatcher()}
+	roadblock := models.LineItem{ID: 1, Priority: 10, RoadblockMode: models.RoadblockAllOrNone,
+		Creatives: []models.Creative{servableCreative(11, 728, 90), servableCreative(12, 300, 250)}}
+
+	st := roadblockState(roadblock)
+	served, reason := h.fillSlot(st, 0, st.candidates[0].eligible)
+	if reason != "" || len(served) != 2 {
+		t.Fatalf("fillSlot = %v, %q; want both slots served", served, reason)
+	}
+	for j, want := range []int{11, 12} {
+		if st.filled[j] == nil || st.filled[j].CreativeID != want {
+			t.Errorf("slot %d filled with %+v, want creative %d", j, st.filled[j], want)
+		}
+	}
+}
+
+func TestFillSlotAsManyAsPossibleServesWhatFits(t *testing.T) {
+	h := &AdsHandler{matcher: targeting.NewMatcher()}
+	roadblock := models.LineItem{ID: 1, Priority: 10, RoadblockMode: models.RoadblockAsManyAsPossible,
+		Creatives: []models.Creative{servableCreative(11, 728, 90)}}
+
+	st := roadblockState(roadblock)
+	served, reason := h.fillSlot(st, 0, st.candidates[0].eligible)
+	if reason != "" || len(served) != 1 || served[0] != 0 {
+		t.Errorf("fillSlot = %v, %q; want only slot 0 served", served, reason)
+	}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// parseCompanyRequest reads and checks the body of an advertiser or agency
+// create or replace request
+func parseCompanyRequest(c *fiber.Ctx) (*models.CompanyRequest, error) {
+	var req models.CompanyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, NewBadRequest("Invalid request body")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, NewBadRequest("Name is required")
+	}
+	if req.Status != "" && req.Status != "active" && req.Status != "inactive" {
+		return nil, NewBadRequest("status must be active or inactive")
+	}
+	for field, email := range map[string]string{"contact_email": req.ContactEmail, "billing_email": req.BillingEmail} {
+		if email == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, NewBadRequest(field + " is not a valid email")
+		}
+	}
+	return &req, nil
+}
+
+// checkCampaignCompanies checks that the advertiser and agency a campaign
+// is being linked to exist in the network. An ID of 0 unlinks and is always
+// allowed.
+func (h *AdminHandler) checkCampaignCompanies(ctx context.Context, networkID, advertiserID, agencyID int) error {
+	if advertiserID != 0 {
+		advertiser, err := h.store.GetAdvertiser(ctx, networkID, advertiserID)
+		if err != nil {
+			return NewInternalError("Failed to check advertiser")
+		}
+		if advertiser == nil {
+			return NewBadRequest("Advertiser " + strconv.Itoa(advertiserID) + " does not exist")
+		}
+	}
+	if agencyID != 0 {
+		agency, err := h.store.GetAgency(ctx, networkID, agencyID)
+		if err != nil {
+			return NewInternalError("Failed to check agency")
+		}
+		if agency == nil {
+			return NewBadRequest("Agency " + strconv.Itoa(agencyID) + " does not exist")
+		}
+	}
+	return nil
+}
+
+// Advertiser handlers
+
+// ListAdvertisers returns all advertisers
+func (h *AdminHandler) ListAdvertisers(c *fiber.Ctx) error {
+	advertisers, err := h.store.ListAdvertisers(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list advertisers")
+	}
+	if advertisers == nil {
+		advertisers = []models.Advertiser{}
+	}
+	return c.JSON(advertisers)
+}
+
+// GetAdvertiser returns a specific advertiser
+func (h *AdminHandler) GetAdvertiser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid advertiser ID")
+	}
+
+	advertiser, err := h.store.GetAdvertiser(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get advertiser")
+	}
+	if advertiser == nil {
+		return NewNotFound("Advertiser not found")
+	}
+
+	return c.JSON(advertiser)
+}
+
+// CreateAdvertiser creates an advertiser
+func (h *AdminHandler) CreateAdvertiser(c *fiber.Ctx) error {
+	req, err := parseCompanyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var advertiser *models.Advertiser
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		advertiser, err = tx.CreateAdvertiser(c.Context(), networkID(c), req)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAdvertiser, strconv.Itoa(advertiser.ID), models.AuditCreate, nil, advertiser)
+	})
+	if err != nil {
+		return NewInternalError("Failed to create advertiser")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(advertiser)
+}
+
+// UpdateAdvertiser replaces an advertiser's details
+func (h *AdminHandler) UpdateAdvertiser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid advertiser ID")
+	}
+	req, err := parseCompanyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	before, err := h.store.GetAdvertiser(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update advertiser")
+	}
+	if before == nil {
+		return NewNotFound("Advertiser not found")
+	}
+
+	var advertiser *models.Advertiser
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		advertiser, err = tx.UpdateAdvertiser(c.Context(), networkID(c), id, req)
+		if err != nil || advertiser == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAdvertiser, strconv.Itoa(id), models.AuditUpdate, before, advertiser)
+	})
+	if err != nil {
+		return NewInternalError("Failed to update advertiser")
+	}
+	if advertiser == nil {
+		return NewNotFound("Advertiser not found")
+	}
+
+	return c.JSON(advertiser)
+}
+
+// DeleteAdvertiser deletes an advertiser that no campaign is linked to
+func (h *AdminHandler) DeleteAdvertiser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid advertiser ID")
+	}
+
+	before, err := h.store.GetAdvertiser(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete advertiser")
+	}
+	if before == nil {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	n, err := h.store.CountCompanyCampaigns(c.Context(), id, false)
+	if err != nil {
+		return NewInternalError("Failed to delete advertiser")
+	}
+	if n > 0 {
+		return NewBadRequest("Advertiser still has " + strconv.Itoa(n) + " campaign(s); move or delete them first")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteAdvertiser(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAdvertiser, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
+		return NewInternalError("Failed to delete advertiser")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Agency handlers
+
+// ListAgencies returns all agencies
+func (h *AdminHandler) ListAgencies(c *fiber.Ctx) error {
+	agencies, err := h.store.ListAgencies(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list agencies")
+	}
+	if agencies == nil {
+		agencies = []models.Agency{}
+	}
+	return c.JSON(agencies)
+}
+
+// GetAgency returns a specific agency
+func (h *AdminHandler) GetAgency(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid agency ID")
+	}
+
+	agency, err := h.store.GetAgency(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get agency")
+	}
+	if agency == nil {
+		return NewNotFound("Agency not found")
+	}
+
+	return c.JSON(agency)
+}
+
+// CreateAgency creates an agency
+func (h *AdminHandler) CreateAgency(c *fiber.Ctx) error {
+	req, err := parseCompanyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var agency *models.Agency
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		agency, err = tx.CreateAgency(c.Context(), networkID(c), req)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAgency, strconv.Itoa(agency.ID), models.AuditCreate, nil, agency)
+	})
+	if err != nil {
+		return NewInternalError("Failed to create agency")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(agency)
+}
+
+// UpdateAgency replaces an agency's details
+func (h *AdminHandler) UpdateAgency(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid agency ID")
+	}
+	req, err := parseCompanyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	before, err := h.store.GetAgency(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update agency")
+	}
+	if before == nil {
+		return NewNotFound("Agency not found")
+	}
+
+	var agency *models.Agency
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		agency, err = tx.UpdateAgency(c.Context(), networkID(c), id, req)
+		if err != nil || agency == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAgency, strconv.Itoa(id), models.AuditUpdate, before, agency)
+	})
+	if err != nil {
+		return NewInternalError("Failed to update agency")
+	}
+	if agency == nil {
+		return NewNotFound("Agency not found")
+	}
+
+	return c.JSON(agency)
+}
+
+// DeleteAgency deletes an agency that no campaign is linked to
+func (h *AdminHandler) DeleteAgency(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid agency ID")
+	}
+
+	before, err := h.store.GetAgency(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete agency")
+	}
+	if before == nil {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	n, err := h.store.CountCompanyCampaigns(c.Context(), id, true)
+	if err != nil {
+		return NewInternalError("Failed to delete agency")
+	}
+	if n > 0 {
+		return NewBadRequest("Agency still has " + strconv.Itoa(n) + " campaign(s); move or delete them first")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeleteAgency(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditAgency, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
+		return NewInternalError("Failed to delete agency")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
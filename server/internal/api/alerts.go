@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// alertListLimit is how many alerts the alert list returns
+const alertListLimit = 200
+
+// AlertsHandler handles delivery alert API requests
+type AlertsHandler struct {
+	store *storage.PostgresStore
+}
+
+// NewAlertsHandler creates a new AlertsHandler
+func NewAlertsHandler(store *storage.PostgresStore) *AlertsHandler {
+	return &AlertsHandler{store: store}
+}
+
+// ListAlerts returns recent alerts, newest first. ?status=open or resolved
+// and ?line_item_id= narrow the list.
+func (h *AlertsHandler) ListAlerts(c *fiber.Ctx) error {
+	status := c.Query("status")
+	if status != "" && status != models.AlertOpen && status != models.AlertResolved {
+		return NewBadRequest("status must be open or resolved")
+	}
+
+	alerts, err := h.store.ListAlerts(c.Context(), networkID(c), status, c.QueryInt("line_item_id"), alertListLimit)
+	if err != nil {
+		return NewInternalError("Failed to list alerts")
+	}
+	if alerts == nil {
+		alerts = []models.Alert{}
+	}
+	return c.JSON(alerts)
+}
+
+// GetAlert returns a specific alert
+func (h *AlertsHandler) GetAlert(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid alert ID")
+	}
+
+	alert, err := h.store.GetAlert(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get alert")
+	}
+	if alert == nil {
+		return NewNotFound("Alert not found")
+	}
+
+	return c.JSON(alert)
+}
+
+// AcknowledgeAlert marks an alert as seen. It stays open until the monitor
+// finds its condition has cleared.
+func (h *AlertsHandler) AcknowledgeAlert(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid alert ID")
+	}
+
+	alert, err := h.store.AcknowledgeAlert(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to acknowledge alert")
+	}
+	if alert == nil {
+		return NewNotFound("Alert not found")
+	}
+
+	return c.JSON(alert)
+}
+
+// ListAlertWebhooks returns all alert webhooks
+func (h *AlertsHandler) ListAlertWebhooks(c *fiber.Ctx) error {
+	webhooks, err := h.store.ListAlertWebhooks(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list alert webhooks")
+	}
+	if webhooks == nil {
+		webhooks = []models.AlertWebhook{}
+	}
+	return c.JSON(webhooks)
+}
+
+// CreateAlertWebhook creates an alert webhook
+func (h *AlertsHandler) CreateAlertWebhook(c *fiber.Ctx) error {
+	var req models.AlertWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+	if err := validateAlertWebhook(&req); err != nil {
+		return err
+	}
+
+	webhook, err := h.store.CreateAlertWebhook(c.Context(), networkID(c), &req)
+	if err != nil {
+		return NewInternalError("Failed to create alert webhook")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(webhook)
+}
+
+// UpdateAlertWebhook replaces an alert webhook
+func (h *AlertsHandler) UpdateAlertWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid alert webhook ID")
+	}
+
+	var req models.AlertWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+	if err := validateAlertWebhook(&req); err != nil {
+		return err
+	}
+
+	webhook, err := h.store.UpdateAlertWebhook(c.Context(), networkID(c), id, &req)
+	if err != nil {
+		return NewInternalError("Failed to update alert webhook")
+	}
+	if webhook == nil {
+		return NewNotFound("Alert webhook not found")
+	}
+
+	return c.JSON(webhook)
+}
+
+// DeleteAlertWebhook deletes an alert webhook
+func (h *AlertsHandler) DeleteAlertWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid alert webhook ID")
+	}
+
+	if err := h.store.DeleteAlertWebhook(c.Context(), networkID(c), id); err != nil {
+		return NewInternalError("Failed to delete alert webhook")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// validateAlertWebhook checks an alert webhook request
+func validateAlertWebhook(req *models.AlertWebhookRequest) error {
+	if req.Name == "" {
+		return NewBadRequest("Name is required")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewBadRequest("url must be an http(s) URL")
+	}
+	for _, t := range req.AlertTypes {
+		if !models.IsValidAlertType(t) {
+			return NewBadRequest("Unknown alert type " + strconv.Quote(t))
+		}
+	}
+	if req.Status != "" && req.Status != "active" && req.Status != "paused" {
+		return NewBadRequest("status must be active or paused")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// ListAPIKeys returns all API keys, without the keys themselves
+func (h *AuthHandler) ListAPIKeys(c *fiber.Ctx) error {
+	keys, err := h.store.ListAPIKeys(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list API keys")
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	return c.JSON(keys)
+}
+
+// CreateAPIKey creates an API key with the given scopes. The response is
+// the only time the key is shown; only its hash is stored.
+func (h *AuthHandler) CreateAPIKey(c *fiber.Ctx) error {
+	var req models.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if req.Name == "" {
+		return NewBadRequest("Name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return NewBadRequest("At least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsValidScope(scope) {
+			return NewBadRequest("Unknown scope " + strconv.Quote(scope))
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return NewBadRequest("expires_at must be in the future")
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return NewInternalError("Failed to create API key")
+	}
+	var createdBy *int
+	if user := currentUser(c); user != nil {
+		createdBy = &user.ID
+	}
+	apiKey, err := h.store.CreateAPIKey(c.Context(), networkID(c), &req, prefix, hash, createdBy)
+	if err != nil {
+		return NewInternalError("Failed to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.CreateAPIKeyResponse{APIKey: *apiKey, Key: key})
+}
+
+// RevokeAPIKey revokes an API key. Requests made with it fail from then on.
+func (h *AuthHandler) RevokeAPIKey(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid API key ID")
+	}
+
+	apiKey, err := h.store.RevokeAPIKey(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to revoke API key")
+	}
+	if apiKey == nil {
+		return NewNotFound("API key not found")
+	}
+
+	return c.JSON(apiKey)
+}
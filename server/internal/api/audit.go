@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+
+	"github.com/mims/ad-manager/internal/audit"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// Audit log page sizes
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// recordAudit appends a change made by a request to the audit log, with the
+// user or API key that made it and the request ID. before is nil for a
+// create and after for a delete; an update that changed nothing isn't
+// recorded. Pass the store of the transaction that makes the change, so the
+// change doesn't happen unless its entry is written too.
+func recordAudit(c *fiber.Ctx, store *storage.PostgresStore, entityType, entityID, action string, before, after interface{}) error {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		return fmt.Errorf("diff %s %s for audit: %w", entityType, entityID, err)
+	}
+	if len(changes) == 0 && action != models.AuditCreate && action != models.AuditDelete {
+		return nil
+	}
+
+	entry := &models.AuditEntry{
+		NetworkID:  networkID(c),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Changes:    changes,
+	}
+	// Clients may send their own X-Request-ID; keep it within the column
+	entry.RequestID, _ = c.Locals(requestid.ConfigDefault.ContextKey).(string)
+	if len(entry.RequestID) > 64 {
+		entry.RequestID = entry.RequestID[:64]
+	}
+	entry.ActorType, entry.ActorID, entry.ActorName = currentActor(c)
+
+	if err := store.CreateAuditEntry(c.Context(), entry); err != nil {
+		return fmt.Errorf("record audit entry for %s %s: %w", entityType, entityID, err)
+	}
+	return nil
+}
+
+// targetingSnapshot is the audited form of a line item's targeting rules.
+// Rule IDs change whenever the rules are replaced, so they are left out.
+func targetingSnapshot(rules []models.TargetingRule) fiber.Map {
+	snapshot := make([]fiber.Map, len(rules))
+	for i, r := range rules {
+		snapshot[i] = fiber.Map{"key": r.Key, "operator": r.Operator, "values": r.Values}
+	}
+	return fiber.Map{"targeting_rules": snapshot}
+}
+
+// daypartsSnapshot is the audited form of a line item's dayparting schedule
+func daypartsSnapshot(dayparts []models.Daypart) fiber.Map {
+	snapshot := make([]fiber.Map, len(dayparts))
+	for i, d := range dayparts {
+		snapshot[i] = fiber.Map{"day_of_week": d.DayOfWeek, "start_time": d.StartTime, "end_time": d.EndTime}
+	}
+	return fiber.Map{"dayparts": snapshot}
+}
+
+// adUnitsSnapshot is the audited form of a line item's ad units, sorted so
+// that reordering them isn't a change
+func adUnitsSnapshot(targets []models.AdUnitTarget) fiber.Map {
+	sorted := append([]models.AdUnitTarget{}, targets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].AdUnitID < sorted[j].AdUnitID })
+	return fiber.Map{"ad_unit_targets": sorted}
+}
+
+// placementsSnapshot is the audited form of a line item's placements, sorted
+// so that reordering them isn't a change
+func placementsSnapshot(ids []int) fiber.Map {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	return fiber.Map{"placement_ids": sorted}
+}
+
+// AuditHandler handles audit log API requests
+type AuditHandler struct {
+	store *storage.PostgresStore
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(store *storage.PostgresStore) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// ListAudit returns audit entries, newest first. They can be narrowed by
+// entity_type, entity_id, actor_type, actor_id, action, and since / until
+// (RFC 3339). before_id pages back from the last entry of a page.
+func (h *AuditHandler) ListAudit(c *fiber.Ctx) error {
+	f := &storage.AuditFilter{
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		ActorType:  c.Query("actor_type"),
+		ActorID:    c.QueryInt("actor_id"),
+		Action:     c.Query("action"),
+	}
+	for param, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return NewBadRequest(param + " must be an RFC 3339 time")
+			}
+			*t = parsed
+		}
+	}
+	return h.list(c, f)
+}
+
+// GetEntityHistory returns the audit entries of one entity, newest first
+func (h *AuditHandler) GetEntityHistory(c *fiber.Ctx) error {
+	return h.list(c, &storage.AuditFilter{
+		EntityType: c.Params("type"),
+		EntityID:   c.Params("id"),
+	})
+}
+
+func (h *AuditHandler) list(c *fiber.Ctx, f *storage.AuditFilter) error {
+	f.NetworkID = networkID(c)
+	f.Limit = c.QueryInt("limit", auditDefaultLimit)
+	if f.Limit < 1 || f.Limit > auditMaxLimit {
+		return NewBadRequest("limit must be between 1 and " + strconv.Itoa(auditMaxLimit))
+	}
+	if v := c.Query("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return NewBadRequest("Invalid before_id")
+		}
+		f.BeforeID = id
+	}
+
+	entries, err := h.store.ListAuditEntries(c.Context(), f)
+	if err != nil {
+		return NewInternalError("Failed to list audit entries")
+	}
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+	return c.JSON(entries)
+}
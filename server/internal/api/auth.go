@@ -0,0 +1,428 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// sessionTTL is how long a session lasts after signing in
+const sessionTTL = 24 * time.Hour
+
+// apiKeyTouchInterval is how stale an API key's last-used time may get
+// before a request updates it, so busy keys don't write on every request
+const apiKeyTouchInterval = time.Minute
+
+// emailUnavailable is the error for creating a user with an email that's
+// already taken. It's the same whichever network has the address, so it
+// doesn't tell one network about another's users.
+const emailUnavailable = "This email address can't be used"
+
+// userLocal and apiKeyLocal are the fiber.Ctx locals the signed-in user or
+// the API key of a request is stored under
+const (
+	userLocal   = "user"
+	apiKeyLocal = "api_key"
+)
+
+// AuthHandler handles sign-in and user management
+type AuthHandler struct {
+	store authStore
+}
+
+// authStore is the storage of users, sessions and API keys AuthHandler
+// uses. PostgresStore implements it.
+type authStore interface {
+	ListUsers(ctx context.Context, networkID int) ([]models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	EmailInUse(ctx context.Context, email string) (bool, error)
+	CreateUser(ctx context.Context, networkID int, req *models.CreateUserRequest, passwordHash string) (*models.User, error)
+	UpdateUser(ctx context.Context, networkID, id int, req *models.UpdateUserRequest, passwordHash string) (*models.User, error)
+	DeleteUser(ctx context.Context, networkID, id int) error
+
+	CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	DeleteUserSessions(ctx context.Context, userID int) error
+
+	ListAPIKeys(ctx context.Context, networkID int) ([]models.APIKey, error)
+	CreateAPIKey(ctx context.Context, networkID int, req *models.CreateAPIKeyRequest, prefix, keyHash string, createdBy *int) (*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, networkID, id int) (*models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int) error
+}
+
+var _ authStore = (*storage.PostgresStore)(nil)
+
+// NewAuthHandler creates a new AuthHandler
+func NewAuthHandler(store *storage.PostgresStore) *AuthHandler {
+	return &AuthHandler{store: store}
+}
+
+// RequireAuth returns middleware that lets a request through only with a
+// valid session token or API key, sent as "Authorization: Bearer <token>".
+// GET requests may pass it as ?access_token= instead, for EventSource
+// streams and download links that can't set headers. The signed-in user or
+// the API key is stored for Require and the handlers.
+func (h *AuthHandler) RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := h.authenticate(c); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// authenticate checks the request's session token or API key and stores the
+// signed-in user or the API key
+func (h *AuthHandler) authenticate(c *fiber.Ctx) error {
+	token := bearerToken(c)
+	if token == "" {
+		return NewUnauthorized("Authentication required")
+	}
+	if auth.IsAPIKey(token) {
+		return h.requireAPIKey(c, token)
+	}
+
+	user, err := h.store.GetSessionUser(c.Context(), auth.HashToken(token))
+	if err != nil {
+		return NewInternalError("Failed to check session")
+	}
+	if user == nil {
+		return NewUnauthorized("Invalid or expired session")
+	}
+
+	c.Locals(userLocal, user)
+	return nil
+}
+
+// requireAPIKey checks an API key and records its use
+func (h *AuthHandler) requireAPIKey(c *fiber.Ctx, key string) error {
+	apiKey, err := h.store.GetAPIKeyByHash(c.Context(), auth.HashToken(key))
+	if err != nil {
+		return NewInternalError("Failed to check API key")
+	}
+	if apiKey == nil || !apiKey.Usable(time.Now()) {
+		return NewUnauthorized("Invalid, revoked or expired API key")
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := h.store.TouchAPIKey(c.Context(), apiKey.ID); err != nil {
+			log.Printf("Warning: Failed to record use of API key %d: %v", apiKey.ID, err)
+		}
+	}
+
+	c.Locals(apiKeyLocal, apiKey)
+	return nil
+}
+
+// Require returns middleware that lets a request through only if the
+// signed-in user's role, or the API key's scopes, grant perm
+func Require(perm auth.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := checkPermission(c, perm); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// checkPermission returns an error unless the signed-in user's role, or the
+// API key's scopes, grant perm
+func checkPermission(c *fiber.Ctx, perm auth.Permission) error {
+	if apiKey := currentAPIKey(c); apiKey != nil {
+		// Keys can't be given users:manage or networks:manage; refuse them
+		// even if a stored key somehow has one
+		if !auth.IsValidScope(string(perm)) || !apiKey.HasScope(string(perm)) {
+			return NewForbidden("This API key does not have the " + string(perm) + " scope")
+		}
+		return nil
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		return NewUnauthorized("Authentication required")
+	}
+	if !auth.RoleHas(user.Role, perm) {
+		return NewForbidden("Your role does not allow " + string(perm))
+	}
+	if perm == auth.NetworksManage && user.NetworkID != models.DefaultNetworkID {
+		return NewForbidden("Only users of the default network can manage networks")
+	}
+	return nil
+}
+
+// currentUser returns the signed-in user, or nil outside RequireAuth and
+// for requests made with an API key
+func currentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals(userLocal).(*models.User)
+	return user
+}
+
+// currentAPIKey returns the API key a request was made with, or nil
+func currentAPIKey(c *fiber.Ctx) *models.APIKey {
+	apiKey, _ := c.Locals(apiKeyLocal).(*models.APIKey)
+	return apiKey
+}
+
+// currentActor returns who made a request: the API key or the signed-in
+// user, with its ID and its name or email. The type is empty for neither.
+func currentActor(c *fiber.Ctx) (actorType string, id int, name string) {
+	if apiKey := currentAPIKey(c); apiKey != nil {
+		return models.ActorAPIKey, apiKey.ID, apiKey.Name
+	}
+	if user := currentUser(c); user != nil {
+		return models.ActorUser, user.ID, user.Email
+	}
+	return "", 0, ""
+}
+
+// networkID returns the network of the signed-in user or API key. Every
+// admin API request only sees and changes that network's data.
+func networkID(c *fiber.Ctx) int {
+	if apiKey := currentAPIKey(c); apiKey != nil {
+		return apiKey.NetworkID
+	}
+	if user := currentUser(c); user != nil {
+		return user.NetworkID
+	}
+	return models.DefaultNetworkID
+}
+
+// bearerToken returns the token of a request's Authorization header, or of
+// its access_token parameter for GET requests
+func bearerToken(c *fiber.Ctx) string {
+	if header := c.Get(fiber.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if c.Method() == fiber.MethodGet {
+		return c.Query("access_token")
+	}
+	return ""
+}
+
+// Login signs in with email and password and returns a session token
+func (h *AuthHandler) Login(c *fiber.Ctx) error {
+	var req models.LoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	user, err := h.store.GetUserByEmail(c.Context(), req.Email)
+	if err != nil {
+		return NewInternalError("Failed to sign in")
+	}
+	hash := ""
+	if user != nil && user.Status == "active" {
+		hash = user.PasswordHash
+	}
+	if !auth.CheckPassword(hash, req.Password) {
+		return NewUnauthorized("Invalid email or password")
+	}
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		return NewInternalError("Failed to sign in")
+	}
+	expiresAt := time.Now().Add(sessionTTL)
+	if err := h.store.CreateSession(c.Context(), user.ID, tokenHash, expiresAt); err != nil {
+		return NewInternalError("Failed to sign in")
+	}
+
+	return c.JSON(models.LoginResponse{Token: token, ExpiresAt: expiresAt, User: *user})
+}
+
+// Logout ends the current session
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	if currentAPIKey(c) != nil {
+		return NewBadRequest("API keys are revoked, not signed out")
+	}
+	if err := h.store.DeleteSession(c.Context(), auth.HashToken(bearerToken(c))); err != nil {
+		return NewInternalError("Failed to sign out")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Me returns the signed-in user and what their role allows, or the API key
+// and its scopes
+func (h *AuthHandler) Me(c *fiber.Ctx) error {
+	if apiKey := currentAPIKey(c); apiKey != nil {
+		return c.JSON(fiber.Map{
+			"api_key":     apiKey,
+			"permissions": apiKey.Scopes,
+		})
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		return NewUnauthorized("Authentication required")
+	}
+	var permissions []auth.Permission
+	for _, p := range auth.RolePermissions(user.Role) {
+		if p != auth.NetworksManage || user.NetworkID == models.DefaultNetworkID {
+			permissions = append(permissions, p)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"user":        user,
+		"permissions": permissions,
+	})
+}
+
+// ListUsers returns all users
+func (h *AuthHandler) ListUsers(c *fiber.Ctx) error {
+	users, err := h.store.ListUsers(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list users")
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	return c.JSON(users)
+}
+
+// CreateUser creates a user
+func (h *AuthHandler) CreateUser(c *fiber.Ctx) error {
+	var req models.CreateUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return NewBadRequest("A valid email is required")
+	}
+	if !auth.IsValidRole(req.Role) {
+		return NewBadRequest("role must be admin, trafficker, sales or reporter")
+	}
+	if len(req.Password) < auth.MinPasswordLength {
+		return NewBadRequest("Password must be at least " + strconv.Itoa(auth.MinPasswordLength) + " characters")
+	}
+
+	// Emails are unique across networks because signing in is by email
+	// alone; the error doesn't say whether the address is taken here or in
+	// another network
+	inUse, err := h.store.EmailInUse(c.Context(), req.Email)
+	if err != nil {
+		return NewInternalError("Failed to create user")
+	}
+	if inUse {
+		return NewBadRequest(emailUnavailable)
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return NewInternalError("Failed to create user")
+	}
+	user, err := h.store.CreateUser(c.Context(), networkID(c), &req, hash)
+	if err != nil {
+		return NewInternalError("Failed to create user")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// UpdateUser changes a user's name, password, role or status. Changing the
+// password or disabling the user signs them out everywhere.
+func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
+	self := currentUser(c)
+	if self == nil {
+		return NewUnauthorized("Authentication required")
+	}
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid user ID")
+	}
+
+	var req models.UpdateUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if req.Role != "" && !auth.IsValidRole(req.Role) {
+		return NewBadRequest("role must be admin, trafficker, sales or reporter")
+	}
+	if req.Status != "" && req.Status != "active" && req.Status != "disabled" {
+		return NewBadRequest("status must be active or disabled")
+	}
+	if req.Password != "" && len(req.Password) < auth.MinPasswordLength {
+		return NewBadRequest("Password must be at least " + strconv.Itoa(auth.MinPasswordLength) + " characters")
+	}
+	if id == self.ID && ((req.Role != "" && req.Role != auth.RoleAdmin) || req.Status == "disabled") {
+		return NewBadRequest("You cannot demote or disable yourself")
+	}
+
+	hash := ""
+	if req.Password != "" {
+		if hash, err = auth.HashPassword(req.Password); err != nil {
+			return NewInternalError("Failed to update user")
+		}
+	}
+
+	user, err := h.store.UpdateUser(c.Context(), networkID(c), id, &req, hash)
+	if err != nil {
+		return NewInternalError("Failed to update user")
+	}
+	if user == nil {
+		return NewNotFound("User not found")
+	}
+
+	if req.Password != "" || req.Status == "disabled" {
+		if err := h.store.DeleteUserSessions(c.Context(), id); err != nil {
+			return NewInternalError("Failed to sign out user")
+		}
+	}
+
+	return c.JSON(user)
+}
+
+// DeleteUser deletes a user and ends their sessions
+func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
+	self := currentUser(c)
+	if self == nil {
+		return NewUnauthorized("Authentication required")
+	}
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid user ID")
+	}
+	if id == self.ID {
+		return NewBadRequest("You cannot delete yourself")
+	}
+
+	if err := h.store.DeleteUser(c.Context(), networkID(c), id); err != nil {
+		return NewInternalError("Failed to delete user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// EnsureAdmin creates an admin with the given email and password when there
+// are no users yet, so a new install can be signed in to. It reports whether
+// one was created.
+func (h *AuthHandler) EnsureAdmin(ctx context.Context, email, password string) (bool, error) {
+	n, err := h.store.CountUsers(ctx)
+	if err != nil || n > 0 || email == "" || password == "" {
+		return false, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	_, err = h.store.CreateUser(ctx, models.DefaultNetworkID, &models.CreateUserRequest{Email: email, Name: "Admin", Role: auth.RoleAdmin}, hash)
+	return err == nil, err
+}
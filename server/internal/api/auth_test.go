@@ -0,0 +1,452 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// fakeAuthStore keeps users, sessions and API keys in memory
+type fakeAuthStore struct {
+	users    []*models.User
+	sessions map[string]int // token hash to user ID
+	expiry   map[string]time.Time
+	apiKeys  map[string]*models.APIKey // key hash to key
+	touched  []int
+	created  []*models.APIKey
+}
+
+func newFakeAuthStore() *fakeAuthStore {
+	return &fakeAuthStore{
+		sessions: make(map[string]int),
+		expiry:   make(map[string]time.Time),
+		apiKeys:  make(map[string]*models.APIKey),
+	}
+}
+
+func (s *fakeAuthStore) addUser(t *testing.T, networkID int, email, role, status, password string) *models.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword returned %v", err)
+	}
+	u := &models.User{ID: len(s.users) + 1, NetworkID: networkID, Email: email, Role: role, Status: status, PasswordHash: hash}
+	s.users = append(s.users, u)
+	return u
+}
+
+func (s *fakeAuthStore) addSession(user *models.User, token string) {
+	s.sessions[auth.HashToken(token)] = user.ID
+	s.expiry[auth.HashToken(token)] = time.Now().Add(time.Hour)
+}
+
+func (s *fakeAuthStore) addAPIKey(key string, k models.APIKey) *models.APIKey {
+	k.ID = len(s.apiKeys) + 1
+	s.apiKeys[auth.HashToken(key)] = &k
+	return &k
+}
+
+func (s *fakeAuthStore) user(id int) *models.User {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *fakeAuthStore) ListUsers(ctx context.Context, networkID int) ([]models.User, error) {
+	return nil, nil
+}
+
+func (s *fakeAuthStore) CountUsers(ctx context.Context) (int, error) {
+	return len(s.users), nil
+}
+
+func (s *fakeAuthStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeAuthStore) EmailInUse(ctx context.Context, email string) (bool, error) {
+	u, err := s.GetUserByEmail(ctx, email)
+	return u != nil, err
+}
+
+func (s *fakeAuthStore) CreateUser(ctx context.Context, networkID int, req *models.CreateUserRequest, passwordHash string) (*models.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeAuthStore) UpdateUser(ctx context.Context, networkID, id int, req *models.UpdateUserRequest, passwordHash string) (*models.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeAuthStore) DeleteUser(ctx context.Context, networkID, id int) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeAuthStore) CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	s.sessions[tokenHash] = userID
+	s.expiry[tokenHash] = expiresAt
+	return nil
+}
+
+// GetSessionUser follows PostgresStore: expired sessions and inactive users
+// have no user
+func (s *fakeAuthStore) GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	id, ok := s.sessions[tokenHash]
+	if !ok || !time.Now().Before(s.expiry[tokenHash]) {
+		return nil, nil
+	}
+	if u := s.user(id); u != nil && u.Status == "active" {
+		return u, nil
+	}
+	return nil, nil
+}
+
+func (s *fakeAuthStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *fakeAuthStore) DeleteUserSessions(ctx context.Context, userID int) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeAuthStore) ListAPIKeys(ctx context.Context, networkID int) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (s *fakeAuthStore) CreateAPIKey(ctx context.Context, networkID int, req *models.CreateAPIKeyRequest, prefix, keyHash string, createdBy *int) (*models.APIKey, error) {
+	k := &models.APIKey{ID: len(s.apiKeys) + 1, NetworkID: networkID, Name: req.Name, Prefix: prefix, Scopes: req.Scopes, CreatedBy: createdBy, ExpiresAt: req.ExpiresAt}
+	s.apiKeys[keyHash] = k
+	s.created = append(s.created, k)
+	return k, nil
+}
+
+func (s *fakeAuthStore) RevokeAPIKey(ctx context.Context, networkID, id int) (*models.APIKey, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeAuthStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	return s.apiKeys[keyHash], nil
+}
+
+func (s *fakeAuthStore) TouchAPIKey(ctx context.Context, id int) error {
+	s.touched = append(s.touched, id)
+	return nil
+}
+
+// newAuthTestApp serves the auth routes and a route per permission, as
+// main.go wires them
+func newAuthTestApp(store *fakeAuthStore) *fiber.App {
+	h := &AuthHandler{store: store}
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Post("/api/auth/login", h.Login)
+	app.Use("/api", h.RequireAuth())
+	app.Post("/api/auth/logout", h.Logout)
+	app.Get("/api/auth/me", h.Me)
+	app.Get("/api/campaigns", Require(auth.CampaignsRead), ok)
+	app.Post("/api/campaigns", Require(auth.CampaignsWrite), ok)
+	app.Post("/api/creatives/:id/approve", Require(auth.CreativesReview), ok)
+	app.Get("/api/audit", Require(auth.AuditRead), ok)
+	app.Get("/api/users", Require(auth.UsersManage), ok)
+	app.Get("/api/networks", Require(auth.NetworksManage), ok)
+	app.Post("/api/api-keys", Require(auth.UsersManage), h.CreateAPIKey)
+	return app
+}
+
+func doRequest(t *testing.T, app *fiber.App, method, target, token, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestRequireAuthAndPermissions(t *testing.T) {
+	store := newFakeAuthStore()
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "admin@example.com", auth.RoleAdmin, "active", "password1"), "admin-session")
+	store.addSession(store.addUser(t, 2, "tenant-admin@example.com", auth.RoleAdmin, "active", "password1"), "tenant-admin-session")
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "trafficker@example.com", auth.RoleTrafficker, "active", "password1"), "trafficker-session")
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "reporter@example.com", auth.RoleReporter, "active", "password1"), "reporter-session")
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "disabled@example.com", auth.RoleAdmin, "disabled", "password1"), "disabled-session")
+	expired := store.addUser(t, models.DefaultNetworkID, "expired@example.com", auth.RoleAdmin, "active", "password1")
+	store.addSession(expired, "expired-session")
+	store.expiry[auth.HashToken("expired-session")] = time.Now().Add(-time.Minute)
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	store.addAPIKey("mak_reader", models.APIKey{NetworkID: models.DefaultNetworkID, Scopes: []string{"campaigns:read"}})
+	store.addAPIKey("mak_expiring", models.APIKey{NetworkID: models.DefaultNetworkID, Scopes: []string{"campaigns:read"}, ExpiresAt: &future})
+	store.addAPIKey("mak_revoked", models.APIKey{NetworkID: models.DefaultNetworkID, Scopes: []string{"campaigns:read"}, RevokedAt: &past})
+	store.addAPIKey("mak_expired", models.APIKey{NetworkID: models.DefaultNetworkID, Scopes: []string{"campaigns:read"}, ExpiresAt: &past})
+	// Keys can't be created with these scopes; one that has them anyway
+	// still can't use them
+	store.addAPIKey("mak_privileged", models.APIKey{NetworkID: models.DefaultNetworkID, Scopes: []string{"users:manage", "networks:manage"}})
+
+	app := newAuthTestApp(store)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no token", "GET", "/api/campaigns", "", 401},
+		{"unknown session", "GET", "/api/campaigns", "nope", 401},
+		{"expired session", "GET", "/api/campaigns", "expired-session", 401},
+		{"disabled user", "GET", "/api/campaigns", "disabled-session", 401},
+
+		{"reporter reads", "GET", "/api/campaigns", "reporter-session", 200},
+		{"reporter can't write", "POST", "/api/campaigns", "reporter-session", 403},
+		{"reporter can't read the audit log", "GET", "/api/audit", "reporter-session", 403},
+		{"trafficker writes", "POST", "/api/campaigns", "trafficker-session", 200},
+		{"trafficker reads the audit log", "GET", "/api/audit", "trafficker-session", 200},
+		{"trafficker can't review", "POST", "/api/creatives/1/approve", "trafficker-session", 403},
+		{"trafficker can't manage users", "GET", "/api/users", "trafficker-session", 403},
+		{"admin reviews", "POST", "/api/creatives/1/approve", "admin-session", 200},
+		{"admin manages users", "GET", "/api/users", "admin-session", 200},
+		{"default network admin manages networks", "GET", "/api/networks", "admin-session", 200},
+		{"other network admin manages users", "GET", "/api/users", "tenant-admin-session", 200},
+		{"other network admin can't manage networks", "GET", "/api/networks", "tenant-admin-session", 403},
+
+		{"API key in scope", "GET", "/api/campaigns", "mak_reader", 200},
+		{"API key before expiry", "GET", "/api/campaigns", "mak_expiring", 200},
+		{"API key out of scope", "POST", "/api/campaigns", "mak_reader", 403},
+		{"unknown API key", "GET", "/api/campaigns", "mak_unknown", 401},
+		{"revoked API key", "GET", "/api/campaigns", "mak_revoked", 401},
+		{"expired API key", "GET", "/api/campaigns", "mak_expired", 401},
+		{"API key can't manage users", "GET", "/api/users", "mak_privileged", 403},
+		{"API key can't manage networks", "GET", "/api/networks", "mak_privileged", 403},
+		{"API key can't create API keys", "POST", "/api/api-keys", "mak_privileged", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, body := doRequest(t, app, tt.method, tt.path, tt.token, ""); got != tt.want {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, got, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	store := newFakeAuthStore()
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "admin@example.com", auth.RoleAdmin, "active", "password1"), "admin-session")
+	app := newAuthTestApp(store)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string
+		want   int
+	}{
+		{"bearer", "GET", "/api/campaigns", "Bearer admin-session", 200},
+		{"scheme is case-insensitive", "GET", "/api/campaigns", "bearer admin-session", 200},
+		{"other scheme", "GET", "/api/campaigns", "Basic admin-session", 401},
+		{"no scheme", "GET", "/api/campaigns", "admin-session", 401},
+		{"access_token on GET", "GET", "/api/campaigns?access_token=admin-session", "", 200},
+		{"access_token on POST", "POST", "/api/campaigns?access_token=admin-session", "", 401},
+		// A header that isn't a bearer token isn't overridden by the query
+		{"header wins", "GET", "/api/campaigns?access_token=admin-session", "Basic x", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyTouch(t *testing.T) {
+	store := newFakeAuthStore()
+	recent := time.Now().Add(-time.Second)
+	stale := time.Now().Add(-time.Hour)
+	unused := store.addAPIKey("mak_unused", models.APIKey{NetworkID: 1, Scopes: []string{"campaigns:read"}})
+	store.addAPIKey("mak_recent", models.APIKey{NetworkID: 1, Scopes: []string{"campaigns:read"}, LastUsedAt: &recent})
+	old := store.addAPIKey("mak_stale", models.APIKey{NetworkID: 1, Scopes: []string{"campaigns:read"}, LastUsedAt: &stale})
+	app := newAuthTestApp(store)
+
+	for _, key := range []string{"mak_unused", "mak_recent", "mak_stale"} {
+		if got, body := doRequest(t, app, "GET", "/api/campaigns", key, ""); got != 200 {
+			t.Fatalf("%s: status %d %s", key, got, body)
+		}
+	}
+	if len(store.touched) != 2 || store.touched[0] != unused.ID || store.touched[1] != old.ID {
+		t.Errorf("touched keys %v, want %d and %d", store.touched, unused.ID, old.ID)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	store := newFakeAuthStore()
+	user := store.addUser(t, 2, "ops@example.com", auth.RoleTrafficker, "active", "password1")
+	store.addUser(t, 2, "gone@example.com", auth.RoleTrafficker, "disabled", "password1")
+	app := newAuthTestApp(store)
+
+	for _, tt := range []struct {
+		name string
+		body string
+	}{
+		{"wrong password", `{"email":"ops@example.com","password":"password2"}`},
+		{"unknown email", `{"email":"nobody@example.com","password":"password1"}`},
+		{"disabled user", `{"email":"gone@example.com","password":"password1"}`},
+		{"no password", `{"email":"ops@example.com"}`},
+	} {
+		got, body := doRequest(t, app, "POST", "/api/auth/login", "", tt.body)
+		if got != 401 {
+			t.Errorf("%s: status %d %s, want 401", tt.name, got, body)
+		}
+		// Every failure reads the same, so it doesn't tell which emails exist
+		if !strings.Contains(body, "Invalid email or password") {
+			t.Errorf("%s: body %s", tt.name, body)
+		}
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("failed logins created %d sessions", len(store.sessions))
+	}
+
+	got, body := doRequest(t, app, "POST", "/api/auth/login", "", `{"email":"ops@example.com","password":"password1"}`)
+	if got != 200 {
+		t.Fatalf("login status %d %s, want 200", got, body)
+	}
+	var resp models.LoginResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	if resp.User.ID != user.ID || strings.Contains(body, user.PasswordHash) {
+		t.Errorf("login response %s, want user %d without the password hash", body, user.ID)
+	}
+	if store.sessions[auth.HashToken(resp.Token)] != user.ID {
+		t.Error("the session isn't stored under the token's hash")
+	}
+	if d := time.Until(resp.ExpiresAt); d < sessionTTL-time.Minute || d > sessionTTL {
+		t.Errorf("session expires in %v, want %v", d, sessionTTL)
+	}
+
+	// The token signs in, with the role's permissions
+	got, body = doRequest(t, app, "GET", "/api/auth/me", resp.Token, "")
+	if got != 200 {
+		t.Fatalf("me status %d %s, want 200", got, body)
+	}
+	var me struct {
+		User        models.User       `json:"user"`
+		Permissions []auth.Permission `json:"permissions"`
+	}
+	if err := json.Unmarshal([]byte(body), &me); err != nil {
+		t.Fatalf("decode me response: %v", err)
+	}
+	if me.User.ID != user.ID || len(me.Permissions) != len(auth.RolePermissions(auth.RoleTrafficker)) {
+		t.Errorf("me = %s, want the trafficker's permissions", body)
+	}
+
+	// Signing out ends the session
+	if got, body := doRequest(t, app, "POST", "/api/auth/logout", resp.Token, ""); got != http.StatusNoContent {
+		t.Fatalf("logout status %d %s, want 204", got, body)
+	}
+	if got, _ := doRequest(t, app, "GET", "/api/auth/me", resp.Token, ""); got != 401 {
+		t.Errorf("me after logout = %d, want 401", got)
+	}
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	store := newFakeAuthStore()
+	store.addSession(store.addUser(t, models.DefaultNetworkID, "admin@example.com", auth.RoleAdmin, "active", "password1"), "admin-session")
+	app := newAuthTestApp(store)
+
+	for _, body := range []string{
+		`{"name":"ci","scopes":["users:manage"]}`,
+		`{"name":"ci","scopes":["campaigns:read","networks:manage"]}`,
+		`{"name":"ci","scopes":["campaigns:*"]}`,
+		`{"name":"ci","scopes":[]}`,
+		`{"scopes":["campaigns:read"]}`,
+		`{"name":"ci","scopes":["campaigns:read"],"expires_at":"2000-01-01T00:00:00Z"}`,
+	} {
+		if got, resp := doRequest(t, app, "POST", "/api/api-keys", "admin-session", body); got != 400 {
+			t.Errorf("creating %s = %d %s, want 400", body, got, resp)
+		}
+	}
+	if len(store.created) != 0 {
+		t.Fatalf("rejected requests created %d keys", len(store.created))
+	}
+
+	got, body := doRequest(t, app, "POST", "/api/api-keys", "admin-session", `{"name":"ci","scopes":["campaigns:read"]}`)
+	if got != 201 {
+		t.Fatalf("create status %d %s, want 201", got, body)
+	}
+	var created models.CreateAPIKeyResponse
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("decode create response: %v", err)
+	}
+	if !auth.IsAPIKey(created.Key) || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Errorf("created key %q with prefix %q", created.Key, created.Prefix)
+	}
+
+	// The new key works within its scope only
+	if got, _ := doRequest(t, app, "GET", "/api/campaigns", created.Key, ""); got != 200 {
+		t.Errorf("new key reading campaigns = %d, want 200", got)
+	}
+	if got, _ := doRequest(t, app, "POST", "/api/campaigns", created.Key, ""); got != 403 {
+		t.Errorf("new key writing campaigns = %d, want 403", got)
+	}
+	if got, _ := doRequest(t, app, "POST", "/api/auth/logout", created.Key, ""); got != 400 {
+		t.Errorf("signing out an API key = %d, want 400", got)
+	}
+}
+
+// TestUserHandlersWithoutUser checks that the handlers that compare against
+// the signed-in user refuse a request that has none rather than panic
+func TestUserHandlersWithoutUser(t *testing.T) {
+	h := &AuthHandler{store: newFakeAuthStore()}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/api/auth/me", h.Me)
+	app.Put("/api/users/:id", h.UpdateUser)
+	app.Delete("/api/users/:id", h.DeleteUser)
+
+	for _, tt := range []struct{ method, path, body string }{
+		{"GET", "/api/auth/me", ""},
+		{"PUT", "/api/users/1", `{"status":"disabled"}`},
+		{"DELETE", "/api/users/1", ""},
+	} {
+		if got, body := doRequest(t, app, tt.method, tt.path, "", tt.body); got != 401 {
+			t.Errorf("%s %s = %d %s, want 401", tt.method, tt.path, got, body)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// Creative review handlers
+
+// ListCreativeReviewQueue returns the creatives waiting for review, longest
+// waiting first
+func (h *AdminHandler) ListCreativeReviewQueue(c *fiber.Ctx) error {
+	creatives, err := h.store.ListPendingCreatives(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list creatives pending review")
+	}
+	if creatives == nil {
+		creatives = []models.Creative{}
+	}
+	return c.JSON(creatives)
+}
+
+// ApproveCreative approves a creative pending review. It still has to be
+// activated before it serves.
+func (h *AdminHandler) ApproveCreative(c *fiber.Ctx) error {
+	return h.reviewCreative(c, models.CreativeApproved, models.AuditApprove)
+}
+
+// RejectCreative rejects a creative pending review with a reason, which is
+// kept on the creative until it is resubmitted
+func (h *AdminHandler) RejectCreative(c *fiber.Ctx) error {
+	return h.reviewCreative(c, models.CreativeRejected, models.AuditReject)
+}
+
+// reviewCreative moves a creative pending review to the given status,
+// recording the reviewer
+func (h *AdminHandler) reviewCreative(c *fiber.Ctx, status, action string) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid creative ID")
+	}
+
+	var req models.ReviewCreativeRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return NewBadRequest("Invalid request body")
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if status == models.CreativeRejected && req.Reason == "" {
+		return NewBadRequest("A reason is required to reject a creative")
+	}
+
+	before, err := h.store.GetCreative(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to review creative")
+	}
+	if before == nil {
+		return NewNotFound("Creative not found")
+	}
+	if before.Status != models.CreativePendingReview {
+		return NewBadRequest("Only creatives pending review can be approved or rejected; this one is " + before.Status)
+	}
+
+	_, _, reviewer := currentActor(c)
+	var creative *models.Creative
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		creative, err = tx.TransitionCreative(c.Context(), networkID(c), id, before.Status, status, reviewer, req.Reason)
+		if err != nil || creative == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditCreative, strconv.Itoa(id), action, before, creative)
+	})
+	if err != nil {
+		return NewInternalError("Failed to review creative")
+	}
+	if creative == nil {
+		return NewBadRequest("Creative status changed during the review; reload it and try again")
+	}
+
+	return c.JSON(creative)
+}
@@ -29,6 +29,16 @@ func NewBadRequest(message string) error {
 	return fiber.NewError(fiber.StatusBadRequest, message)
 }
 
+// NewUnauthorized returns an unauthorized error
+func NewUnauthorized(message string) error {
+	return fiber.NewError(fiber.StatusUnauthorized, message)
+}
+
+// NewForbidden returns a forbidden error
+func NewForbidden(message string) error {
+	return fiber.NewError(fiber.StatusForbidden, message)
+}
+
 // NewNotFound returns a not found error
 func NewNotFound(message string) error {
 	return fiber.NewError(fiber.StatusNotFound, message)
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/forecast"
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// ForecastHandler handles inventory forecasting requests
+type ForecastHandler struct {
+	forecaster *forecast.Forecaster
+}
+
+// NewForecastHandler creates a new ForecastHandler
+func NewForecastHandler(forecaster *forecast.Forecaster) *ForecastHandler {
+	return &ForecastHandler{forecaster: forecaster}
+}
+
+// GetAvailability forecasts matched, available and contending impressions
+// for a proposed line item's targeting and dates
+func (h *ForecastHandler) GetAvailability(c *fiber.Ctx) error {
+	var req models.AvailabilityRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return NewBadRequest("start_date is required (YYYY-MM-DD)")
+	}
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return NewBadRequest("end_date is required (YYYY-MM-DD)")
+	}
+	endDate = endDate.AddDate(0, 0, 1) // Include the end date
+	if !endDate.After(startDate) {
+		return NewBadRequest("end_date must not be before start_date")
+	}
+	if req.Impressions < 0 {
+		return NewBadRequest("Impressions cannot be negative")
+	}
+
+	availability, err := h.forecaster.Availability(c.Context(), networkID(c), &req, startDate, endDate)
+	if err != nil {
+		return NewInternalError("Failed to forecast availability")
+	}
+
+	return c.JSON(fiber.Map{
+		"availability": availability,
+		"start_date":   req.StartDate,
+		"end_date":     req.EndDate,
+	})
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/live"
+)
+
+const (
+	// defaultLiveInterval is how often the live feed pushes stats, in seconds
+	defaultLiveInterval = 5
+	// defaultLiveWindow is the window live stats cover, in minutes
+	defaultLiveWindow = 15
+)
+
+// LiveHandler serves live delivery stats from the in-memory counter
+type LiveHandler struct {
+	counter *live.Counter
+}
+
+// NewLiveHandler creates a new LiveHandler
+func NewLiveHandler(counter *live.Counter) *LiveHandler {
+	return &LiveHandler{counter: counter}
+}
+
+// StreamLive pushes live stats as Server-Sent Events: a "stats" event with a
+// snapshot right away, then every interval seconds (1-60, default 5) until
+// the client disconnects. window is the minutes covered (1-60, default 15);
+// line_item_id and ad_unit narrow the stats, which only ever cover the
+// caller's network.
+func (h *LiveHandler) StreamLive(c *fiber.Ctx) error {
+	window, filter, err := liveQuery(c)
+	if err != nil {
+		return err
+	}
+	interval := c.QueryInt("interval", defaultLiveInterval)
+	if interval < 1 || interval > 60 {
+		return NewBadRequest("interval must be between 1 and 60 seconds")
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		fmt.Fprintf(w, "retry: %d\n\n", interval*1000)
+
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			data, err := json.Marshal(h.counter.Snapshot(window, filter))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data)
+			// Flush fails once the client has gone away
+			if err := w.Flush(); err != nil {
+				return
+			}
+			<-ticker.C
+		}
+	})
+	return nil
+}
+
+// GetLiveSnapshot returns the current live stats once, for clients that
+// poll instead of subscribing. It takes the same window and filters as the
+// stream.
+func (h *LiveHandler) GetLiveSnapshot(c *fiber.Ctx) error {
+	window, filter, err := liveQuery(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(h.counter.Snapshot(window, filter))
+}
+
+// liveQuery parses the window and filters of a live stats request
+func liveQuery(c *fiber.Ctx) (int, live.Filter, error) {
+	window := c.QueryInt("window", defaultLiveWindow)
+	if window < 1 || window > 60 {
+		return 0, live.Filter{}, NewBadRequest("window must be between 1 and 60 minutes")
+	}
+	filter := live.Filter{
+		NetworkID:  networkID(c),
+		LineItemID: c.QueryInt("line_item_id"),
+		AdUnit:     c.Query("ad_unit"),
+	}
+	return window, filter, nil
+}
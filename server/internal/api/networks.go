@@ -0,0 +1,195 @@
+package api
+
+import (
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/auth"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// NetworksHandler handles network (tenant) management. Only admins of the
+// default network reach it.
+type NetworksHandler struct {
+	store *storage.PostgresStore
+	cache *storage.InMemoryCache
+}
+
+// NewNetworksHandler creates a new NetworksHandler
+func NewNetworksHandler(store *storage.PostgresStore, cache *storage.InMemoryCache) *NetworksHandler {
+	return &NetworksHandler{store: store, cache: cache}
+}
+
+// ListNetworks returns all networks
+func (h *NetworksHandler) ListNetworks(c *fiber.Ctx) error {
+	networks, err := h.store.ListNetworks(c.Context())
+	if err != nil {
+		return NewInternalError("Failed to list networks")
+	}
+	if networks == nil {
+		networks = []models.Network{}
+	}
+	return c.JSON(networks)
+}
+
+// GetNetwork returns a specific network
+func (h *NetworksHandler) GetNetwork(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid network ID")
+	}
+
+	network, err := h.store.GetNetwork(c.Context(), id)
+	if err != nil {
+		return NewInternalError("Failed to get network")
+	}
+	if network == nil {
+		return NewNotFound("Network not found")
+	}
+
+	return c.JSON(network)
+}
+
+// CreateNetwork creates a network and, when an admin email and password are
+// given, its first admin user, who can then sign in and set the network up
+func (h *NetworksHandler) CreateNetwork(c *fiber.Ctx) error {
+	var req models.CreateNetworkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if req.Code == "" {
+		return NewBadRequest("Code is required")
+	}
+	if req.Name == "" {
+		return NewBadRequest("Name is required")
+	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return NewBadRequest("Invalid timezone")
+		}
+	}
+	withAdmin := req.AdminEmail != "" || req.AdminPassword != ""
+	if withAdmin {
+		if _, err := mail.ParseAddress(req.AdminEmail); err != nil {
+			return NewBadRequest("A valid admin_email is required")
+		}
+		if len(req.AdminPassword) < auth.MinPasswordLength {
+			return NewBadRequest("admin_password must be at least " + strconv.Itoa(auth.MinPasswordLength) + " characters")
+		}
+		inUse, err := h.store.EmailInUse(c.Context(), req.AdminEmail)
+		if err != nil {
+			return NewInternalError("Failed to create network")
+		}
+		if inUse {
+			return NewBadRequest(emailUnavailable)
+		}
+	}
+
+	existing, err := h.store.GetNetworkByCode(c.Context(), req.Code)
+	if err != nil {
+		return NewInternalError("Failed to create network")
+	}
+	if existing != nil {
+		return NewBadRequest("A network with this code already exists")
+	}
+
+	var admin *models.CreateUserRequest
+	var hash string
+	if withAdmin {
+		hash, err = auth.HashPassword(req.AdminPassword)
+		if err != nil {
+			return NewInternalError("Failed to create network admin")
+		}
+		name := req.AdminName
+		if name == "" {
+			name = "Admin"
+		}
+		admin = &models.CreateUserRequest{Email: req.AdminEmail, Name: name, Role: auth.RoleAdmin}
+	}
+
+	// The network and its first admin are created together, so a failed
+	// admin doesn't leave a network nobody can sign in to
+	var network *models.Network
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		network, err = tx.CreateNetwork(c.Context(), &req)
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(c, tx, models.AuditNetwork, strconv.Itoa(network.ID), models.AuditCreate, nil, network); err != nil {
+			return err
+		}
+		if admin != nil {
+			if _, err := tx.CreateUser(c.Context(), network.ID, admin, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return NewInternalError("Failed to create network")
+	}
+
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.Status(fiber.StatusCreated).JSON(network)
+}
+
+// UpdateNetwork renames a network or changes its status or timezone.
+// Deactivating a network stops its ads and signs its users and API keys out.
+func (h *NetworksHandler) UpdateNetwork(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid network ID")
+	}
+
+	var req models.UpdateNetworkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	if req.Status != "" && req.Status != "active" && req.Status != "inactive" {
+		return NewBadRequest("status must be active or inactive")
+	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return NewBadRequest("Invalid timezone")
+		}
+	}
+	if id == models.DefaultNetworkID && req.Status == "inactive" {
+		return NewBadRequest("The default network cannot be deactivated")
+	}
+
+	before, err := h.store.GetNetwork(c.Context(), id)
+	if err != nil {
+		return NewInternalError("Failed to update network")
+	}
+	if before == nil {
+		return NewNotFound("Network not found")
+	}
+
+	var network *models.Network
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		network, err = tx.UpdateNetwork(c.Context(), id, &req)
+		if err != nil || network == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditNetwork, strconv.Itoa(id), models.AuditUpdate, before, network)
+	})
+	if err != nil {
+		return NewInternalError("Failed to update network")
+	}
+	if network == nil {
+		return NewNotFound("Network not found")
+	}
+
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.JSON(network)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// parsePlacementRequest reads and checks the body of a placement create or
+// replace request. Every ad unit must exist in the caller's network.
+func (h *AdminHandler) parsePlacementRequest(c *fiber.Ctx) (*models.PlacementRequest, error) {
+	var req models.PlacementRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, NewBadRequest("Invalid request body")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, NewBadRequest("Name is required")
+	}
+	if len(req.AdUnitIDs) == 0 {
+		return nil, NewBadRequest("At least one ad unit is required")
+	}
+
+	units, err := h.store.ListAdUnits(c.Context(), networkID(c))
+	if err != nil {
+		return nil, NewInternalError("Failed to check ad units")
+	}
+	known := make(map[int]bool, len(units))
+	for _, u := range units {
+		known[u.ID] = true
+	}
+	for _, id := range req.AdUnitIDs {
+		if !known[id] {
+			return nil, NewBadRequest("Ad unit " + strconv.Itoa(id) + " does not exist")
+		}
+	}
+	return &req, nil
+}
+
+// checkPlacementName returns a bad request error if another placement of the
+// network already has the name
+func (h *AdminHandler) checkPlacementName(c *fiber.Ctx, id int, name string) error {
+	existing, err := h.store.GetPlacementByName(c.Context(), networkID(c), name)
+	if err != nil {
+		return NewInternalError("Failed to check placement name")
+	}
+	if existing != nil && existing.ID != id {
+		return NewBadRequest("A placement with this name already exists")
+	}
+	return nil
+}
+
+// Placement handlers
+
+// ListPlacements returns all placements
+func (h *AdminHandler) ListPlacements(c *fiber.Ctx) error {
+	placements, err := h.store.ListPlacements(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list placements")
+	}
+	if placements == nil {
+		placements = []models.Placement{}
+	}
+	return c.JSON(placements)
+}
+
+// GetPlacement returns a specific placement
+func (h *AdminHandler) GetPlacement(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid placement ID")
+	}
+
+	placement, err := h.store.GetPlacement(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get placement")
+	}
+	if placement == nil {
+		return NewNotFound("Placement not found")
+	}
+
+	return c.JSON(placement)
+}
+
+// CreatePlacement creates a placement
+func (h *AdminHandler) CreatePlacement(c *fiber.Ctx) error {
+	req, err := h.parsePlacementRequest(c)
+	if err != nil {
+		return err
+	}
+	if err := h.checkPlacementName(c, 0, req.Name); err != nil {
+		return err
+	}
+
+	var placement *models.Placement
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		placement, err = tx.CreatePlacement(c.Context(), networkID(c), req)
+		if err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditPlacement, strconv.Itoa(placement.ID), models.AuditCreate, nil, placement)
+	})
+	if err != nil {
+		return NewInternalError("Failed to create placement")
+	}
+
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.Status(fiber.StatusCreated).JSON(placement)
+}
+
+// UpdatePlacement replaces a placement's name, description and ad units.
+// Line items targeting it serve on the new ad units right away.
+func (h *AdminHandler) UpdatePlacement(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid placement ID")
+	}
+	req, err := h.parsePlacementRequest(c)
+	if err != nil {
+		return err
+	}
+
+	before, err := h.store.GetPlacement(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to update placement")
+	}
+	if before == nil {
+		return NewNotFound("Placement not found")
+	}
+	if err := h.checkPlacementName(c, id, req.Name); err != nil {
+		return err
+	}
+
+	var placement *models.Placement
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		var err error
+		placement, err = tx.UpdatePlacement(c.Context(), networkID(c), id, req)
+		if err != nil || placement == nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditPlacement, strconv.Itoa(id), models.AuditUpdate, before, placement)
+	})
+	if err != nil {
+		return NewInternalError("Failed to update placement")
+	}
+	if placement == nil {
+		return NewNotFound("Placement not found")
+	}
+
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.JSON(placement)
+}
+
+// DeletePlacement deletes a placement that no line item targets
+func (h *AdminHandler) DeletePlacement(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid placement ID")
+	}
+
+	before, err := h.store.GetPlacement(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to delete placement")
+	}
+	if before == nil {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	n, err := h.store.CountPlacementLineItems(c.Context(), id)
+	if err != nil {
+		return NewInternalError("Failed to delete placement")
+	}
+	if n > 0 {
+		return NewBadRequest("Placement is still targeted by " + strconv.Itoa(n) + " line item(s); remove it from them first")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.DeletePlacement(c.Context(), networkID(c), id); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditPlacement, strconv.Itoa(id), models.AuditDelete, before, nil)
+	})
+	if err != nil {
+		return NewInternalError("Failed to delete placement")
+	}
+
+	h.cache.Refresh(c.Context(), h.store)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetLineItemPlacements returns the placements a line item targets
+func (h *AdminHandler) GetLineItemPlacements(c *fiber.Ctx) error {
+	lineItemID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid line item ID")
+	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
+
+	ids, err := h.store.GetLineItemPlacements(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get line item placements")
+	}
+	if ids == nil {
+		ids = []int{}
+	}
+
+	return c.JSON(fiber.Map{"placement_ids": ids})
+}
+
+// SetLineItemPlacements sets the placements a line item targets. The line
+// item serves on their ad units as well as its own targeted ad units, and
+// its excluded ad units still apply.
+func (h *AdminHandler) SetLineItemPlacements(c *fiber.Ctx) error {
+	lineItemID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid line item ID")
+	}
+	if err := h.checkLineItem(c, lineItemID); err != nil {
+		return err
+	}
+
+	var req struct {
+		PlacementIDs []int `json:"placement_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+	for _, id := range req.PlacementIDs {
+		placement, err := h.store.GetPlacement(c.Context(), networkID(c), id)
+		if err != nil {
+			return NewInternalError("Failed to set line item placements")
+		}
+		if placement == nil {
+			return NewBadRequest("Placement " + strconv.Itoa(id) + " does not exist")
+		}
+	}
+
+	before, err := h.store.GetLineItemPlacements(c.Context(), lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to set line item placements")
+	}
+
+	err = h.store.InTx(c.Context(), func(tx *storage.PostgresStore) error {
+		if err := tx.SetLineItemPlacements(c.Context(), lineItemID, req.PlacementIDs); err != nil {
+			return err
+		}
+		return recordAudit(c, tx, models.AuditLineItem, strconv.Itoa(lineItemID), models.AuditSetPlacements,
+			placementsSnapshot(before), placementsSnapshot(req.PlacementIDs))
+	})
+	if err != nil {
+		return NewInternalError("Failed to set line item placements")
+	}
+
+	// Refresh cache
+	h.cache.Refresh(c.Context(), h.store)
+
+	if req.PlacementIDs == nil {
+		req.PlacementIDs = []int{}
+	}
+	return c.JSON(fiber.Map{"placement_ids": req.PlacementIDs})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// QueryReport runs a report builder query: any combination of dimensions
+// and metrics, with filters, sorting and a row limit
+func (h *ReportsHandler) QueryReport(c *fiber.Ctx) error {
+	var req models.ReportQueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	tz, err := reportTimezone(c, h.store, req.Timezone)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return NewBadRequest("Invalid timezone")
+	}
+
+	startDate, endDate := dateRange(req.StartDate, req.EndDate, loc)
+	if !endDate.After(startDate) {
+		return NewBadRequest("end_date must not be before start_date")
+	}
+
+	q, err := storage.NewReportQuery(networkID(c), &req, startDate, endDate, tz)
+	if err != nil {
+		return NewBadRequest(err.Error())
+	}
+	if err := checkReportKeys(c, h.store, q); err != nil {
+		return err
+	}
+
+	result, err := h.reports.RunReportQuery(c.Context(), q)
+	if err != nil {
+		return NewInternalError("Failed to run report query")
+	}
+
+	return c.JSON(models.ReportQueryResponse{
+		Columns:   result.Columns,
+		Rows:      reportRows(result),
+		StartDate: startDate.Format("2006-01-02"),
+		EndDate:   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+		Timezone:  tz,
+	})
+}
+
+// reportRows turns report result rows into objects keyed by column name
+func reportRows(result *storage.ReportResult) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(result.Rows))
+	for _, values := range result.Rows {
+		row := make(map[string]interface{}, len(values))
+		for i, column := range result.Columns {
+			row[column] = values[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// checkReportKeys returns a bad request error if the query groups or filters
+// by a key that is not one of the network's targeting keys
+func checkReportKeys(c *fiber.Ctx, store *storage.PostgresStore, q *storage.ReportQuery) error {
+	key, err := store.UnknownTargetingKey(c.Context(), networkID(c), q.Keys())
+	if err != nil {
+		return NewInternalError("Failed to check targeting keys")
+	}
+	if key != "" {
+		return NewBadRequest(fmt.Sprintf("unknown targeting key %q", key))
+	}
+	return nil
+}
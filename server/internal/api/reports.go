@@ -1,39 +1,64 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 
+	"github.com/mims/ad-manager/internal/export"
+	"github.com/mims/ad-manager/internal/inventory"
+	"github.com/mims/ad-manager/internal/models"
 	"github.com/mims/ad-manager/internal/storage"
 )
 
 // ReportsHandler handles reporting API requests
 type ReportsHandler struct {
-	store *storage.PostgresStore
+	store      *storage.PostgresStore
+	reports    storage.ReportReader
+	exportJobs *export.JobRunner
 }
 
 // NewReportsHandler creates a new ReportsHandler
 func NewReportsHandler(store *storage.PostgresStore) *ReportsHandler {
-	return &ReportsHandler{store: store}
+	return &ReportsHandler{store: store, reports: store}
+}
+
+// SetExportJobs sets the runner large exports are queued for
+func (h *ReportsHandler) SetExportJobs(runner *export.JobRunner) {
+	h.exportJobs = runner
+}
+
+// SetReportReader sets where the delivery reports are read from (Postgres by
+// default). Fill-rate and request key reports always come from Postgres.
+func (h *ReportsHandler) SetReportReader(reports storage.ReportReader) {
+	h.reports = reports
 }
 
 // GetSummary returns overall stats
 func (h *ReportsHandler) GetSummary(c *fiber.Ctx) error {
 	// Parse date range (default to last 7 days)
-	startDate, endDate := h.parseDateRange(c)
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 	adUnit := c.Query("ad_unit", "")
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
 
-	summary, err := h.store.GetReportSummary(c.Context(), startDate, endDate, adUnit)
+	summary, err := h.reports.GetReportSummary(c.Context(), networkID(c), startDate, endDate, adUnit, advertiserID)
 	if err != nil {
 		return NewInternalError("Failed to get summary report")
 	}
 
 	return c.JSON(fiber.Map{
 		"summary":    summary,
+		"timezone":   tz,
 		"start_date": startDate.Format("2006-01-02"),
 		"end_date":   endDate.Format("2006-01-02"),
 	})
@@ -41,10 +66,14 @@ func (h *ReportsHandler) GetSummary(c *fiber.Ctx) error {
 
 // GetDailyReport returns daily stats
 func (h *ReportsHandler) GetDailyReport(c *fiber.Ctx) error {
-	startDate, endDate := h.parseDateRange(c)
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 	adUnit := c.Query("ad_unit", "")
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
 
-	daily, err := h.store.GetDailyReport(c.Context(), startDate, endDate, adUnit)
+	daily, err := h.reports.GetDailyReport(c.Context(), networkID(c), startDate, endDate, tz, adUnit, advertiserID)
 	if err != nil {
 		return NewInternalError("Failed to get daily report")
 	}
@@ -55,11 +84,41 @@ func (h *ReportsHandler) GetDailyReport(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{
 		"daily":      daily,
+		"timezone":   tz,
 		"start_date": startDate.Format("2006-01-02"),
 		"end_date":   endDate.Format("2006-01-02"),
 	})
 }
 
+// GetHourlyReport returns stats grouped by hour of day, used to check
+// delivery against dayparting schedules
+func (h *ReportsHandler) GetHourlyReport(c *fiber.Ctx) error {
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+	adUnit := c.Query("ad_unit", "")
+	campaignID, _ := strconv.Atoi(c.Query("campaign_id"))
+	lineItemID, _ := strconv.Atoi(c.Query("line_item_id"))
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
+
+	hourly, err := h.reports.GetHourlyReport(c.Context(), networkID(c), startDate, endDate, tz, campaignID, lineItemID, adUnit, advertiserID)
+	if err != nil {
+		return NewInternalError("Failed to get hourly report")
+	}
+
+	if hourly == nil {
+		hourly = []storage.HourlyStats{}
+	}
+
+	return c.JSON(fiber.Map{
+		"hourly":     hourly,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
 // GetCampaignReport returns stats for a specific campaign
 func (h *ReportsHandler) GetCampaignReport(c *fiber.Ctx) error {
 	campaignID, err := strconv.Atoi(c.Params("id"))
@@ -67,9 +126,12 @@ func (h *ReportsHandler) GetCampaignReport(c *fiber.Ctx) error {
 		return NewBadRequest("Invalid campaign ID")
 	}
 
-	startDate, endDate := h.parseDateRange(c)
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 
-	report, err := h.store.GetCampaignReport(c.Context(), campaignID, startDate, endDate)
+	report, err := h.reports.GetCampaignReport(c.Context(), networkID(c), campaignID, startDate, endDate)
 	if err != nil {
 		return NewInternalError("Failed to get campaign report")
 	}
@@ -79,25 +141,57 @@ func (h *ReportsHandler) GetCampaignReport(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{
 		"campaign":   report,
+		"timezone":   tz,
 		"start_date": startDate.Format("2006-01-02"),
 		"end_date":   endDate.Format("2006-01-02"),
 	})
 }
 
-// parseDateRange extracts start and end dates from query params
-func (h *ReportsHandler) parseDateRange(c *fiber.Ctx) (time.Time, time.Time) {
-	now := time.Now()
-	endDate := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+// parseDateRange extracts the timezone (tz, defaulting to the network
+// timezone) and the start and end dates from query params. Dates are
+// midnights in that timezone.
+func (h *ReportsHandler) parseDateRange(c *fiber.Ctx) (time.Time, time.Time, string, error) {
+	tz, err := reportTimezone(c, h.store, c.Query("tz"))
+	if err != nil {
+		return time.Time{}, time.Time{}, "", err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, time.Time{}, "", NewBadRequest("Invalid timezone")
+	}
+
+	startDate, endDate := dateRange(c.Query("start_date"), c.Query("end_date"), loc)
+	return startDate, endDate, tz, nil
+}
+
+// reportTimezone returns tz, or the timezone of the request's network when
+// tz is empty
+func reportTimezone(c *fiber.Ctx, store *storage.PostgresStore, tz string) (string, error) {
+	if tz != "" {
+		return tz, nil
+	}
+	tz, err := store.GetNetworkTimezone(c.Context(), networkID(c))
+	if err != nil {
+		return "", NewInternalError("Failed to load network timezone")
+	}
+	return tz, nil
+}
+
+// dateRange parses a YYYY-MM-DD start and inclusive end date into a
+// [start, end) range of midnights in loc, defaulting to the last 7 days
+func dateRange(start, end string, loc *time.Location) (time.Time, time.Time) {
+	now := time.Now().In(loc)
+	endDate := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
 	startDate := endDate.AddDate(0, 0, -7)
 
-	if start := c.Query("start_date"); start != "" {
-		if parsed, err := time.Parse("2006-01-02", start); err == nil {
+	if start != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", start, loc); err == nil {
 			startDate = parsed
 		}
 	}
 
-	if end := c.Query("end_date"); end != "" {
-		if parsed, err := time.Parse("2006-01-02", end); err == nil {
+	if end != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", end, loc); err == nil {
 			endDate = parsed.AddDate(0, 0, 1) // Include the end date
 		}
 	}
@@ -108,10 +202,14 @@ func (h *ReportsHandler) parseDateRange(c *fiber.Ctx) (time.Time, time.Time) {
 // GetKeyValueReport returns stats grouped by a key
 func (h *ReportsHandler) GetKeyValueReport(c *fiber.Ctx) error {
 	key := c.Query("key", "country")
-	startDate, endDate := h.parseDateRange(c)
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 	adUnit := c.Query("ad_unit", "")
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
 
-	stats, err := h.store.GetKeyValueReport(c.Context(), key, startDate, endDate, adUnit)
+	stats, err := h.reports.GetKeyValueReport(c.Context(), networkID(c), key, startDate, endDate, adUnit, advertiserID)
 	if err != nil {
 		return NewInternalError("Failed to get key-value report")
 	}
@@ -123,6 +221,187 @@ func (h *ReportsHandler) GetKeyValueReport(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"key":        key,
 		"data":       stats,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
+// GetFillRateReport returns ad slot requests and fill rate grouped by ad unit
+// (the default) or by the values pages send for a targeting key
+func (h *ReportsHandler) GetFillRateReport(c *fiber.Ctx) error {
+	key := c.Query("key", "ad_unit")
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+	adUnit := c.Query("ad_unit", "")
+
+	stats, err := h.store.GetFillRateReport(c.Context(), networkID(c), key, startDate, endDate, adUnit)
+	if err != nil {
+		return NewInternalError("Failed to get fill rate report")
+	}
+
+	return c.JSON(fiber.Map{
+		"key":        key,
+		"data":       stats,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
+// GetAdUnitReport returns requests, fill rate and delivery per ad unit,
+// both the ad unit's own and rolled up with every ad unit below it. Rows are
+// in hierarchy order, each parent before its children.
+func (h *ReportsHandler) GetAdUnitReport(c *fiber.Ctx) error {
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	units, err := h.store.ListAdUnits(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to get ad unit report")
+	}
+	tree := inventory.NewTree(units)
+	byCode := make(map[string]models.AdUnit, len(units))
+	for _, u := range units {
+		byCode[u.Code] = u
+	}
+
+	own := make(map[int]*models.AdUnitCounts, len(units))
+	counts := func(code string) *models.AdUnitCounts {
+		u, ok := byCode[code]
+		if !ok {
+			return nil
+		}
+		if own[u.ID] == nil {
+			own[u.ID] = &models.AdUnitCounts{}
+		}
+		return own[u.ID]
+	}
+
+	fill, err := h.store.GetFillRateReport(c.Context(), networkID(c), "ad_unit", startDate, endDate, "")
+	if err != nil {
+		return NewInternalError("Failed to get ad unit report")
+	}
+	for _, fs := range fill {
+		if n := counts(fs.Value); n != nil {
+			n.Requests += int64(fs.Requests)
+			n.Filled += int64(fs.Filled)
+		}
+	}
+
+	q, err := storage.NewReportQuery(networkID(c), &models.ReportQueryRequest{
+		Dimensions: []string{"ad_unit"},
+		Metrics:    []string{"impressions", "clicks", "viewable"},
+		Limit:      storage.MaxReportLimit,
+	}, startDate, endDate, tz)
+	if err != nil {
+		return NewInternalError("Failed to get ad unit report")
+	}
+	delivery, err := h.reports.RunReportQuery(c.Context(), q)
+	if err != nil {
+		return NewInternalError("Failed to get ad unit report")
+	}
+	for _, row := range delivery.Rows {
+		code, _ := row[0].(string)
+		if n := counts(code); n != nil {
+			n.Impressions += countValue(row[1])
+			n.Clicks += countValue(row[2])
+			n.Viewable += countValue(row[3])
+		}
+	}
+
+	total := make(map[int]*models.AdUnitCounts, len(units))
+	for id, n := range own {
+		for _, target := range append([]int{id}, tree.Ancestors(id)...) {
+			if total[target] == nil {
+				total[target] = &models.AdUnitCounts{}
+			}
+			t := total[target]
+			t.Requests += n.Requests
+			t.Filled += n.Filled
+			t.Impressions += n.Impressions
+			t.Clicks += n.Clicks
+			t.Viewable += n.Viewable
+		}
+	}
+
+	rows := []models.AdUnitReportRow{}
+	tree.Walk(func(u models.AdUnit, depth int) {
+		row := models.AdUnitReportRow{
+			AdUnitID: u.ID,
+			ParentID: u.ParentID,
+			Code:     u.Code,
+			Name:     u.Name,
+			Path:     tree.Path(u.ID),
+			Depth:    depth,
+		}
+		if n := own[u.ID]; n != nil {
+			row.Own = withRates(*n)
+		}
+		if n := total[u.ID]; n != nil {
+			row.Total = withRates(*n)
+		}
+		rows = append(rows, row)
+	})
+
+	return c.JSON(fiber.Map{
+		"data":       rows,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
+// withRates fills in the fill rate and CTR of ad unit counts, in percent
+func withRates(n models.AdUnitCounts) models.AdUnitCounts {
+	if n.Requests > 0 {
+		n.FillRate = float64(n.Filled) / float64(n.Requests) * 100
+	}
+	if n.Impressions > 0 {
+		n.CTR = float64(n.Clicks) / float64(n.Impressions) * 100
+	}
+	return n
+}
+
+// countValue converts a count column of a report query row to an integer
+func countValue(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// GetRequestKeysReport returns the targeting keys pages actually send
+func (h *ReportsHandler) GetRequestKeysReport(c *fiber.Ctx) error {
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+	adUnit := c.Query("ad_unit", "")
+
+	stats, err := h.store.GetRequestKeysReport(c.Context(), networkID(c), startDate, endDate, adUnit)
+	if err != nil {
+		return NewInternalError("Failed to get request keys report")
+	}
+
+	if stats == nil {
+		stats = []storage.RequestKeyStats{}
+	}
+
+	return c.JSON(fiber.Map{
+		"keys":       stats,
+		"timezone":   tz,
 		"start_date": startDate.Format("2006-01-02"),
 		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
 	})
@@ -130,11 +409,15 @@ func (h *ReportsHandler) GetKeyValueReport(c *fiber.Ctx) error {
 
 // GetLineItemReport returns stats grouped by line item
 func (h *ReportsHandler) GetLineItemReport(c *fiber.Ctx) error {
-	startDate, endDate := h.parseDateRange(c)
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 	adUnit := c.Query("ad_unit", "")
 	creativeSize := c.Query("creative_size", "")
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
 
-	stats, err := h.store.GetLineItemReport(c.Context(), startDate, endDate, adUnit, creativeSize)
+	stats, err := h.reports.GetLineItemReport(c.Context(), networkID(c), startDate, endDate, adUnit, creativeSize, advertiserID)
 	if err != nil {
 		return NewInternalError("Failed to get line item report")
 	}
@@ -145,6 +428,65 @@ func (h *ReportsHandler) GetLineItemReport(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{
 		"data":       stats,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
+// GetReachReport returns unique reach and average frequency per campaign
+// (the default), advertiser or line item, optionally for one advertiser_id,
+// campaign_id or line_item_id
+func (h *ReportsHandler) GetReachReport(c *fiber.Ctx) error {
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+	groupBy := c.Query("group_by", "campaign")
+	if groupBy != "advertiser" && groupBy != "campaign" && groupBy != "line_item" {
+		return NewBadRequest("group_by must be advertiser, campaign or line_item")
+	}
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
+	campaignID, _ := strconv.Atoi(c.Query("campaign_id"))
+	lineItemID, _ := strconv.Atoi(c.Query("line_item_id"))
+
+	stats, err := h.store.GetReachReport(c.Context(), networkID(c), startDate, endDate, groupBy, advertiserID, campaignID, lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get reach report")
+	}
+
+	if stats == nil {
+		stats = []storage.ReachStats{}
+	}
+
+	return c.JSON(fiber.Map{
+		"group_by":   groupBy,
+		"data":       stats,
+		"timezone":   tz,
+		"start_date": startDate.Format("2006-01-02"),
+		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+	})
+}
+
+// GetFrequencyReport returns how many users saw 1, 2, 3, 4-5 and 6+
+// impressions, optionally for one advertiser_id, campaign_id or line_item_id
+func (h *ReportsHandler) GetFrequencyReport(c *fiber.Ctx) error {
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
+	advertiserID, _ := strconv.Atoi(c.Query("advertiser_id"))
+	campaignID, _ := strconv.Atoi(c.Query("campaign_id"))
+	lineItemID, _ := strconv.Atoi(c.Query("line_item_id"))
+
+	buckets, err := h.store.GetFrequencyReport(c.Context(), networkID(c), startDate, endDate, advertiserID, campaignID, lineItemID)
+	if err != nil {
+		return NewInternalError("Failed to get frequency report")
+	}
+
+	return c.JSON(fiber.Map{
+		"buckets":    buckets,
+		"timezone":   tz,
 		"start_date": startDate.Format("2006-01-02"),
 		"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
 	})
@@ -152,7 +494,7 @@ func (h *ReportsHandler) GetLineItemReport(c *fiber.Ctx) error {
 
 // GetCreativeSizes returns distinct creative sizes
 func (h *ReportsHandler) GetCreativeSizes(c *fiber.Ctx) error {
-	sizes, err := h.store.GetCreativeSizes(c.Context())
+	sizes, err := h.store.GetCreativeSizes(c.Context(), networkID(c))
 	if err != nil {
 		return NewInternalError("Failed to get creative sizes")
 	}
@@ -164,58 +506,167 @@ func (h *ReportsHandler) GetCreativeSizes(c *fiber.Ctx) error {
 	return c.JSON(sizes)
 }
 
-// ExportReport exports report data as CSV
+const (
+	// asyncExportDays is the longest range exported in the request; longer
+	// ranges are queued as export jobs
+	asyncExportDays = 62
+	// exportTimeout bounds a streamed export's query
+	exportTimeout = 10 * time.Minute
+)
+
+// exportGroupings are the dimensions of the export's group_by shortcuts
+var exportGroupings = map[string][]string{
+	"daily":      {"date", "campaign", "line_item"},
+	"advertiser": {"date", "advertiser", "campaign", "line_item"},
+	"country":    {"date", "campaign", "line_item", "country"},
+	"section":    {"date", "campaign", "line_item", "section"},
+	"full":       {"date", "campaign", "line_item", "country", "section", "platform"},
+}
+
+// ExportReport exports report data as CSV, JSON, XLSX or Parquet. The
+// columns are either a group_by shortcut (daily, advertiser, country,
+// section, full) or report builder dimensions and metrics given as
+// comma-separated lists; advertiser_id restricts the rows to one advertiser. Rows
+// are streamed from the query to the response as they are read. Ranges over
+// asyncExportDays, or any range with async=true, are queued as an export job
+// instead and answered with 202 and the job.
 func (h *ReportsHandler) ExportReport(c *fiber.Ctx) error {
-	startDate, endDate := h.parseDateRange(c)
-	groupBy := c.Query("group_by", "daily")
+	startDate, endDate, tz, err := h.parseDateRange(c)
+	if err != nil {
+		return err
+	}
 	format := c.Query("format", "csv")
+	if !export.IsValidFormat(format) {
+		return NewBadRequest("format must be csv, json, xlsx or parquet")
+	}
 
-	data, err := h.store.GetExportData(c.Context(), startDate, endDate, groupBy)
+	req := &models.ReportQueryRequest{}
+	if dimensions := c.Query("dimensions"); dimensions != "" {
+		req.Dimensions = strings.Split(dimensions, ",")
+	} else {
+		groupBy := c.Query("group_by", "daily")
+		grouping, ok := exportGroupings[groupBy]
+		if !ok {
+			grouping = exportGroupings["daily"]
+		}
+		req.Dimensions = grouping
+		req.Sort = []models.ReportSort{{Field: "date", Direction: "desc"}, {Field: "impressions", Direction: "desc"}}
+	}
+	if metrics := c.Query("metrics"); metrics != "" {
+		req.Metrics = strings.Split(metrics, ",")
+	}
+	if advertiserID := c.Query("advertiser_id"); advertiserID != "" {
+		req.Filters = append(req.Filters, models.ReportFilter{Dimension: "advertiser", Operator: "IN", Values: []string{advertiserID}})
+	}
+
+	q, err := storage.NewReportQuery(networkID(c), req, startDate, endDate, tz)
 	if err != nil {
-		return NewInternalError("Failed to get export data")
+		return NewBadRequest(err.Error())
+	}
+	if err := checkReportKeys(c, h.store, q); err != nil {
+		return err
 	}
+	q.Limit = 0
 
-	if format == "json" {
-		return c.JSON(fiber.Map{
-			"data":       data,
-			"start_date": startDate.Format("2006-01-02"),
-			"end_date":   endDate.AddDate(0, 0, -1).Format("2006-01-02"),
-		})
+	if c.QueryBool("async") || endDate.Sub(startDate) > asyncExportDays*24*time.Hour {
+		return h.queueExport(c, req, format, q)
 	}
 
-	// Generate CSV
-	var csv strings.Builder
-	csv.WriteString("Date,Campaign,Line Item,Country,Section,Platform,Impressions,Clicks,Viewable,CTR\n")
+	c.Set("Content-Type", export.ContentType(format))
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", export.Filename(format, startDate, endDate)))
+
+	// The body is written after the handler returns, so the query can't use
+	// the request context
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		rw, err := export.NewReportWriter(w, format, q)
+		if err != nil {
+			log.Printf("Warning: Failed to start export: %v", err)
+			return
+		}
+		err = h.reports.StreamReportQuery(ctx, q, rw.WriteRow)
+		if err == nil {
+			err = rw.Close()
+		}
+		if err != nil {
+			log.Printf("Warning: Export stopped early: %v", err)
+		}
+	})
+	return nil
+}
+
+// queueExport queues an export job for the export runner
+func (h *ReportsHandler) queueExport(c *fiber.Ctx, req *models.ReportQueryRequest, format string, q *storage.ReportQuery) error {
+	if h.exportJobs == nil {
+		return NewInternalError("Export jobs are not enabled")
+	}
 
-	for _, row := range data {
-		csv.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s,%d,%d,%d,%.2f%%\n",
-			row.Date,
-			escapeCsv(row.CampaignName),
-			escapeCsv(row.LineItemName),
-			row.Country,
-			row.Section,
-			row.Platform,
-			row.Impressions,
-			row.Clicks,
-			row.Viewable,
-			row.CTR,
-		))
+	job, err := h.store.CreateExportJob(c.Context(), &models.ExportJob{
+		ID:        uuid.New().String(),
+		NetworkID: q.NetworkID,
+		Format:    format,
+		Query:     *req,
+		StartDate: q.StartDate,
+		EndDate:   q.EndDate,
+		Timezone:  q.Timezone,
+	})
+	if err != nil {
+		return NewInternalError("Failed to queue export")
 	}
+	h.exportJobs.Notify()
 
-	filename := fmt.Sprintf("report_%s_to_%s.csv",
-		startDate.Format("2006-01-02"),
-		endDate.AddDate(0, 0, -1).Format("2006-01-02"))
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
 
-	c.Set("Content-Type", "text/csv")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+// GetExportJob returns an export job's status. Once it is done the job has
+// a download_url.
+func (h *ReportsHandler) GetExportJob(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if err != nil {
+		return err
+	}
+	if job.Status == models.ExportDone {
+		job.DownloadURL = c.BaseURL() + "/api/reports/export/jobs/" + job.ID + "/download"
+	}
+	return c.JSON(job)
+}
 
-	return c.SendString(csv.String())
+// DownloadExportJob sends a finished export job's file
+func (h *ReportsHandler) DownloadExportJob(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if err != nil {
+		return err
+	}
+	if job.Status != models.ExportDone {
+		return NewBadRequest("Export is " + job.Status)
+	}
+	if job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()) {
+		return NewNotFound("Export has expired")
+	}
+
+	loc, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	c.Set("Content-Type", export.ContentType(job.Format))
+	return c.Download(job.FilePath, export.Filename(job.Format, job.StartDate.In(loc), job.EndDate.In(loc)))
 }
 
-// escapeCsv escapes a string for CSV
-func escapeCsv(s string) string {
-	if strings.Contains(s, ",") || strings.Contains(s, "\"") || strings.Contains(s, "\n") {
-		return "\"" + strings.ReplaceAll(s, "\"", "\"\"") + "\""
+// exportJob loads the export job named in the request
+func (h *ReportsHandler) exportJob(c *fiber.Ctx) (*models.ExportJob, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, NewBadRequest("Invalid export job ID")
+	}
+
+	job, err := h.store.GetExportJob(c.Context(), networkID(c), id.String())
+	if err != nil {
+		return nil, NewInternalError("Failed to get export job")
+	}
+	if job == nil {
+		return nil, NewNotFound("Export job not found")
 	}
-	return s
+	return job, nil
 }
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mims/ad-manager/internal/export"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/scheduler"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// runHistoryLimit is how many recent runs the run history returns
+const runHistoryLimit = 50
+
+// ScheduledReportsHandler handles scheduled report API requests
+type ScheduledReportsHandler struct {
+	store *storage.PostgresStore
+}
+
+// NewScheduledReportsHandler creates a new ScheduledReportsHandler
+func NewScheduledReportsHandler(store *storage.PostgresStore) *ScheduledReportsHandler {
+	return &ScheduledReportsHandler{store: store}
+}
+
+// ListScheduledReports returns all scheduled reports
+func (h *ScheduledReportsHandler) ListScheduledReports(c *fiber.Ctx) error {
+	reports, err := h.store.ListScheduledReports(c.Context(), networkID(c))
+	if err != nil {
+		return NewInternalError("Failed to list scheduled reports")
+	}
+	if reports == nil {
+		reports = []models.ScheduledReport{}
+	}
+	return c.JSON(reports)
+}
+
+// GetScheduledReport returns a specific scheduled report
+func (h *ScheduledReportsHandler) GetScheduledReport(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid scheduled report ID")
+	}
+
+	report, err := h.store.GetScheduledReport(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get scheduled report")
+	}
+	if report == nil {
+		return NewNotFound("Scheduled report not found")
+	}
+
+	return c.JSON(report)
+}
+
+// CreateScheduledReport creates a scheduled report
+func (h *ScheduledReportsHandler) CreateScheduledReport(c *fiber.Ctx) error {
+	var req models.ScheduledReportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	nextRunAt, err := h.validate(c, &req)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.store.CreateScheduledReport(c.Context(), networkID(c), &req, nextRunAt)
+	if err != nil {
+		return NewInternalError("Failed to create scheduled report")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// UpdateScheduledReport replaces a scheduled report's definition. The next
+// run time is recomputed from the schedule.
+func (h *ScheduledReportsHandler) UpdateScheduledReport(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid scheduled report ID")
+	}
+
+	var req models.ScheduledReportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return NewBadRequest("Invalid request body")
+	}
+
+	nextRunAt, err := h.validate(c, &req)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.store.UpdateScheduledReport(c.Context(), networkID(c), id, &req, nextRunAt)
+	if err != nil {
+		return NewInternalError("Failed to update scheduled report")
+	}
+	if report == nil {
+		return NewNotFound("Scheduled report not found")
+	}
+
+	return c.JSON(report)
+}
+
+// DeleteScheduledReport deletes a scheduled report and its run history
+func (h *ScheduledReportsHandler) DeleteScheduledReport(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid scheduled report ID")
+	}
+
+	if err := h.store.DeleteScheduledReport(c.Context(), networkID(c), id); err != nil {
+		return NewInternalError("Failed to delete scheduled report")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RunScheduledReport queues a run of a report now, outside its schedule.
+// The scheduler picks it up within a minute.
+func (h *ScheduledReportsHandler) RunScheduledReport(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid scheduled report ID")
+	}
+
+	report, err := h.store.GetScheduledReport(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get scheduled report")
+	}
+	if report == nil {
+		return NewNotFound("Scheduled report not found")
+	}
+
+	run, err := h.store.CreateScheduledReportRun(c.Context(), id, time.Now())
+	if err != nil {
+		return NewInternalError("Failed to queue scheduled report run")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(run)
+}
+
+// ListScheduledReportRuns returns a scheduled report's recent runs, newest
+// first, with their status, attempts and errors
+func (h *ScheduledReportsHandler) ListScheduledReportRuns(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return NewBadRequest("Invalid scheduled report ID")
+	}
+
+	report, err := h.store.GetScheduledReport(c.Context(), networkID(c), id)
+	if err != nil {
+		return NewInternalError("Failed to get scheduled report")
+	}
+	if report == nil {
+		return NewNotFound("Scheduled report not found")
+	}
+
+	runs, err := h.store.ListScheduledReportRuns(c.Context(), id, runHistoryLimit)
+	if err != nil {
+		return NewInternalError("Failed to list scheduled report runs")
+	}
+	if runs == nil {
+		runs = []models.ScheduledReportRun{}
+	}
+	return c.JSON(runs)
+}
+
+// validate checks a scheduled report request, fills in defaults and returns
+// the report's next run time
+func (h *ScheduledReportsHandler) validate(c *fiber.Ctx, req *models.ScheduledReportRequest) (*time.Time, error) {
+	if req.Name == "" {
+		return nil, NewBadRequest("Name is required")
+	}
+
+	if req.DateRange == "" {
+		req.DateRange = "last_7_days"
+	}
+	if !models.IsValidReportDateRange(req.DateRange) {
+		return nil, NewBadRequest("date_range must be yesterday, last_7_days, last_30_days, last_week, month_to_date or last_month")
+	}
+
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	if !export.IsValidFormat(req.Format) {
+		return nil, NewBadRequest("format must be csv, json, xlsx or parquet")
+	}
+
+	switch req.Delivery {
+	case models.DeliveryEmail:
+		if len(req.Recipients) == 0 {
+			return nil, NewBadRequest("Email delivery needs at least one recipient")
+		}
+		for _, r := range req.Recipients {
+			if _, err := mail.ParseAddress(r); err != nil {
+				return nil, NewBadRequest("Invalid recipient " + strconv.Quote(r))
+			}
+		}
+	case models.DeliveryWebhook:
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, NewBadRequest("Webhook delivery needs an http(s) webhook_url")
+		}
+	default:
+		return nil, NewBadRequest("delivery must be email or webhook")
+	}
+
+	if req.Status != "" && req.Status != "active" && req.Status != "paused" {
+		return nil, NewBadRequest("status must be active or paused")
+	}
+
+	// Without a tz the schedule runs in the network's timezone
+	tz, err := reportTimezone(c, h.store, req.Query.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return nil, NewBadRequest("Invalid timezone")
+	}
+	q, err := storage.NewReportQuery(networkID(c), &req.Query, time.Time{}, time.Time{}, tz)
+	if err != nil {
+		return nil, NewBadRequest(err.Error())
+	}
+	if err := checkReportKeys(c, h.store, q); err != nil {
+		return nil, err
+	}
+
+	nextRunAt, err := scheduler.NextRun(req.Schedule, tz, time.Now())
+	if err != nil {
+		return nil, NewBadRequest("Invalid schedule: " + err.Error())
+	}
+	if nextRunAt == nil {
+		return nil, NewBadRequest("Schedule never runs")
+	}
+	return nextRunAt, nil
+}
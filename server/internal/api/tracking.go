@@ -2,55 +2,104 @@ package api
 
 import (
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/mims/ad-manager/internal/live"
 	"github.com/mims/ad-manager/internal/models"
 	"github.com/mims/ad-manager/internal/storage"
 )
 
+// Limits on the key-values carried by tracking URLs and stored with events
+const (
+	maxEventKeys     = 20
+	maxEventKeyValue = 100 // characters of a key or value
+)
+
+// limitKeyValues keeps the first maxEventKeys keys in key order, shortening
+// long keys and values
+func limitKeyValues(targeting map[string]string) map[string]string {
+	keys := make([]string, 0, len(targeting))
+	for k := range targeting {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > maxEventKeys {
+		keys = keys[:maxEventKeys]
+	}
+
+	limited := make(map[string]string, len(keys))
+	for _, k := range keys {
+		limited[truncate(k, maxEventKeyValue)] = truncate(targeting[k], maxEventKeyValue)
+	}
+	return limited
+}
+
+// encodeKeyValues encodes an ad request's key-values for the kv parameter of
+// its tracking URLs
+func encodeKeyValues(targeting map[string]string) string {
+	values := url.Values{}
+	for k, v := range limitKeyValues(targeting) {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// decodeKeyValues decodes the kv parameter of a tracking URL. The URL comes
+// from the client, so the limits are applied again.
+func decodeKeyValues(kv string) map[string]string {
+	values, err := url.ParseQuery(kv)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	targeting := make(map[string]string, len(values))
+	for k := range values {
+		targeting[k] = values.Get(k)
+	}
+	return limitKeyValues(targeting)
+}
+
+// truncate shortens s to at most n characters, dropping NUL characters,
+// which Postgres does not allow in JSONB
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\x00", "")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
 // TrackingHandler handles tracking events
 type TrackingHandler struct {
-	store *storage.PostgresStore
+	store storage.EventWriter
+	cache *storage.InMemoryCache
+	live  *live.Counter
+}
+
+// NewTrackingHandler creates a new TrackingHandler. Events are attributed to
+// the network of their line item in the cache.
+func NewTrackingHandler(store storage.EventWriter, cache *storage.InMemoryCache) *TrackingHandler {
+	return &TrackingHandler{store: store, cache: cache}
 }
 
-// NewTrackingHandler creates a new TrackingHandler
-func NewTrackingHandler(store *storage.PostgresStore) *TrackingHandler {
-	return &TrackingHandler{store: store}
+// SetLiveCounter sets the counter live stats are fed to
+func (h *TrackingHandler) SetLiveCounter(counter *live.Counter) {
+	h.live = counter
 }
 
 // TrackImpression records an impression event
 func (h *TrackingHandler) TrackImpression(c *fiber.Ctx) error {
-	impressionID := c.Query("id")
-	lineItemID, _ := strconv.Atoi(c.Query("li"))
-	creativeID, _ := strconv.Atoi(c.Query("c"))
-	userID := c.Query("u")
-	platform := c.Query("p")
-	country := c.Query("co")
-	adUnit := c.Query("au")
-	section := c.Query("sec")
-
-	if impressionID == "" || lineItemID == 0 {
+	event := parseEvent(c, models.EventTypeImpression)
+	if event == nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
-
-	event := &models.Event{
-		EventType:    models.EventTypeImpression,
-		ImpressionID: impressionID,
-		LineItemID:   lineItemID,
-		CreativeID:   creativeID,
-		UserID:       userID,
-		Platform:     platform,
-		Country:      country,
-		AdUnit:       adUnit,
-		Section:      section,
-	}
-
-	if err := h.store.RecordEvent(c.Context(), event); err != nil {
-		// Log error but don't fail - tracking should be fire and forget
-		// In production, you'd queue this for retry
-	}
+	h.record(c, event)
 
 	// Return 1x1 transparent pixel
 	return h.sendPixel(c)
@@ -58,34 +107,11 @@ func (h *TrackingHandler) TrackImpression(c *fiber.Ctx) error {
 
 // TrackViewable records a viewable impression event
 func (h *TrackingHandler) TrackViewable(c *fiber.Ctx) error {
-	impressionID := c.Query("id")
-	lineItemID, _ := strconv.Atoi(c.Query("li"))
-	creativeID, _ := strconv.Atoi(c.Query("c"))
-	userID := c.Query("u")
-	platform := c.Query("p")
-	country := c.Query("co")
-	adUnit := c.Query("au")
-	section := c.Query("sec")
-
-	if impressionID == "" || lineItemID == 0 {
+	event := parseEvent(c, models.EventTypeViewable)
+	if event == nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
-
-	event := &models.Event{
-		EventType:    models.EventTypeViewable,
-		ImpressionID: impressionID,
-		LineItemID:   lineItemID,
-		CreativeID:   creativeID,
-		UserID:       userID,
-		Platform:     platform,
-		Country:      country,
-		AdUnit:       adUnit,
-		Section:      section,
-	}
-
-	if err := h.store.RecordEvent(c.Context(), event); err != nil {
-		// Log error but don't fail
-	}
+	h.record(c, event)
 
 	// Return 1x1 transparent pixel
 	return h.sendPixel(c)
@@ -93,47 +119,72 @@ func (h *TrackingHandler) TrackViewable(c *fiber.Ctx) error {
 
 // TrackClick records a click event and redirects to destination
 func (h *TrackingHandler) TrackClick(c *fiber.Ctx) error {
+	event := parseEvent(c, models.EventTypeClick)
+	if event == nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	h.record(c, event)
+
+	// Redirect to destination URL
+	if redirectURL := c.Query("url"); redirectURL != "" {
+		// Decode URL if needed
+		decodedURL, err := url.QueryUnescape(redirectURL)
+		if err != nil {
+			decodedURL = redirectURL
+		}
+		return c.Redirect(decodedURL, fiber.StatusFound)
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// parseEvent reads an event from a tracking URL, or returns nil if it has no
+// impression or line item ID. The network is left for record to resolve.
+func parseEvent(c *fiber.Ctx, eventType string) *models.Event {
 	impressionID := c.Query("id")
 	lineItemID, _ := strconv.Atoi(c.Query("li"))
-	creativeID, _ := strconv.Atoi(c.Query("c"))
-	userID := c.Query("u")
-	platform := c.Query("p")
-	country := c.Query("co")
-	adUnit := c.Query("au")
-	section := c.Query("sec")
-	redirectURL := c.Query("url")
-
 	if impressionID == "" || lineItemID == 0 {
-		return c.SendStatus(fiber.StatusBadRequest)
+		return nil
 	}
+	creativeID, _ := strconv.Atoi(c.Query("c"))
 
-	event := &models.Event{
-		EventType:    models.EventTypeClick,
+	return &models.Event{
+		EventType:    eventType,
 		ImpressionID: impressionID,
 		LineItemID:   lineItemID,
 		CreativeID:   creativeID,
-		UserID:       userID,
-		Platform:     platform,
-		Country:      country,
-		AdUnit:       adUnit,
-		Section:      section,
+		UserID:       c.Query("u"),
+		Platform:     c.Query("p"),
+		Country:      c.Query("co"),
+		AdUnit:       c.Query("au"),
+		Section:      c.Query("sec"),
+		Targeting:    decodeKeyValues(c.Query("kv")),
 	}
+}
 
-	if err := h.store.RecordEvent(c.Context(), event); err != nil {
-		// Log error but don't fail
+// record attributes an event to its line item's network and stores it.
+// Tracking URLs come from the client, so an event whose line item doesn't
+// exist, or whose creative isn't that line item's, is dropped rather than
+// counted against whichever network it names.
+func (h *TrackingHandler) record(c *fiber.Ctx, event *models.Event) {
+	networkID, ok := h.cache.GetTrackingNetwork(event.LineItemID, event.CreativeID)
+	if !ok {
+		return
 	}
+	event.NetworkID = networkID
 
-	// Redirect to destination URL
-	if redirectURL != "" {
-		// Decode URL if needed
-		decodedURL, err := url.QueryUnescape(redirectURL)
-		if err != nil {
-			decodedURL = redirectURL
-		}
-		return c.Redirect(decodedURL, fiber.StatusFound)
+	h.recordLive(event)
+	if err := h.store.RecordEvent(c.Context(), event); err != nil {
+		// Log error but don't fail - tracking should be fire and forget
+		// In production, you'd queue this for retry
 	}
+}
 
-	return c.SendStatus(fiber.StatusOK)
+// recordLive counts an event in the live stats
+func (h *TrackingHandler) recordLive(event *models.Event) {
+	if h.live != nil {
+		h.live.RecordEvent(event.NetworkID, event.EventType, event.LineItemID, event.AdUnit)
+	}
 }
 
 // sendPixel sends a 1x1 transparent GIF
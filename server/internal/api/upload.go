@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+
+	"github.com/mims/ad-manager/internal/models"
 )
 
 // UploadHandler handles file uploads
@@ -54,8 +57,8 @@ func (h *UploadHandler) UploadImage(c *fiber.Ctx) error {
 		return NewBadRequest("File too large. Maximum size is 5MB")
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), uuid.New().String()[:8], ext)
+	// Generate unique filename, prefixed with the network it belongs to
+	filename := fmt.Sprintf("n%d_%d_%s%s", networkID(c), time.Now().Unix(), uuid.New().String()[:8], ext)
 	filepath := filepath.Join(h.uploadDir, filename)
 
 	// Save the file
@@ -86,7 +89,7 @@ func (h *UploadHandler) UploadImage(c *fiber.Ctx) error {
 	})
 }
 
-// ListUploads returns all uploaded files
+// ListUploads returns the files uploaded by the caller's network
 func (h *UploadHandler) ListUploads(c *fiber.Ctx) error {
 	files, err := os.ReadDir(h.uploadDir)
 	if err != nil {
@@ -109,7 +112,7 @@ func (h *UploadHandler) ListUploads(c *fiber.Ctx) error {
 
 	var uploads []fiber.Map
 	for _, file := range files {
-		if !file.IsDir() {
+		if !file.IsDir() && uploadNetwork(file.Name()) == networkID(c) {
 			info, _ := file.Info()
 			uploads = append(uploads, fiber.Map{
 				"filename":   file.Name(),
@@ -138,6 +141,9 @@ func (h *UploadHandler) DeleteUpload(c *fiber.Ctx) error {
 	if strings.Contains(filename, "..") || strings.Contains(filename, "/") || strings.Contains(filename, "\\") {
 		return NewBadRequest("Invalid filename")
 	}
+	if uploadNetwork(filename) != networkID(c) {
+		return NewNotFound("File not found")
+	}
 
 	filepath := filepath.Join(h.uploadDir, filename)
 
@@ -150,3 +156,17 @@ func (h *UploadHandler) DeleteUpload(c *fiber.Ctx) error {
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// uploadNetwork returns the network an uploaded file belongs to, from its
+// "n<id>_" prefix. Files uploaded before networks were added have none and
+// belong to the default network.
+func uploadNetwork(filename string) int {
+	if rest, ok := strings.CutPrefix(filename, "n"); ok {
+		if id, _, ok := strings.Cut(rest, "_"); ok {
+			if n, err := strconv.Atoi(id); err == nil {
+				return n
+			}
+		}
+	}
+	return models.DefaultNetworkID
+}
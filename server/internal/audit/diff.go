@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// ignoredFields are kept up to date by the store rather than changed by a
+// request, so they are left out of diffs
+var ignoredFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+}
+
+// Diff compares the JSON of two versions of an entity field by field and
+// returns the fields that differ. before is nil for a create and after for
+// a delete, so every field shows up.
+func Diff(before, after interface{}) (map[string]models.AuditChange, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]models.AuditChange)
+	for k, v := range b {
+		if ignoredFields[k] || reflect.DeepEqual(v, a[k]) {
+			continue
+		}
+		changes[k] = models.AuditChange{Before: v, After: a[k]}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; ok || ignoredFields[k] {
+			continue
+		}
+		changes[k] = models.AuditChange{Before: nil, After: v}
+	}
+	return changes, nil
+}
+
+// fields decodes the JSON of v into its top-level fields
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return m, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
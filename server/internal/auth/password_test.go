@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword returned %v", err)
+	}
+	if hash == "correct horse" {
+		t.Fatal("HashPassword returned the password")
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("CheckPassword rejected the right password")
+	}
+	for _, wrong := range []string{"", "correct horse ", "Correct horse", "correct"} {
+		if CheckPassword(hash, wrong) {
+			t.Errorf("CheckPassword accepted %q", wrong)
+		}
+	}
+
+	// Hashes are salted
+	again, _ := HashPassword("correct horse")
+	if again == hash {
+		t.Error("hashing the same password twice gave the same hash")
+	}
+}
+
+func TestCheckPasswordEmptyHash(t *testing.T) {
+	// Unknown users and users without a password never sign in
+	for _, password := range []string{"", "not a password"} {
+		if CheckPassword("", password) {
+			t.Errorf("CheckPassword with an empty hash accepted %q", password)
+		}
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken returned %v", err)
+	}
+	if len(token) != 43 {
+		t.Errorf("token is %d characters, want 43 (32 random bytes)", len(token))
+	}
+	if hash != HashToken(token) {
+		t.Error("NewToken's hash differs from HashToken of the token")
+	}
+	if IsAPIKey(token) {
+		t.Error("a session token is taken for an API key")
+	}
+
+	other, _, _ := NewToken()
+	if other == token {
+		t.Error("NewToken returned the same token twice")
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey returned %v", err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, APIKeyPrefix) {
+		t.Errorf("key %q doesn't start with %q", key, APIKeyPrefix)
+	}
+	if !strings.HasPrefix(key, prefix) || len(prefix) != len(APIKeyPrefix)+8 {
+		t.Errorf("prefix %q isn't the first %d characters of the key", prefix, len(APIKeyPrefix)+8)
+	}
+	if hash != HashToken(key) {
+		t.Error("NewAPIKey's hash differs from HashToken of the key")
+	}
+	if strings.Contains(hash, key) {
+		t.Error("the key's hash contains the key")
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+// Permission allows one kind of access to the admin API
+type Permission string
+
+// Permissions. Read covers GET requests; write covers creating, changing
+// and deleting. Creative review covers approving and rejecting creatives.
+const (
+	CampaignsRead   Permission = "campaigns:read"
+	CampaignsWrite  Permission = "campaigns:write"
+	CreativesRead   Permission = "creatives:read"
+	CreativesWrite  Permission = "creatives:write"
+	CreativesReview Permission = "creatives:review"
+	InventoryRead   Permission = "inventory:read"
+	InventoryWrite  Permission = "inventory:write"
+	ReportsRead     Permission = "reports:read"
+	ReportsWrite    Permission = "reports:write"
+	ForecastRead    Permission = "forecast:read"
+	AlertsRead      Permission = "alerts:read"
+	AlertsWrite     Permission = "alerts:write"
+	AuditRead       Permission = "audit:read"
+	UsersManage     Permission = "users:manage"
+	NetworksManage  Permission = "networks:manage"
+)
+
+// AllPermissions lists every permission
+var AllPermissions = []Permission{
+	CampaignsRead, CampaignsWrite,
+	CreativesRead, CreativesWrite, CreativesReview,
+	InventoryRead, InventoryWrite,
+	ReportsRead, ReportsWrite,
+	ForecastRead,
+	AlertsRead, AlertsWrite,
+	AuditRead,
+	UsersManage,
+	NetworksManage,
+}
+
+// Roles
+const (
+	RoleAdmin      = "admin"
+	RoleTrafficker = "trafficker"
+	RoleSales      = "sales"
+	RoleReporter   = "reporter"
+)
+
+// rolePermissions are the permissions of each role:
+//   - admin: everything, including user management and creative review;
+//     only admins of the default network may manage networks
+//   - trafficker: sets up and changes campaigns, creatives and inventory,
+//     sends creatives for review, and reads the audit log
+//   - sales: reads everything, forecasts availability and schedules reports
+//   - reporter: reads campaigns, inventory, reports and alerts
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: AllPermissions,
+	RoleTrafficker: {
+		CampaignsRead, CampaignsWrite,
+		CreativesRead, CreativesWrite,
+		InventoryRead, InventoryWrite,
+		ReportsRead, ReportsWrite,
+		ForecastRead,
+		AlertsRead, AlertsWrite,
+		AuditRead,
+	},
+	RoleSales: {
+		CampaignsRead, CreativesRead, InventoryRead,
+		ReportsRead, ReportsWrite,
+		ForecastRead,
+		AlertsRead,
+		AuditRead,
+	},
+	RoleReporter: {
+		CampaignsRead, CreativesRead, InventoryRead,
+		ReportsRead,
+		AlertsRead,
+	},
+}
+
+// IsValidRole checks if a role is known
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHas reports whether a role grants a permission
+func RoleHas(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions returns the permissions a role grants
+func RolePermissions(role string) []Permission {
+	return rolePermissions[role]
+}
+
+// IsValidScope checks if a permission can be granted to an API key. Keys
+// can't manage users or networks, so a leaked key can't create accounts or
+// more keys.
+func IsValidScope(scope string) bool {
+	for _, p := range AllPermissions {
+		if string(p) == scope {
+			return p != UsersManage && p != NetworksManage
+		}
+	}
+	return false
+}
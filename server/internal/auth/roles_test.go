@@ -0,0 +1,79 @@
+package auth
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	// Every role against every permission, so a change to the matrix has
+	// to change this table too
+	want := map[string][]Permission{
+		RoleAdmin: AllPermissions,
+		RoleTrafficker: {
+			CampaignsRead, CampaignsWrite, CreativesRead, CreativesWrite,
+			InventoryRead, InventoryWrite, ReportsRead, ReportsWrite,
+			ForecastRead, AlertsRead, AlertsWrite, AuditRead,
+		},
+		RoleSales: {
+			CampaignsRead, CreativesRead, InventoryRead, ReportsRead, ReportsWrite,
+			ForecastRead, AlertsRead, AuditRead,
+		},
+		RoleReporter: {
+			CampaignsRead, CreativesRead, InventoryRead, ReportsRead, AlertsRead,
+		},
+	}
+
+	for role, perms := range want {
+		if !IsValidRole(role) {
+			t.Errorf("IsValidRole(%q) = false", role)
+		}
+		granted := make(map[Permission]bool)
+		for _, p := range perms {
+			granted[p] = true
+		}
+		for _, p := range AllPermissions {
+			if got := RoleHas(role, p); got != granted[p] {
+				t.Errorf("RoleHas(%q, %q) = %v, want %v", role, p, got, granted[p])
+			}
+		}
+		if got := len(RolePermissions(role)); got != len(perms) {
+			t.Errorf("RolePermissions(%q) has %d permissions, want %d", role, got, len(perms))
+		}
+	}
+}
+
+func TestOnlyAdminsReviewAndManage(t *testing.T) {
+	for _, p := range []Permission{CreativesReview, UsersManage, NetworksManage} {
+		for _, role := range []string{RoleTrafficker, RoleSales, RoleReporter} {
+			if RoleHas(role, p) {
+				t.Errorf("%s has %s, want admins only", role, p)
+			}
+		}
+	}
+}
+
+func TestUnknownRole(t *testing.T) {
+	for _, role := range []string{"", "root", "Admin"} {
+		if IsValidRole(role) {
+			t.Errorf("IsValidRole(%q) = true", role)
+		}
+		if RoleHas(role, CampaignsRead) {
+			t.Errorf("RoleHas(%q, %q) = true", role, CampaignsRead)
+		}
+		if len(RolePermissions(role)) != 0 {
+			t.Errorf("RolePermissions(%q) = %v, want none", role, RolePermissions(role))
+		}
+	}
+}
+
+func TestIsValidScope(t *testing.T) {
+	for _, p := range AllPermissions {
+		want := p != UsersManage && p != NetworksManage
+		if got := IsValidScope(string(p)); got != want {
+			t.Errorf("IsValidScope(%q) = %v, want %v", p, got, want)
+		}
+	}
+	for _, scope := range []string{"", "campaigns", "campaigns:*", "*", "CAMPAIGNS:READ"} {
+		if IsValidScope(scope) {
+			t.Errorf("IsValidScope(%q) = true, want false", scope)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		expr string
+		// the values each field should match, minute to day of week
+		want [5][]int
+	}{
+		{"* * * * *", [5][]int{span(0, 59, 1), span(0, 23, 1), span(1, 31, 1), span(1, 12, 1), span(0, 7, 1)}},
+		{"0 6 1 1 1", [5][]int{{0}, {6}, {1}, {1}, {1}}},
+		{"*/15 0-23/6 1,15 3-5 1-5", [5][]int{{0, 15, 30, 45}, {0, 6, 12, 18}, {1, 15}, {3, 4, 5}, {1, 2, 3, 4, 5}}},
+		// A start with a step runs to the end of the field
+		{"5/15 10/5 * * *", [5][]int{{5, 20, 35, 50}, {10, 15, 20}, span(1, 31, 1), span(1, 12, 1), span(0, 7, 1)}},
+		{"0-30/10,45 * * * *", [5][]int{{0, 10, 20, 30, 45}, span(0, 23, 1), span(1, 31, 1), span(1, 12, 1), span(0, 7, 1)}},
+		// 7 is Sunday, like 0
+		{"0 0 * * 7", [5][]int{{0}, {0}, span(1, 31, 1), span(1, 12, 1), {0, 7}}},
+		{"0 0 * * 5-7", [5][]int{{0}, {0}, span(1, 31, 1), span(1, 12, 1), {0, 5, 6, 7}}},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", tt.expr, err)
+			continue
+		}
+		for i, got := range []uint64{s.minutes, s.hours, s.days, s.months, s.weekdays} {
+			if want := bits(tt.want[i]); got != want {
+				t.Errorf("Parse(%q) %s field = %v, want %v", tt.expr, fields[i].name, values(got), tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", date(utc, 2026, 1, 5, 10, 0), date(utc, 2026, 1, 5, 10, 1)},
+		{"seconds are dropped", "* * * * *", time.Date(2026, 1, 5, 10, 0, 59, 0, utc), date(utc, 2026, 1, 5, 10, 1)},
+		{"strictly after", "30 10 * * *", date(utc, 2026, 1, 5, 10, 30), date(utc, 2026, 1, 6, 10, 30)},
+		{"later today", "30 10 * * *", date(utc, 2026, 1, 5, 9, 45), date(utc, 2026, 1, 5, 10, 30)},
+		{"step from a start", "5/15 * * * *", date(utc, 2026, 1, 5, 10, 51), date(utc, 2026, 1, 5, 11, 5)},
+		{"next month", "0 6 1 * *", date(utc, 2026, 1, 5, 0, 0), date(utc, 2026, 2, 1, 6, 0)},
+		{"next year", "0 0 1 1 *", date(utc, 2026, 1, 1, 0, 0), date(utc, 2027, 1, 1, 0, 0)},
+		// 2026-01-05 is a Monday
+		{"Monday", "0 8 * * 1", date(utc, 2026, 1, 6, 0, 0), date(utc, 2026, 1, 12, 8, 0)},
+		{"Sunday as 0", "0 8 * * 0", date(utc, 2026, 1, 5, 0, 0), date(utc, 2026, 1, 11, 8, 0)},
+		{"Sunday as 7", "0 8 * * 7", date(utc, 2026, 1, 5, 0, 0), date(utc, 2026, 1, 11, 8, 0)},
+		{"weekdays", "0 8 * * 1-5", date(utc, 2026, 1, 9, 9, 0), date(utc, 2026, 1, 12, 8, 0)},
+		// Day of month and day of week both restricted: either one matches
+		{"day of month or weekday, weekday first", "0 0 15 * 1", date(utc, 2026, 1, 6, 0, 0), date(utc, 2026, 1, 12, 0, 0)},
+		{"day of month or weekday, day first", "0 0 15 * 1", date(utc, 2026, 1, 13, 0, 0), date(utc, 2026, 1, 15, 0, 0)},
+		// Only one restricted: it alone decides
+		{"day of month with any weekday", "0 0 15 * *", date(utc, 2026, 1, 6, 0, 0), date(utc, 2026, 1, 15, 0, 0)},
+		{"weekday with any day of month", "0 0 * * 1", date(utc, 2026, 1, 13, 0, 0), date(utc, 2026, 1, 19, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(utc, 2026, 3, 1, 0, 0), date(utc, 2028, 2, 29, 0, 0)},
+		{"31st skips short months", "0 0 31 * *", date(utc, 2026, 4, 1, 0, 0), date(utc, 2026, 5, 31, 0, 0)},
+		{"never", "0 0 30 2 *", date(utc, 2026, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned %v", tt.expr, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) for %q = %v, want %v", tt.from, tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	// In 2026 New York clocks go forward at 02:00 on March 8 and back at
+	// 02:00 on November 1
+	est := time.FixedZone("EST", -5*3600)
+	edt := time.FixedZone("EDT", -4*3600)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"local time", "0 9 * * *", date(ny, 2026, 1, 5, 0, 0), date(est, 2026, 1, 5, 9, 0)},
+		{"across spring forward", "0 9 * * *", date(ny, 2026, 3, 7, 10, 0), date(edt, 2026, 3, 8, 9, 0)},
+		{"across fall back", "0 9 * * *", date(ny, 2026, 10, 31, 10, 0), date(est, 2026, 11, 1, 9, 0)},
+		// 02:30 doesn't exist on March 8; the run fires when the clocks
+		// change, not on the next day
+		{"skipped time", "30 2 * * *", date(ny, 2026, 3, 8, 0, 0), date(edt, 2026, 3, 8, 3, 0)},
+		{"after a skipped time", "30 2 * * *", date(edt, 2026, 3, 8, 3, 0), date(edt, 2026, 3, 9, 2, 30)},
+		{"every 15 minutes into the gap", "*/15 * * * *", date(est, 2026, 3, 8, 1, 45), date(edt, 2026, 3, 8, 3, 0)},
+		{"every 15 minutes after the gap", "*/15 * * * *", date(edt, 2026, 3, 8, 3, 0), date(edt, 2026, 3, 8, 3, 15)},
+		// 01:30 happens twice on November 1; the run fires once
+		{"repeated time", "30 1 * * *", date(ny, 2026, 11, 1, 0, 0), date(edt, 2026, 11, 1, 1, 30)},
+		{"after a repeated time", "30 1 * * *", date(edt, 2026, 11, 1, 1, 30), date(est, 2026, 11, 2, 1, 30)},
+		{"during the repeated hour", "30 1 * * *", date(est, 2026, 11, 1, 1, 10), date(est, 2026, 11, 2, 1, 30)},
+		{"hourly in the repeated hour", "0 * * * *", date(est, 2026, 11, 1, 1, 10), date(est, 2026, 11, 1, 2, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned %v", tt.expr, err)
+			}
+			got := s.Next(tt.from.In(ny))
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) for %q = %v, want %v", tt.from.In(ny), tt.expr, got, tt.want.In(ny))
+			}
+			if got.Location() != ny {
+				t.Errorf("Next returned a time in %v, want %v", got.Location(), ny)
+			}
+		})
+	}
+}
+
+func date(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, loc)
+}
+
+func span(lo, hi, step int) []int {
+	var vs []int
+	for v := lo; v <= hi; v += step {
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func bits(vs []int) uint64 {
+	var set uint64
+	for _, v := range vs {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func values(set uint64) []int {
+	var vs []int
+	for v := 0; v < 64; v++ {
+		if set&(1<<uint(v)) != 0 {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
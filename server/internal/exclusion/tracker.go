@@ -0,0 +1,190 @@
+package exclusion
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// pageViewTTL is how long the line items chosen for a page view are remembered,
+// so that slots requested later on the same page (lazy loading) still respect them
+const pageViewTTL = 5 * time.Minute
+
+// Page tracks the line items already chosen for earlier slots of a page view
+type Page struct {
+	advertiserIDs map[int]int    // campaign advertiser ID -> line item ID
+	advertisers   map[string]int // advertiser label -> line item ID
+	labels        map[string]int // competitive exclusion label -> line item ID
+}
+
+// NewPage creates an empty Page
+func NewPage() *Page {
+	return &Page{
+		advertiserIDs: make(map[int]int),
+		advertisers:   make(map[string]int),
+		labels:        make(map[string]int),
+	}
+}
+
+// Conflicts returns true if serving the line item would place it next to a
+// different line item with the same advertiser or a shared exclusion label
+func (p *Page) Conflicts(li models.LineItem) bool {
+	return p.Conflict(li) != ""
+}
+
+// Conflict describes why the line item conflicts with the page, or returns
+// an empty string if it does not. Line items are separated by their
+// campaign's advertiser and by their advertiser label, so a line item with
+// both still sees line items that only have the label.
+func (p *Page) Conflict(li models.LineItem) string {
+	if li.AdvertiserID != 0 {
+		if id, ok := p.advertiserIDs[li.AdvertiserID]; ok && id != li.ID {
+			return fmt.Sprintf("advertiser %d already on the page (line item %d)", li.AdvertiserID, id)
+		}
+	}
+	if li.Advertiser != "" {
+		if id, ok := p.advertisers[li.Advertiser]; ok && id != li.ID {
+			return fmt.Sprintf("advertiser %q already on the page (line item %d)", li.Advertiser, id)
+		}
+	}
+	for _, label := range li.CompetitiveExclusions {
+		if id, ok := p.labels[label]; ok && id != li.ID {
+			return fmt.Sprintf("exclusion label %q already on the page (line item %d)", label, id)
+		}
+	}
+	return ""
+}
+
+// Add records a line item as served on the page. Its label is recorded
+// as well as its advertiser, so line items without an advertiser still
+// see it.
+func (p *Page) Add(li models.LineItem) {
+	if li.AdvertiserID != 0 {
+		p.advertiserIDs[li.AdvertiserID] = li.ID
+	}
+	if li.Advertiser != "" {
+		p.advertisers[li.Advertiser] = li.ID
+	}
+	for _, label := range li.CompetitiveExclusions {
+		p.labels[label] = li.ID
+	}
+}
+
+// Filter returns the line items that do not conflict with the page
+func (p *Page) Filter(lineItems []models.LineItem) []models.LineItem {
+	var filtered []models.LineItem
+	for _, li := range lineItems {
+		if !p.Conflicts(li) {
+			filtered = append(filtered, li)
+		}
+	}
+	return filtered
+}
+
+type trackedPage struct {
+	page      *Page
+	expiresAt time.Time
+}
+
+// pageKey identifies a page view within a network, so page view IDs of
+// different networks never collide
+type pageKey struct {
+	networkID  int
+	pageViewID string
+}
+
+// Tracker remembers pages by network and page view ID across ad requests
+type Tracker struct {
+	mu    sync.Mutex
+	pages map[pageKey]*trackedPage
+	now   func() time.Time
+}
+
+// NewTracker creates a new Tracker
+func NewTracker() *Tracker {
+	t := &Tracker{
+		pages: make(map[pageKey]*trackedPage),
+		now:   time.Now,
+	}
+
+	// Start goroutine to drop expired page views
+	go t.startCleanup()
+
+	return t
+}
+
+// Get returns a copy of the page for a page view ID, or an empty page if
+// the ID is unknown or empty
+func (t *Tracker) Get(networkID int, pageViewID string) *Page {
+	page := NewPage()
+	if pageViewID == "" {
+		return page
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tp, ok := t.pages[pageKey{networkID, pageViewID}]; ok && t.now().Before(tp.expiresAt) {
+		for k, v := range tp.page.advertiserIDs {
+			page.advertiserIDs[k] = v
+		}
+		for k, v := range tp.page.advertisers {
+			page.advertisers[k] = v
+		}
+		for k, v := range tp.page.labels {
+			page.labels[k] = v
+		}
+	}
+	return page
+}
+
+// Reserve records a line item as served on a page view, unless a line item
+// recorded since the caller's Get conflicts with it. Concurrent requests for
+// the same page view (lazy-loaded slots) are merged into one page. Returns
+// the conflict, or an empty string if the line item was recorded.
+func (t *Tracker) Reserve(networkID int, pageViewID string, li models.LineItem) string {
+	if pageViewID == "" {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := pageKey{networkID, pageViewID}
+	now := t.now()
+	tp, ok := t.pages[key]
+	if !ok || now.After(tp.expiresAt) {
+		tp = &trackedPage{page: NewPage()}
+		t.pages[key] = tp
+	}
+	if conflict := tp.page.Conflict(li); conflict != "" {
+		return conflict
+	}
+	tp.page.Add(li)
+	tp.expiresAt = now.Add(pageViewTTL)
+	return ""
+}
+
+// startCleanup periodically removes expired page views
+func (t *Tracker) startCleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.removeExpired()
+	}
+}
+
+// removeExpired drops the page views past their TTL
+func (t *Tracker) removeExpired() {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, tp := range t.pages {
+		if now.After(tp.expiresAt) {
+			delete(t.pages, key)
+		}
+	}
+}
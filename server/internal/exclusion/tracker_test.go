@@ -0,0 +1,163 @@
+package exclusion
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// newTestTracker returns a tracker without the cleanup goroutine, on a clock
+// the test moves
+func newTestTracker(now *time.Time) *Tracker {
+	return &Tracker{
+		pages: make(map[pageKey]*trackedPage),
+		now:   func() time.Time { return *now },
+	}
+}
+
+func TestConflict(t *testing.T) {
+	served := []models.LineItem{
+		{ID: 1, AdvertiserID: 10, Advertiser: "Acme"},
+		{ID: 2, Advertiser: "Globex"},
+		{ID: 3, CompetitiveExclusions: []string{"auto", "finance"}},
+	}
+	tests := []struct {
+		name     string
+		li       models.LineItem
+		conflict bool
+	}{
+		{"same advertiser", models.LineItem{ID: 4, AdvertiserID: 10}, true},
+		{"same line item", models.LineItem{ID: 1, AdvertiserID: 10, Advertiser: "Acme"}, false},
+		{"other advertiser", models.LineItem{ID: 4, AdvertiserID: 11}, false},
+		{"label of an advertiser's line item", models.LineItem{ID: 4, Advertiser: "Acme"}, true},
+		{"advertiser with a served label", models.LineItem{ID: 4, AdvertiserID: 12, Advertiser: "Globex"}, true},
+		{"other advertiser and label", models.LineItem{ID: 4, AdvertiserID: 12, Advertiser: "Initech"}, false},
+		{"shared exclusion label", models.LineItem{ID: 4, CompetitiveExclusions: []string{"travel", "finance"}}, true},
+		{"other exclusion labels", models.LineItem{ID: 4, CompetitiveExclusions: []string{"travel"}}, false},
+		{"nothing to separate on", models.LineItem{ID: 4}, false},
+	}
+
+	page := NewPage()
+	for _, li := range served {
+		page.Add(li)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflict := page.Conflict(tt.li)
+			if (conflict != "") != tt.conflict {
+				t.Errorf("Conflict = %q, want a conflict: %v", conflict, tt.conflict)
+			}
+			if page.Conflicts(tt.li) != tt.conflict {
+				t.Errorf("Conflicts = %v, want %v", !tt.conflict, tt.conflict)
+			}
+		})
+	}
+
+	filtered := page.Filter([]models.LineItem{tests[0].li, tests[2].li, tests[3].li, tests[8].li})
+	if len(filtered) != 2 || filtered[0].AdvertiserID != 11 || filtered[1].ID != 4 || filtered[1].AdvertiserID != 0 {
+		t.Errorf("Filter kept %+v, want the other advertiser and the line item with nothing to separate on", filtered)
+	}
+}
+
+func TestTrackerGetCopies(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+	if conflict := tr.Reserve(1, "pv", models.LineItem{ID: 1, AdvertiserID: 10}); conflict != "" {
+		t.Fatalf("Reserve on an empty page: %s", conflict)
+	}
+
+	page := tr.Get(1, "pv")
+	if !page.Conflicts(models.LineItem{ID: 2, AdvertiserID: 10}) {
+		t.Fatal("Get lost the reserved advertiser")
+	}
+	page.Add(models.LineItem{ID: 3, AdvertiserID: 20})
+	if tr.Get(1, "pv").Conflicts(models.LineItem{ID: 4, AdvertiserID: 20}) {
+		t.Error("adding to the page Get returned changed the tracked page")
+	}
+
+	// Page views are per network, and an empty ID is never tracked
+	if tr.Get(2, "pv").Conflicts(models.LineItem{ID: 2, AdvertiserID: 10}) {
+		t.Error("another network's page view with the same ID sees the line item")
+	}
+	if conflict := tr.Reserve(1, "", models.LineItem{ID: 5, AdvertiserID: 10}); conflict != "" {
+		t.Errorf("Reserve without a page view ID: %s", conflict)
+	}
+	if tr.Get(1, "").Conflicts(models.LineItem{ID: 5, AdvertiserID: 10}) {
+		t.Error("Get without a page view ID returned a tracked page")
+	}
+}
+
+func TestTrackerReserveRace(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	// Lazy-loaded slots of one page view all picked line items of the same
+	// advertiser from the same empty page; only one may be served
+	const slots = 50
+	var wg sync.WaitGroup
+	reserved := make(chan int, slots)
+	for i := 1; i <= slots; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			li := models.LineItem{ID: id, AdvertiserID: 10}
+			if tr.Get(1, "pv").Conflicts(li) {
+				return
+			}
+			if tr.Reserve(1, "pv", li) == "" {
+				reserved <- id
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(reserved)
+
+	var ids []int
+	for id := range reserved {
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("reserved line items %v, want exactly one", ids)
+	}
+	if conflict := tr.Reserve(1, "pv", models.LineItem{ID: ids[0], AdvertiserID: 10}); conflict != "" {
+		t.Errorf("the reserved line item conflicts with itself: %s", conflict)
+	}
+}
+
+func TestTrackerTTL(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+	acme := models.LineItem{ID: 1, AdvertiserID: 10}
+	rival := models.LineItem{ID: 2, AdvertiserID: 10}
+
+	tr.Reserve(1, "pv", acme)
+	now = now.Add(pageViewTTL - time.Second)
+	if tr.Reserve(1, "pv", rival) == "" {
+		t.Fatal("a conflicting line item was reserved before the page view expired")
+	}
+
+	// Reserving again keeps the page view alive
+	tr.Reserve(1, "pv", acme)
+	now = now.Add(pageViewTTL - time.Second)
+	if !tr.Get(1, "pv").Conflicts(rival) {
+		t.Fatal("page view expired though it was reserved within its TTL")
+	}
+	tr.removeExpired()
+	if len(tr.pages) != 1 {
+		t.Fatalf("cleanup removed a live page view")
+	}
+
+	now = now.Add(2 * time.Second)
+	if tr.Get(1, "pv").Conflicts(rival) {
+		t.Error("Get returned an expired page view")
+	}
+	tr.removeExpired()
+	if len(tr.pages) != 0 {
+		t.Errorf("cleanup kept %d expired page views", len(tr.pages))
+	}
+	if conflict := tr.Reserve(1, "pv", rival); conflict != "" {
+		t.Errorf("Reserve after the page view expired: %s", conflict)
+	}
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// headers are the display headers of report columns; other columns use
+// their name
+var headers = map[string]string{
+	"date":          "Date",
+	"hour":          "Hour",
+	"advertiser_id": "Advertiser ID",
+	"advertiser":    "Advertiser",
+	"agency_id":     "Agency ID",
+	"agency":        "Agency",
+	"campaign_id":   "Campaign ID",
+	"campaign":      "Campaign",
+	"line_item_id":  "Line Item ID",
+	"line_item":     "Line Item",
+	"creative_id":   "Creative ID",
+	"creative":      "Creative",
+	"size":          "Size",
+	"ad_unit":       "Ad Unit",
+	"placement_id":  "Placement ID",
+	"placement":     "Placement",
+	"country":       "Country",
+	"section":       "Section",
+	"platform":      "Platform",
+	"impressions":   "Impressions",
+	"clicks":        "Clicks",
+	"viewable":      "Viewable",
+	"ctr":           "CTR",
+	"viewability":   "Viewability",
+	"unique_users":  "Unique Users",
+}
+
+// Header returns the display header of a report column
+func Header(column string) string {
+	if header, ok := headers[column]; ok {
+		return header
+	}
+	return column
+}
+
+// csvWriter writes report rows as CSV with a header row. Every field is
+// quoted as needed, whatever its column.
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+// NewCSVWriter creates a RowWriter that writes CSV and writes the header row
+func NewCSVWriter(w io.Writer, columns []string) (RowWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		cw.record[i] = Header(column)
+	}
+	if err := cw.w.Write(cw.record); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		cw.record[i] = csvValue(cw.columns[i], value)
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csvValue formats a report value for CSV; rate metrics are percentages
+func csvValue(column string, value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		if storage.IsRateMetric(column) {
+			return fmt.Sprintf("%.2f%%", v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestCSVEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCSVWriter(&buf, []string{"country", "section", "impressions", "ctr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Country and section are whatever the ad request sent
+	rows := [][]interface{}{
+		{"Korea, Republic of", `the "best" news`, int64(10), 12.345},
+		{"US", "line\nbreak", int64(0), nil},
+		{" GB", "café,bar\r\n", uint64(3), 0.0},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRaw := "Country,Section,Impressions,CTR\n" +
+		`"Korea, Republic of","the ""best"" news",10,12.35%` + "\n" +
+		"US,\"line\nbreak\",0,\n" +
+		"\" GB\",\"café,bar\r\n\",3,0.00%\n"
+	if buf.String() != wantRaw {
+		t.Errorf("csv\n%q\nwant\n%q", buf.String(), wantRaw)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+	want := [][]string{
+		{"Country", "Section", "Impressions", "CTR"},
+		{"Korea, Republic of", `the "best" news`, "10", "12.35%"},
+		{"US", "line\nbreak", "0", ""},
+		// encoding/csv reads a quoted \r\n back as \n
+		{" GB", "café,bar\n", "3", "0.00%"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("read back\n%q\nwant\n%q", records, want)
+	}
+}
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		column string
+		value  interface{}
+		want   string
+	}{
+		{"country", nil, ""},
+		{"country", "FR", "FR"},
+		{"impressions", int64(1200), "1200"},
+		{"impressions", 1200.0, "1200"},
+		{"viewability", 66.666, "66.67%"},
+		{"hour", int32(23), "23"},
+	}
+	for _, tt := range tests {
+		if got := csvValue(tt.column, tt.value); got != tt.want {
+			t.Errorf("csvValue(%s, %v) = %q, want %q", tt.column, tt.value, got, tt.want)
+		}
+	}
+}
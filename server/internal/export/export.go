@@ -0,0 +1,84 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// RowWriter writes report rows to a file as they arrive, so an export never
+// holds the whole result in memory. Close finishes the file; it does not
+// close the underlying writer.
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// IsValidFormat checks if a file format is supported
+func IsValidFormat(format string) bool {
+	return format == "csv" || format == "json" || format == "xlsx" || format == "parquet"
+}
+
+// NewWriter creates a RowWriter for a file format (csv, json, xlsx or
+// parquet) with the given columns
+func NewWriter(w io.Writer, format string, columns []string) (RowWriter, error) {
+	switch format {
+	case "csv":
+		return NewCSVWriter(w, columns)
+	case "json":
+		return NewJSONWriter(w, columns, nil)
+	case "xlsx":
+		return NewXLSXWriter(w, columns)
+	case "parquet":
+		return NewParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// NewReportWriter creates a RowWriter for a report query's columns. JSON
+// output also records the query's timezone and date range.
+func NewReportWriter(w io.Writer, format string, q *storage.ReportQuery) (RowWriter, error) {
+	if format == "json" {
+		return NewJSONWriter(w, q.Columns(), map[string]interface{}{
+			"timezone":   q.Timezone,
+			"start_date": q.StartDate.Format("2006-01-02"),
+			"end_date":   q.EndDate.AddDate(0, 0, -1).Format("2006-01-02"),
+		})
+	}
+	return NewWriter(w, format, q.Columns())
+}
+
+// Filename returns the download filename of a report export
+func Filename(format string, startDate, endDate time.Time) string {
+	return fmt.Sprintf("report_%s_to_%s.%s",
+		startDate.Format("2006-01-02"), endDate.AddDate(0, 0, -1).Format("2006-01-02"), format)
+}
+
+// Write renders a whole report result in a file format
+func Write(w io.Writer, format string, result *storage.ReportResult) error {
+	rw, err := NewWriter(w, format, result.Columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range result.Rows {
+		if err := rw.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// ContentType returns the MIME type of a file format
+func ContentType(format string) string {
+	switch format {
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "parquet":
+		return "application/vnd.apache.parquet"
+	case "json":
+		return "application/json"
+	}
+	return "text/csv"
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// jobInterval is how often queued jobs and expired files are checked for
+	// when nothing wakes the runner sooner
+	jobInterval = 1 * time.Minute
+	// jobTimeout bounds one export's query and file write
+	jobTimeout = 30 * time.Minute
+	// jobRetention is how long a finished export can be downloaded, and how
+	// long a failed one is kept
+	jobRetention = 24 * time.Hour
+)
+
+// JobRunner runs export jobs from a background goroutine. Each job streams
+// its query into a file under dir, which can be downloaded until it expires.
+// Jobs are claimed with row locks, so several servers can run side by side
+// as long as they share dir.
+type JobRunner struct {
+	store   *storage.PostgresStore
+	reports storage.ReportReader
+	dir     string
+	wake    chan struct{}
+}
+
+// NewJobRunner creates a new JobRunner writing files to dir, which is
+// created if needed. Queries run against reports (Postgres or ClickHouse).
+func NewJobRunner(store *storage.PostgresStore, reports storage.ReportReader, dir string) (*JobRunner, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &JobRunner{
+		store:   store,
+		reports: reports,
+		dir:     dir,
+		wake:    make(chan struct{}, 1),
+	}
+
+	// Start goroutine to run queued jobs
+	go r.loop()
+
+	return r, nil
+}
+
+// Notify wakes the runner to pick up a newly queued job
+func (r *JobRunner) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *JobRunner) loop() {
+	r.run()
+
+	ticker := time.NewTicker(jobInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		}
+		r.run()
+	}
+}
+
+func (r *JobRunner) run() {
+	ctx := context.Background()
+	r.deleteExpired(ctx)
+
+	for {
+		job, err := r.store.ClaimExportJob(ctx)
+		if err != nil {
+			log.Printf("Warning: Failed to claim export job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		r.execute(ctx, job)
+	}
+}
+
+// execute runs one claimed job, recording the outcome
+func (r *JobRunner) execute(ctx context.Context, job *models.ExportJob) {
+	path := filepath.Join(r.dir, job.ID+"."+job.Format)
+	rows, size, err := r.write(ctx, job, path)
+	if err != nil {
+		log.Printf("Warning: Export job %s failed: %v", job.ID, err)
+		if err := r.store.FailExportJob(ctx, job.ID, err.Error(), time.Now().Add(jobRetention)); err != nil {
+			log.Printf("Warning: Failed to record export job %s failure: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := r.store.CompleteExportJob(ctx, job.ID, path, rows, size, time.Now().Add(jobRetention)); err != nil {
+		log.Printf("Warning: Failed to record export job %s: %v", job.ID, err)
+	}
+}
+
+// write streams a job's query into a file at path and returns its row count
+// and size. The file is written under a temporary name and only renamed into
+// place once complete.
+func (r *JobRunner) write(ctx context.Context, job *models.ExportJob, path string) (int, int64, error) {
+	q, err := storage.NewReportQuery(job.NetworkID, &job.Query, job.StartDate, job.EndDate, job.Timezone)
+	if err != nil {
+		return 0, 0, err
+	}
+	q.Limit = 0
+
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	rw, err := NewReportWriter(f, job.Format, q)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows := 0
+	err = r.reports.StreamReportQuery(ctx, q, func(values []interface{}) error {
+		rows++
+		return rw.WriteRow(values)
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("query: %w", err)
+	}
+	if err := rw.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+	return rows, info.Size(), nil
+}
+
+// deleteExpired removes expired jobs and their files
+func (r *JobRunner) deleteExpired(ctx context.Context) {
+	paths, err := r.store.DeleteExpiredExportJobs(ctx, time.Now())
+	if err != nil {
+		log.Printf("Warning: Failed to delete expired export jobs: %v", err)
+		return
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to delete export file %s: %v", path, err)
+		}
+	}
+}
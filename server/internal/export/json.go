@@ -0,0 +1,80 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// jsonWriter writes report rows as a JSON object: the columns, any extra
+// fields, then "data", an array with one object per row keyed by column
+type jsonWriter struct {
+	w       *bufio.Writer
+	columns [][]byte // JSON-encoded column names
+	rows    int
+}
+
+// NewJSONWriter creates a RowWriter that writes JSON. fields are added to
+// the top-level object ahead of the data.
+func NewJSONWriter(w io.Writer, columns []string, fields map[string]interface{}) (RowWriter, error) {
+	jw := &jsonWriter{w: bufio.NewWriter(w)}
+	for _, column := range columns {
+		name, _ := json.Marshal(column)
+		jw.columns = append(jw.columns, name)
+	}
+
+	columnsJSON, err := json.Marshal(columns)
+	if err != nil {
+		return nil, err
+	}
+	jw.w.WriteString(`{"columns":`)
+	jw.w.Write(columnsJSON)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(fields[k])
+		if err != nil {
+			return nil, err
+		}
+		jw.w.WriteString(",")
+		jw.w.Write(key)
+		jw.w.WriteString(":")
+		jw.w.Write(value)
+	}
+	jw.w.WriteString(`,"data":[`)
+	return jw, nil
+}
+
+func (jw *jsonWriter) WriteRow(values []interface{}) error {
+	if jw.rows > 0 {
+		jw.w.WriteString(",")
+	}
+	jw.rows++
+
+	jw.w.WriteString("{")
+	for i, value := range values {
+		if i > 0 {
+			jw.w.WriteString(",")
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		jw.w.Write(jw.columns[i])
+		jw.w.WriteString(":")
+		jw.w.Write(encoded)
+	}
+	_, err := jw.w.WriteString("}")
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	jw.w.WriteString("]}")
+	return jw.w.Flush()
+}
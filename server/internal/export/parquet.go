@@ -0,0 +1,411 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// parquetRowGroupSize is how many rows are buffered before they are written
+// out as a row group, which bounds the writer's memory
+const parquetRowGroupSize = 50000
+
+// Parquet physical types, encodings and other enum values used in the
+// file metadata
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+	parquetUTF8     = 0
+
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetIntegerColumns are the report columns written as INT64; rate
+// metrics are DOUBLE and everything else is a UTF-8 string
+var parquetIntegerColumns = map[string]bool{
+	"hour":          true,
+	"advertiser_id": true,
+	"agency_id":     true,
+	"campaign_id":   true,
+	"line_item_id":  true,
+	"creative_id":   true,
+	"placement_id":  true,
+	"impressions":   true,
+	"clicks":        true,
+	"viewable":      true,
+	"unique_users":  true,
+}
+
+// parquetWriter writes report rows as an uncompressed Parquet file, one
+// optional column per report column and one data page per column chunk.
+// Rows are buffered per column and flushed as a row group every
+// parquetRowGroupSize rows.
+type parquetWriter struct {
+	w       *countingWriter
+	columns []*parquetColumn
+	rows    int // rows in the current row group
+	total   int64
+	groups  []parquetRowGroup
+}
+
+type parquetColumn struct {
+	name     string
+	kind     int32
+	defined  []bool // definition level of each row: false is null
+	values   bytes.Buffer
+	scratch  [8]byte
+	chunkBuf bytes.Buffer
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// NewParquetWriter creates a RowWriter that writes a Parquet file
+func NewParquetWriter(w io.Writer, columns []string) (RowWriter, error) {
+	pw := &parquetWriter{w: &countingWriter{w: w}}
+	for _, name := range columns {
+		kind := int32(parquetByteArray)
+		if parquetIntegerColumns[name] {
+			kind = parquetInt64
+		} else if storage.IsRateMetric(name) {
+			kind = parquetDouble
+		}
+		pw.columns = append(pw.columns, &parquetColumn{name: name, kind: kind})
+	}
+
+	if _, err := io.WriteString(pw.w, "PAR1"); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		if err := pw.columns[i].add(value); err != nil {
+			return err
+		}
+	}
+	pw.rows++
+	if pw.rows == parquetRowGroupSize {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+func (pw *parquetWriter) Close() error {
+	if pw.rows > 0 {
+		if err := pw.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	footer := pw.fileMetaData()
+	if _, err := pw.w.Write(footer); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if _, err := pw.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(pw.w, "PAR1")
+	return err
+}
+
+// add appends one value to the column's buffers
+func (c *parquetColumn) add(value interface{}) error {
+	if value == nil {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+
+	switch c.kind {
+	case parquetInt64:
+		var n int64
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case int32:
+			n = int64(v)
+		case int:
+			n = int64(v)
+		case uint32:
+			n = int64(v)
+		case uint64:
+			n = int64(v)
+		case float64:
+			n = int64(v)
+		default:
+			return fmt.Errorf("column %s: cannot write %T as an integer", c.name, value)
+		}
+		binary.LittleEndian.PutUint64(c.scratch[:], uint64(n))
+		c.values.Write(c.scratch[:])
+	case parquetDouble:
+		f, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("column %s: cannot write %T as a double", c.name, value)
+		}
+		binary.LittleEndian.PutUint64(c.scratch[:], math.Float64bits(f))
+		c.values.Write(c.scratch[:])
+	default:
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		binary.LittleEndian.PutUint32(c.scratch[:4], uint32(len(s)))
+		c.values.Write(c.scratch[:4])
+		c.values.WriteString(s)
+	}
+	c.defined = append(c.defined, true)
+	return nil
+}
+
+// flushRowGroup writes the buffered rows as a row group: one column chunk
+// per column, each a single PLAIN data page
+func (pw *parquetWriter) flushRowGroup() error {
+	group := parquetRowGroup{rows: int64(pw.rows)}
+	for _, c := range pw.columns {
+		page := &c.chunkBuf
+		page.Reset()
+
+		levels := encodeDefinitionLevels(c.defined)
+		binary.LittleEndian.PutUint32(c.scratch[:4], uint32(len(levels)))
+		page.Write(c.scratch[:4])
+		page.Write(levels)
+		page.Write(c.values.Bytes())
+
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.structBegin(5)
+		header.i32(1, int32(pw.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.structEnd()
+		header.stop()
+
+		chunk := parquetChunk{offset: pw.w.n, size: int64(header.buf.Len() + page.Len())}
+		if _, err := pw.w.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := pw.w.Write(page.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.size
+
+		c.defined = c.defined[:0]
+		c.values.Reset()
+	}
+
+	pw.groups = append(pw.groups, group)
+	pw.total += int64(pw.rows)
+	pw.rows = 0
+	return nil
+}
+
+// encodeDefinitionLevels encodes definition levels (bit width 1) with the
+// RLE/bit-packing hybrid encoding, as runs of equal levels
+func encodeDefinitionLevels(defined []bool) []byte {
+	var buf []byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		if defined[i] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		i = j
+	}
+	return buf
+}
+
+// fileMetaData encodes the footer: schema, row groups and column chunk
+// locations
+func (pw *parquetWriter) fileMetaData() []byte {
+	var t thriftWriter
+	t.i32(1, 1) // version
+
+	t.listBegin(2, thriftStruct, len(pw.columns)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)))
+	t.elemEnd()
+	for _, c := range pw.columns {
+		t.elemBegin()
+		t.i32(1, c.kind)
+		t.i32(3, parquetOptional)
+		t.binary(4, c.name)
+		if c.kind == parquetByteArray {
+			t.i32(6, parquetUTF8)
+		}
+		t.elemEnd()
+	}
+
+	t.i64(3, pw.total)
+
+	t.listBegin(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			c := pw.columns[i]
+			t.elemBegin()
+			t.i64(2, chunk.offset)
+			t.structBegin(3)
+			t.i32(1, c.kind)
+			t.listBegin(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.listBinary(c.name)
+			t.i32(4, parquetUncompressed)
+			t.i64(5, g.rows)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.elemEnd()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.elemEnd()
+	}
+
+	t.binary(6, "mims-ad-manager")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// countingWriter counts the bytes written, for column chunk offsets
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol type IDs
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol structs Parquet metadata
+// is made of. Fields must be written in increasing ID order within a struct.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+// elemBegin starts a struct that is a list element (or a field, after
+// field); elemEnd ends it
+func (t *thriftWriter) elemBegin() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) listBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
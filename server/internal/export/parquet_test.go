@@ -0,0 +1,368 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes Thrift compact protocol values into Go values:
+// structs as maps of field ID to value, lists as slices, integers as int64
+// and binary as string
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) readStruct() (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var lastID int16
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return fields, nil
+		}
+		id := lastID + int16(b>>4)
+		if b>>4 == 0 {
+			n, err := binary.ReadVarint(t.r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(n)
+		}
+		lastID = id
+		if fields[id], err = t.readValue(b & 0x0f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *thriftReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		return binary.ReadVarint(t.r)
+	case thriftBinary:
+		n, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(t.r, b)
+		return string(b), err
+	case thriftList:
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(b >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(t.r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = t.readValue(b & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftStruct:
+		return t.readStruct()
+	}
+	return nil, fmt.Errorf("unsupported thrift type %d", typ)
+}
+
+// parquetFile is a Parquet file read back: its footer and its rows
+type parquetFile struct {
+	meta    map[int16]interface{}
+	columns []string
+	kinds   []int64
+	groups  []int64 // rows in each row group
+	rows    [][]interface{}
+}
+
+// readParquet reads back a file written by parquetWriter, checking its
+// structure along the way. Values are int64, float64, string or nil.
+func readParquet(t *testing.T, data []byte) *parquetFile {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("file does not start and end with PAR1")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	meta, err := (&thriftReader{bytes.NewReader(data[footerStart : len(data)-8])}).readStruct()
+	if err != nil {
+		t.Fatalf("decode footer: %v", err)
+	}
+
+	f := &parquetFile{meta: meta}
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if root[5].(int64) != int64(len(schema)-1) {
+		t.Fatalf("schema root has %d children, want %d", root[5], len(schema)-1)
+	}
+	for _, e := range schema[1:] {
+		element := e.(map[int16]interface{})
+		if element[3].(int64) != parquetOptional {
+			t.Errorf("column %s is not optional", element[4])
+		}
+		f.columns = append(f.columns, element[4].(string))
+		f.kinds = append(f.kinds, element[1].(int64))
+	}
+
+	offset := int64(4)
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(map[int16]interface{})
+		rows := group[3].(int64)
+		f.groups = append(f.groups, rows)
+		groupRows := make([][]interface{}, rows)
+		for i := range groupRows {
+			groupRows[i] = make([]interface{}, len(f.columns))
+		}
+
+		var groupSize int64
+		for i, c := range group[1].([]interface{}) {
+			chunk := c.(map[int16]interface{})
+			chunkMeta := chunk[3].(map[int16]interface{})
+			if chunk[2] != offset || chunkMeta[9] != offset {
+				t.Fatalf("column chunk at %v (data page %v), want %d", chunk[2], chunkMeta[9], offset)
+			}
+			if chunkMeta[1] != f.kinds[i] || chunkMeta[5] != rows {
+				t.Errorf("column chunk type %v with %v values, want %d with %d", chunkMeta[1], chunkMeta[5], f.kinds[i], rows)
+			}
+			if path := chunkMeta[3].([]interface{}); len(path) != 1 || path[0] != f.columns[i] {
+				t.Errorf("column chunk path %v, want [%s]", path, f.columns[i])
+			}
+			size := chunkMeta[7].(int64)
+
+			r := bytes.NewReader(data[offset : offset+size])
+			header, err := (&thriftReader{r}).readStruct()
+			if err != nil {
+				t.Fatalf("decode page header: %v", err)
+			}
+			pageHeader := header[5].(map[int16]interface{})
+			if header[1].(int64) != parquetDataPage || pageHeader[1] != rows {
+				t.Fatalf("page type %v with %v values, want a data page with %d", header[1], pageHeader[1], rows)
+			}
+			page := make([]byte, header[3].(int64))
+			if _, err := io.ReadFull(r, page); err != nil {
+				t.Fatalf("read page: %v", err)
+			}
+			if r.Len() != 0 {
+				t.Fatalf("column chunk has %d bytes after its page", r.Len())
+			}
+			readColumn(t, page, f.kinds[i], groupRows, i)
+
+			offset += size
+			groupSize += size
+		}
+		if group[2] != groupSize {
+			t.Errorf("row group size %v, want %d", group[2], groupSize)
+		}
+		f.rows = append(f.rows, groupRows...)
+	}
+
+	if offset != int64(footerStart) {
+		t.Errorf("footer at %d, want it after the last column chunk at %d", footerStart, offset)
+	}
+	if meta[3] != int64(len(f.rows)) {
+		t.Errorf("file has %v rows, row groups %d", meta[3], len(f.rows))
+	}
+	return f
+}
+
+// readColumn decodes a data page into column i of rows
+func readColumn(t *testing.T, page []byte, kind int64, rows [][]interface{}, i int) {
+	t.Helper()
+	levelsLen := binary.LittleEndian.Uint32(page)
+	defined := decodeDefinitionLevels(t, page[4:4+levelsLen], len(rows))
+	values := bytes.NewReader(page[4+levelsLen:])
+
+	for row := range rows {
+		if !defined[row] {
+			continue
+		}
+		switch kind {
+		case parquetInt64:
+			var n int64
+			binary.Read(values, binary.LittleEndian, &n)
+			rows[row][i] = n
+		case parquetDouble:
+			var bits uint64
+			binary.Read(values, binary.LittleEndian, &bits)
+			rows[row][i] = math.Float64frombits(bits)
+		default:
+			var n uint32
+			binary.Read(values, binary.LittleEndian, &n)
+			s := make([]byte, n)
+			io.ReadFull(values, s)
+			rows[row][i] = string(s)
+		}
+	}
+	if values.Len() != 0 {
+		t.Errorf("page has %d bytes past its values", values.Len())
+	}
+}
+
+// decodeDefinitionLevels decodes bit width 1 levels in the RLE/bit-packing
+// hybrid encoding
+func decodeDefinitionLevels(t *testing.T, levels []byte, n int) []bool {
+	t.Helper()
+	r := bytes.NewReader(levels)
+	var defined []bool
+	for r.Len() > 0 {
+		header, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("decode level run: %v", err)
+		}
+		if header&1 == 1 {
+			// Bit-packed groups of 8
+			for g := uint64(0); g < header>>1; g++ {
+				b, _ := r.ReadByte()
+				for bit := 0; bit < 8; bit++ {
+					defined = append(defined, b>>bit&1 == 1)
+				}
+			}
+			continue
+		}
+		b, _ := r.ReadByte()
+		for j := uint64(0); j < header>>1; j++ {
+			defined = append(defined, b == 1)
+		}
+	}
+	if len(defined) < n {
+		t.Fatalf("%d definition levels, want %d", len(defined), n)
+	}
+	return defined[:n]
+}
+
+// writeParquet writes rows with columns and returns the file
+func writeParquet(t *testing.T, columns []string, rows [][]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewParquetWriter(&buf, columns)
+	if err != nil {
+		t.Fatalf("NewParquetWriter: %v", err)
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := []string{"date", "country", "line_item_id", "impressions", "ctr"}
+	rows := [][]interface{}{
+		{"2026-03-01", "US", 7, int64(1200), 1.25},
+		{"2026-03-01", nil, nil, int64(0), nil},
+		{"2026-03-02", "Côte d'Ivoire", uint32(8), uint64(3), 0.0},
+		{"2026-03-02", "", int32(-1), 4.0, 100.0},
+		{nil, nil, nil, nil, nil},
+	}
+
+	f := readParquet(t, writeParquet(t, columns, rows))
+	if !reflect.DeepEqual(f.columns, columns) {
+		t.Errorf("columns %v, want %v", f.columns, columns)
+	}
+	wantKinds := []int64{parquetByteArray, parquetByteArray, parquetInt64, parquetInt64, parquetDouble}
+	if !reflect.DeepEqual(f.kinds, wantKinds) {
+		t.Errorf("column types %v, want %v", f.kinds, wantKinds)
+	}
+	if f.meta[6] != "mims-ad-manager" {
+		t.Errorf("created_by %v", f.meta[6])
+	}
+
+	want := [][]interface{}{
+		{"2026-03-01", "US", int64(7), int64(1200), 1.25},
+		{"2026-03-01", nil, nil, int64(0), nil},
+		{"2026-03-02", "Côte d'Ivoire", int64(8), int64(3), 0.0},
+		{"2026-03-02", "", int64(-1), int64(4), 100.0},
+		{nil, nil, nil, nil, nil},
+	}
+	if !reflect.DeepEqual(f.rows, want) {
+		t.Errorf("rows\n%v\nwant\n%v", f.rows, want)
+	}
+}
+
+func TestParquetEmpty(t *testing.T) {
+	f := readParquet(t, writeParquet(t, []string{"date", "impressions"}, nil))
+	if len(f.groups) != 0 || len(f.rows) != 0 {
+		t.Errorf("%d row groups with %d rows, want none", len(f.groups), len(f.rows))
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	n := 2*parquetRowGroupSize + 10
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		// Every seventh section is null, so null runs cross row groups
+		var section interface{} = fmt.Sprintf("s%d", i%3)
+		if i%7 == 0 {
+			section = nil
+		}
+		rows[i] = []interface{}{section, int64(i)}
+	}
+
+	f := readParquet(t, writeParquet(t, []string{"section", "impressions"}, rows))
+	if want := []int64{parquetRowGroupSize, parquetRowGroupSize, 10}; !reflect.DeepEqual(f.groups, want) {
+		t.Fatalf("row groups of %v rows, want %v", f.groups, want)
+	}
+	if len(f.rows) != n {
+		t.Fatalf("read %d rows, want %d", len(f.rows), n)
+	}
+	for i, row := range f.rows {
+		if !reflect.DeepEqual(row, rows[i]) {
+			t.Fatalf("row %d is %v, want %v", i, row, rows[i])
+		}
+	}
+}
+
+func TestParquetRejectsBadValues(t *testing.T) {
+	tests := []struct {
+		column string
+		value  interface{}
+	}{
+		{"impressions", "12"},
+		{"ctr", int64(1)},
+	}
+	for _, tt := range tests {
+		w, err := NewParquetWriter(io.Discard, []string{tt.column})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteRow([]interface{}{tt.value}); err == nil {
+			t.Errorf("writing %T to %s succeeded, want an error", tt.value, tt.column)
+		}
+	}
+}
+
+func TestEncodeDefinitionLevels(t *testing.T) {
+	tests := []struct {
+		defined []bool
+		want    []byte
+	}{
+		{nil, nil},
+		{[]bool{true}, []byte{2, 1}},
+		{[]bool{true, true, false}, []byte{4, 1, 2, 0}},
+		{[]bool{false, true, false}, []byte{2, 0, 2, 1, 2, 0}},
+		// A run of 64 takes a two-byte varint header
+		{make([]bool, 64), []byte{0x80, 0x01, 0}},
+	}
+	for _, tt := range tests {
+		if got := encodeDefinitionLevels(tt.defined); !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeDefinitionLevels(%v) = %v, want %v", tt.defined, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// The fixed parts of a single-sheet workbook. Cell style 1 is a bold header,
+// style 2 a percentage.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Report" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="3">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="10" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs>` +
+		`</styleSheet>`
+)
+
+// xlsxMaxRows is the most rows an Excel sheet holds, header included
+const xlsxMaxRows = 1048576
+
+// xlsxWriter writes report rows as an Excel workbook with one sheet: a bold
+// header row, then one row per report row. The sheet is streamed into the
+// zip as rows arrive. Numbers are written as numbers and rate metrics as
+// percentages, so they sort and sum in Excel.
+type xlsxWriter struct {
+	zw      *zip.Writer
+	sheet   *bufio.Writer
+	columns []string
+	row     int
+}
+
+// NewXLSXWriter creates a RowWriter that writes an XLSX workbook and writes
+// the header row
+func NewXLSXWriter(w io.Writer, columns []string) (RowWriter, error) {
+	zw := zip.NewWriter(w)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(f), columns: columns, row: 1}
+	xw.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	xw.sheet.WriteString(`<row r="1">`)
+	for i, column := range columns {
+		writeStringCell(xw.sheet, cellRef(i, 1), Header(column), 1)
+	}
+	xw.sheet.WriteString(`</row>`)
+	return xw, nil
+}
+
+func (xw *xlsxWriter) WriteRow(values []interface{}) error {
+	if xw.row >= xlsxMaxRows {
+		return fmt.Errorf("xlsx holds at most %d rows; export as csv or parquet", xlsxMaxRows-1)
+	}
+	xw.row++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.row)
+	for i, value := range values {
+		writeCell(xw.sheet, cellRef(i, xw.row), xw.columns[i], value)
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) Close() error {
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zw.Close()
+}
+
+// writeCell writes one report value: numbers as numeric cells, rate metrics
+// (percentages) as fractions in percent format, anything else as text
+func writeCell(w *bufio.Writer, ref, column string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		writeStringCell(w, ref, v, 0)
+	case float64:
+		if storage.IsRateMetric(column) {
+			fmt.Fprintf(w, `<c r="%s" s="2"><v>%s</v></c>`, ref, strconv.FormatFloat(v/100, 'g', -1, 64))
+			return
+		}
+		fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'g', -1, 64))
+	case int, int32, int64, uint32, uint64:
+		fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, v)
+	default:
+		writeStringCell(w, ref, fmt.Sprint(v), 0)
+	}
+}
+
+func writeStringCell(w *bufio.Writer, ref, value string, style int) {
+	fmt.Fprintf(w, `<c r="%s" t="inlineStr"`, ref)
+	if style > 0 {
+		fmt.Fprintf(w, ` s="%d"`, style)
+	}
+	w.WriteString(`><is><t xml:space="preserve">`)
+	xml.EscapeText(w, []byte(value))
+	w.WriteString(`</t></is></c>`)
+}
+
+// cellRef returns the A1 reference of a zero-based column and a row number
+func cellRef(col, row int) string {
+	var name []byte
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = append(name, byte('A'+(col-1)%26))
+	}
+	for i, j := 0, len(name)-1; i < j; i, j = i+1, j-1 {
+		name[i], name[j] = name[j], name[i]
+	}
+	return string(name) + strconv.Itoa(row)
+}
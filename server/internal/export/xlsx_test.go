@@ -0,0 +1,190 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+// xlsxCell is a cell of sheet1.xml as read back
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Style  string `xml:"s,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Ref   string     `xml:"r,attr"`
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX unzips a workbook, checks every part is well-formed XML and
+// returns the sheet
+func readXLSX(t *testing.T, data []byte) *xlsxSheet {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		dec := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", f.Name, err)
+			}
+		}
+		parts[f.Name] = body
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook has no %s", name)
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatalf("decode sheet: %v", err)
+	}
+	return &sheet
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXWriter(&buf, []string{"date", "section", "line_item_id", "impressions", "ctr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]interface{}{
+		{"2026-03-01", `<sports> & "news"`, int64(7), int64(1200), 12.5},
+		{"2026-03-02", nil, uint32(8), 3.5, nil},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sheet := readXLSX(t, buf.Bytes())
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("sheet has %d rows, want a header and 2", len(sheet.Rows))
+	}
+	want := [][]xlsxCell{
+		{
+			{Ref: "A1", Type: "inlineStr", Style: "1", Inline: "Date"},
+			{Ref: "B1", Type: "inlineStr", Style: "1", Inline: "Section"},
+			{Ref: "C1", Type: "inlineStr", Style: "1", Inline: "Line Item ID"},
+			{Ref: "D1", Type: "inlineStr", Style: "1", Inline: "Impressions"},
+			{Ref: "E1", Type: "inlineStr", Style: "1", Inline: "CTR"},
+		},
+		{
+			{Ref: "A2", Type: "inlineStr", Inline: "2026-03-01"},
+			{Ref: "B2", Type: "inlineStr", Inline: `<sports> & "news"`},
+			{Ref: "C2", Value: "7"},
+			{Ref: "D2", Value: "1200"},
+			{Ref: "E2", Style: "2", Value: "0.125"},
+		},
+		{
+			{Ref: "A3", Type: "inlineStr", Inline: "2026-03-02"},
+			{Ref: "C3", Value: "8"},
+			{Ref: "D3", Value: "3.5"},
+		},
+	}
+	for i, row := range sheet.Rows {
+		if !reflect.DeepEqual(row.Cells, want[i]) {
+			t.Errorf("row %s is\n%+v\nwant\n%+v", row.Ref, row.Cells, want[i])
+		}
+	}
+}
+
+func TestXLSXManyColumns(t *testing.T) {
+	columns := make([]string, 30)
+	row := make([]interface{}, 30)
+	for i := range columns {
+		columns[i] = strings.Repeat("c", i+1)
+		row[i] = int64(i)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "xlsx", &storage.ReportResult{Columns: columns, Rows: [][]interface{}{row}}); err != nil {
+		t.Fatal(err)
+	}
+	sheet := readXLSX(t, buf.Bytes())
+	cells := sheet.Rows[1].Cells
+	if len(cells) != 30 || cells[25].Ref != "Z2" || cells[26].Ref != "AA2" || cells[29].Ref != "AD2" {
+		t.Fatalf("cells %+v, want A2 to AD2", cells)
+	}
+}
+
+func TestXLSXRowLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXWriter(&buf, []string{"impressions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Start just short of the limit rather than writing a million rows
+	w.(*xlsxWriter).row = xlsxMaxRows - 1
+
+	if err := w.WriteRow([]interface{}{int64(1)}); err != nil {
+		t.Fatalf("writing the last row the sheet holds: %v", err)
+	}
+	if err := w.WriteRow([]interface{}{int64(2)}); err == nil {
+		t.Fatal("writing past the row limit succeeded, want an error")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sheet := readXLSX(t, buf.Bytes())
+	last := sheet.Rows[len(sheet.Rows)-1]
+	if last.Ref != "1048576" || last.Cells[0].Ref != "A1048576" || last.Cells[0].Value != "1" {
+		t.Errorf("last row %+v, want row 1048576 holding 1", last)
+	}
+}
+
+func TestCellRef(t *testing.T) {
+	tests := []struct {
+		col, row int
+		want     string
+	}{
+		{0, 1, "A1"},
+		{25, 2, "Z2"},
+		{26, 3, "AA3"},
+		{51, 4, "AZ4"},
+		{52, 5, "BA5"},
+		{701, 6, "ZZ6"},
+		{702, 7, "AAA7"},
+		{16383, 1048576, "XFD1048576"},
+	}
+	for _, tt := range tests {
+		if got := cellRef(tt.col, tt.row); got != tt.want {
+			t.Errorf("cellRef(%d, %d) = %s, want %s", tt.col, tt.row, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package forecast
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mims/ad-manager/internal/inventory"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+	"github.com/mims/ad-manager/internal/targeting"
+)
+
+// lookbackDays is how much logged traffic the forecast is based on
+const lookbackDays = 28
+
+// defaultPriority matches the priority new line items get when none is set
+const defaultPriority = 5
+
+// segmentKeys are the targeting keys logged with traffic; rules on any
+// other key cannot be evaluated against history
+var segmentKeys = map[string]bool{"country": true, "section": true, "platform": true}
+
+// forecastStore is the storage the forecaster reads. PostgresStore
+// implements it.
+type forecastStore interface {
+	GetTrafficSegments(ctx context.Context, networkID int, since, until time.Time) ([]storage.TrafficSegment, error)
+	GetFirstOpportunity(ctx context.Context, networkID int) (time.Time, bool, error)
+	GetActiveLineItemsWithCreatives(ctx context.Context) ([]models.LineItem, error)
+	GetLineItemDelivery(ctx context.Context, networkID int) (map[int]int, error)
+	ListAdUnits(ctx context.Context, networkID int) ([]models.AdUnit, error)
+	ListPlacements(ctx context.Context, networkID int) ([]models.Placement, error)
+}
+
+var _ forecastStore = (*storage.PostgresStore)(nil)
+
+// Forecaster estimates available impressions for proposed line items
+type Forecaster struct {
+	store forecastStore
+	now   func() time.Time
+}
+
+// NewForecaster creates a new Forecaster
+func NewForecaster(store *storage.PostgresStore) *Forecaster {
+	return &Forecaster{store: store, now: time.Now}
+}
+
+// segment is one ad unit/key-value combination of forecast traffic
+type segment struct {
+	adUnit    string
+	targeting map[string]string
+	remaining float64 // ad requests left in the forecast window
+}
+
+// Availability forecasts the proposed line item between start and end in a
+// network: historical ad requests per ad unit and key-value, filled or not,
+// are projected over the dates, then booked line items of the same or higher
+// priority take their share in priority order, and what is left over is
+// available
+func (f *Forecaster) Availability(ctx context.Context, networkID int, req *models.AvailabilityRequest, start, end time.Time) (*models.AvailabilityResponse, error) {
+	now := f.now()
+	since := now.AddDate(0, 0, -lookbackDays)
+	traffic, err := f.store.GetTrafficSegments(ctx, networkID, since, now)
+	if err != nil {
+		return nil, err
+	}
+	// A request log younger than the lookback only covers the days since it
+	// started
+	days := float64(lookbackDays)
+	first, ok, err := f.store.GetFirstOpportunity(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	if ok && first.After(since) {
+		days = math.Max(1, now.Sub(first).Hours()/24)
+	}
+	booked, err := f.store.GetActiveLineItemsWithCreatives(ctx)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := f.store.GetLineItemDelivery(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	adUnits, err := f.store.ListAdUnits(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	adUnitCodes := make(map[int]string, len(adUnits))
+	for _, u := range adUnits {
+		adUnitCodes[u.ID] = u.Code
+	}
+	tree := inventory.NewTree(adUnits)
+	placements, err := f.store.ListPlacements(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	placementAdUnits := make(map[int][]int, len(placements))
+	for _, p := range placements {
+		placementAdUnits[p.ID] = p.AdUnitIDs
+	}
+
+	windowDays := end.Sub(start).Hours() / 24
+	segments := make([]*segment, 0, len(traffic))
+	for _, ts := range traffic {
+		daily := float64(ts.Requests) / days
+		segments = append(segments, &segment{
+			adUnit: ts.AdUnit,
+			targeting: map[string]string{
+				"country":  ts.Country,
+				"section":  ts.Section,
+				"platform": ts.Platform,
+			},
+			remaining: daily * windowDays,
+		})
+	}
+
+	priority := req.Priority
+	if priority == 0 {
+		priority = defaultPriority
+	}
+	proposedRules, ignored := segmentRules(toRules(req.Targeting))
+	proposedAdUnits := append([]string{}, req.AdUnits...)
+	for _, id := range req.PlacementIDs {
+		proposedAdUnits = append(proposedAdUnits, codesOf(placementAdUnits[id], adUnitCodes)...)
+	}
+	var proposed []*segment
+	if len(req.PlacementIDs) == 0 || len(proposedAdUnits) > 0 {
+		proposed = matchingSegments(segments, proposedAdUnits, nil, proposedRules)
+	}
+
+	resp := &models.AvailabilityResponse{
+		Requested:    req.Impressions,
+		Contending:   []models.ContendingLineItem{},
+		LookbackDays: int(math.Ceil(days)),
+		IgnoredKeys:  ignored,
+	}
+	for _, s := range proposed {
+		resp.Matched += int64(math.Round(s.remaining))
+	}
+
+	// Booked line items that outrank or tie the proposal claim inventory first
+	sort.SliceStable(booked, func(i, j int) bool { return booked[i].Priority > booked[j].Priority })
+	for _, li := range booked {
+		if li.NetworkID != networkID || li.ID == req.LineItemID || li.LineType == models.LineTypeHouse || li.Priority < priority {
+			continue
+		}
+
+		overlapStart, overlapEnd := start, end
+		if li.StartDate != nil && li.StartDate.After(overlapStart) {
+			overlapStart = *li.StartDate
+		}
+		if li.EndDate != nil && li.EndDate.Before(overlapEnd) {
+			overlapEnd = *li.EndDate
+		}
+		if !overlapEnd.After(overlapStart) {
+			continue
+		}
+		overlap := overlapEnd.Sub(overlapStart).Hours() / 24 / windowDays
+
+		included, excluded := tree.Resolve(li.AdUnitTargets)
+		for _, id := range li.PlacementIDs {
+			for _, adUnitID := range placementAdUnits[id] {
+				if !containsInt(excluded, adUnitID) {
+					included = append(included, adUnitID)
+				}
+			}
+		}
+		if (inventory.HasTargets(li.AdUnitTargets) || len(li.PlacementIDs) > 0) && len(included) == 0 {
+			continue
+		}
+		codes := codesOf(included, adUnitCodes)
+		rules, _ := segmentRules(li.TargetingRules)
+		eligible := matchingSegments(segments, codes, codesOf(excluded, adUnitCodes), rules)
+
+		// Inventory the line item can reach during the overlap
+		reachable := 0.0
+		for _, s := range eligible {
+			reachable += s.remaining * overlap
+		}
+		if reachable <= 0 {
+			continue
+		}
+
+		demand := lineItemDemand(li, delivery[li.ID], reachable, overlapStart, overlapEnd, now, priority)
+		share := math.Min(1, demand/reachable)
+		if share <= 0 {
+			continue
+		}
+
+		var contended float64
+		for _, s := range eligible {
+			taken := s.remaining * overlap * share
+			s.remaining -= taken
+			if containsSegment(proposed, s) {
+				contended += taken
+			}
+		}
+		if contended >= 1 {
+			resp.Contending = append(resp.Contending, models.ContendingLineItem{
+				LineItemID:  li.ID,
+				Name:        li.Name,
+				Priority:    li.Priority,
+				Impressions: int64(math.Round(contended)),
+			})
+		}
+	}
+
+	for _, s := range proposed {
+		resp.Available += int64(math.Round(s.remaining))
+	}
+	resp.Bookable = resp.Available > 0 && resp.Available >= int64(req.Impressions)
+
+	return resp, nil
+}
+
+// lineItemDemand estimates how many of the reachable impressions a booked
+// line item takes during its overlap with the forecast window:
+//   - with an impression goal, the undelivered goal spread evenly over the
+//     rest of its flight
+//   - with share of voice, its SOV percentage
+//   - otherwise everything at a higher priority, or a weight-based split
+//     with the proposal at the same priority
+func lineItemDemand(li models.LineItem, delivered int, reachable float64, overlapStart, overlapEnd, now time.Time, priority int) float64 {
+	if li.ImpressionGoal > 0 {
+		remaining := float64(li.ImpressionGoal - delivered)
+		if remaining <= 0 {
+			return 0
+		}
+		if li.EndDate == nil {
+			// No end date: assume the goal is delivered within the overlap
+			return remaining
+		}
+		flightStart := now
+		if li.StartDate != nil && li.StartDate.After(flightStart) {
+			flightStart = *li.StartDate
+		}
+		flightDays := li.EndDate.Sub(flightStart).Hours() / 24
+		if flightDays <= 0 {
+			return 0
+		}
+		overlapDays := overlapEnd.Sub(overlapStart).Hours() / 24
+		return remaining * math.Min(1, overlapDays/flightDays)
+	}
+
+	if li.SOVPercentage > 0 {
+		return reachable * float64(li.SOVPercentage) / 100
+	}
+
+	if li.Priority > priority {
+		return reachable
+	}
+	weight := li.Weight
+	if weight <= 0 {
+		weight = 100
+	}
+	return reachable * float64(weight) / float64(weight+100)
+}
+
+// matchingSegments returns the segments within the ad units (all if none)
+// whose key-values satisfy the rules
+func matchingSegments(segments []*segment, adUnits, excluded []string, rules []models.TargetingRule) []*segment {
+	var matched []*segment
+	for _, s := range segments {
+		if len(adUnits) > 0 && !containsString(adUnits, s.adUnit) {
+			continue
+		}
+		if containsString(excluded, s.adUnit) {
+			continue
+		}
+		if targeting.MatchesRules(s.targeting, rules) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+// codesOf returns the codes of ad unit IDs
+func codesOf(ids []int, adUnitCodes map[int]string) []string {
+	var codes []string
+	for _, id := range ids {
+		if code, ok := adUnitCodes[id]; ok {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// segmentRules keeps the rules on keys recorded with traffic and returns the
+// keys of the rules it dropped
+func segmentRules(rules []models.TargetingRule) ([]models.TargetingRule, []string) {
+	var kept []models.TargetingRule
+	var ignored []string
+	for _, r := range rules {
+		if segmentKeys[r.Key] {
+			kept = append(kept, r)
+		} else if !containsString(ignored, r.Key) {
+			ignored = append(ignored, r.Key)
+		}
+	}
+	return kept, ignored
+}
+
+// toRules converts targeting rule inputs into rules
+func toRules(inputs []models.TargetingRuleInput) []models.TargetingRule {
+	rules := make([]models.TargetingRule, 0, len(inputs))
+	for _, in := range inputs {
+		rules = append(rules, models.TargetingRule{Key: in.Key, Operator: in.Operator, Values: in.Values})
+	}
+	return rules
+}
+
+func containsSegment(segments []*segment, s *segment) bool {
+	for _, other := range segments {
+		if other == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,318 @@
+package forecast
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+var (
+	forecastNow   = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	forecastStart = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	forecastEnd   = time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC)
+)
+
+func day(d int) *time.Time {
+	t := time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+// fakeStore has 1000 ad requests a day on each of two ad units over the
+// whole lookback, and placement 7 holding the sports ad unit
+type fakeStore struct {
+	first    *time.Time
+	booked   []models.LineItem
+	delivery map[int]int
+}
+
+func (s *fakeStore) GetTrafficSegments(ctx context.Context, networkID int, since, until time.Time) ([]storage.TrafficSegment, error) {
+	return []storage.TrafficSegment{
+		{AdUnit: "home", Country: "US", Platform: "web", Requests: 1000 * lookbackDays},
+		{AdUnit: "sports", Country: "US", Platform: "web", Requests: 1000 * lookbackDays},
+	}, nil
+}
+
+func (s *fakeStore) GetFirstOpportunity(ctx context.Context, networkID int) (time.Time, bool, error) {
+	if s.first == nil {
+		return time.Time{}, false, nil
+	}
+	return *s.first, true, nil
+}
+
+func (s *fakeStore) GetActiveLineItemsWithCreatives(ctx context.Context) ([]models.LineItem, error) {
+	return s.booked, nil
+}
+
+func (s *fakeStore) GetLineItemDelivery(ctx context.Context, networkID int) (map[int]int, error) {
+	return s.delivery, nil
+}
+
+func (s *fakeStore) ListAdUnits(ctx context.Context, networkID int) ([]models.AdUnit, error) {
+	return []models.AdUnit{{ID: 1, NetworkID: 1, Code: "home"}, {ID: 2, NetworkID: 1, Code: "sports"}}, nil
+}
+
+func (s *fakeStore) ListPlacements(ctx context.Context, networkID int) ([]models.Placement, error) {
+	return []models.Placement{{ID: 7, NetworkID: 1, AdUnitIDs: []int{2}}}, nil
+}
+
+// booked is a run of network line item of network 1 with no goal
+func booked(id, priority int) models.LineItem {
+	return models.LineItem{ID: id, NetworkID: 1, Name: "booked", Priority: priority, Weight: 100}
+}
+
+func forecast(t *testing.T, store *fakeStore, req models.AvailabilityRequest) *models.AvailabilityResponse {
+	t.Helper()
+	f := &Forecaster{store: store, now: func() time.Time { return forecastNow }}
+	resp, err := f.Availability(context.Background(), 1, &req, forecastStart, forecastEnd)
+	if err != nil {
+		t.Fatalf("Availability: %v", err)
+	}
+	return resp
+}
+
+func TestAvailabilityContention(t *testing.T) {
+	onHome := []models.AdUnitTarget{{AdUnitID: 1}}
+	tests := []struct {
+		name       string
+		booked     []models.LineItem
+		delivery   map[int]int
+		edit       func(req *models.AvailabilityRequest)
+		available  int64
+		contending []int64 // impressions taken by each contending line item, in order
+	}{
+		{name: "nothing booked", available: 10000},
+		{name: "higher priority takes everything", booked: []models.LineItem{booked(1, 10)},
+			available: 0, contending: []int64{10000}},
+		{name: "lower priority", booked: []models.LineItem{booked(1, 4)}, available: 10000},
+		{name: "same priority splits by weight", booked: []models.LineItem{booked(1, 5)},
+			available: 5000, contending: []int64{5000}},
+		{name: "same priority with a heavier weight", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 5)
+			li.Weight = 300
+			return li
+		}()}, available: 2500, contending: []int64{7500}},
+		{name: "same priority without a weight", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 5)
+			li.Weight = 0
+			return li
+		}()}, available: 5000, contending: []int64{5000}},
+		{name: "proposal priority", booked: []models.LineItem{booked(1, 8)},
+			edit:      func(req *models.AvailabilityRequest) { req.Priority = 9 },
+			available: 10000},
+		{name: "house line item", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.LineType = models.LineTypeHouse
+			return li
+		}()}, available: 10000},
+		{name: "other network", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.NetworkID = 2
+			return li
+		}()}, available: 10000},
+		{name: "re-forecasting a booked line item", booked: []models.LineItem{booked(1, 10)},
+			edit:      func(req *models.AvailabilityRequest) { req.LineItemID = 1 },
+			available: 10000},
+
+		// Overlap with the forecast window
+		{name: "second half of the window", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.StartDate = day(7)
+			return li
+		}()}, available: 5000, contending: []int64{5000}},
+		{name: "ends in the first fifth", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.EndDate = day(4)
+			return li
+		}()}, available: 8000, contending: []int64{2000}},
+		{name: "ends before the window", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.EndDate = day(2)
+			return li
+		}()}, available: 10000},
+
+		// Goals are paced over the rest of the flight; the line item
+		// reaches 20000 requests over both ad units
+		{name: "goal paced over its flight", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.ImpressionGoal, li.EndDate = 6000, day(22)
+			return li
+		}()}, delivery: map[int]int{1: 1000},
+			// 5000 left over 21 days, 10 of them in the window, half on home
+			available: 8810, contending: []int64{1190}},
+		{name: "goal starting in the window", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.ImpressionGoal, li.StartDate, li.EndDate = 8000, day(7), day(17)
+			return li
+		}()}, available: 8000, contending: []int64{2000}},
+		{name: "goal without an end date", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.ImpressionGoal = 3000
+			return li
+		}()}, available: 8500, contending: []int64{1500}},
+		{name: "goal already delivered", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.ImpressionGoal = 3000
+			return li
+		}()}, delivery: map[int]int{1: 3000}, available: 10000},
+		{name: "goal larger than the inventory", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.ImpressionGoal = 50000
+			return li
+		}()}, available: 0, contending: []int64{10000}},
+		{name: "share of voice", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.SOVPercentage = 25
+			return li
+		}()}, available: 7500, contending: []int64{2500}},
+
+		// Higher priorities go first: the share of voice line item takes
+		// half of home, then the goal 4000 of the 5000 left. The other
+		// way round would leave 3000.
+		{name: "priority order", booked: []models.LineItem{
+			func() models.LineItem {
+				li := booked(1, 8)
+				li.ImpressionGoal, li.AdUnitTargets = 4000, onHome
+				return li
+			}(),
+			func() models.LineItem {
+				li := booked(2, 10)
+				li.SOVPercentage, li.AdUnitTargets = 50, onHome
+				return li
+			}(),
+		}, available: 1000, contending: []int64{5000, 4000}},
+
+		// Only overlapping inventory is contended
+		{name: "other ad unit", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.AdUnitTargets = []models.AdUnitTarget{{AdUnitID: 2}}
+			return li
+		}()}, available: 10000},
+		{name: "other placement", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.PlacementIDs = []int{7}
+			return li
+		}()}, available: 10000},
+		{name: "unknown placement", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.PlacementIDs = []int{99}
+			return li
+		}()}, available: 10000},
+		{name: "other country", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.TargetingRules = []models.TargetingRule{{Key: "country", Operator: "IN", Values: []string{"CA"}}}
+			return li
+		}()}, available: 10000},
+		{name: "key without traffic data", booked: []models.LineItem{func() models.LineItem {
+			li := booked(1, 10)
+			li.TargetingRules = []models.TargetingRule{{Key: "brand", Operator: "EQ", Values: []string{"acme"}}}
+			return li
+		}()}, available: 0, contending: []int64{10000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := models.AvailabilityRequest{AdUnits: []string{"home"}}
+			if tt.edit != nil {
+				tt.edit(&req)
+			}
+			resp := forecast(t, &fakeStore{booked: tt.booked, delivery: tt.delivery}, req)
+			if resp.Matched != 10000 {
+				t.Errorf("matched %d, want 10000", resp.Matched)
+			}
+			if resp.Available != tt.available {
+				t.Errorf("available %d, want %d", resp.Available, tt.available)
+			}
+			var contending []int64
+			for _, c := range resp.Contending {
+				contending = append(contending, c.Impressions)
+			}
+			if !reflect.DeepEqual(contending, tt.contending) {
+				t.Errorf("contending impressions %v, want %v", contending, tt.contending)
+			}
+		})
+	}
+}
+
+func TestAvailabilityTraffic(t *testing.T) {
+	t.Run("run of network", func(t *testing.T) {
+		resp := forecast(t, &fakeStore{}, models.AvailabilityRequest{Impressions: 20000})
+		if resp.Matched != 20000 || resp.Available != 20000 || !resp.Bookable || resp.LookbackDays != lookbackDays {
+			t.Errorf("got %+v, want 20000 matched and available, bookable, over %d days", resp, lookbackDays)
+		}
+	})
+
+	t.Run("more than available", func(t *testing.T) {
+		resp := forecast(t, &fakeStore{}, models.AvailabilityRequest{AdUnits: []string{"home"}, Impressions: 10001})
+		if resp.Bookable || resp.Requested != 10001 {
+			t.Errorf("got %+v, want 10001 requested and not bookable", resp)
+		}
+	})
+
+	t.Run("young request log", func(t *testing.T) {
+		// Four weeks of requests logged in one week are 4000 a day
+		resp := forecast(t, &fakeStore{first: day(-6)}, models.AvailabilityRequest{AdUnits: []string{"home"}})
+		if resp.Matched != 40000 || resp.LookbackDays != 7 {
+			t.Errorf("matched %d over %d days, want 40000 over 7", resp.Matched, resp.LookbackDays)
+		}
+	})
+
+	t.Run("placement", func(t *testing.T) {
+		resp := forecast(t, &fakeStore{}, models.AvailabilityRequest{PlacementIDs: []int{7}})
+		if resp.Matched != 10000 {
+			t.Errorf("matched %d, want the sports ad unit's 10000", resp.Matched)
+		}
+		resp = forecast(t, &fakeStore{}, models.AvailabilityRequest{PlacementIDs: []int{99}})
+		if resp.Matched != 0 || resp.Bookable {
+			t.Errorf("unknown placement matched %d, want nothing", resp.Matched)
+		}
+	})
+
+	t.Run("targeting", func(t *testing.T) {
+		resp := forecast(t, &fakeStore{}, models.AvailabilityRequest{Targeting: []models.TargetingRuleInput{
+			{Key: "country", Operator: "IN", Values: []string{"CA"}},
+		}})
+		if resp.Matched != 0 {
+			t.Errorf("matched %d, want no Canadian traffic", resp.Matched)
+		}
+		resp = forecast(t, &fakeStore{}, models.AvailabilityRequest{Targeting: []models.TargetingRuleInput{
+			{Key: "platform", Operator: "EQ", Values: []string{"web"}},
+			{Key: "brand", Operator: "EQ", Values: []string{"acme"}},
+		}})
+		if resp.Matched != 20000 || !reflect.DeepEqual(resp.IgnoredKeys, []string{"brand"}) {
+			t.Errorf("matched %d ignoring %v, want 20000 ignoring [brand]", resp.Matched, resp.IgnoredKeys)
+		}
+	})
+}
+
+func TestLineItemDemand(t *testing.T) {
+	start, end := *day(2), *day(12)
+	tests := []struct {
+		name      string
+		li        models.LineItem
+		delivered int
+		want      float64
+	}{
+		{"higher priority", models.LineItem{Priority: 10}, 0, 1000},
+		{"same priority", models.LineItem{Priority: 5, Weight: 100}, 0, 500},
+		{"same priority, light weight", models.LineItem{Priority: 5, Weight: 25}, 0, 200},
+		{"share of voice", models.LineItem{Priority: 10, SOVPercentage: 40}, 0, 400},
+		{"goal beats share of voice", models.LineItem{Priority: 10, SOVPercentage: 40, ImpressionGoal: 300}, 0, 300},
+		{"goal within the window", models.LineItem{Priority: 10, ImpressionGoal: 300, EndDate: day(6)}, 100, 200},
+		{"goal past the window", models.LineItem{Priority: 10, ImpressionGoal: 4000, EndDate: day(41)}, 0, 1000},
+		{"goal delivered", models.LineItem{Priority: 10, ImpressionGoal: 300}, 400, 0},
+		{"flight over", models.LineItem{Priority: 10, ImpressionGoal: 300, EndDate: day(1)}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lineItemDemand(tt.li, tt.delivered, 1000, start, end, forecastNow, 5)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("demand %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
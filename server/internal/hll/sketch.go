@@ -0,0 +1,151 @@
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// precision is the number of hash bits that pick a register; 2^12
+	// registers give a standard error of about 1.6%
+	precision = 12
+	registers = 1 << precision
+
+	formatDense  = 1
+	formatSparse = 2
+
+	// sparseLimit is the register count above which the dense encoding is
+	// smaller than the 3 bytes per register of the sparse one
+	sparseLimit = registers / 3
+)
+
+// Sketch is a HyperLogLog sketch estimating the number of distinct values
+// added to it. Sketches of disjoint or overlapping sets can be merged, so
+// hourly sketches add up to the distinct count over any range of hours.
+type Sketch struct {
+	regs [registers]uint8
+}
+
+// New creates an empty Sketch
+func New() *Sketch {
+	return &Sketch{}
+}
+
+// Add adds a value to the sketch
+func (s *Sketch) Add(value string) {
+	h := hash(value)
+	idx := h >> (64 - precision)
+	rank := uint8(bits.LeadingZeros64(h<<precision|1<<(precision-1)) + 1)
+	if rank > s.regs[idx] {
+		s.regs[idx] = rank
+	}
+}
+
+// Merge adds every value of other to the sketch
+func (s *Sketch) Merge(other *Sketch) {
+	for i, r := range other.regs {
+		if r > s.regs[i] {
+			s.regs[i] = r
+		}
+	}
+}
+
+// Estimate returns the estimated number of distinct values added
+func (s *Sketch) Estimate() int64 {
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(registers)
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// MarshalBinary encodes the sketch: the non-zero registers as (index, rank)
+// pairs while there are few of them, all registers otherwise
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	nonZero := 0
+	for _, r := range s.regs {
+		if r != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero > sparseLimit {
+		data := make([]byte, 1+registers)
+		data[0] = formatDense
+		copy(data[1:], s.regs[:])
+		return data, nil
+	}
+
+	data := make([]byte, 1, 1+3*nonZero)
+	data[0] = formatSparse
+	for i, r := range s.regs {
+		if r != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, r)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("hll: empty sketch")
+	}
+
+	s.regs = [registers]uint8{}
+	switch data[0] {
+	case formatDense:
+		if len(data) != 1+registers {
+			return errors.New("hll: bad dense sketch length")
+		}
+		copy(s.regs[:], data[1:])
+	case formatSparse:
+		pairs := data[1:]
+		if len(pairs)%3 != 0 {
+			return errors.New("hll: bad sparse sketch length")
+		}
+		for i := 0; i < len(pairs); i += 3 {
+			idx := binary.BigEndian.Uint16(pairs[i:])
+			if int(idx) >= registers {
+				return errors.New("hll: register out of range")
+			}
+			s.regs[idx] = pairs[i+2]
+		}
+	default:
+		return errors.New("hll: unknown sketch format")
+	}
+	return nil
+}
+
+// hash returns a well-mixed 64-bit hash of the value. FNV alone clusters
+// similar strings (user-1, user-2...), so its output goes through the
+// MurmurHash3 finalizer.
+func hash(value string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	h := f.Sum64()
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
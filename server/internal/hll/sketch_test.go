@@ -0,0 +1,174 @@
+package hll
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// maxError is the relative error the tests allow: about four standard errors
+// (1.04/sqrt(2^12) = 1.6%), so a correct sketch fails only by bad luck with
+// a particular set of values
+const maxError = 0.065
+
+// userIDs returns n distinct values shaped like user IDs, starting at from
+func userIDs(from, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user-%d", from+i)
+	}
+	return ids
+}
+
+func sketchOf(values []string) *Sketch {
+	s := New()
+	for _, v := range values {
+		s.Add(v)
+	}
+	return s
+}
+
+func relativeError(estimate int64, actual int) float64 {
+	return math.Abs(float64(estimate)-float64(actual)) / float64(actual)
+}
+
+func TestEstimateAccuracy(t *testing.T) {
+	if got := New().Estimate(); got != 0 {
+		t.Errorf("empty sketch estimate = %d, want 0", got)
+	}
+
+	for _, n := range []int{1, 10, 100, 1000, 5000, 10000, 20000, 50000, 100000, 1000000} {
+		if testing.Short() && n > 100000 {
+			continue
+		}
+		got := sketchOf(userIDs(0, n)).Estimate()
+		if e := relativeError(got, n); e > maxError {
+			t.Errorf("estimate of %d distinct values = %d, off by %.1f%%", n, got, 100*e)
+		}
+	}
+}
+
+func TestAddDuplicates(t *testing.T) {
+	s := New()
+	ids := userIDs(0, 1000)
+	for i := 0; i < 10; i++ {
+		for _, id := range ids {
+			s.Add(id)
+		}
+	}
+	if want := sketchOf(ids).Estimate(); s.Estimate() != want {
+		t.Errorf("adding every value 10 times estimates %d, adding once %d", s.Estimate(), want)
+	}
+}
+
+func TestMergeEqualsUnion(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   []string
+		unique int
+	}{
+		{"disjoint", userIDs(0, 30000), userIDs(30000, 20000), 50000},
+		{"overlapping", userIDs(0, 30000), userIDs(20000, 30000), 50000},
+		{"subset", userIDs(0, 30000), userIDs(1000, 500), 30000},
+		{"identical", userIDs(0, 5000), userIDs(0, 5000), 5000},
+		{"with empty", userIDs(0, 5000), nil, 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := sketchOf(tt.a)
+			merged.Merge(sketchOf(tt.b))
+			union := sketchOf(append(append([]string{}, tt.a...), tt.b...))
+
+			// Registers keep the maximum rank, so a merge is exactly the
+			// sketch of the union
+			if merged.regs != union.regs {
+				t.Error("merged sketch differs from the sketch of the union")
+			}
+			if e := relativeError(merged.Estimate(), tt.unique); e > maxError {
+				t.Errorf("merged estimate = %d, want about %d (off by %.1f%%)", merged.Estimate(), tt.unique, 100*e)
+			}
+
+			// Merging is order-independent
+			reversed := sketchOf(tt.b)
+			reversed.Merge(sketchOf(tt.a))
+			if reversed.regs != merged.regs {
+				t.Error("merging b into a differs from merging a into b")
+			}
+		})
+	}
+}
+
+func TestMergeHours(t *testing.T) {
+	// 24 hourly sketches, each seeing 2000 users of a 10000-user audience
+	day := New()
+	for h := 0; h < 24; h++ {
+		hour := New()
+		for i := 0; i < 2000; i++ {
+			hour.Add(fmt.Sprintf("user-%d", (h*400+i)%10000))
+		}
+		day.Merge(hour)
+	}
+	if e := relativeError(day.Estimate(), 10000); e > maxError {
+		t.Errorf("daily reach = %d, want about 10000 (off by %.1f%%)", day.Estimate(), 100*e)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 100, sparseLimit / 2, 5000, 100000} {
+		s := sketchOf(userIDs(0, n))
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary of %d values returned %v", n, err)
+		}
+
+		nonZero := 0
+		for _, r := range s.regs {
+			if r != 0 {
+				nonZero++
+			}
+		}
+		wantFormat, wantLen := byte(formatSparse), 1+3*nonZero
+		if nonZero > sparseLimit {
+			wantFormat, wantLen = formatDense, 1+registers
+		}
+		if data[0] != wantFormat || len(data) != wantLen {
+			t.Errorf("%d values (%d registers set) encoded as format %d in %d bytes, want format %d in %d bytes",
+				n, nonZero, data[0], len(data), wantFormat, wantLen)
+		}
+
+		// Decoding into a used sketch replaces its registers
+		decoded := sketchOf(userIDs(1000000, 50))
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary of %d values returned %v", n, err)
+		}
+		if decoded.regs != s.regs {
+			t.Errorf("%d values: decoded sketch differs from the original", n)
+		}
+		if decoded.Estimate() != s.Estimate() {
+			t.Errorf("%d values: decoded estimate %d, original %d", n, decoded.Estimate(), s.Estimate())
+		}
+
+		again, _ := decoded.MarshalBinary()
+		if !bytes.Equal(again, data) {
+			t.Errorf("%d values: re-encoding a decoded sketch changed it", n)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":              {},
+		"unknown format":     {9, 0, 0, 1},
+		"short dense":        append([]byte{formatDense}, make([]byte, registers-1)...),
+		"long dense":         append([]byte{formatDense}, make([]byte, registers+1)...),
+		"partial pair":       {formatSparse, 0, 1},
+		"register too large": {formatSparse, 0x10, 0x00, 1},
+	}
+	for name, data := range tests {
+		if err := New().UnmarshalBinary(data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package inventory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// Tree is a network's ad units arranged by parent. Ad units whose parent is
+// missing are treated as top level.
+type Tree struct {
+	units    map[int]models.AdUnit
+	children map[int][]int // parent ID -> child IDs, 0 for the top level
+}
+
+// NewTree builds the tree of a set of ad units
+func NewTree(units []models.AdUnit) *Tree {
+	t := &Tree{
+		units:    make(map[int]models.AdUnit, len(units)),
+		children: make(map[int][]int),
+	}
+	for _, u := range units {
+		t.units[u.ID] = u
+	}
+	for _, u := range units {
+		t.children[t.parent(u.ID)] = append(t.children[t.parent(u.ID)], u.ID)
+	}
+	for _, ids := range t.children {
+		sort.Ints(ids)
+	}
+	return t
+}
+
+// parent returns the ad unit's parent ID, or 0 at the top level
+func (t *Tree) parent(id int) int {
+	u, ok := t.units[id]
+	if !ok || u.ParentID == nil {
+		return 0
+	}
+	if _, ok := t.units[*u.ParentID]; !ok {
+		return 0
+	}
+	return *u.ParentID
+}
+
+// Get returns an ad unit of the tree
+func (t *Tree) Get(id int) (models.AdUnit, bool) {
+	u, ok := t.units[id]
+	return u, ok
+}
+
+// Descendants returns the IDs of every ad unit below id, depth first
+func (t *Tree) Descendants(id int) []int {
+	var ids []int
+	seen := map[int]bool{id: true}
+	var walk func(parent int)
+	walk = func(parent int) {
+		for _, child := range t.children[parent] {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			ids = append(ids, child)
+			walk(child)
+		}
+	}
+	walk(id)
+	return ids
+}
+
+// Ancestors returns the IDs of the ad units above id, nearest first
+func (t *Tree) Ancestors(id int) []int {
+	var ids []int
+	seen := map[int]bool{id: true}
+	for p := t.parent(id); p != 0 && !seen[p]; p = t.parent(p) {
+		seen[p] = true
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// IsDescendant reports whether id is below ancestor
+func (t *Tree) IsDescendant(id, ancestor int) bool {
+	for _, a := range t.Ancestors(id) {
+		if a == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the codes from the top level down to the ad unit, joined by
+// "/", e.g. "site/news/article_sidebar"
+func (t *Tree) Path(id int) string {
+	ancestors := t.Ancestors(id)
+	codes := make([]string, 0, len(ancestors)+1)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		codes = append(codes, t.units[ancestors[i]].Code)
+	}
+	codes = append(codes, t.units[id].Code)
+	return strings.Join(codes, "/")
+}
+
+// Walk calls fn for every ad unit, parents before their children, with its
+// depth (0 at the top level)
+func (t *Tree) Walk(fn func(u models.AdUnit, depth int)) {
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, id := range t.children[parent] {
+			fn(t.units[id], depth)
+			walk(id, depth+1)
+		}
+	}
+	walk(0, 0)
+}
+
+// Resolve turns a line item's ad unit targets into the ad units it may serve
+// on and the ad units it must not. Targets with IncludeDescendants add the
+// ad units below them, and exclusions always cover their descendants.
+// Targets of ad units not in the tree are ignored.
+func (t *Tree) Resolve(targets []models.AdUnitTarget) (included, excluded []int) {
+	skip := make(map[int]bool)
+	for _, target := range targets {
+		if !target.Excluded {
+			continue
+		}
+		if _, ok := t.units[target.AdUnitID]; !ok {
+			continue
+		}
+		for _, id := range append([]int{target.AdUnitID}, t.Descendants(target.AdUnitID)...) {
+			if !skip[id] {
+				skip[id] = true
+				excluded = append(excluded, id)
+			}
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, target := range targets {
+		if target.Excluded {
+			continue
+		}
+		if _, ok := t.units[target.AdUnitID]; !ok {
+			continue
+		}
+		ids := []int{target.AdUnitID}
+		if target.IncludeDescendants {
+			ids = append(ids, t.Descendants(target.AdUnitID)...)
+		}
+		for _, id := range ids {
+			if !skip[id] && !seen[id] {
+				seen[id] = true
+				included = append(included, id)
+			}
+		}
+	}
+	return included, excluded
+}
+
+// HasTargets reports whether targets limit a line item to some ad units.
+// Without any, it serves on every ad unit but the excluded ones.
+func HasTargets(targets []models.AdUnitTarget) bool {
+	for _, target := range targets {
+		if !target.Excluded {
+			return true
+		}
+	}
+	return false
+}
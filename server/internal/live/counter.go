@@ -0,0 +1,249 @@
+package live
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// windowMinutes is how many minutes of counts are kept; snapshots can cover
+// any window up to this
+const windowMinutes = 60
+
+// key identifies the counts of one line item on one ad unit of a network.
+// Unfilled requests have line item 0.
+type key struct {
+	networkID  int
+	lineItemID int
+	adUnit     string
+}
+
+// Counts are delivery counts over a window
+type Counts struct {
+	Requests    int64 `json:"requests"`
+	Filled      int64 `json:"filled"`
+	Impressions int64 `json:"impressions"`
+	Viewable    int64 `json:"viewable"`
+	Clicks      int64 `json:"clicks"`
+}
+
+func (c *Counts) add(o *Counts) {
+	c.Requests += o.Requests
+	c.Filled += o.Filled
+	c.Impressions += o.Impressions
+	c.Viewable += o.Viewable
+	c.Clicks += o.Clicks
+}
+
+// bucket holds one minute's counts
+type bucket struct {
+	minute int64 // Unix minute the counts belong to
+	counts map[key]*Counts
+}
+
+// Counter counts ad requests and tracking events in memory, per network,
+// line item, ad unit and minute, over a sliding window of the last hour. It is fed as
+// ads are served and tracked, so live stats never touch the database. Counts
+// are per server and start empty on restart.
+type Counter struct {
+	mu      sync.Mutex
+	buckets [windowMinutes]bucket
+	now     func() time.Time
+}
+
+// NewCounter creates an empty Counter
+func NewCounter() *Counter {
+	return &Counter{now: time.Now}
+}
+
+// RecordRequest counts one ad slot request, filled by lineItemID or
+// unfilled (lineItemID 0)
+func (c *Counter) RecordRequest(networkID int, adUnit string, lineItemID int) {
+	c.update(key{networkID: networkID, lineItemID: lineItemID, adUnit: adUnit}, func(n *Counts) {
+		n.Requests++
+		if lineItemID != 0 {
+			n.Filled++
+		}
+	})
+}
+
+// RecordEvent counts one tracking event
+func (c *Counter) RecordEvent(networkID int, eventType string, lineItemID int, adUnit string) {
+	c.update(key{networkID: networkID, lineItemID: lineItemID, adUnit: adUnit}, func(n *Counts) {
+		switch eventType {
+		case models.EventTypeImpression:
+			n.Impressions++
+		case models.EventTypeViewable:
+			n.Viewable++
+		case models.EventTypeClick:
+			n.Clicks++
+		}
+	})
+}
+
+// update applies fn to the current minute's counts for k, recycling the
+// minute's bucket if it last held an older minute
+func (c *Counter) update(k key, fn func(*Counts)) {
+	minute := c.now().Unix() / 60
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := &c.buckets[minute%windowMinutes]
+	if b.minute != minute || b.counts == nil {
+		b.minute = minute
+		b.counts = make(map[key]*Counts)
+	}
+	n, ok := b.counts[k]
+	if !ok {
+		n = &Counts{}
+		b.counts[k] = n
+	}
+	fn(n)
+}
+
+// Filter limits a snapshot to one network, line item and/or ad unit; zero
+// values match everything
+type Filter struct {
+	NetworkID  int
+	LineItemID int
+	AdUnit     string
+}
+
+func (f Filter) match(k key) bool {
+	return (f.NetworkID == 0 || k.networkID == f.NetworkID) &&
+		(f.LineItemID == 0 || k.lineItemID == f.LineItemID) &&
+		(f.AdUnit == "" || k.adUnit == f.AdUnit)
+}
+
+// LineItemStats are a line item's counts over a snapshot's window
+type LineItemStats struct {
+	LineItemID int `json:"line_item_id"`
+	Counts
+	CTR float64 `json:"ctr"`
+}
+
+// AdUnitStats are an ad unit's counts over a snapshot's window
+type AdUnitStats struct {
+	AdUnit string `json:"ad_unit"`
+	Counts
+	FillRate float64 `json:"fill_rate"`
+	CTR      float64 `json:"ctr"`
+}
+
+// MinuteStats are the counts of one minute of a snapshot's window
+type MinuteStats struct {
+	Minute time.Time `json:"minute"`
+	Counts
+}
+
+// Snapshot is the live stats over the last few minutes. Rates are
+// percentages.
+type Snapshot struct {
+	Time          time.Time `json:"time"`
+	WindowMinutes int       `json:"window_minutes"`
+	Counts
+	FillRate  float64         `json:"fill_rate"`
+	CTR       float64         `json:"ctr"`
+	LineItems []LineItemStats `json:"line_items"`
+	AdUnits   []AdUnitStats   `json:"ad_units"`
+	Minutes   []MinuteStats   `json:"minutes"`
+}
+
+// Snapshot sums the counts of the last minutes (including the current,
+// partial minute) that match the filter. Line items and ad units are sorted
+// by impressions, busiest first; minutes are oldest first.
+func (c *Counter) Snapshot(minutes int, filter Filter) *Snapshot {
+	if minutes < 1 {
+		minutes = 1
+	}
+	if minutes > windowMinutes {
+		minutes = windowMinutes
+	}
+
+	now := c.now()
+	current := now.Unix() / 60
+	s := &Snapshot{
+		Time:          now,
+		WindowMinutes: minutes,
+		LineItems:     []LineItemStats{},
+		AdUnits:       []AdUnitStats{},
+		Minutes:       make([]MinuteStats, minutes),
+	}
+	lineItems := make(map[int]*LineItemStats)
+	adUnits := make(map[string]*AdUnitStats)
+
+	c.mu.Lock()
+	for i := 0; i < minutes; i++ {
+		minute := current - int64(minutes-1-i)
+		m := &s.Minutes[i]
+		m.Minute = time.Unix(minute*60, 0).UTC()
+
+		b := &c.buckets[minute%windowMinutes]
+		if b.minute != minute {
+			continue
+		}
+		for k, n := range b.counts {
+			if !filter.match(k) {
+				continue
+			}
+			m.add(n)
+			if k.lineItemID != 0 {
+				li, ok := lineItems[k.lineItemID]
+				if !ok {
+					li = &LineItemStats{LineItemID: k.lineItemID}
+					lineItems[k.lineItemID] = li
+				}
+				li.add(n)
+			}
+			au, ok := adUnits[k.adUnit]
+			if !ok {
+				au = &AdUnitStats{AdUnit: k.adUnit}
+				adUnits[k.adUnit] = au
+			}
+			au.add(n)
+		}
+		s.add(&m.Counts)
+	}
+	c.mu.Unlock()
+
+	s.FillRate = rate(s.Filled, s.Requests)
+	s.CTR = rate(s.Clicks, s.Impressions)
+	for _, li := range lineItems {
+		li.CTR = rate(li.Clicks, li.Impressions)
+		s.LineItems = append(s.LineItems, *li)
+	}
+	for _, au := range adUnits {
+		au.FillRate = rate(au.Filled, au.Requests)
+		au.CTR = rate(au.Clicks, au.Impressions)
+		s.AdUnits = append(s.AdUnits, *au)
+	}
+	sort.Slice(s.LineItems, func(i, j int) bool {
+		a, b := s.LineItems[i], s.LineItems[j]
+		if a.Impressions != b.Impressions {
+			return a.Impressions > b.Impressions
+		}
+		return a.LineItemID < b.LineItemID
+	})
+	sort.Slice(s.AdUnits, func(i, j int) bool {
+		a, b := s.AdUnits[i], s.AdUnits[j]
+		if a.Impressions != b.Impressions {
+			return a.Impressions > b.Impressions
+		}
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.AdUnit < b.AdUnit
+	})
+	return s
+}
+
+// rate returns n as a percentage of total, or 0 if total is 0
+func rate(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
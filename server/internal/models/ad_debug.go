@@ -0,0 +1,44 @@
+package models
+
+// AdDebugResponse is returned by the ad endpoint in explain mode (?debug=1)
+type AdDebugResponse struct {
+	Ads   []AdResult  `json:"ads"`
+	Debug []SlotDebug `json:"debug"`
+}
+
+// SlotDebug explains how one slot of the request was decided
+type SlotDebug struct {
+	SlotID     string           `json:"slot_id"`
+	LineItemID int              `json:"line_item_id,omitempty"` // the winner, if any
+	Fallback   string           `json:"fallback,omitempty"`
+	Reason     string           `json:"reason,omitempty"`
+	Candidates []CandidateDebug `json:"candidates"`
+}
+
+// CandidateDebug records the stage at which a line item dropped out of a slot
+type CandidateDebug struct {
+	LineItemID int    `json:"line_item_id"`
+	Name       string `json:"name"`
+	CampaignID int    `json:"campaign_id"`
+	Priority   int    `json:"priority"`
+	Stage      string `json:"stage"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Decision stages reported in explain mode, in the order they are applied
+const (
+	DebugStageSize        = "size"
+	DebugStageFlight      = "flight"
+	DebugStageDaypart     = "daypart"
+	DebugStageTargeting   = "targeting"
+	DebugStageAdUnit      = "ad_unit"
+	DebugStageFrequency   = "frequency_cap"
+	DebugStageExclusion   = "competitive_exclusion"
+	DebugStageHouse       = "house"
+	DebugStagePriority    = "priority"
+	DebugStageSOV         = "sov"
+	DebugStageRoadblock   = "roadblock"
+	DebugStageCreative    = "creative"
+	DebugStageNotSelected = "not_selected"
+	DebugStageSelected    = "selected"
+)
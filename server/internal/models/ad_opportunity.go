@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// AdOpportunity is one slot of an ad request, logged whether or not it filled
+type AdOpportunity struct {
+	NetworkID  int               `json:"network_id"`
+	AdUnit     string            `json:"ad_unit"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Targeting  map[string]string `json:"targeting"`
+	Platform   string            `json:"platform"`
+	Country    string            `json:"country"`
+	Filled     bool              `json:"filled"`
+	Reason     string            `json:"reason"`
+	LineItemID int               `json:"line_item_id"`
+	// SampleRate is the share of requests logged when this one was, so
+	// reports can scale sampled counts back up
+	SampleRate float64   `json:"sample_rate"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -2,11 +2,18 @@ package models
 
 // AdRequest represents a request for ads
 type AdRequest struct {
-	Slots     []AdSlot          `json:"slots"`
-	Targeting map[string]string `json:"targeting"`
-	UserID    string            `json:"user_id"`
-	Platform  string            `json:"platform"`
-	Country   string            `json:"country"`
+	// PublisherID is the code of the network the request is for. Without
+	// it the network is found from the slots' ad units, falling back to
+	// the default network.
+	PublisherID string            `json:"publisher_id,omitempty"`
+	Slots       []AdSlot          `json:"slots"`
+	Targeting   map[string]string `json:"targeting"`
+	UserID      string            `json:"user_id"`
+	Platform    string            `json:"platform"`
+	Country     string            `json:"country"`
+	// PageViewID groups separate ad requests made by the same page view so
+	// competitive exclusions apply across them
+	PageViewID string `json:"page_view_id,omitempty"`
 }
 
 // AdSlot represents a single ad slot in a request
@@ -25,17 +32,43 @@ type AdResponse struct {
 
 // AdResult represents a single ad result for a slot
 type AdResult struct {
-	SlotID       string `json:"slot_id"`
-	ImpressionID string `json:"impression_id"`
-	LineItemID   int    `json:"line_item_id"`
-	CreativeID   int    `json:"creative_id"`
-	Width        int    `json:"width"`
-	Height       int    `json:"height"`
-	ImageURL     string `json:"image_url"`
-	ClickURL     string `json:"click_url"`
+	SlotID       string   `json:"slot_id"`
+	ImpressionID string   `json:"impression_id"`
+	LineItemID   int      `json:"line_item_id"`
+	CreativeID   int      `json:"creative_id"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	ImageURL     string   `json:"image_url"`
+	ClickURL     string   `json:"click_url"`
 	TrackingURLs Tracking `json:"tracking"`
+
+	// Fallback is set when no regular line item filled the slot: "house" when a
+	// house ad was served, otherwise the ad unit's passback/collapse instruction
+	// ("none" when nothing is configured). Reason says why the slot went unfilled.
+	Fallback     string `json:"fallback,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	PassbackURL  string `json:"passback_url,omitempty"`
+	PassbackHTML string `json:"passback_html,omitempty"`
 }
 
+// Fallback types returned for slots without a regular ad
+const (
+	FallbackNone     = "none"
+	FallbackHouse    = "house"
+	FallbackPassback = "passback"
+	FallbackCollapse = "collapse"
+)
+
+// Reason codes explaining why a slot was not filled by a regular line item
+const (
+	UnfilledNoMatch              = "no_matching_line_items"
+	UnfilledFrequencyCapped      = "frequency_capped"
+	UnfilledCompetitiveExclusion = "competitive_exclusion"
+	UnfilledSOV                  = "sov_unfilled"
+	UnfilledRoadblock            = "roadblock_unavailable"
+	UnfilledNoCreative           = "no_creative"
+)
+
 // Tracking contains tracking URLs for the ad
 type Tracking struct {
 	Impression string `json:"impression"`
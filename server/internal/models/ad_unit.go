@@ -2,34 +2,100 @@ package models
 
 import "time"
 
-// AdUnit represents an ad inventory unit (like GAM ad units)
+// AdUnit represents an ad inventory unit (like GAM ad units). Ad units form
+// a tree through ParentID; top-level ad units have none.
 type AdUnit struct {
-	ID          int       `json:"id"`
-	Code        string    `json:"code"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Platform    string    `json:"platform"`
-	Sizes       [][]int   `json:"sizes"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-}
-
-// CreateAdUnitRequest represents a request to create an ad unit
-type CreateAdUnitRequest struct {
+	ID          int     `json:"id"`
+	NetworkID   int     `json:"network_id"`
+	ParentID    *int    `json:"parent_id"`
 	Code        string  `json:"code"`
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Platform    string  `json:"platform"`
 	Sizes       [][]int `json:"sizes"`
+	Status      string  `json:"status"`
+	// FallbackMode is what unfilled slots do: "passback", "collapse" or "" (leave empty)
+	FallbackMode string    `json:"fallback_mode"`
+	PassbackURL  string    `json:"passback_url"`
+	PassbackHTML string    `json:"passback_html"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
-// UpdateAdUnitRequest represents a request to update an ad unit
+// CreateAdUnitRequest represents a request to create an ad unit
+type CreateAdUnitRequest struct {
+	ParentID     *int    `json:"parent_id,omitempty"`
+	Code         string  `json:"code"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Platform     string  `json:"platform"`
+	Sizes        [][]int `json:"sizes"`
+	FallbackMode string  `json:"fallback_mode,omitempty"`
+	PassbackURL  string  `json:"passback_url,omitempty"`
+	PassbackHTML string  `json:"passback_html,omitempty"`
+}
+
+// UpdateAdUnitRequest represents a request to update an ad unit. A ParentID
+// of 0 moves the ad unit to the top level.
 type UpdateAdUnitRequest struct {
-	Code        string  `json:"code"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Platform    string  `json:"platform"`
-	Sizes       [][]int `json:"sizes"`
-	Status      string  `json:"status"`
+	ParentID     *int    `json:"parent_id,omitempty"`
+	Code         string  `json:"code"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Platform     string  `json:"platform"`
+	Sizes        [][]int `json:"sizes"`
+	Status       string  `json:"status"`
+	FallbackMode *string `json:"fallback_mode,omitempty"`
+	PassbackURL  *string `json:"passback_url,omitempty"`
+	PassbackHTML *string `json:"passback_html,omitempty"`
+}
+
+// IsValidFallbackMode checks if an ad unit fallback mode is known
+func IsValidFallbackMode(mode string) bool {
+	switch mode {
+	case "", FallbackPassback, FallbackCollapse:
+		return true
+	}
+	return false
+}
+
+// AdUnitTarget is one ad unit a line item targets or excludes.
+// IncludeDescendants also targets every ad unit below it. An excluded ad
+// unit never serves the line item, nor do its descendants, even inside a
+// targeted subtree.
+type AdUnitTarget struct {
+	AdUnitID           int  `json:"ad_unit_id"`
+	IncludeDescendants bool `json:"include_descendants"`
+	Excluded           bool `json:"excluded"`
+}
+
+// SetLineItemAdUnitsRequest replaces a line item's ad unit targeting.
+// AdUnitIDs target single ad units, as before ad units had children.
+type SetLineItemAdUnitsRequest struct {
+	AdUnitIDs []int          `json:"ad_unit_ids"`
+	Targets   []AdUnitTarget `json:"targets"`
+}
+
+// AdUnitCounts are the delivery counts of an ad unit report row
+type AdUnitCounts struct {
+	Requests    int64   `json:"requests"`
+	Filled      int64   `json:"filled"`
+	Impressions int64   `json:"impressions"`
+	Clicks      int64   `json:"clicks"`
+	Viewable    int64   `json:"viewable"`
+	FillRate    float64 `json:"fill_rate"`
+	CTR         float64 `json:"ctr"`
+}
+
+// AdUnitReportRow is one ad unit's delivery: its own, and rolled up with
+// every ad unit below it
+type AdUnitReportRow struct {
+	AdUnitID int          `json:"ad_unit_id"`
+	ParentID *int         `json:"parent_id"`
+	Code     string       `json:"code"`
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Depth    int          `json:"depth"`
+	Own      AdUnitCounts `json:"own"`
+	Total    AdUnitCounts `json:"total"`
 }
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Contact holds the contact and billing details of an advertiser or agency
+type Contact struct {
+	ContactName    string `json:"contact_name"`
+	ContactEmail   string `json:"contact_email"`
+	ContactPhone   string `json:"contact_phone"`
+	BillingAddress string `json:"billing_address"`
+	BillingEmail   string `json:"billing_email"`
+	Notes          string `json:"notes"`
+}
+
+// Advertiser is the client campaigns are run for
+type Advertiser struct {
+	ID        int    `json:"id"`
+	NetworkID int    `json:"network_id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Contact
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Agency books campaigns on behalf of advertisers
+type Agency struct {
+	ID        int    `json:"id"`
+	NetworkID int    `json:"network_id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Contact
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CompanyRequest represents the request to create or replace an advertiser
+// or agency
+type CompanyRequest struct {
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+	Contact
+}
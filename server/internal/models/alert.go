@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Alert types raised by the delivery monitor
+const (
+	AlertZeroDelivery  = "zero_delivery"
+	AlertUnderDelivery = "under_delivery"
+	AlertCTRAnomaly    = "ctr_anomaly"
+	AlertCreative404   = "creative_404"
+)
+
+// IsValidAlertType checks if an alert type is known
+func IsValidAlertType(t string) bool {
+	switch t {
+	case AlertZeroDelivery, AlertUnderDelivery, AlertCTRAnomaly, AlertCreative404:
+		return true
+	}
+	return false
+}
+
+// Alert severities
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Alert statuses. An open alert is resolved by the monitor once its
+// condition clears.
+const (
+	AlertOpen     = "open"
+	AlertResolved = "resolved"
+)
+
+// Alert is a delivery problem the monitor found on a line item, or one of
+// its creatives. There is at most one open alert per type, line item and
+// creative; while the problem lasts the monitor keeps updating it.
+type Alert struct {
+	ID           int    `json:"id"`
+	NetworkID    int    `json:"network_id"`
+	Type         string `json:"type"`
+	Severity     string `json:"severity"`
+	Status       string `json:"status"`
+	LineItemID   int    `json:"line_item_id"`
+	LineItemName string `json:"line_item_name"`
+	// CreativeID is set for creative alerts, 0 otherwise
+	CreativeID int    `json:"creative_id,omitempty"`
+	Message    string `json:"message"`
+	// Details holds the numbers the alert was raised on
+	Details        map[string]interface{} `json:"details"`
+	AcknowledgedAt *time.Time             `json:"acknowledged_at"`
+	ResolvedAt     *time.Time             `json:"resolved_at"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+// AlertWebhook is a URL alerts are POSTed to when they are raised and
+// resolved
+type AlertWebhook struct {
+	ID        int    `json:"id"`
+	NetworkID int    `json:"network_id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	// AlertTypes limits the webhook to some alert types; empty means all
+	AlertTypes []string  `json:"alert_types"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AlertWebhookRequest represents a request to create or replace an alert
+// webhook
+type AlertWebhookRequest struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	AlertTypes []string `json:"alert_types"`
+	Status     string   `json:"status,omitempty"`
+}
+
+// Wants reports whether the webhook receives alerts of type t
+func (w *AlertWebhook) Wants(t string) bool {
+	if len(w.AlertTypes) == 0 {
+		return true
+	}
+	for _, at := range w.AlertTypes {
+		if at == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// APIKey lets a script call the admin API without a user's password. Its
+// scopes are the permissions it grants; the key itself is only shown once,
+// when it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	NetworkID  int        `json:"network_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Usable reports whether the key can still be used at now: it hasn't been
+// revoked and hasn't expired
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HasScope checks if the key grants a permission
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyRequest represents the request to create an API key. Without
+// an expiry the key lasts until it is revoked.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse carries a new key, to send as
+// "Authorization: Bearer <key>"
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
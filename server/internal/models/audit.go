@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Audited entity types
+const (
+	AuditCampaign     = "campaign"
+	AuditLineItem     = "line_item"
+	AuditCreative     = "creative"
+	AuditAdUnit       = "ad_unit"
+	AuditTargetingKey = "targeting_key"
+	AuditAdvertiser   = "advertiser"
+	AuditAgency       = "agency"
+	AuditNetwork      = "network"
+	AuditPlacement    = "placement"
+)
+
+// Audited actions. Targeting, dayparts, ad units and placements are changes to their
+// line item, recorded under its ID.
+const (
+	AuditCreate        = "create"
+	AuditUpdate        = "update"
+	AuditDelete        = "delete"
+	AuditSetTargeting  = "set_targeting"
+	AuditSetDayparts   = "set_dayparts"
+	AuditSetAdUnits    = "set_ad_units"
+	AuditSetPlacements = "set_placements"
+	AuditApprove       = "approve"
+	AuditReject        = "reject"
+)
+
+// Audit actor types
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+)
+
+// AuditChange is the value of a field before and after a change. Before is
+// null for a create and After for a delete.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry records one change made through the admin API: who made it,
+// to what, and which fields changed. Entries are never changed or removed.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	NetworkID  int                    `json:"network_id"`
+	ActorType  string                 `json:"actor_type"`
+	ActorID    int                    `json:"actor_id"`
+	ActorName  string                 `json:"actor_name"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	Action     string                 `json:"action"`
+	Changes    map[string]AuditChange `json:"changes"`
+	RequestID  string                 `json:"request_id"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
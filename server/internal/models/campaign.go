@@ -2,23 +2,39 @@ package models
 
 import "time"
 
-// Campaign represents an advertising campaign
+// Campaign represents an advertising campaign, run for an advertiser and
+// optionally booked through an agency under an order (IO) number
 type Campaign struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int       `json:"id"`
+	NetworkID    int       `json:"network_id"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	Timezone     string    `json:"timezone"`
+	AdvertiserID *int      `json:"advertiser_id"`
+	AgencyID     *int      `json:"agency_id"`
+	OrderNumber  string    `json:"order_number"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // CreateCampaignRequest represents the request to create a campaign
 type CreateCampaignRequest struct {
-	Name   string `json:"name"`
-	Status string `json:"status,omitempty"`
+	Name         string `json:"name"`
+	Status       string `json:"status,omitempty"`
+	Timezone     string `json:"timezone,omitempty"`
+	AdvertiserID int    `json:"advertiser_id,omitempty"`
+	AgencyID     int    `json:"agency_id,omitempty"`
+	OrderNumber  string `json:"order_number,omitempty"`
 }
 
-// UpdateCampaignRequest represents the request to update a campaign
+// UpdateCampaignRequest represents the request to update a campaign.
+// Advertiser and agency ID 0 unlink them; an empty order number clears it,
+// and an empty timezone sets it back to the network timezone.
 type UpdateCampaignRequest struct {
-	Name   string `json:"name,omitempty"`
-	Status string `json:"status,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	Status       string  `json:"status,omitempty"`
+	Timezone     *string `json:"timezone,omitempty"`
+	AdvertiserID *int    `json:"advertiser_id,omitempty"`
+	AgencyID     *int    `json:"agency_id,omitempty"`
+	OrderNumber  *string `json:"order_number,omitempty"`
 }
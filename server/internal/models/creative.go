@@ -5,6 +5,7 @@ import "time"
 // Creative represents an ad creative
 type Creative struct {
 	ID         int       `json:"id"`
+	NetworkID  int       `json:"network_id"`
 	LineItemID int       `json:"line_item_id"`
 	Name       string    `json:"name"`
 	Width      int       `json:"width"`
@@ -12,8 +13,21 @@ type Creative struct {
 	ImageURL   string    `json:"image_url"`
 	ClickURL   string    `json:"click_url"`
 	Status     string    `json:"status"`
+	Weight     int       `json:"weight"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// SubmittedAt is when the creative was last sent for review. ReviewedBy
+	// and ReviewedAt record its last approval or rejection, the user's
+	// email or API key's name; RejectionReason is kept while it is rejected.
+	SubmittedAt     *time.Time `json:"submitted_at"`
+	ReviewedBy      string     `json:"reviewed_by"`
+	ReviewedAt      *time.Time `json:"reviewed_at"`
+	RejectionReason string     `json:"rejection_reason"`
+
+	// Recent delivery, loaded into the serving cache for CTR-optimized rotation
+	Impressions int `json:"-"`
+	Clicks      int `json:"-"`
 }
 
 // CreateCreativeRequest represents the request to create a creative
@@ -25,6 +39,7 @@ type CreateCreativeRequest struct {
 	ImageURL   string `json:"image_url"`
 	ClickURL   string `json:"click_url"`
 	Status     string `json:"status,omitempty"`
+	Weight     int    `json:"weight,omitempty"`
 }
 
 // UpdateCreativeRequest represents the request to update a creative
@@ -35,4 +50,65 @@ type UpdateCreativeRequest struct {
 	ImageURL string `json:"image_url,omitempty"`
 	ClickURL string `json:"click_url,omitempty"`
 	Status   string `json:"status,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+}
+
+// ReviewCreativeRequest represents an approval or rejection of a creative.
+// A rejection needs a reason.
+type ReviewCreativeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Creative statuses. A creative is drafted, sent for review, and approved or
+// rejected by ad ops. Approved creatives are activated, paused and archived;
+// only active ones serve.
+const (
+	CreativeDraft         = "draft"
+	CreativePendingReview = "pending_review"
+	CreativeApproved      = "approved"
+	CreativeRejected      = "rejected"
+	CreativeActive        = "active"
+	CreativePaused        = "paused"
+	CreativeArchived      = "archived"
+)
+
+// creativeTransitions lists the statuses each status can change to.
+// Archived is final.
+var creativeTransitions = map[string][]string{
+	CreativeDraft:         {CreativePendingReview, CreativeArchived},
+	CreativePendingReview: {CreativeApproved, CreativeRejected, CreativeDraft, CreativeArchived},
+	CreativeApproved:      {CreativeActive, CreativePendingReview, CreativeArchived},
+	CreativeRejected:      {CreativePendingReview, CreativeDraft, CreativeArchived},
+	CreativeActive:        {CreativePaused, CreativePendingReview, CreativeArchived},
+	CreativePaused:        {CreativeActive, CreativePendingReview, CreativeArchived},
+	CreativeArchived:      {},
+}
+
+// IsValidCreativeStatus checks if a creative status is known
+func IsValidCreativeStatus(status string) bool {
+	_, ok := creativeTransitions[status]
+	return ok
+}
+
+// CanTransitionCreative reports whether a creative may change from one
+// status to another
+func CanTransitionCreative(from, to string) bool {
+	for _, s := range creativeTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReviewed reports whether a creative's status depends on an approval, so
+// changing what it shows has to send it back for review
+func IsReviewed(status string) bool {
+	return status == CreativeApproved || status == CreativeActive || status == CreativePaused
+}
+
+// IsServable reports whether a creative may serve: it is active, which it
+// can only become once approved, and has a recorded review
+func (c *Creative) IsServable() bool {
+	return c.Status == CreativeActive && c.ReviewedAt != nil
 }
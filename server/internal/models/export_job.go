@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Export job statuses
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is a report export run in the background, for exports too large
+// to wait for. When done its file can be downloaded until it expires.
+type ExportJob struct {
+	ID        string `json:"id"`
+	NetworkID int    `json:"network_id"`
+	Status    string `json:"status"`
+	Format    string `json:"format"`
+	// Query holds the dimensions, metrics, filters and sort; the range is
+	// StartDate to EndDate in Timezone
+	Query       ReportQueryRequest `json:"query"`
+	StartDate   time.Time          `json:"start_date"`
+	EndDate     time.Time          `json:"end_date"`
+	Timezone    string             `json:"timezone"`
+	RowCount    int                `json:"row_count"`
+	FileSize    int64              `json:"file_size"`
+	FilePath    string             `json:"-"`
+	Error       string             `json:"error,omitempty"`
+	DownloadURL string             `json:"download_url,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	StartedAt   *time.Time         `json:"started_at"`
+	FinishedAt  *time.Time         `json:"finished_at"`
+	ExpiresAt   *time.Time         `json:"expires_at"`
+}
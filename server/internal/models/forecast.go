@@ -0,0 +1,41 @@
+package models
+
+// AvailabilityRequest describes a proposed line item to forecast
+type AvailabilityRequest struct {
+	// AdUnits are ad unit codes; empty means run of network
+	AdUnits []string `json:"ad_units"`
+	// PlacementIDs add the ad units of placements to AdUnits
+	PlacementIDs []int                `json:"placement_ids,omitempty"`
+	Targeting    []TargetingRuleInput `json:"targeting"`
+	StartDate    string               `json:"start_date"` // YYYY-MM-DD
+	EndDate      string               `json:"end_date"`   // YYYY-MM-DD, inclusive
+	Priority     int                  `json:"priority,omitempty"`
+	// Impressions is the goal the advertiser wants to book (optional)
+	Impressions int `json:"impressions,omitempty"`
+	// LineItemID excludes an existing line item from contention, so a booked
+	// line item can be re-forecast
+	LineItemID int `json:"line_item_id,omitempty"`
+}
+
+// AvailabilityResponse is the forecast for a proposed line item.
+// Matched is all forecast traffic matching the targeting, Available is what
+// is left after higher and equal priority booked line items take their share.
+type AvailabilityResponse struct {
+	Matched      int64                `json:"matched"`
+	Available    int64                `json:"available"`
+	Requested    int                  `json:"requested,omitempty"`
+	Bookable     bool                 `json:"bookable"`
+	Contending   []ContendingLineItem `json:"contending"`
+	LookbackDays int                  `json:"lookback_days"`
+	// IgnoredKeys lists targeting keys the traffic model has no data for;
+	// rules on them are not applied to the forecast
+	IgnoredKeys []string `json:"ignored_keys,omitempty"`
+}
+
+// ContendingLineItem is a booked line item competing for the proposed inventory
+type ContendingLineItem struct {
+	LineItemID  int    `json:"line_item_id"`
+	Name        string `json:"name"`
+	Priority    int    `json:"priority"`
+	Impressions int64  `json:"impressions"`
+}
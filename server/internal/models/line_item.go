@@ -4,20 +4,88 @@ import "time"
 
 // LineItem represents a line item within a campaign
 type LineItem struct {
-	ID                 int              `json:"id"`
-	CampaignID         int              `json:"campaign_id"`
-	Name               string           `json:"name"`
-	Priority           int              `json:"priority"`
-	Weight             int              `json:"weight"`
-	SOVPercentage      int              `json:"sov_percentage"`
-	FrequencyCap       int              `json:"frequency_cap"`
-	FrequencyCapPeriod string           `json:"frequency_cap_period"`
-	Status             string           `json:"status"`
-	CreatedAt          time.Time        `json:"created_at"`
-	UpdatedAt          time.Time        `json:"updated_at"`
-	TargetingRules     []TargetingRule  `json:"targeting_rules,omitempty"`
-	Creatives          []Creative       `json:"creatives,omitempty"`
-	AdUnitIDs          []int            `json:"ad_unit_ids,omitempty"`
+	ID                    int             `json:"id"`
+	NetworkID             int             `json:"network_id"`
+	CampaignID            int             `json:"campaign_id"`
+	Name                  string          `json:"name"`
+	Priority              int             `json:"priority"`
+	Weight                int             `json:"weight"`
+	SOVPercentage         int             `json:"sov_percentage"`
+	FrequencyCap          int             `json:"frequency_cap"`
+	FrequencyCapPeriod    string          `json:"frequency_cap_period"`
+	Status                string          `json:"status"`
+	Advertiser            string          `json:"advertiser"`
+	CompetitiveExclusions []string        `json:"competitive_exclusions"`
+	RoadblockMode         string          `json:"roadblock_mode"`
+	CreativeRotation      string          `json:"creative_rotation"`
+	LineType              string          `json:"line_type"`
+	StartDate             *time.Time      `json:"start_date"`
+	EndDate               *time.Time      `json:"end_date"`
+	ImpressionGoal        int             `json:"impression_goal"`
+	CreatedAt             time.Time       `json:"created_at"`
+	UpdatedAt             time.Time       `json:"updated_at"`
+	TargetingRules        []TargetingRule `json:"targeting_rules,omitempty"`
+	Creatives             []Creative      `json:"creatives,omitempty"`
+	AdUnitIDs             []int           `json:"ad_unit_ids,omitempty"`
+	Dayparts              []Daypart       `json:"dayparts,omitempty"`
+	Timezone              string          `json:"timezone,omitempty"`
+
+	// AdvertiserID is the campaign's advertiser, loaded with the serving
+	// cache. Competitive separation keys on it and on the free-text
+	// Advertiser label.
+	AdvertiserID int `json:"advertiser_id,omitempty"`
+
+	// AdUnitTargets are the ad units the line item targets and excludes.
+	// Loading the serving cache resolves them through the ad unit tree into
+	// AdUnitIDs, every ad unit it may serve on, and ExcludedAdUnitIDs.
+	AdUnitTargets     []AdUnitTarget `json:"ad_unit_targets,omitempty"`
+	ExcludedAdUnitIDs []int          `json:"excluded_ad_unit_ids,omitempty"`
+
+	// PlacementIDs are the placements the line item targets, on top of its
+	// ad units
+	PlacementIDs []int `json:"placement_ids,omitempty"`
+}
+
+// Roadblock modes control how a line item is placed across the slots of a
+// request once it wins one of them
+const (
+	RoadblockNone             = ""
+	RoadblockAllOrNone        = "all_or_none"
+	RoadblockAsManyAsPossible = "as_many_as_possible"
+	RoadblockOneOrMore        = "one_or_more"
+)
+
+// IsValidRoadblockMode checks if a roadblock mode is known
+func IsValidRoadblockMode(mode string) bool {
+	switch mode {
+	case RoadblockNone, RoadblockAllOrNone, RoadblockAsManyAsPossible, RoadblockOneOrMore:
+		return true
+	}
+	return false
+}
+
+// Line item types. House line items only serve when nothing else can.
+const (
+	LineTypeStandard = "standard"
+	LineTypeHouse    = "house"
+)
+
+// Creative rotation modes control how a line item picks between creatives
+// of the same size
+const (
+	CreativeRotationEven       = "even"
+	CreativeRotationWeighted   = "weighted"
+	CreativeRotationOptimized  = "optimized"
+	CreativeRotationSequential = "sequential"
+)
+
+// IsValidCreativeRotation checks if a creative rotation mode is known
+func IsValidCreativeRotation(mode string) bool {
+	switch mode {
+	case CreativeRotationEven, CreativeRotationWeighted, CreativeRotationOptimized, CreativeRotationSequential:
+		return true
+	}
+	return false
 }
 
 // TargetingRule represents a targeting rule for a line item
@@ -29,6 +97,17 @@ type TargetingRule struct {
 	Values     []string `json:"values"`
 }
 
+// Daypart represents a weekly time window during which a line item may serve.
+// DayOfWeek follows time.Weekday (0 = Sunday). StartTime and EndTime are
+// "HH:MM" in the campaign's timezone; EndTime is exclusive and may be "24:00".
+type Daypart struct {
+	ID         int    `json:"id"`
+	LineItemID int    `json:"line_item_id"`
+	DayOfWeek  int    `json:"day_of_week"`
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+}
+
 // CreateLineItemRequest represents the request to create a line item
 type CreateLineItemRequest struct {
 	CampaignID         int    `json:"campaign_id"`
@@ -38,17 +117,41 @@ type CreateLineItemRequest struct {
 	FrequencyCap       int    `json:"frequency_cap,omitempty"`
 	FrequencyCapPeriod string `json:"frequency_cap_period,omitempty"`
 	Status             string `json:"status,omitempty"`
+	// Advertiser and CompetitiveExclusions are labels used to keep
+	// conflicting line items from serving on the same page
+	Advertiser            string   `json:"advertiser,omitempty"`
+	CompetitiveExclusions []string `json:"competitive_exclusions,omitempty"`
+	RoadblockMode         string   `json:"roadblock_mode,omitempty"`
+	CreativeRotation      string   `json:"creative_rotation,omitempty"`
+	LineType              string   `json:"line_type,omitempty"`
+	// StartDate and EndDate bound the flight; ImpressionGoal is the booked
+	// impressions (0 = no goal). Both feed the inventory forecast.
+	StartDate      *time.Time `json:"start_date,omitempty"`
+	EndDate        *time.Time `json:"end_date,omitempty"`
+	ImpressionGoal int        `json:"impression_goal,omitempty"`
 }
 
-// UpdateLineItemRequest represents the request to update a line item
+// UpdateLineItemRequest represents the request to update a line item.
+// ClearStartDate and ClearEndDate remove that side of the flight, leaving
+// it open-ended.
 type UpdateLineItemRequest struct {
-	Name               string `json:"name,omitempty"`
-	Priority           int    `json:"priority,omitempty"`
-	Weight             *int   `json:"weight,omitempty"`
-	SOVPercentage      *int   `json:"sov_percentage,omitempty"`
-	FrequencyCap       int    `json:"frequency_cap,omitempty"`
-	FrequencyCapPeriod string `json:"frequency_cap_period,omitempty"`
-	Status             string `json:"status,omitempty"`
+	Name                  string     `json:"name,omitempty"`
+	Priority              int        `json:"priority,omitempty"`
+	Weight                *int       `json:"weight,omitempty"`
+	SOVPercentage         *int       `json:"sov_percentage,omitempty"`
+	FrequencyCap          int        `json:"frequency_cap,omitempty"`
+	FrequencyCapPeriod    string     `json:"frequency_cap_period,omitempty"`
+	Status                string     `json:"status,omitempty"`
+	Advertiser            *string    `json:"advertiser,omitempty"`
+	CompetitiveExclusions []string   `json:"competitive_exclusions,omitempty"`
+	RoadblockMode         *string    `json:"roadblock_mode,omitempty"`
+	CreativeRotation      string     `json:"creative_rotation,omitempty"`
+	LineType              string     `json:"line_type,omitempty"`
+	StartDate             *time.Time `json:"start_date,omitempty"`
+	EndDate               *time.Time `json:"end_date,omitempty"`
+	ClearStartDate        bool       `json:"clear_start_date,omitempty"`
+	ClearEndDate          bool       `json:"clear_end_date,omitempty"`
+	ImpressionGoal        *int       `json:"impression_goal,omitempty"`
 }
 
 // SetTargetingRulesRequest represents the request to set targeting rules
@@ -62,3 +165,15 @@ type TargetingRuleInput struct {
 	Operator string   `json:"operator"`
 	Values   []string `json:"values"`
 }
+
+// SetDaypartsRequest represents the request to set a line item's dayparting schedule
+type SetDaypartsRequest struct {
+	Dayparts []DaypartInput `json:"dayparts"`
+}
+
+// DaypartInput represents input for a daypart
+type DaypartInput struct {
+	DayOfWeek int    `json:"day_of_week"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
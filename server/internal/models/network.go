@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// DefaultNetworkID is the network that existed before networks were added.
+// Its users manage the other networks.
+const DefaultNetworkID = 1
+
+// Network is a publisher (tenant) with its own inventory, campaigns,
+// reporting and users. Code is its publisher ID, which ad requests can send
+// to pick the network. Timezone (IANA name) is used for dayparting when a
+// campaign has none, and for reports that don't ask for one.
+type Network struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Timezone  string    `json:"timezone"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateNetworkRequest represents the request to create a network. With an
+// admin email and password the network's first admin user is created too.
+type CreateNetworkRequest struct {
+	Code          string `json:"code"`
+	Name          string `json:"name"`
+	Timezone      string `json:"timezone,omitempty"`
+	AdminEmail    string `json:"admin_email,omitempty"`
+	AdminName     string `json:"admin_name,omitempty"`
+	AdminPassword string `json:"admin_password,omitempty"`
+}
+
+// UpdateNetworkRequest represents the request to update a network. An
+// inactive network serves no ads and its users can't sign in.
+type UpdateNetworkRequest struct {
+	Name     string `json:"name,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
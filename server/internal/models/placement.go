@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Placement is a named group of ad units, such as "Homepage Takeover" or
+// "All Leaderboards", that line items can target as a whole
+type Placement struct {
+	ID          int       `json:"id"`
+	NetworkID   int       `json:"network_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	AdUnitIDs   []int     `json:"ad_unit_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PlacementRequest represents the request to create or replace a placement
+type PlacementRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AdUnitIDs   []int  `json:"ad_unit_ids"`
+}
@@ -0,0 +1,46 @@
+package models
+
+// ReportQueryRequest is a report builder query: delivery metrics grouped by
+// any combination of dimensions
+type ReportQueryRequest struct {
+	// Dimensions: date, hour, advertiser, agency, campaign, line_item,
+	// creative, size, ad_unit, placement, and the key-values recorded with
+	// events: country, section, platform, or key:<name> for any other
+	// targeting key of the network
+	Dimensions []string `json:"dimensions"`
+	// Metrics: impressions, clicks, viewable, ctr, viewability, unique_users
+	Metrics   []string       `json:"metrics"`
+	Filters   []ReportFilter `json:"filters"`
+	Sort      []ReportSort   `json:"sort"`
+	Limit     int            `json:"limit"`
+	StartDate string         `json:"start_date"` // YYYY-MM-DD
+	EndDate   string         `json:"end_date"`   // YYYY-MM-DD, inclusive
+	// Timezone (IANA name) dates and hours are bucketed in; defaults to the
+	// network timezone
+	Timezone string `json:"tz"`
+}
+
+// ReportFilter restricts a report to rows whose dimension value is (EQ, IN)
+// or is not (NOT_IN) one of the values. Advertiser, agency, campaign, line
+// item and creative filters take IDs.
+type ReportFilter struct {
+	Dimension string   `json:"dimension"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// ReportSort orders a report by a requested dimension or metric
+type ReportSort struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"` // asc or desc (default)
+}
+
+// ReportQueryResponse is the result of a report builder query. Each row maps
+// column name to value; columns lists the names in order.
+type ReportQueryResponse struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	StartDate string                   `json:"start_date"`
+	EndDate   string                   `json:"end_date"`
+	Timezone  string                   `json:"timezone"`
+}
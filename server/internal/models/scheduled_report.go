@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Scheduled report delivery methods
+const (
+	DeliveryEmail   = "email"
+	DeliveryWebhook = "webhook"
+)
+
+// Scheduled report run statuses
+const (
+	RunPending  = "pending"
+	RunRunning  = "running"
+	RunRetrying = "retrying"
+	RunSuccess  = "success"
+	RunFailed   = "failed"
+)
+
+// ScheduledReport is a saved report builder query that runs on a cron
+// schedule and is delivered by email or webhook
+type ScheduledReport struct {
+	ID        int    `json:"id"`
+	NetworkID int    `json:"network_id"`
+	Name      string `json:"name"`
+	// Query is the report builder query; its start and end dates are ignored
+	// and taken from DateRange on every run. Its tz is also the timezone the
+	// schedule runs in.
+	Query ReportQueryRequest `json:"query"`
+	// DateRange is the period each run reports on, relative to the run day
+	DateRange string `json:"date_range"`
+	// Schedule is a five-field cron expression, e.g. "0 8 * * 1" for 08:00
+	// every Monday
+	Schedule   string     `json:"schedule"`
+	Format     string     `json:"format"`
+	Delivery   string     `json:"delivery"`
+	Recipients []string   `json:"recipients"`
+	WebhookURL string     `json:"webhook_url"`
+	Status     string     `json:"status"`
+	NextRunAt  *time.Time `json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ScheduledReportRequest represents a request to create or replace a
+// scheduled report
+type ScheduledReportRequest struct {
+	Name       string             `json:"name"`
+	Query      ReportQueryRequest `json:"query"`
+	DateRange  string             `json:"date_range"`
+	Schedule   string             `json:"schedule"`
+	Format     string             `json:"format"`
+	Delivery   string             `json:"delivery"`
+	Recipients []string           `json:"recipients"`
+	WebhookURL string             `json:"webhook_url"`
+	Status     string             `json:"status,omitempty"`
+}
+
+// ScheduledReportRun is one run of a scheduled report. Failed deliveries are
+// retried with backoff until the attempts run out.
+type ScheduledReportRun struct {
+	ID           int        `json:"id"`
+	ReportID     int        `json:"report_id"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	NextAttempt  *time.Time `json:"next_attempt_at,omitempty"`
+	StartDate    string     `json:"start_date,omitempty"`
+	EndDate      string     `json:"end_date,omitempty"`
+	RowCount     int        `json:"row_count"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    *time.Time `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// IsValidReportDateRange checks if a scheduled report date range is known
+func IsValidReportDateRange(dateRange string) bool {
+	switch dateRange {
+	case "yesterday", "last_7_days", "last_30_days", "last_week", "month_to_date", "last_month":
+		return true
+	}
+	return false
+}
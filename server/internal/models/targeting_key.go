@@ -5,6 +5,7 @@ import "time"
 // TargetingKey represents a targeting key with its known values
 type TargetingKey struct {
 	ID        int       `json:"id"`
+	NetworkID int       `json:"network_id"`
 	Key       string    `json:"key"`
 	Values    []string  `json:"values"`
 	CreatedAt time.Time `json:"created_at"`
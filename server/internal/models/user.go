@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// User is an account that can sign in to the admin API. Its role decides
+// what it may do.
+type User struct {
+	ID          int        `json:"id"`
+	NetworkID   int        `json:"network_id"`
+	Email       string     `json:"email"`
+	Name        string     `json:"name"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// PasswordHash is only loaded to check a login
+	PasswordHash string `json:"-"`
+}
+
+// CreateUserRequest represents the request to create a user
+type CreateUserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UpdateUserRequest represents the request to update a user. An empty
+// password keeps the current one.
+type UpdateUserRequest struct {
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// LoginRequest represents a sign-in with email and password
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoginResponse carries the session token to send as
+// "Authorization: Bearer <token>"
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
+}
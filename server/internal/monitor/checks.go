@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// zeroDeliveryAge is how long a line item must have been in flight
+	// before it is expected to be serving
+	zeroDeliveryAge = 2 * time.Hour
+	// paceMinElapsed is the share of the flight that must have passed before
+	// pacing is judged; early on a few slow hours skew it too much
+	paceMinElapsed = 0.1
+	// paceThreshold is the share of the expected impressions below which a
+	// line item with a goal is under-delivering
+	paceThreshold = 0.8
+	// baselineMinImpressions is the least a line item must have served in
+	// the baseline hours for a drop to count
+	baselineMinImpressions = 100
+	// baselineDropThreshold is the share of the baseline below which a line
+	// item without a goal is under-delivering
+	baselineDropThreshold = 0.5
+	// ctrMinImpressions is the least impressions both CTR windows need
+	ctrMinImpressions = 1000
+	// ctrMinExpectedClicks is the least clicks the last day should have had
+	// at the weekly CTR, so a quiet day isn't taken for a drop
+	ctrMinExpectedClicks = 10
+	// ctrAnomalyRatio is how many times higher or lower than the weekly CTR
+	// the daily CTR must be to be an anomaly
+	ctrAnomalyRatio = 3
+)
+
+// checkLineItem returns the alerts a line item's recent delivery calls for.
+// delivered is its impressions since the start of its flight, for line
+// items with a goal.
+func checkLineItem(h *storage.LineItemHealth, delivered int, now time.Time) []models.Alert {
+	var alerts []models.Alert
+	flightStart := h.CreatedAt
+	if h.StartDate != nil {
+		flightStart = *h.StartDate
+	}
+
+	// Impressions a line item with a goal and an end date should serve each
+	// hour to hit its goal
+	var hourlyPace float64
+	if h.ImpressionGoal > 0 && h.EndDate != nil {
+		if hoursLeft := h.EndDate.Sub(now).Hours(); hoursLeft > 0 && delivered < h.ImpressionGoal {
+			hourlyPace = float64(h.ImpressionGoal-delivered) / hoursLeft
+		}
+	}
+
+	// Zero delivery: nothing served recently by a line item that served
+	// before or has a goal to make. Dayparted line items are skipped, as
+	// they stop serving outside their hours.
+	if h.LastHours == 0 && h.ActiveCreatives > 0 && !h.HasDayparts && now.Sub(flightStart) >= zeroDeliveryAge &&
+		(h.DayImpressions+h.WeekImpressions > 0 || hourlyPace >= 1) {
+		alerts = append(alerts, models.Alert{
+			Type:       models.AlertZeroDelivery,
+			Severity:   models.SeverityCritical,
+			LineItemID: h.LineItemID,
+			Message:    fmt.Sprintf("%s has served no impressions for over %d hours", h.Name, int(zeroDeliveryAge.Hours())),
+			Details: map[string]interface{}{
+				"last_24h_impressions": h.DayImpressions,
+				"active_creatives":     h.ActiveCreatives,
+			},
+		})
+		return alerts
+	}
+
+	// Under-delivery: behind pace for a goal with an end date, otherwise
+	// well down on the same hours a day earlier
+	if h.ImpressionGoal > 0 && h.EndDate != nil && h.EndDate.After(flightStart) {
+		elapsed := now.Sub(flightStart).Seconds() / h.EndDate.Sub(flightStart).Seconds()
+		expected := float64(h.ImpressionGoal) * elapsed
+		if elapsed >= paceMinElapsed && float64(delivered) < paceThreshold*expected {
+			alerts = append(alerts, models.Alert{
+				Type:       models.AlertUnderDelivery,
+				Severity:   models.SeverityWarning,
+				LineItemID: h.LineItemID,
+				Message: fmt.Sprintf("%s has delivered %d of the %d impressions expected by now (%.0f%% of pace)",
+					h.Name, delivered, int(expected), float64(delivered)/expected*100),
+				Details: map[string]interface{}{
+					"basis":           "pace",
+					"impression_goal": h.ImpressionGoal,
+					"delivered":       delivered,
+					"expected":        int(expected),
+					"hourly_pace":     int(hourlyPace),
+				},
+			})
+		}
+	} else if h.Baseline >= baselineMinImpressions && float64(h.Recent) < baselineDropThreshold*float64(h.Baseline) {
+		alerts = append(alerts, models.Alert{
+			Type:       models.AlertUnderDelivery,
+			Severity:   models.SeverityWarning,
+			LineItemID: h.LineItemID,
+			Message: fmt.Sprintf("%s served %d impressions in the last 3 hours, down %.0f%% on %d in the same hours yesterday",
+				h.Name, h.Recent, (1-float64(h.Recent)/float64(h.Baseline))*100, h.Baseline),
+			Details: map[string]interface{}{
+				"basis":    "baseline",
+				"recent":   h.Recent,
+				"baseline": h.Baseline,
+			},
+		})
+	}
+
+	// CTR anomaly: the last day's CTR far off the previous week's
+	if h.DayImpressions >= ctrMinImpressions && h.WeekImpressions >= ctrMinImpressions && h.WeekClicks > 0 {
+		dayCTR := float64(h.DayClicks) / float64(h.DayImpressions)
+		weekCTR := float64(h.WeekClicks) / float64(h.WeekImpressions)
+		ratio := dayCTR / weekCTR
+		if weekCTR*float64(h.DayImpressions) >= ctrMinExpectedClicks && (ratio >= ctrAnomalyRatio || ratio <= 1/float64(ctrAnomalyRatio)) {
+			alerts = append(alerts, models.Alert{
+				Type:       models.AlertCTRAnomaly,
+				Severity:   models.SeverityWarning,
+				LineItemID: h.LineItemID,
+				Message: fmt.Sprintf("%s has a CTR of %.2f%% over the last 24 hours, against %.2f%% over the previous 7 days",
+					h.Name, dayCTR*100, weekCTR*100),
+				Details: map[string]interface{}{
+					"day_impressions":  h.DayImpressions,
+					"day_clicks":       h.DayClicks,
+					"day_ctr":          dayCTR * 100,
+					"week_impressions": h.WeekImpressions,
+					"week_clicks":      h.WeekClicks,
+					"week_ctr":         weekCTR * 100,
+				},
+			})
+		}
+	}
+
+	return alerts
+}
@@ -0,0 +1,162 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+var checkNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) *time.Time {
+	t := checkNow.Add(d)
+	return &t
+}
+
+// healthyLineItem has served steadily for ten days with a 1% CTR and no
+// goal
+func healthyLineItem() storage.LineItemHealth {
+	return storage.LineItemHealth{
+		LineItemID:      1,
+		Name:            "Spring",
+		CreatedAt:       checkNow.Add(-240 * time.Hour),
+		ActiveCreatives: 1,
+		LastHours:       150,
+		Recent:          150,
+		Baseline:        150,
+		DayImpressions:  1200,
+		DayClicks:       12,
+		WeekImpressions: 8400,
+		WeekClicks:      84,
+	}
+}
+
+func TestCheckLineItem(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(h *storage.LineItemHealth)
+		delivered int
+		want      []string
+	}{
+		{"healthy", func(h *storage.LineItemHealth) {}, 0, nil},
+
+		// Zero delivery
+		{"stopped serving", func(h *storage.LineItemHealth) { h.LastHours = 0 }, 0,
+			[]string{models.AlertZeroDelivery}},
+		{"zero delivery masks other alerts", func(h *storage.LineItemHealth) { h.LastHours, h.Recent, h.DayClicks = 0, 0, 100 }, 0,
+			[]string{models.AlertZeroDelivery}},
+		{"too new to judge", func(h *storage.LineItemHealth) {
+			h.LastHours = 0
+			h.CreatedAt = checkNow.Add(-time.Hour)
+		}, 0, nil},
+		{"start date counts over creation", func(h *storage.LineItemHealth) {
+			h.LastHours = 0
+			h.StartDate = at(-zeroDeliveryAge + time.Minute)
+		}, 0, nil},
+		{"flight started two hours ago", func(h *storage.LineItemHealth) {
+			h.LastHours = 0
+			h.StartDate = at(-zeroDeliveryAge)
+		}, 0, []string{models.AlertZeroDelivery}},
+		{"dayparted", func(h *storage.LineItemHealth) { h.LastHours, h.HasDayparts = 0, true }, 0, nil},
+		{"no active creatives", func(h *storage.LineItemHealth) { h.LastHours, h.ActiveCreatives = 0, 0 }, 0, nil},
+		{"never served and no goal", func(h *storage.LineItemHealth) {
+			*h = storage.LineItemHealth{LineItemID: 1, CreatedAt: checkNow.Add(-240 * time.Hour), ActiveCreatives: 1}
+		}, 0, nil},
+		{"never served with a goal to make", func(h *storage.LineItemHealth) {
+			*h = storage.LineItemHealth{LineItemID: 1, CreatedAt: checkNow.Add(-time.Hour), ActiveCreatives: 1,
+				StartDate: at(-3 * time.Hour), EndDate: at(97 * time.Hour), ImpressionGoal: 10000}
+		}, 0, []string{models.AlertZeroDelivery}},
+		{"never served with a goal under an impression an hour", func(h *storage.LineItemHealth) {
+			*h = storage.LineItemHealth{LineItemID: 1, CreatedAt: checkNow.Add(-time.Hour), ActiveCreatives: 1,
+				StartDate: at(-3 * time.Hour), EndDate: at(97 * time.Hour), ImpressionGoal: 50}
+		}, 0, nil},
+
+		// Under-delivery against pace: half the flight gone, 500 expected
+		{"behind pace", func(h *storage.LineItemHealth) {
+			h.StartDate, h.EndDate, h.ImpressionGoal = at(-50*time.Hour), at(50*time.Hour), 1000
+		}, 399, []string{models.AlertUnderDelivery}},
+		{"at the pace threshold", func(h *storage.LineItemHealth) {
+			h.StartDate, h.EndDate, h.ImpressionGoal = at(-50*time.Hour), at(50*time.Hour), 1000
+		}, 400, nil},
+		{"too early in the flight", func(h *storage.LineItemHealth) {
+			h.StartDate, h.EndDate, h.ImpressionGoal = at(-9*time.Hour), at(91*time.Hour), 1000
+		}, 0, nil},
+		{"past the end date", func(h *storage.LineItemHealth) {
+			h.StartDate, h.EndDate, h.ImpressionGoal = at(-100*time.Hour), at(-time.Hour), 1000
+		}, 700, []string{models.AlertUnderDelivery}},
+		{"pace replaces the baseline", func(h *storage.LineItemHealth) {
+			h.StartDate, h.EndDate, h.ImpressionGoal = at(-50*time.Hour), at(50*time.Hour), 1000
+			h.Recent, h.Baseline = 10, 1000
+		}, 500, nil},
+
+		// Under-delivery against the day before
+		{"down on yesterday", func(h *storage.LineItemHealth) { h.Recent, h.Baseline = 99, 200 }, 0,
+			[]string{models.AlertUnderDelivery}},
+		{"at half of yesterday", func(h *storage.LineItemHealth) { h.Recent, h.Baseline = 100, 200 }, 0, nil},
+		{"baseline too small", func(h *storage.LineItemHealth) { h.Recent, h.Baseline = 0, baselineMinImpressions-1 }, 0, nil},
+		{"goal without an end date uses the baseline", func(h *storage.LineItemHealth) {
+			h.ImpressionGoal, h.Recent, h.Baseline = 1000, 10, 200
+		}, 0, []string{models.AlertUnderDelivery}},
+
+		// CTR anomalies against a 1% weekly CTR
+		{"CTR three times higher", func(h *storage.LineItemHealth) { h.DayImpressions, h.DayClicks = 1000, 30 }, 0,
+			[]string{models.AlertCTRAnomaly}},
+		{"CTR a third", func(h *storage.LineItemHealth) { h.DayImpressions, h.DayClicks = 1000, 3 }, 0,
+			[]string{models.AlertCTRAnomaly}},
+		{"CTR within range", func(h *storage.LineItemHealth) { h.DayImpressions, h.DayClicks = 1000, 29 }, 0, nil},
+		{"too few impressions today", func(h *storage.LineItemHealth) {
+			h.DayImpressions, h.DayClicks = ctrMinImpressions-1, 100
+		}, 0, nil},
+		{"too few impressions last week", func(h *storage.LineItemHealth) {
+			h.WeekImpressions, h.WeekClicks, h.DayClicks = ctrMinImpressions-1, 9, 100
+		}, 0, nil},
+		{"no clicks last week", func(h *storage.LineItemHealth) { h.WeekClicks, h.DayClicks = 0, 100 }, 0, nil},
+		{"too few clicks expected", func(h *storage.LineItemHealth) {
+			// 0.1% of 1200 is under ctrMinExpectedClicks
+			h.WeekClicks, h.DayClicks = 8, 100
+		}, 0, nil},
+		{"under-delivering with a CTR anomaly", func(h *storage.LineItemHealth) {
+			h.Recent, h.Baseline, h.DayClicks = 50, 200, 100
+		}, 0, []string{models.AlertUnderDelivery, models.AlertCTRAnomaly}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := healthyLineItem()
+			tt.edit(&h)
+			var got []string
+			for _, a := range checkLineItem(&h, tt.delivered, checkNow) {
+				if a.LineItemID != h.LineItemID {
+					t.Errorf("%s alert for line item %d, want %d", a.Type, a.LineItemID, h.LineItemID)
+				}
+				got = append(got, a.Type)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alerts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLineItemPaceDetails(t *testing.T) {
+	h := healthyLineItem()
+	h.StartDate, h.EndDate, h.ImpressionGoal = at(-50*time.Hour), at(50*time.Hour), 1000
+
+	alerts := checkLineItem(&h, 300, checkNow)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	want := map[string]interface{}{
+		"basis":           "pace",
+		"impression_goal": 1000,
+		"delivered":       300,
+		"expected":        500,
+		"hourly_pace":     14,
+	}
+	if !reflect.DeepEqual(alerts[0].Details, want) {
+		t.Errorf("details %v, want %v", alerts[0].Details, want)
+	}
+}
@@ -0,0 +1,279 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// checkInterval is how often delivery is checked
+	checkInterval = 5 * time.Minute
+	// creativeCheckInterval is how often creative URLs are fetched
+	creativeCheckInterval = 1 * time.Hour
+	// requestTimeout bounds a creative check or webhook delivery
+	requestTimeout = 10 * time.Second
+)
+
+// Webhook events
+const (
+	eventRaised   = "alert.raised"
+	eventResolved = "alert.resolved"
+)
+
+// alertKey identifies the open alert for a condition
+type alertKey struct {
+	alertType  string
+	lineItemID int
+	creativeID int
+}
+
+func keyOf(a *models.Alert) alertKey {
+	return alertKey{a.Type, a.LineItemID, a.CreativeID}
+}
+
+// Monitor checks the delivery of active line items from a background
+// goroutine. Each check raises alerts for zero delivery, under-delivery
+// against pace or the day before, and CTR anomalies; creative image URLs
+// that return 404 are checked hourly. Alerts whose condition has cleared are
+// resolved. Raised and resolved alerts are POSTed to the alert webhooks.
+type Monitor struct {
+	store             *storage.PostgresStore
+	client            *http.Client
+	uploadDir         string
+	lastCreativeCheck time.Time
+	now               func() time.Time
+}
+
+// NewMonitor creates a new Monitor. uploadDir is where the images uploaded
+// to this server are saved.
+func NewMonitor(store *storage.PostgresStore, uploadDir string) *Monitor {
+	m := &Monitor{
+		store:     store,
+		client:    &http.Client{Timeout: requestTimeout},
+		uploadDir: uploadDir,
+		now:       time.Now,
+	}
+
+	// Start goroutine to check delivery
+	go m.loop()
+
+	return m
+}
+
+func (m *Monitor) loop() {
+	m.run()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.run()
+	}
+}
+
+func (m *Monitor) run() {
+	ctx := context.Background()
+	now := m.now()
+
+	health, err := m.store.GetLineItemHealth(ctx, now)
+	if err != nil {
+		log.Printf("Warning: Failed to get line item delivery: %v", err)
+		return
+	}
+	delivered, err := m.store.GetLineItemDelivery(ctx, 0)
+	if err != nil {
+		log.Printf("Warning: Failed to get line item delivery: %v", err)
+		return
+	}
+
+	var found []models.Alert
+	for i := range health {
+		found = append(found, checkLineItem(&health[i], delivered[health[i].LineItemID], now)...)
+	}
+	checked := map[string]bool{
+		models.AlertZeroDelivery:  true,
+		models.AlertUnderDelivery: true,
+		models.AlertCTRAnomaly:    true,
+	}
+	if now.Sub(m.lastCreativeCheck) >= creativeCheckInterval {
+		creativeAlerts, err := m.checkCreatives(ctx, health)
+		if err != nil {
+			log.Printf("Warning: Failed to check creatives: %v", err)
+		} else {
+			found = append(found, creativeAlerts...)
+			checked[models.AlertCreative404] = true
+			m.lastCreativeCheck = now
+		}
+	}
+
+	current := make(map[alertKey]bool)
+	for i := range found {
+		current[keyOf(&found[i])] = true
+		alert, raised, err := m.store.RaiseAlert(ctx, &found[i])
+		if err != nil {
+			log.Printf("Warning: Failed to raise %s alert for line item %d: %v", found[i].Type, found[i].LineItemID, err)
+			continue
+		}
+		if raised {
+			m.notify(ctx, eventRaised, alert)
+		}
+	}
+
+	// Resolve open alerts whose condition was checked and has cleared,
+	// including those of line items no longer active
+	open, err := m.store.ListAlerts(ctx, 0, models.AlertOpen, 0, 0)
+	if err != nil {
+		log.Printf("Warning: Failed to list open alerts: %v", err)
+		return
+	}
+	for i := range open {
+		if !checked[open[i].Type] || current[keyOf(&open[i])] {
+			continue
+		}
+		alert, err := m.store.ResolveAlert(ctx, open[i].ID)
+		if err != nil {
+			log.Printf("Warning: Failed to resolve alert %d: %v", open[i].ID, err)
+			continue
+		}
+		if alert != nil {
+			m.notify(ctx, eventResolved, alert)
+		}
+	}
+}
+
+// checkCreatives fetches the image of every active creative of the checked
+// line items and returns an alert for each that is gone (404 or 410).
+// Other failures may be temporary and are ignored.
+func (m *Monitor) checkCreatives(ctx context.Context, health []storage.LineItemHealth) ([]models.Alert, error) {
+	var alerts []models.Alert
+	for _, h := range health {
+		creatives, err := m.store.ListCreatives(ctx, h.NetworkID, h.LineItemID)
+		if err != nil {
+			return nil, err
+		}
+		for _, cr := range creatives {
+			if !cr.IsServable() {
+				continue
+			}
+			status, ok := m.imageStatus(ctx, cr.ImageURL)
+			if !ok || (status != http.StatusNotFound && status != http.StatusGone) {
+				continue
+			}
+			alerts = append(alerts, models.Alert{
+				Type:       models.AlertCreative404,
+				Severity:   models.SeverityCritical,
+				LineItemID: h.LineItemID,
+				CreativeID: cr.ID,
+				Message:    fmt.Sprintf("Creative %q of %s returns HTTP %d", cr.Name, h.Name, status),
+				Details: map[string]interface{}{
+					"image_url":   cr.ImageURL,
+					"status_code": status,
+				},
+			})
+		}
+	}
+	return alerts, nil
+}
+
+// imageStatus returns the HTTP status of a creative image. Images uploaded
+// to this server have a relative /uploads/ URL and are 404 when their file
+// is gone from the upload directory; absolute URLs are fetched. ok is false
+// when the image could not be checked.
+func (m *Monitor) imageStatus(ctx context.Context, imageURL string) (status int, ok bool) {
+	if name, found := strings.CutPrefix(imageURL, "/uploads/"); found {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\?#`) {
+			return 0, false
+		}
+		_, err := os.Stat(filepath.Join(m.uploadDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return http.StatusNotFound, true
+		}
+		if err != nil {
+			return 0, false
+		}
+		return http.StatusOK, true
+	}
+
+	if !strings.HasPrefix(imageURL, "http") {
+		return 0, false
+	}
+	status, err := m.fetchStatus(ctx, imageURL)
+	return status, err == nil
+}
+
+// fetchStatus returns the HTTP status of a URL, trying HEAD first and GET
+// for servers that don't allow HEAD
+func (m *Monitor) fetchStatus(ctx context.Context, url string) (int, error) {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	return status, nil
+}
+
+// notify POSTs an alert event to every active webhook of the alert's network
+// that wants the alert's type. Failures are logged; the alert itself is
+// already stored.
+func (m *Monitor) notify(ctx context.Context, event string, alert *models.Alert) {
+	webhooks, err := m.store.ListAlertWebhooks(ctx, alert.NetworkID)
+	if err != nil {
+		log.Printf("Warning: Failed to list alert webhooks: %v", err)
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"event": event, "alert": alert})
+	if err != nil {
+		return
+	}
+	for _, w := range webhooks {
+		if w.Status != "active" || !w.Wants(alert.Type) {
+			continue
+		}
+		if err := m.post(ctx, w.URL, event, body); err != nil {
+			log.Printf("Warning: Failed to deliver alert %d to webhook %d: %v", alert.ID, w.ID, err)
+		}
+	}
+}
+
+func (m *Monitor) post(ctx context.Context, url, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alert-Event", event)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
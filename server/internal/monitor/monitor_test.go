@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageStatus(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "n1_1700000000_ab12cd34.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+		case "/gone.png":
+			w.WriteHeader(http.StatusGone)
+		case "/no-head.png":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	m := &Monitor{client: srv.Client(), uploadDir: dir}
+	tests := []struct {
+		imageURL   string
+		wantStatus int
+		wantOK     bool
+	}{
+		{"/uploads/n1_1700000000_ab12cd34.png", http.StatusOK, true},
+		{"/uploads/n1_1700000000_deleted0.png", http.StatusNotFound, true},
+		{"/uploads/../monitor.go", 0, false},
+		{"/uploads/", 0, false},
+		{"/uploads/..", 0, false},
+		{"data:image/png;base64,iVBORw0KGgo=", 0, false},
+		{"", 0, false},
+		{srv.URL + "/ok.png", http.StatusOK, true},
+		{srv.URL + "/missing.png", http.StatusNotFound, true},
+		{srv.URL + "/gone.png", http.StatusGone, true},
+		{srv.URL + "/no-head.png", http.StatusNotFound, true},
+		{"http://127.0.0.1:1/unreachable.png", 0, false},
+	}
+	for _, tt := range tests {
+		status, ok := m.imageStatus(context.Background(), tt.imageURL)
+		if status != tt.wantStatus || ok != tt.wantOK {
+			t.Errorf("imageStatus(%q) = %d, %v, want %d, %v", tt.imageURL, status, ok, tt.wantStatus, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package requestlog
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// batchSize is the number of opportunities written per insert
+	batchSize = 500
+	// flushInterval bounds how long an opportunity waits before being written
+	flushInterval = 5 * time.Second
+	// queueSize is how many opportunities may wait for the writer; beyond
+	// that new ones are dropped rather than slowing down ad serving
+	queueSize = 10000
+	// dropReportInterval is how often dropped opportunities are logged
+	dropReportInterval = 1 * time.Minute
+)
+
+// Logger samples ad slot opportunities and writes them to the database in
+// batches from a background goroutine
+type Logger struct {
+	store      *storage.PostgresStore
+	sampleRate float64
+	queue      chan models.AdOpportunity
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	// dropped counts opportunities dropped because the queue was full, in
+	// total and since the last report. Fill-rate reports undercount
+	// requests by as much.
+	dropped          atomic.Int64
+	droppedUnlogged  atomic.Int64
+	lastDropReported time.Time
+}
+
+// NewLogger creates a Logger that keeps the given share of ad requests
+// (0 disables logging, 1 logs everything)
+func NewLogger(store *storage.PostgresStore, sampleRate float64) *Logger {
+	if sampleRate < 0 {
+		sampleRate = 0
+	}
+	if sampleRate > 1 {
+		sampleRate = 1
+	}
+
+	l := &Logger{
+		store:      store,
+		sampleRate: sampleRate,
+		queue:      make(chan models.AdOpportunity, queueSize),
+		done:       make(chan struct{}),
+	}
+
+	// Start goroutine to write batches
+	go l.run()
+
+	return l
+}
+
+// Sample decides whether an ad request is logged. Sampling is per request,
+// so all slots of a sampled request are kept together.
+func (l *Logger) Sample() bool {
+	return l.sampleRate > 0 && (l.sampleRate >= 1 || rand.Float64() < l.sampleRate)
+}
+
+// Log queues the opportunities of a sampled ad request
+func (l *Logger) Log(opportunities []models.AdOpportunity) {
+	now := time.Now()
+	for _, o := range opportunities {
+		o.SampleRate = l.sampleRate
+		o.CreatedAt = now
+		o.Platform = truncate(o.Platform, 20)
+		o.Country = truncate(o.Country, 10)
+		o.AdUnit = truncate(o.AdUnit, 100)
+
+		select {
+		case l.queue <- o:
+		default:
+			// Queue full - drop rather than block ad serving
+			l.dropped.Add(1)
+			l.droppedUnlogged.Add(1)
+		}
+	}
+}
+
+// Close flushes queued opportunities and stops the writer
+func (l *Logger) Close() {
+	l.closeOnce.Do(func() {
+		close(l.queue)
+		<-l.done
+	})
+}
+
+// run collects opportunities into batches and writes them when a batch is
+// full or the flush interval passes
+func (l *Logger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AdOpportunity, 0, batchSize)
+	for {
+		select {
+		case o, ok := <-l.queue:
+			if !ok {
+				l.flush(batch)
+				l.reportDropped()
+				return
+			}
+			batch = append(batch, o)
+			if len(batch) >= batchSize {
+				l.flush(batch)
+				batch = batch[:0]
+			}
+		case now := <-ticker.C:
+			l.flush(batch)
+			batch = batch[:0]
+			if now.Sub(l.lastDropReported) >= dropReportInterval {
+				l.reportDropped()
+				l.lastDropReported = now
+			}
+		}
+	}
+}
+
+// reportDropped logs the opportunities dropped since the last report
+func (l *Logger) reportDropped() {
+	if n := l.droppedUnlogged.Swap(0); n > 0 {
+		log.Printf("Warning: Dropped %d ad opportunities because the request log queue was full (%d in total); lower REQUEST_LOG_SAMPLE_RATE", n, l.dropped.Load())
+	}
+}
+
+// flush writes a batch, logging (not retrying) failures
+func (l *Logger) flush(batch []models.AdOpportunity) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := l.store.InsertAdOpportunities(ctx, batch); err != nil {
+		log.Printf("Warning: Failed to write %d ad opportunities: %v", len(batch), err)
+	}
+}
+
+// truncate shortens s to fit a column of n characters
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package rollup
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// runInterval is how often completed hours are rolled up
+	runInterval = 1 * time.Minute
+	// settleDelay is how long after an hour ends it is first rolled up, so
+	// events still being written at the end of the hour make it in. Events
+	// that land in an hour after it was rolled up mark it to be rolled up
+	// again.
+	settleDelay = 5 * time.Minute
+)
+
+// rollupStore is the storage the aggregator uses. PostgresStore implements
+// it.
+type rollupStore interface {
+	GetRollupWatermark(ctx context.Context) (time.Time, bool, error)
+	SetRollupWatermark(ctx context.Context, rolledUntil time.Time) error
+	GetEarliestEventTime(ctx context.Context) (time.Time, bool, error)
+	RollupHour(ctx context.Context, hour time.Time) error
+	ClaimRollupDirtyHours(ctx context.Context) ([]time.Time, error)
+	MarkRollupHoursDirty(ctx context.Context, hours []time.Time) error
+}
+
+var _ rollupStore = (*storage.PostgresStore)(nil)
+
+// Aggregator rolls raw events up into hourly counts from a background
+// goroutine. Every hour is recomputed from scratch, so runs are idempotent.
+type Aggregator struct {
+	store rollupStore
+	now   func() time.Time
+}
+
+// NewAggregator creates a new Aggregator
+func NewAggregator(store *storage.PostgresStore) *Aggregator {
+	a := &Aggregator{
+		store: store,
+		now:   time.Now,
+	}
+
+	// Start goroutine to roll up completed hours
+	go a.loop()
+
+	return a
+}
+
+func (a *Aggregator) loop() {
+	a.run()
+
+	ticker := time.NewTicker(runInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.run()
+	}
+}
+
+// run rolls up every settled hour since the watermark and advances the
+// watermark past them, then rolls up again the hours that received events
+// after they were rolled up. The first run backfills from the oldest event.
+func (a *Aggregator) run() {
+	ctx := context.Background()
+	if a.advance(ctx) {
+		a.rerollDirty(ctx)
+	}
+}
+
+// advance rolls up the hours from the watermark to the last settled hour and
+// moves the watermark to its end. It reports whether the watermark could be
+// read.
+func (a *Aggregator) advance(ctx context.Context) bool {
+	settled := a.now().UTC().Add(-settleDelay).Truncate(time.Hour)
+
+	watermark, ok, err := a.store.GetRollupWatermark(ctx)
+	if err != nil {
+		log.Printf("Warning: Failed to read rollup watermark: %v", err)
+		return false
+	}
+
+	from := watermark
+	if !ok {
+		earliest, found, err := a.store.GetEarliestEventTime(ctx)
+		if err != nil {
+			log.Printf("Warning: Failed to find earliest event: %v", err)
+			return false
+		}
+		if !found {
+			earliest = settled
+		}
+		from = earliest.UTC().Truncate(time.Hour)
+	}
+
+	for hour := from; hour.Before(settled); hour = hour.Add(time.Hour) {
+		if err := a.store.RollupHour(ctx, hour); err != nil {
+			// Leave the watermark at the failed hour so reports keep
+			// reading raw events from it on
+			log.Printf("Warning: Failed to roll up events for %s: %v", hour.Format(time.RFC3339), err)
+			if hour.After(from) {
+				a.setWatermark(ctx, hour)
+			}
+			return true
+		}
+	}
+
+	if ok && !settled.After(watermark) {
+		return true
+	}
+	a.setWatermark(ctx, settled)
+	return true
+}
+
+func (a *Aggregator) setWatermark(ctx context.Context, rolledUntil time.Time) {
+	if err := a.store.SetRollupWatermark(ctx, rolledUntil); err != nil {
+		log.Printf("Warning: Failed to advance rollup watermark: %v", err)
+	}
+}
+
+// rerollDirty rolls up again the hours that received late events. Hours that
+// fail are marked again for the next run.
+func (a *Aggregator) rerollDirty(ctx context.Context) {
+	hours, err := a.store.ClaimRollupDirtyHours(ctx)
+	if err != nil {
+		log.Printf("Warning: Failed to read late rollup hours: %v", err)
+		return
+	}
+
+	var failed []time.Time
+	for _, hour := range hours {
+		if err := a.store.RollupHour(ctx, hour); err != nil {
+			log.Printf("Warning: Failed to roll up late events for %s: %v", hour.Format(time.RFC3339), err)
+			failed = append(failed, hour)
+		}
+	}
+	if err := a.store.MarkRollupHoursDirty(ctx, failed); err != nil {
+		log.Printf("Warning: Failed to mark late rollup hours: %v", err)
+	}
+}
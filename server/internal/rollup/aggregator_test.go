@@ -0,0 +1,184 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeStore records the hours rolled up and fails the hours in fail
+type fakeStore struct {
+	watermark    time.Time
+	hasWatermark bool
+	earliest     time.Time
+	hasEvents    bool
+	dirty        []time.Time
+	fail         map[time.Time]bool
+
+	rolled []time.Time
+}
+
+func (s *fakeStore) GetRollupWatermark(ctx context.Context) (time.Time, bool, error) {
+	return s.watermark, s.hasWatermark, nil
+}
+
+func (s *fakeStore) SetRollupWatermark(ctx context.Context, rolledUntil time.Time) error {
+	s.watermark, s.hasWatermark = rolledUntil, true
+	return nil
+}
+
+func (s *fakeStore) GetEarliestEventTime(ctx context.Context) (time.Time, bool, error) {
+	return s.earliest, s.hasEvents, nil
+}
+
+func (s *fakeStore) RollupHour(ctx context.Context, hour time.Time) error {
+	if s.fail[hour] {
+		return errors.New("rollup failed")
+	}
+	s.rolled = append(s.rolled, hour)
+	return nil
+}
+
+func (s *fakeStore) ClaimRollupDirtyHours(ctx context.Context) ([]time.Time, error) {
+	hours := s.dirty
+	s.dirty = nil
+	return hours, nil
+}
+
+func (s *fakeStore) MarkRollupHoursDirty(ctx context.Context, hours []time.Time) error {
+	s.dirty = append(s.dirty, hours...)
+	return nil
+}
+
+func at(hour, min int) time.Time {
+	return time.Date(2026, 1, 5, hour, min, 0, 0, time.UTC)
+}
+
+func hours(hs ...int) []time.Time {
+	var ts []time.Time
+	for _, h := range hs {
+		ts = append(ts, at(h, 0))
+	}
+	return ts
+}
+
+func TestRunAdvancesWatermark(t *testing.T) {
+	tests := []struct {
+		name          string
+		store         fakeStore
+		now           time.Time
+		wantRolled    []time.Time
+		wantWatermark time.Time
+	}{
+		{
+			name:          "first run backfills from the oldest event",
+			store:         fakeStore{earliest: at(7, 42), hasEvents: true},
+			now:           at(10, 30),
+			wantRolled:    hours(7, 8, 9),
+			wantWatermark: at(10, 0),
+		},
+		{
+			name:          "first run without events",
+			now:           at(10, 30),
+			wantWatermark: at(10, 0),
+		},
+		{
+			name:          "completed hours since the watermark",
+			store:         fakeStore{watermark: at(8, 0), hasWatermark: true},
+			now:           at(10, 30),
+			wantRolled:    hours(8, 9),
+			wantWatermark: at(10, 0),
+		},
+		{
+			// The 09:00 hour ended less than settleDelay ago
+			name:          "an hour isn't rolled up until it settles",
+			store:         fakeStore{watermark: at(9, 0), hasWatermark: true},
+			now:           at(10, 3),
+			wantWatermark: at(9, 0),
+		},
+		{
+			name:          "an hour is rolled up once it settles",
+			store:         fakeStore{watermark: at(9, 0), hasWatermark: true},
+			now:           at(10, 5),
+			wantRolled:    hours(9),
+			wantWatermark: at(10, 0),
+		},
+		{
+			name:          "up to date",
+			store:         fakeStore{watermark: at(10, 0), hasWatermark: true},
+			now:           at(10, 59),
+			wantWatermark: at(10, 0),
+		},
+		{
+			name:          "a failed hour holds the watermark there",
+			store:         fakeStore{watermark: at(6, 0), hasWatermark: true, fail: map[time.Time]bool{at(8, 0): true}},
+			now:           at(10, 30),
+			wantRolled:    hours(6, 7),
+			wantWatermark: at(8, 0),
+		},
+		{
+			name:          "a failed first hour leaves the watermark",
+			store:         fakeStore{watermark: at(6, 0), hasWatermark: true, fail: map[time.Time]bool{at(6, 0): true}},
+			now:           at(10, 30),
+			wantWatermark: at(6, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			a := &Aggregator{store: &store, now: func() time.Time { return tt.now }}
+			a.run()
+			if !reflect.DeepEqual(store.rolled, tt.wantRolled) {
+				t.Errorf("rolled up %v, want %v", store.rolled, tt.wantRolled)
+			}
+			if !store.watermark.Equal(tt.wantWatermark) {
+				t.Errorf("watermark = %v, want %v", store.watermark, tt.wantWatermark)
+			}
+		})
+	}
+}
+
+func TestRunRerollsLateHoursOnly(t *testing.T) {
+	store := &fakeStore{watermark: at(10, 0), hasWatermark: true, dirty: hours(3, 9)}
+	a := &Aggregator{store: store, now: func() time.Time { return at(10, 20) }}
+
+	// Only the hours marked by late events are rolled up again, however old
+	a.run()
+	if want := hours(3, 9); !reflect.DeepEqual(store.rolled, want) {
+		t.Errorf("rolled up %v, want %v", store.rolled, want)
+	}
+	if len(store.dirty) != 0 {
+		t.Errorf("late hours %v left after they were rolled up", store.dirty)
+	}
+
+	// Nothing late: nothing is rolled up again
+	store.rolled = nil
+	a.run()
+	if len(store.rolled) != 0 {
+		t.Errorf("rolled up %v with no late events", store.rolled)
+	}
+}
+
+func TestRunRemarksFailedLateHours(t *testing.T) {
+	store := &fakeStore{watermark: at(10, 0), hasWatermark: true, dirty: hours(4, 5), fail: map[time.Time]bool{at(5, 0): true}}
+	a := &Aggregator{store: store, now: func() time.Time { return at(10, 20) }}
+
+	a.run()
+	if want := hours(4); !reflect.DeepEqual(store.rolled, want) {
+		t.Errorf("rolled up %v, want %v", store.rolled, want)
+	}
+	if want := hours(5); !reflect.DeepEqual(store.dirty, want) {
+		t.Errorf("late hours after the run = %v, want %v marked again", store.dirty, want)
+	}
+
+	// The next run picks the failed hour up again
+	delete(store.fail, at(5, 0))
+	store.rolled = nil
+	a.run()
+	if want := hours(5); !reflect.DeepEqual(store.rolled, want) {
+		t.Errorf("rolled up %v, want %v", store.rolled, want)
+	}
+}
@@ -0,0 +1,32 @@
+package scheduler
+
+import "time"
+
+// reportDates returns the start and (exclusive) end midnights a scheduled
+// report's date range covers for a run at runAt, in runAt's location. Ranges
+// end before the run day, which is still in progress: a Monday run of
+// last_7_days covers the previous Monday to Sunday.
+func reportDates(dateRange string, runAt time.Time) (time.Time, time.Time) {
+	loc := runAt.Location()
+	today := time.Date(runAt.Year(), runAt.Month(), runAt.Day(), 0, 0, 0, 0, loc)
+
+	switch dateRange {
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today
+	case "last_30_days":
+		return today.AddDate(0, 0, -30), today
+	case "last_week":
+		// The previous Monday to Sunday
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return monday.AddDate(0, 0, -7), monday
+	case "month_to_date":
+		// On the 1st this is the whole previous month
+		yesterday := today.AddDate(0, 0, -1)
+		return time.Date(yesterday.Year(), yesterday.Month(), 1, 0, 0, 0, 0, loc), today
+	case "last_month":
+		first := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, loc)
+		return first.AddDate(0, -1, 0), first
+	default: // last_7_days
+		return today.AddDate(0, 0, -7), today
+	}
+}
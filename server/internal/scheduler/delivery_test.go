@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// smtpMessage is a message received by smtpSink
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpSink is an in-process SMTP server that accepts every message, like a
+// local Mailpit
+type smtpSink struct {
+	ln       net.Listener
+	messages chan smtpMessage
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpSink{ln: ln, messages: make(chan smtpMessage, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) addr() (string, int) {
+	a := s.ln.Addr().(*net.TCPAddr)
+	return a.IP.String(), a.Port
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var msg smtpMessage
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = smtpMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.Bytes()
+			s.messages <- msg
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestMailerSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	host, port := sink.addr()
+	m := NewMailer(host, port, "", "", "reports@example.com")
+
+	file := attachment{filename: "daily_delivery.csv", contentType: "text/csv", data: []byte(strings.Repeat("date,impressions\n2026-01-05,1000\n", 10))}
+	to := []string{"ops@example.com", "sales@example.com"}
+	if err := m.Send(to, "Daily delivery: 2026-01-05 to 2026-01-05", "Rows: 10\n", file); err != nil {
+		t.Fatalf("Send returned %v", err)
+	}
+
+	got := <-sink.messages
+	if got.from != "reports@example.com" {
+		t.Errorf("MAIL FROM = %q, want reports@example.com", got.from)
+	}
+	if strings.Join(got.to, ",") != strings.Join(to, ",") {
+		t.Errorf("RCPT TO = %v, want %v", got.to, to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(got.data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Daily delivery: 2026-01-05 to 2026-01-05" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/mixed", msg.Header.Get("Content-Type"), err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("read body part: %v", err)
+	}
+	// Line breaks in text parts are CRLF on the wire
+	text, _ := io.ReadAll(quotedprintable.NewReader(body))
+	if string(text) != "Rows: 10\r\n" {
+		t.Errorf("body = %q, want %q", text, "Rows: 10\r\n")
+	}
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("read attachment part: %v", err)
+	}
+	if part.FileName() != file.filename {
+		t.Errorf("attachment filename = %q, want %q", part.FileName(), file.filename)
+	}
+	encoded, _ := io.ReadAll(part)
+	for _, line := range strings.Split(strings.TrimRight(string(encoded), "\r\n"), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line is %d characters, want at most 76", len(line))
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(data, file.data) {
+		t.Errorf("attachment = %q (%v), want %q", data, err, file.data)
+	}
+}
+
+func TestMailerSendUnconfigured(t *testing.T) {
+	file := attachment{filename: "r.csv", contentType: "text/csv"}
+	for _, m := range []*Mailer{nil, NewMailer("", 25, "", "", "reports@example.com")} {
+		if err := m.Send([]string{"ops@example.com"}, "s", "b", file); err == nil {
+			t.Error("Send without SMTP_HOST succeeded, want an error")
+		}
+	}
+}
+
+func TestMailerSendRefused(t *testing.T) {
+	// Nothing listens on a closed listener's port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	m := NewMailer("127.0.0.1", port, "", "", "reports@example.com")
+	file := attachment{filename: "r.csv", contentType: "text/csv"}
+	if err := m.Send([]string{"ops@example.com"}, "s", "b", file); err == nil {
+		t.Error("Send to a closed port succeeded, want an error")
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	file := attachment{filename: "daily.json", contentType: "application/json", data: []byte(`{"rows":[]}`)}
+	err := deliverWebhook(context.Background(), srv.Client(), srv.URL, map[string]string{"X-Report-ID": "7"}, file)
+	if err != nil {
+		t.Fatalf("deliverWebhook returned %v", err)
+	}
+
+	if got.Method != http.MethodPost {
+		t.Errorf("method = %s, want POST", got.Method)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if _, params, _ := mime.ParseMediaType(got.Header.Get("Content-Disposition")); params["filename"] != "daily.json" {
+		t.Errorf("Content-Disposition = %q, want filename daily.json", got.Header.Get("Content-Disposition"))
+	}
+	if id := got.Header.Get("X-Report-ID"); id != "7" {
+		t.Errorf("X-Report-ID = %q, want 7", id)
+	}
+	if !bytes.Equal(body, file.data) {
+		t.Errorf("body = %q, want %q", body, file.data)
+	}
+}
+
+func TestDeliverWebhookFailure(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		file := attachment{filename: "r.csv", contentType: "text/csv"}
+		if err := deliverWebhook(context.Background(), srv.Client(), srv.URL, nil, file); err == nil {
+			t.Errorf("deliverWebhook with a %d response succeeded, want an error", status)
+		}
+		srv.Close()
+	}
+}
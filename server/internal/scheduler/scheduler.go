@@ -0,0 +1,257 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/cron"
+	"github.com/mims/ad-manager/internal/export"
+	"github.com/mims/ad-manager/internal/models"
+	"github.com/mims/ad-manager/internal/storage"
+)
+
+const (
+	// runInterval is how often due reports are queued and runs are picked up
+	runInterval = 1 * time.Minute
+	// claimBatch is the most runs one tick picks up
+	claimBatch = 10
+	// maxAttempts is how many times a run is tried before it is marked failed
+	maxAttempts = 4
+	// retryBackoff is the wait before the first retry; each later retry waits
+	// three times as long (5, 15, 45 minutes)
+	retryBackoff = 5 * time.Minute
+)
+
+// Scheduler runs scheduled reports from a background goroutine. Each tick
+// queues a run for every report that is due and advances its next run time,
+// then works through the queued runs: run the report query, render the file
+// and deliver it. Failed runs are retried with backoff. Runs are claimed with
+// row locks, so several servers can run schedulers side by side.
+type Scheduler struct {
+	store   *storage.PostgresStore
+	reports storage.ReportReader
+	mailer  *Mailer
+	client  *http.Client
+	now     func() time.Time
+}
+
+// NewScheduler creates a new Scheduler. Reports run against reports (Postgres
+// or ClickHouse) and are bucketed in their network's timezone unless their
+// query sets one. A nil mailer fails email deliveries.
+func NewScheduler(store *storage.PostgresStore, reports storage.ReportReader, mailer *Mailer) *Scheduler {
+	s := &Scheduler{
+		store:   store,
+		reports: reports,
+		mailer:  mailer,
+		client:  &http.Client{},
+		now:     time.Now,
+	}
+
+	// Start goroutine to run due reports
+	go s.loop()
+
+	return s
+}
+
+// NextRun returns the first time after now a schedule fires in the report's
+// timezone, or nil if it never does
+func NextRun(schedule, timezone string, now time.Time) (*time.Time, error) {
+	sched, err := cron.Parse(schedule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := sched.Next(now.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+func (s *Scheduler) loop() {
+	s.run()
+
+	ticker := time.NewTicker(runInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.run()
+	}
+}
+
+func (s *Scheduler) run() {
+	ctx := context.Background()
+	s.queueDueReports(ctx)
+
+	for {
+		runs, err := s.store.ClaimScheduledReportRuns(ctx, claimBatch)
+		if err != nil {
+			log.Printf("Warning: Failed to claim scheduled report runs: %v", err)
+			return
+		}
+		for i := range runs {
+			s.execute(ctx, &runs[i])
+		}
+		if len(runs) < claimBatch {
+			return
+		}
+	}
+}
+
+// queueDueReports queues one run for every report whose next run time has
+// passed. Runs missed while the server was down collapse into one.
+func (s *Scheduler) queueDueReports(ctx context.Context) {
+	now := s.now()
+	due, err := s.store.ListDueScheduledReports(ctx, now)
+	if err != nil {
+		log.Printf("Warning: Failed to list due scheduled reports: %v", err)
+		return
+	}
+
+	for _, r := range due {
+		tz, err := s.timezone(ctx, &r)
+		if err != nil {
+			log.Printf("Warning: Failed to load the timezone of scheduled report %d: %v", r.ID, err)
+			continue
+		}
+		next, err := NextRun(r.Schedule, tz, now)
+		if err != nil {
+			log.Printf("Warning: Scheduled report %d has an invalid schedule: %v", r.ID, err)
+			continue
+		}
+		if _, err := s.store.QueueScheduledReportRun(ctx, r.ID, *r.NextRunAt, next); err != nil {
+			log.Printf("Warning: Failed to queue scheduled report %d: %v", r.ID, err)
+		}
+	}
+}
+
+// execute runs and delivers one claimed run, recording the outcome
+func (s *Scheduler) execute(ctx context.Context, run *models.ScheduledReportRun) {
+	report, err := s.store.GetScheduledReport(ctx, 0, run.ReportID)
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+	if report == nil {
+		// Deleting a report deletes its runs, so this only races a delete
+		return
+	}
+
+	tz, err := s.timezone(ctx, report)
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+	startDate, endDate := reportDates(report.DateRange, run.ScheduledFor.In(loc))
+	lastDate := endDate.AddDate(0, 0, -1)
+
+	req := report.Query
+	if req.Limit == 0 {
+		req.Limit = storage.MaxReportLimit
+	}
+	q, err := storage.NewReportQuery(report.NetworkID, &req, startDate, endDate, tz)
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+	result, err := s.reports.RunReportQuery(ctx, q)
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+
+	var data bytes.Buffer
+	if err := export.Write(&data, report.Format, result); err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+	file := attachment{
+		filename: fmt.Sprintf("%s_%s_to_%s.%s", slug(report.Name),
+			startDate.Format("2006-01-02"), lastDate.Format("2006-01-02"), report.Format),
+		contentType: export.ContentType(report.Format),
+		data:        data.Bytes(),
+	}
+
+	switch report.Delivery {
+	case models.DeliveryEmail:
+		subject := fmt.Sprintf("%s: %s to %s", report.Name, startDate.Format("2006-01-02"), lastDate.Format("2006-01-02"))
+		body := fmt.Sprintf("%s\n\nPeriod: %s to %s (%s)\nRows: %d\n\nThe report is attached.\n",
+			report.Name, startDate.Format("2006-01-02"), lastDate.Format("2006-01-02"), tz, len(result.Rows))
+		err = s.mailer.Send(report.Recipients, subject, body, file)
+	case models.DeliveryWebhook:
+		err = deliverWebhook(ctx, s.client, report.WebhookURL, map[string]string{
+			"X-Report-ID":         strconv.Itoa(report.ID),
+			"X-Report-Run-ID":     strconv.Itoa(run.ID),
+			"X-Report-Start-Date": startDate.Format("2006-01-02"),
+			"X-Report-End-Date":   lastDate.Format("2006-01-02"),
+			"X-Report-Timezone":   tz,
+		}, file)
+	default:
+		err = fmt.Errorf("unknown delivery method %q", report.Delivery)
+	}
+	if err != nil {
+		s.fail(ctx, run, err)
+		return
+	}
+
+	if err := s.store.CompleteScheduledReportRun(ctx, run.ID, startDate, lastDate, len(result.Rows)); err != nil {
+		log.Printf("Warning: Failed to record scheduled report run %d: %v", run.ID, err)
+	}
+}
+
+// fail records a failed attempt and schedules a retry while attempts remain
+func (s *Scheduler) fail(ctx context.Context, run *models.ScheduledReportRun, cause error) {
+	retryAt := nextAttempt(run.Attempts, s.now())
+	log.Printf("Warning: Scheduled report %d run %d failed (attempt %d/%d): %v", run.ReportID, run.ID, run.Attempts, maxAttempts, cause)
+	if err := s.store.FailScheduledReportRun(ctx, run.ID, cause.Error(), retryAt); err != nil {
+		log.Printf("Warning: Failed to record scheduled report run %d: %v", run.ID, err)
+	}
+}
+
+// nextAttempt returns when a run that has failed attempts times is retried,
+// or nil once it is out of attempts
+func nextAttempt(attempts int, now time.Time) *time.Time {
+	if attempts >= maxAttempts {
+		return nil
+	}
+	backoff := retryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 3
+	}
+	t := now.Add(backoff)
+	return &t
+}
+
+// timezone returns the timezone a report runs in: its query's, else its
+// network's
+func (s *Scheduler) timezone(ctx context.Context, r *models.ScheduledReport) (string, error) {
+	if r.Query.Timezone != "" {
+		return r.Query.Timezone, nil
+	}
+	return s.store.GetNetworkTimezone(ctx, r.NetworkID)
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns a report name into a file name
+func slug(name string) string {
+	s := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if s == "" {
+		return "report"
+	}
+	return s
+}
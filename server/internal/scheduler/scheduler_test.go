@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextAttempt(t *testing.T) {
+	now := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	// attempts counts the attempt that just failed
+	for attempts, want := range map[int]time.Duration{
+		1: 5 * time.Minute,
+		2: 15 * time.Minute,
+		3: 45 * time.Minute,
+	} {
+		got := nextAttempt(attempts, now)
+		if got == nil || !got.Equal(now.Add(want)) {
+			t.Errorf("nextAttempt(%d) = %v, want %v", attempts, got, now.Add(want))
+		}
+	}
+	for _, attempts := range []int{maxAttempts, maxAttempts + 1} {
+		if got := nextAttempt(attempts, now); got != nil {
+			t.Errorf("nextAttempt(%d) = %v, want nil after the last attempt", attempts, got)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	// 06:00 in Singapore (UTC+8) is 22:00 UTC the day before
+	next, err := NextRun("0 6 * * *", "Asia/Singapore", now)
+	if err != nil {
+		t.Fatalf("NextRun returned %v", err)
+	}
+	if want := time.Date(2026, 1, 5, 22, 0, 0, 0, time.UTC); next == nil || !next.Equal(want) {
+		t.Errorf("NextRun = %v, want %v", next, want)
+	}
+
+	if next, err := NextRun("0 0 30 2 *", "UTC", now); err != nil || next != nil {
+		t.Errorf("NextRun for a schedule that never fires = %v, %v; want nil, nil", next, err)
+	}
+	if _, err := NextRun("0 6 * *", "UTC", now); err == nil {
+		t.Error("NextRun with an invalid schedule succeeded, want an error")
+	}
+	if _, err := NextRun("0 6 * * *", "Mars/Olympus", now); err == nil {
+		t.Error("NextRun with an invalid timezone succeeded, want an error")
+	}
+}
+
+func TestReportDates(t *testing.T) {
+	// Monday 2026-01-05 at 06:00
+	runAt := time.Date(2026, 1, 5, 6, 0, 0, 0, time.UTC)
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		dateRange  string
+		start, end time.Time
+	}{
+		{"yesterday", day(2026, 1, 4), day(2026, 1, 5)},
+		{"last_7_days", day(2025, 12, 29), day(2026, 1, 5)},
+		{"last_30_days", day(2025, 12, 6), day(2026, 1, 5)},
+		{"last_week", day(2025, 12, 29), day(2026, 1, 5)},
+		{"month_to_date", day(2026, 1, 1), day(2026, 1, 5)},
+		{"last_month", day(2025, 12, 1), day(2026, 1, 1)},
+	}
+	for _, tt := range tests {
+		start, end := reportDates(tt.dateRange, runAt)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("reportDates(%q) = %v to %v, want %v to %v", tt.dateRange, start, end, tt.start, tt.end)
+		}
+	}
+
+	// On the 1st, month_to_date is the whole previous month
+	start, end := reportDates("month_to_date", time.Date(2026, 2, 1, 6, 0, 0, 0, time.UTC))
+	if !start.Equal(day(2026, 1, 1)) || !end.Equal(day(2026, 2, 1)) {
+		t.Errorf("month_to_date on the 1st = %v to %v, want January", start, end)
+	}
+}
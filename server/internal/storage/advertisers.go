@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// Advertisers and agencies share their columns
+const companyColumns = `id, network_id, name, status, contact_name, contact_email, contact_phone, billing_address, billing_email, notes, created_at, updated_at`
+
+func scanAdvertiser(row pgx.Row) (*models.Advertiser, error) {
+	var a models.Advertiser
+	if err := row.Scan(&a.ID, &a.NetworkID, &a.Name, &a.Status, &a.ContactName, &a.ContactEmail, &a.ContactPhone, &a.BillingAddress, &a.BillingEmail, &a.Notes, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func scanAgency(row pgx.Row) (*models.Agency, error) {
+	var a models.Agency
+	if err := row.Scan(&a.ID, &a.NetworkID, &a.Name, &a.Status, &a.ContactName, &a.ContactEmail, &a.ContactPhone, &a.BillingAddress, &a.BillingEmail, &a.Notes, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// companyArgs returns the column values of a create or replace request,
+// in the order of $2..$9 below
+func companyArgs(req *models.CompanyRequest) []interface{} {
+	status := req.Status
+	if status == "" {
+		status = "active"
+	}
+	return []interface{}{req.Name, status, req.ContactName, req.ContactEmail, req.ContactPhone, req.BillingAddress, req.BillingEmail, req.Notes}
+}
+
+const companyInsert = `(network_id, name, status, contact_name, contact_email, contact_phone, billing_address, billing_email, notes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())`
+
+const companyUpdate = `SET name = $2, status = $3, contact_name = $4, contact_email = $5, contact_phone = $6,
+		    billing_address = $7, billing_email = $8, notes = $9, updated_at = NOW()
+		WHERE id = $1 AND ($10 = 0 OR network_id = $10)`
+
+// Advertiser operations
+
+// ListAdvertisers returns all advertisers by name
+func (s *PostgresStore) ListAdvertisers(ctx context.Context, networkID int) ([]models.Advertiser, error) {
+	args := []interface{}{}
+	query := `SELECT ` + companyColumns + ` FROM advertisers WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY name, id`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var advertisers []models.Advertiser
+	for rows.Next() {
+		a, err := scanAdvertiser(rows)
+		if err != nil {
+			return nil, err
+		}
+		advertisers = append(advertisers, *a)
+	}
+	return advertisers, rows.Err()
+}
+
+// GetAdvertiser returns an advertiser by ID
+func (s *PostgresStore) GetAdvertiser(ctx context.Context, networkID, id int) (*models.Advertiser, error) {
+	a, err := scanAdvertiser(s.pool.QueryRow(ctx, `
+		SELECT `+companyColumns+` FROM advertisers WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// CreateAdvertiser creates an advertiser
+func (s *PostgresStore) CreateAdvertiser(ctx context.Context, networkID int, req *models.CompanyRequest) (*models.Advertiser, error) {
+	return scanAdvertiser(s.pool.QueryRow(ctx, `INSERT INTO advertisers `+companyInsert+` RETURNING `+companyColumns,
+		append([]interface{}{networkID}, companyArgs(req)...)...))
+}
+
+// UpdateAdvertiser replaces an advertiser's details
+func (s *PostgresStore) UpdateAdvertiser(ctx context.Context, networkID, id int, req *models.CompanyRequest) (*models.Advertiser, error) {
+	a, err := scanAdvertiser(s.pool.QueryRow(ctx, `UPDATE advertisers `+companyUpdate+` RETURNING `+companyColumns,
+		append(append([]interface{}{id}, companyArgs(req)...), networkID)...))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// DeleteAdvertiser deletes an advertiser
+func (s *PostgresStore) DeleteAdvertiser(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM advertisers WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// Agency operations
+
+// ListAgencies returns all agencies by name
+func (s *PostgresStore) ListAgencies(ctx context.Context, networkID int) ([]models.Agency, error) {
+	args := []interface{}{}
+	query := `SELECT ` + companyColumns + ` FROM agencies WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY name, id`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agencies []models.Agency
+	for rows.Next() {
+		a, err := scanAgency(rows)
+		if err != nil {
+			return nil, err
+		}
+		agencies = append(agencies, *a)
+	}
+	return agencies, rows.Err()
+}
+
+// GetAgency returns an agency by ID
+func (s *PostgresStore) GetAgency(ctx context.Context, networkID, id int) (*models.Agency, error) {
+	a, err := scanAgency(s.pool.QueryRow(ctx, `
+		SELECT `+companyColumns+` FROM agencies WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// CreateAgency creates an agency
+func (s *PostgresStore) CreateAgency(ctx context.Context, networkID int, req *models.CompanyRequest) (*models.Agency, error) {
+	return scanAgency(s.pool.QueryRow(ctx, `INSERT INTO agencies `+companyInsert+` RETURNING `+companyColumns,
+		append([]interface{}{networkID}, companyArgs(req)...)...))
+}
+
+// UpdateAgency replaces an agency's details
+func (s *PostgresStore) UpdateAgency(ctx context.Context, networkID, id int, req *models.CompanyRequest) (*models.Agency, error) {
+	a, err := scanAgency(s.pool.QueryRow(ctx, `UPDATE agencies `+companyUpdate+` RETURNING `+companyColumns,
+		append(append([]interface{}{id}, companyArgs(req)...), networkID)...))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// DeleteAgency deletes an agency
+func (s *PostgresStore) DeleteAgency(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM agencies WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// CountCompanyCampaigns returns how many campaigns are linked to an
+// advertiser or, with agency set, to an agency
+func (s *PostgresStore) CountCompanyCampaigns(ctx context.Context, id int, agency bool) (int, error) {
+	column := "advertiser_id"
+	if agency {
+		column = "agency_id"
+	}
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM campaigns WHERE `+column+` = $1`, id).Scan(&n)
+	return n, err
+}
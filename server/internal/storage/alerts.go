@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const alertColumns = `a.id, li.network_id, a.type, a.severity, a.status, a.line_item_id, li.name, a.creative_id, a.message, a.details, a.acknowledged_at, a.resolved_at, a.created_at, a.updated_at`
+
+const alertWebhookColumns = `id, network_id, name, url, alert_types, status, created_at, updated_at`
+
+func scanAlert(row pgx.Row, extra ...interface{}) (*models.Alert, error) {
+	var a models.Alert
+	var detailsJSON []byte
+	dest := []interface{}{&a.ID, &a.NetworkID, &a.Type, &a.Severity, &a.Status, &a.LineItemID, &a.LineItemName, &a.CreativeID, &a.Message, &detailsJSON, &a.AcknowledgedAt, &a.ResolvedAt, &a.CreatedAt, &a.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(detailsJSON, &a.Details)
+	return &a, nil
+}
+
+func scanAlertWebhook(row pgx.Row) (*models.AlertWebhook, error) {
+	var w models.AlertWebhook
+	if err := row.Scan(&w.ID, &w.NetworkID, &w.Name, &w.URL, &w.AlertTypes, &w.Status, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// ListAlerts returns a network's alerts, newest first, optionally only those
+// with a status or for a line item. limit 0 returns all.
+func (s *PostgresStore) ListAlerts(ctx context.Context, networkID int, status string, lineItemID, limit int) ([]models.Alert, error) {
+	args := []interface{}{}
+	query := `SELECT ` + alertColumns + ` FROM alerts a JOIN line_items li ON li.id = a.line_item_id WHERE true` + networkFilter(&args, "li.network_id", networkID)
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND a.status = $%d", len(args))
+	}
+	if lineItemID != 0 {
+		args = append(args, lineItemID)
+		query += fmt.Sprintf(" AND a.line_item_id = $%d", len(args))
+	}
+	query += " ORDER BY a.created_at DESC, a.id DESC"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, *a)
+	}
+	return alerts, rows.Err()
+}
+
+// GetAlert returns an alert by ID
+func (s *PostgresStore) GetAlert(ctx context.Context, networkID, id int) (*models.Alert, error) {
+	a, err := scanAlert(s.pool.QueryRow(ctx, `
+		SELECT `+alertColumns+` FROM alerts a JOIN line_items li ON li.id = a.line_item_id
+		WHERE a.id = $1 AND ($2 = 0 OR li.network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// RaiseAlert opens an alert, or updates the open alert of the same type,
+// line item and creative with the latest message and details. raised is
+// true if the alert is new.
+func (s *PostgresStore) RaiseAlert(ctx context.Context, alert *models.Alert) (a *models.Alert, raised bool, err error) {
+	detailsJSON, _ := json.Marshal(alert.Details)
+	a, err = scanAlert(s.pool.QueryRow(ctx, `
+		WITH a AS (
+			INSERT INTO alerts (type, severity, line_item_id, creative_id, message, details, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+			ON CONFLICT (type, line_item_id, creative_id) WHERE status = 'open'
+			DO UPDATE SET severity = EXCLUDED.severity, message = EXCLUDED.message, details = EXCLUDED.details, updated_at = NOW()
+			RETURNING *, (xmax = 0) AS inserted
+		)
+		SELECT `+alertColumns+`, a.inserted FROM a JOIN line_items li ON li.id = a.line_item_id
+	`, alert.Type, alert.Severity, alert.LineItemID, alert.CreativeID, alert.Message, detailsJSON), &raised)
+	return a, raised, err
+}
+
+// ResolveAlert marks an open alert resolved. It returns nil if the alert
+// isn't open.
+func (s *PostgresStore) ResolveAlert(ctx context.Context, id int) (*models.Alert, error) {
+	a, err := scanAlert(s.pool.QueryRow(ctx, `
+		WITH a AS (
+			UPDATE alerts SET status = 'resolved', resolved_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND status = 'open'
+			RETURNING *
+		)
+		SELECT `+alertColumns+` FROM a JOIN line_items li ON li.id = a.line_item_id
+	`, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// AcknowledgeAlert records that someone is looking at an alert. It stays
+// open until its condition clears.
+func (s *PostgresStore) AcknowledgeAlert(ctx context.Context, networkID, id int) (*models.Alert, error) {
+	a, err := scanAlert(s.pool.QueryRow(ctx, `
+		WITH a AS (
+			UPDATE alerts SET acknowledged_at = COALESCE(acknowledged_at, NOW())
+			WHERE id = $1 AND ($2 = 0 OR line_item_id IN (SELECT id FROM line_items WHERE network_id = $2))
+			RETURNING *
+		)
+		SELECT `+alertColumns+` FROM a JOIN line_items li ON li.id = a.line_item_id
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// ListAlertWebhooks returns a network's alert webhooks
+func (s *PostgresStore) ListAlertWebhooks(ctx context.Context, networkID int) ([]models.AlertWebhook, error) {
+	args := []interface{}{}
+	query := `SELECT ` + alertWebhookColumns + ` FROM alert_webhooks WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY name`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.AlertWebhook
+	for rows.Next() {
+		w, err := scanAlertWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetAlertWebhook returns an alert webhook by ID
+func (s *PostgresStore) GetAlertWebhook(ctx context.Context, networkID, id int) (*models.AlertWebhook, error) {
+	w, err := scanAlertWebhook(s.pool.QueryRow(ctx, `
+		SELECT `+alertWebhookColumns+` FROM alert_webhooks WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// CreateAlertWebhook creates an alert webhook
+func (s *PostgresStore) CreateAlertWebhook(ctx context.Context, networkID int, req *models.AlertWebhookRequest) (*models.AlertWebhook, error) {
+	alertTypes := req.AlertTypes
+	if alertTypes == nil {
+		alertTypes = []string{}
+	}
+	status := req.Status
+	if status == "" {
+		status = "active"
+	}
+
+	return scanAlertWebhook(s.pool.QueryRow(ctx, `
+		INSERT INTO alert_webhooks (network_id, name, url, alert_types, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+		RETURNING `+alertWebhookColumns,
+		networkID, req.Name, req.URL, alertTypes, status))
+}
+
+// UpdateAlertWebhook replaces an alert webhook
+func (s *PostgresStore) UpdateAlertWebhook(ctx context.Context, networkID, id int, req *models.AlertWebhookRequest) (*models.AlertWebhook, error) {
+	alertTypes := req.AlertTypes
+	if alertTypes == nil {
+		alertTypes = []string{}
+	}
+
+	w, err := scanAlertWebhook(s.pool.QueryRow(ctx, `
+		UPDATE alert_webhooks
+		SET name = $2, url = $3, alert_types = $4, status = COALESCE(NULLIF($5, ''), status), updated_at = NOW()
+		WHERE id = $1 AND ($6 = 0 OR network_id = $6)
+		RETURNING `+alertWebhookColumns,
+		id, req.Name, req.URL, alertTypes, req.Status, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// DeleteAlertWebhook deletes an alert webhook
+func (s *PostgresStore) DeleteAlertWebhook(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM alert_webhooks WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// LineItemHealth is the recent delivery of an active line item, as the
+// delivery monitor sees it. Windows are whole UTC hours before the current
+// hour; LastHours also covers the current hour so far.
+type LineItemHealth struct {
+	LineItemID      int
+	NetworkID       int
+	Name            string
+	ImpressionGoal  int
+	StartDate       *time.Time
+	EndDate         *time.Time
+	CreatedAt       time.Time
+	ActiveCreatives int
+	// HasDayparts is set for line items that only serve at some hours
+	HasDayparts bool
+	// LastHours is impressions over the last two hours and the current hour
+	LastHours int
+	// Recent is impressions over the last three hours; Baseline over the
+	// same three hours a day earlier
+	Recent   int
+	Baseline int
+	// Day is the last 24 hours; Week the 7 days before that
+	DayImpressions  int
+	DayClicks       int
+	WeekImpressions int
+	WeekClicks      int
+}
+
+// GetLineItemHealth returns the recent delivery of every active, in-flight
+// standard line item of an active campaign
+func (s *PostgresStore) GetLineItemHealth(ctx context.Context, now time.Time) ([]LineItemHealth, error) {
+	hour := now.Truncate(time.Hour)
+	var args []interface{}
+	query := factsCTE(&args, 0, hour.Add(-8*24*time.Hour), hour.Add(time.Hour))
+	// $4 now, then the window bounds
+	args = append(args, now, hour.Add(-2*time.Hour), hour.Add(-3*time.Hour), hour, hour.Add(-27*time.Hour), hour.Add(-24*time.Hour))
+	query += `
+		SELECT
+			li.id, li.network_id, li.name, li.impression_goal, li.start_date, li.end_date, li.created_at,
+			(SELECT COUNT(*) FROM creatives cr WHERE cr.line_item_id = li.id AND cr.status = 'active'),
+			EXISTS (SELECT 1 FROM line_item_dayparts d WHERE d.line_item_id = li.id),
+			COALESCE(SUM(f.impressions) FILTER (WHERE f.hour >= $5), 0),
+			COALESCE(SUM(f.impressions) FILTER (WHERE f.hour >= $6 AND f.hour < $7), 0),
+			COALESCE(SUM(f.impressions) FILTER (WHERE f.hour >= $8 AND f.hour < $9), 0),
+			COALESCE(SUM(f.impressions) FILTER (WHERE f.hour >= $9 AND f.hour < $7), 0),
+			COALESCE(SUM(f.clicks) FILTER (WHERE f.hour >= $9 AND f.hour < $7), 0),
+			COALESCE(SUM(f.impressions) FILTER (WHERE f.hour < $9), 0),
+			COALESCE(SUM(f.clicks) FILTER (WHERE f.hour < $9), 0)
+		FROM line_items li
+		JOIN campaigns c ON c.id = li.campaign_id
+		LEFT JOIN facts f ON f.line_item_id = li.id
+		WHERE li.status = 'active' AND c.status = 'active' AND li.line_type <> 'house'
+		  AND (li.start_date IS NULL OR li.start_date <= $4)
+		  AND (li.end_date IS NULL OR li.end_date > $4)
+		GROUP BY li.id
+		ORDER BY li.id`
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var health []LineItemHealth
+	for rows.Next() {
+		var h LineItemHealth
+		if err := rows.Scan(&h.LineItemID, &h.NetworkID, &h.Name, &h.ImpressionGoal, &h.StartDate, &h.EndDate, &h.CreatedAt, &h.ActiveCreatives, &h.HasDayparts,
+			&h.LastHours, &h.Recent, &h.Baseline, &h.DayImpressions, &h.DayClicks, &h.WeekImpressions, &h.WeekClicks); err != nil {
+			return nil, err
+		}
+		health = append(health, h)
+	}
+	return health, rows.Err()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const apiKeyColumns = `k.id, k.network_id, k.name, k.prefix, k.scopes, k.created_by, k.last_used_at, k.expires_at, k.revoked_at, k.created_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := row.Scan(&k.ID, &k.NetworkID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedBy, &k.LastUsedAt, &k.ExpiresAt, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListAPIKeys returns a network's API keys, revoked ones included, newest
+// first
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, networkID int) ([]models.APIKey, error) {
+	args := []interface{}{}
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys k WHERE true` + networkFilter(&args, "k.network_id", networkID) + ` ORDER BY k.created_at DESC`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateAPIKey stores an API key of a network under its hash
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, networkID int, req *models.CreateAPIKeyRequest, prefix, keyHash string, createdBy *int) (*models.APIKey, error) {
+	return scanAPIKey(s.pool.QueryRow(ctx, `
+		INSERT INTO api_keys AS k (network_id, name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		RETURNING `+apiKeyColumns,
+		networkID, req.Name, prefix, keyHash, req.Scopes, createdBy, req.ExpiresAt))
+}
+
+// RevokeAPIKey revokes an API key. The key stays listed, with the time it
+// was revoked.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, networkID, id int) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.pool.QueryRow(ctx, `
+		UPDATE api_keys AS k SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+		RETURNING `+apiKeyColumns, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// GetAPIKeyByHash returns the API key a key hash belongs to, or nil if it
+// doesn't exist or its network is inactive. Revoked and expired keys are
+// returned; APIKey.Usable tells them apart.
+func (s *PostgresStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys k
+		JOIN networks n ON n.id = k.network_id
+		WHERE k.key_hash = $1 AND n.status = 'active'
+	`, keyHash))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// TouchAPIKey records that an API key was used
+func (s *PostgresStore) TouchAPIKey(ctx context.Context, id int) error {
+	_, err := s.pool.Exec(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const auditColumns = `id, network_id, actor_type, actor_id, actor_name, entity_type, entity_id, action, changes, request_id, created_at`
+
+func scanAuditEntry(row pgx.Row) (*models.AuditEntry, error) {
+	var e models.AuditEntry
+	var changesJSON []byte
+	if err := row.Scan(&e.ID, &e.NetworkID, &e.ActorType, &e.ActorID, &e.ActorName, &e.EntityType, &e.EntityID, &e.Action, &changesJSON, &e.RequestID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(changesJSON, &e.Changes)
+	return &e, nil
+}
+
+// AuditFilter narrows the audit log. Empty fields match every entry.
+type AuditFilter struct {
+	NetworkID  int
+	EntityType string
+	EntityID   string
+	ActorType  string
+	ActorID    int
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	// BeforeID pages back through the log: only older entries are returned
+	BeforeID int64
+	Limit    int
+}
+
+// CreateAuditEntry appends an entry to the audit log
+func (s *PostgresStore) CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	changesJSON, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO audit_log (network_id, actor_type, actor_id, actor_name, entity_type, entity_id, action, changes, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+	`, e.NetworkID, e.ActorType, e.ActorID, e.ActorName, e.EntityType, e.EntityID, e.Action, changesJSON, e.RequestID)
+	return err
+}
+
+// ListAuditEntries returns audit entries matching a filter, newest first
+func (s *PostgresStore) ListAuditEntries(ctx context.Context, f *AuditFilter) ([]models.AuditEntry, error) {
+	args := []interface{}{}
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE true`
+	where := func(cond string, v interface{}) {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND "+cond, len(args))
+	}
+	if f.NetworkID != 0 {
+		where("network_id = $%d", f.NetworkID)
+	}
+	if f.EntityType != "" {
+		where("entity_type = $%d", f.EntityType)
+	}
+	if f.EntityID != "" {
+		where("entity_id = $%d", f.EntityID)
+	}
+	if f.ActorType != "" {
+		where("actor_type = $%d", f.ActorType)
+	}
+	if f.ActorID != 0 {
+		where("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		where("action = $%d", f.Action)
+	}
+	if !f.Since.IsZero() {
+		where("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		where("created_at < $%d", f.Until)
+	}
+	if f.BeforeID != 0 {
+		where("id < $%d", f.BeforeID)
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
@@ -3,24 +3,47 @@ package storage
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/mims/ad-manager/internal/inventory"
 	"github.com/mims/ad-manager/internal/models"
 )
 
-// InMemoryCache provides in-memory caching for active campaigns
+// creativeStatsWindow is how much past delivery CTR-optimized rotation looks at
+const creativeStatsWindow = 7 * 24 * time.Hour
+
+// InMemoryCache provides in-memory caching for active campaigns, keyed by
+// network
 type InMemoryCache struct {
-	mu           sync.RWMutex
-	lineItems    []models.LineItem
-	adUnits      []models.AdUnit
-	adUnitByCode map[string]models.AdUnit
+	mu             sync.RWMutex
+	lineItems      map[int][]models.LineItem
+	adUnitByCode   map[int]map[string]models.AdUnit
+	adUnitNetworks map[string][]int // ad unit code -> networks that have it
+	networkByCode  map[string]int   // active network code -> network ID
+	// network ID -> its timezone, the dayparting default of its campaigns
+	networkTimezones map[int]string
+	// ad unit ID -> IDs of the placements that contain it
+	adUnitPlacements map[int][]int
+	// creative ID -> recent delivery, refreshed on its own schedule
+	creativeStats map[int]CreativeStats
+	// Every line item's network and every creative's line item, including
+	// inactive ones, so late tracking events still resolve
+	lineItemNetworks  map[int]int
+	creativeLineItems map[int]int
 }
 
 // NewInMemoryCache creates a new InMemoryCache
 func NewInMemoryCache() *InMemoryCache {
 	return &InMemoryCache{
-		lineItems:    make([]models.LineItem, 0),
-		adUnits:      make([]models.AdUnit, 0),
-		adUnitByCode: make(map[string]models.AdUnit),
+		lineItems:         make(map[int][]models.LineItem),
+		adUnitByCode:      make(map[int]map[string]models.AdUnit),
+		adUnitNetworks:    make(map[string][]int),
+		networkByCode:     make(map[string]int),
+		networkTimezones:  make(map[int]string),
+		adUnitPlacements:  make(map[int][]int),
+		creativeStats:     make(map[int]CreativeStats),
+		lineItemNetworks:  make(map[int]int),
+		creativeLineItems: make(map[int]int),
 	}
 }
 
@@ -31,31 +54,130 @@ func (c *InMemoryCache) LoadCampaigns(ctx context.Context, store *PostgresStore)
 		return err
 	}
 
-	// Load ad units
-	adUnits, err := store.ListAdUnits(ctx)
+	// Load ad units and networks
+	adUnits, err := store.ListAdUnits(ctx, 0)
+	if err != nil {
+		return err
+	}
+	networks, err := store.ListNetworks(ctx)
+	if err != nil {
+		return err
+	}
+	placements, err := store.ListPlacements(ctx, 0)
+	if err != nil {
+		return err
+	}
+	lineItemNetworks, creativeLineItems, err := store.GetLineItemOwners(ctx)
 	if err != nil {
 		return err
 	}
 
+	// Resolve ad unit targeting through each network's ad unit tree, so
+	// serving only has to look the slot's ad unit up
+	unitsByNetwork := make(map[int][]models.AdUnit)
+	for _, au := range adUnits {
+		unitsByNetwork[au.NetworkID] = append(unitsByNetwork[au.NetworkID], au)
+	}
+	trees := make(map[int]*inventory.Tree)
+	for networkID, units := range unitsByNetwork {
+		trees[networkID] = inventory.NewTree(units)
+	}
+
+	lineItems := make(map[int][]models.LineItem)
+	for _, li := range items {
+		if tree := trees[li.NetworkID]; tree != nil {
+			li.AdUnitIDs, li.ExcludedAdUnitIDs = tree.Resolve(li.AdUnitTargets)
+		}
+		lineItems[li.NetworkID] = append(lineItems[li.NetworkID], li)
+	}
+	networkByCode := make(map[string]int)
+	networkTimezones := make(map[int]string)
+	for _, n := range networks {
+		if n.Status == "active" {
+			networkByCode[n.Code] = n.ID
+		}
+		networkTimezones[n.ID] = n.Timezone
+	}
+	adUnitByCode := make(map[int]map[string]models.AdUnit)
+	adUnitNetworks := make(map[string][]int)
+	for _, au := range adUnits {
+		if adUnitByCode[au.NetworkID] == nil {
+			adUnitByCode[au.NetworkID] = make(map[string]models.AdUnit)
+		}
+		adUnitByCode[au.NetworkID][au.Code] = au
+		adUnitNetworks[au.Code] = append(adUnitNetworks[au.Code], au.NetworkID)
+	}
+	adUnitPlacements := make(map[int][]int)
+	for _, p := range placements {
+		for _, id := range p.AdUnitIDs {
+			adUnitPlacements[id] = append(adUnitPlacements[id], p.ID)
+		}
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.lineItems = items
-	c.adUnits = adUnits
-	c.adUnitByCode = make(map[string]models.AdUnit)
-	for _, au := range adUnits {
-		c.adUnitByCode[au.Code] = au
+	// Attach the last loaded creative stats for CTR-optimized rotation
+	for networkID, items := range lineItems {
+		lineItems[networkID] = withCreativeStats(items, c.creativeStats)
+	}
+	c.lineItems = lineItems
+	c.adUnitByCode = adUnitByCode
+	c.adUnitNetworks = adUnitNetworks
+	c.networkByCode = networkByCode
+	c.networkTimezones = networkTimezones
+	c.adUnitPlacements = adUnitPlacements
+	c.lineItemNetworks = lineItemNetworks
+	c.creativeLineItems = creativeLineItems
+	return nil
+}
+
+// RefreshCreativeStats reloads the recent delivery of every creative used by
+// CTR-optimized rotation and attaches it to the cached line items. On error
+// the previous stats are kept.
+func (c *InMemoryCache) RefreshCreativeStats(ctx context.Context, store *PostgresStore) error {
+	stats, err := store.GetCreativeStats(ctx, time.Now().Add(-creativeStatsWindow))
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creativeStats = stats
+	lineItems := make(map[int][]models.LineItem, len(c.lineItems))
+	for networkID, items := range c.lineItems {
+		lineItems[networkID] = withCreativeStats(items, stats)
 	}
+	c.lineItems = lineItems
 	return nil
 }
 
-// GetActiveLineItems returns all cached active line items
-func (c *InMemoryCache) GetActiveLineItems() []models.LineItem {
+// withCreativeStats returns copies of the line items with the stats set on
+// their creatives. The originals are left alone, as readers may hold them.
+func withCreativeStats(items []models.LineItem, stats map[int]CreativeStats) []models.LineItem {
+	result := make([]models.LineItem, len(items))
+	for i, li := range items {
+		creatives := make([]models.Creative, len(li.Creatives))
+		for j, cr := range li.Creatives {
+			cs := stats[cr.ID]
+			cr.Impressions = cs.Impressions
+			cr.Clicks = cs.Clicks
+			creatives[j] = cr
+		}
+		li.Creatives = creatives
+		result[i] = li
+	}
+	return result
+}
+
+// GetActiveLineItems returns the cached active line items of a network
+func (c *InMemoryCache) GetActiveLineItems(networkID int) []models.LineItem {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// Return a copy to avoid race conditions
-	result := make([]models.LineItem, len(c.lineItems))
-	copy(result, c.lineItems)
+	items := c.lineItems[networkID]
+	result := make([]models.LineItem, len(items))
+	copy(result, items)
 	return result
 }
 
@@ -64,13 +186,66 @@ func (c *InMemoryCache) Refresh(ctx context.Context, store *PostgresStore) error
 	return c.LoadCampaigns(ctx, store)
 }
 
-// GetAdUnitByCode returns an ad unit by its code
-func (c *InMemoryCache) GetAdUnitByCode(code string) *models.AdUnit {
+// GetAdUnitByCode returns a network's ad unit by its code
+func (c *InMemoryCache) GetAdUnitByCode(networkID int, code string) *models.AdUnit {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if au, ok := c.adUnitByCode[code]; ok {
+	if au, ok := c.adUnitByCode[networkID][code]; ok {
 		return &au
 	}
 	return nil
 }
+
+// GetNetworkByCode returns the ID of the active network with the given code
+// (publisher ID)
+func (c *InMemoryCache) GetNetworkByCode(code string) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	id, ok := c.networkByCode[code]
+	return id, ok
+}
+
+// GetNetworkTimezone returns a network's timezone, or "" if the network
+// isn't cached
+func (c *InMemoryCache) GetNetworkTimezone(networkID int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.networkTimezones[networkID]
+}
+
+// AdUnitNetworks returns the networks that have an ad unit with the given code
+func (c *InMemoryCache) AdUnitNetworks(code string) []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]int(nil), c.adUnitNetworks[code]...)
+}
+
+// GetTrackingNetwork returns the network of a tracking event's line item, or
+// false if the line item doesn't exist or the creative, when given, isn't
+// one of its creatives
+func (c *InMemoryCache) GetTrackingNetwork(lineItemID, creativeID int) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	networkID, ok := c.lineItemNetworks[lineItemID]
+	if !ok {
+		return 0, false
+	}
+	if creativeID != 0 && c.creativeLineItems[creativeID] != lineItemID {
+		return 0, false
+	}
+	return networkID, true
+}
+
+// GetAdUnitPlacements returns the IDs of the placements that contain an ad
+// unit
+func (c *InMemoryCache) GetAdUnitPlacements(adUnitID int) []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]int(nil), c.adUnitPlacements[adUnitID]...)
+}
@@ -0,0 +1,518 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// clickHouseTimeFormat is how DateTime query parameters are sent
+const clickHouseTimeFormat = "2006-01-02 15:04:05"
+
+// ClickHouseStore writes events to ClickHouse and serves the delivery
+// reports from it, over the ClickHouse HTTP interface. The schema is in
+// clickhouse/init.sql: a MergeTree events table and the events_hourly
+// SummingMergeTree table fed by a materialized view, which the reports read.
+//
+// Campaign, line item and creative metadata still lives in Postgres, so the
+// reports look names up through meta.
+type ClickHouseStore struct {
+	baseURL  string
+	database string
+	user     string
+	password string
+	client   *http.Client
+	meta     *PostgresStore
+}
+
+// NewClickHouseStore creates a new ClickHouseStore for the server at baseURL
+// (e.g. http://localhost:8123)
+func NewClickHouseStore(baseURL, database, user, password string, meta *PostgresStore) *ClickHouseStore {
+	return &ClickHouseStore{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		database: database,
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		meta:     meta,
+	}
+}
+
+// Ping checks that ClickHouse is reachable and the events table exists
+func (s *ClickHouseStore) Ping(ctx context.Context) error {
+	return s.query(ctx, `SELECT count() AS n FROM events WHERE 0`, nil, func(dec *json.Decoder) error {
+		var row struct{}
+		return dec.Decode(&row)
+	})
+}
+
+// chEvent is an event row as inserted into ClickHouse
+type chEvent struct {
+	NetworkID    int               `json:"network_id"`
+	EventType    string            `json:"event_type"`
+	ImpressionID string            `json:"impression_id"`
+	LineItemID   int               `json:"line_item_id"`
+	CreativeID   int               `json:"creative_id"`
+	UserID       string            `json:"user_id"`
+	Country      string            `json:"country"`
+	Platform     string            `json:"platform"`
+	AdUnit       string            `json:"ad_unit"`
+	Section      string            `json:"section"`
+	Targeting    map[string]string `json:"targeting"`
+	CreatedAt    string            `json:"created_at"`
+}
+
+// RecordEvent records a tracking event. It uses an asynchronous insert, so
+// ClickHouse batches single-row inserts server-side instead of creating a
+// part per event.
+func (s *ClickHouseStore) RecordEvent(ctx context.Context, event *models.Event) error {
+	createdAt := event.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	row, err := json.Marshal(chEvent{
+		NetworkID:    event.NetworkID,
+		EventType:    event.EventType,
+		ImpressionID: event.ImpressionID,
+		LineItemID:   event.LineItemID,
+		CreativeID:   event.CreativeID,
+		UserID:       event.UserID,
+		Country:      event.Country,
+		Platform:     event.Platform,
+		AdUnit:       event.AdUnit,
+		Section:      event.Section,
+		Targeting:    event.Targeting,
+		CreatedAt:    createdAt.UTC().Format(clickHouseTimeFormat),
+	})
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("async_insert", "1")
+	params.Set("wait_for_async_insert", "0")
+	resp, err := s.do(ctx, `INSERT INTO events FORMAT JSONEachRow`, params, strings.NewReader(string(row)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a statement to ClickHouse. Statements with a body (inserts) are
+// passed in the query string; otherwise the statement is the body.
+func (s *ClickHouseStore) do(ctx context.Context, statement string, params url.Values, body io.Reader) (*http.Response, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("database", s.database)
+	params.Set("output_format_json_quote_64bit_integers", "0")
+	if body == nil {
+		body = strings.NewReader(statement)
+	} else {
+		params.Set("query", statement)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/?"+params.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+	}
+	if s.password != "" {
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// query runs a SELECT with FORMAT JSONEachRow and calls scan for each row.
+// Values are bound through ClickHouse query parameters ({name:Type} in the
+// statement, param_name in params), never interpolated.
+func (s *ClickHouseStore) query(ctx context.Context, statement string, params url.Values, scan func(dec *json.Decoder) error) error {
+	resp, err := s.do(ctx, statement+"\nFORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		if err := scan(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeParams binds the reported network to {network} and the date range to
+// {start} and {end}
+func rangeParams(networkID int, startDate, endDate time.Time) url.Values {
+	params := url.Values{}
+	params.Set("param_network", strconv.Itoa(networkID))
+	params.Set("param_start", startDate.UTC().Format(clickHouseTimeFormat))
+	params.Set("param_end", endDate.UTC().Format(clickHouseTimeFormat))
+	return params
+}
+
+// idArray formats IDs as a ClickHouse Array(UInt32) parameter
+func idArray(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// campaignLineItemIDs returns the IDs of the campaign's line items
+func (s *ClickHouseStore) campaignLineItemIDs(ctx context.Context, networkID, campaignID int) ([]int, error) {
+	names, err := s.meta.GetLineItemNames(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for id, n := range names {
+		if n.CampaignID == campaignID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// advertiserFilter returns the condition restricting a report to the line
+// items of an advertiser's campaigns, binding their IDs to params, or "" when
+// advertiserID is 0
+func (s *ClickHouseStore) advertiserFilter(ctx context.Context, params url.Values, networkID, advertiserID int) (string, error) {
+	if advertiserID <= 0 {
+		return "", nil
+	}
+	names, err := s.meta.GetLineItemNames(ctx, networkID)
+	if err != nil {
+		return "", err
+	}
+	var ids []int
+	for id, n := range names {
+		if n.AdvertiserID == advertiserID {
+			ids = append(ids, id)
+		}
+	}
+	params.Set("param_advertiser_line_items", idArray(ids))
+	return ` AND line_item_id IN {advertiser_line_items:Array(UInt32)}`, nil
+}
+
+// chCounts is the metric part of a report row
+type chCounts struct {
+	Impressions int `json:"impressions"`
+	Clicks      int `json:"clicks"`
+	Viewable    int `json:"viewable"`
+}
+
+const (
+	hourlyRange = `hour >= {start:DateTime('UTC')} AND hour < {end:DateTime('UTC')}
+		AND ({network:UInt32} = 0 OR network_id = {network:UInt32})`
+	countSums = `sum(impressions) AS impressions, sum(clicks) AS clicks, sum(viewable) AS viewable`
+)
+
+// rawCounts is the raw events table shaped like events_hourly, with each
+// event's exact time in hour
+const rawCounts = `(
+	SELECT created_at AS hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform,
+		toUInt64(event_type = 'impression') AS impressions, toUInt64(event_type = 'click') AS clicks,
+		toUInt64(event_type = 'viewable') AS viewable
+	FROM events
+)`
+
+// countsTable returns what the reports count from: the hourly rollup, or raw
+// events when the range does not start and end on a whole UTC hour (days in
+// a zone with a half-hour offset), since rollup hours cannot be split
+func countsTable(startDate, endDate time.Time) string {
+	if !onHour(startDate) || !onHour(endDate) {
+		return rawCounts
+	}
+	return "events_hourly"
+}
+
+// GetReportSummary returns overall stats, optionally only for an
+// advertiser's line items
+func (s *ClickHouseStore) GetReportSummary(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, advertiserID int) (*ReportSummary, error) {
+	params := rangeParams(networkID, startDate, endDate)
+	statement := `SELECT ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange
+	if adUnit != "" {
+		statement += ` AND ad_unit = {ad_unit:String}`
+		params.Set("param_ad_unit", adUnit)
+	}
+	filter, err := s.advertiserFilter(ctx, params, networkID, advertiserID)
+	if err != nil {
+		return nil, err
+	}
+	statement += filter
+
+	var summary ReportSummary
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row chCounts
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		summary.TotalImpressions = row.Impressions
+		summary.TotalClicks = row.Clicks
+		summary.TotalViewable = row.Viewable
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if summary.TotalImpressions > 0 {
+		summary.CTR = float64(summary.TotalClicks) / float64(summary.TotalImpressions) * 100
+		summary.ViewabilityRate = float64(summary.TotalViewable) / float64(summary.TotalImpressions) * 100
+	}
+
+	return &summary, nil
+}
+
+// GetDailyReport returns daily stats, with days in the given timezone,
+// optionally only for an advertiser's line items
+func (s *ClickHouseStore) GetDailyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, adUnit string, advertiserID int) ([]DailyStats, error) {
+	params := rangeParams(networkID, startDate, endDate)
+	params.Set("param_tz", timezone)
+	statement := `SELECT toString(toDate(hour, {tz:String})) AS date, ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange
+	if adUnit != "" {
+		statement += ` AND ad_unit = {ad_unit:String}`
+		params.Set("param_ad_unit", adUnit)
+	}
+	filter, err := s.advertiserFilter(ctx, params, networkID, advertiserID)
+	if err != nil {
+		return nil, err
+	}
+	statement += filter + ` GROUP BY date ORDER BY date DESC`
+
+	var stats []DailyStats
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row DailyStats
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		stats = append(stats, row)
+		return nil
+	})
+	return stats, err
+}
+
+// GetCampaignReport returns stats for a specific campaign
+func (s *ClickHouseStore) GetCampaignReport(ctx context.Context, networkID, campaignID int, startDate, endDate time.Time) (*CampaignReport, error) {
+	campaign, err := s.meta.GetCampaign(ctx, networkID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil {
+		return nil, nil
+	}
+	report := CampaignReport{CampaignID: campaignID, CampaignName: campaign.Name}
+
+	ids, err := s.campaignLineItemIDs(ctx, networkID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return &report, nil
+	}
+
+	params := rangeParams(networkID, startDate, endDate)
+	params.Set("param_line_items", idArray(ids))
+	statement := `SELECT ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange + ` AND line_item_id IN {line_items:Array(UInt32)}`
+
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row chCounts
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		report.Impressions = row.Impressions
+		report.Clicks = row.Clicks
+		report.Viewable = row.Viewable
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if report.Impressions > 0 {
+		report.CTR = float64(report.Clicks) / float64(report.Impressions) * 100
+	}
+
+	return &report, nil
+}
+
+// GetHourlyReport returns stats grouped by hour of day in the given timezone,
+// optionally restricted to an advertiser, campaign or line item
+func (s *ClickHouseStore) GetHourlyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, campaignID, lineItemID int, adUnit string, advertiserID int) ([]HourlyStats, error) {
+	params := rangeParams(networkID, startDate, endDate)
+	params.Set("param_tz", timezone)
+	statement := `SELECT toHour(toTimeZone(hour, {tz:String})) AS hour_of_day, ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange
+
+	if campaignID > 0 {
+		ids, err := s.campaignLineItemIDs(ctx, networkID, campaignID)
+		if err != nil {
+			return nil, err
+		}
+		statement += ` AND line_item_id IN {line_items:Array(UInt32)}`
+		params.Set("param_line_items", idArray(ids))
+	}
+	if lineItemID > 0 {
+		statement += ` AND line_item_id = {line_item:UInt32}`
+		params.Set("param_line_item", strconv.Itoa(lineItemID))
+	}
+	if adUnit != "" {
+		statement += ` AND ad_unit = {ad_unit:String}`
+		params.Set("param_ad_unit", adUnit)
+	}
+	filter, err := s.advertiserFilter(ctx, params, networkID, advertiserID)
+	if err != nil {
+		return nil, err
+	}
+	statement += filter + ` GROUP BY hour_of_day ORDER BY hour_of_day`
+
+	var stats []HourlyStats
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row struct {
+			HourOfDay int `json:"hour_of_day"`
+			chCounts
+		}
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		hs := HourlyStats{Hour: row.HourOfDay, Impressions: row.Impressions, Clicks: row.Clicks, Viewable: row.Viewable}
+		if hs.Impressions > 0 {
+			hs.CTR = float64(hs.Clicks) / float64(hs.Impressions) * 100
+		}
+		stats = append(stats, hs)
+		return nil
+	})
+	return stats, err
+}
+
+// GetKeyValueReport returns stats grouped by a specific key, optionally only
+// for an advertiser's line items
+func (s *ClickHouseStore) GetKeyValueReport(ctx context.Context, networkID int, key string, startDate, endDate time.Time, adUnit string, advertiserID int) ([]KeyValueStats, error) {
+	columnName := keyValueColumn(key)
+
+	params := rangeParams(networkID, startDate, endDate)
+	statement := `SELECT if(` + columnName + ` = '', 'unknown', ` + columnName + `) AS value, ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange
+	if adUnit != "" {
+		statement += ` AND ad_unit = {ad_unit:String}`
+		params.Set("param_ad_unit", adUnit)
+	}
+	filter, err := s.advertiserFilter(ctx, params, networkID, advertiserID)
+	if err != nil {
+		return nil, err
+	}
+	statement += filter + ` GROUP BY value ORDER BY impressions DESC`
+
+	var stats []KeyValueStats
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row struct {
+			Value string `json:"value"`
+			chCounts
+		}
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		kv := KeyValueStats{Key: key, Value: row.Value, Impressions: row.Impressions, Clicks: row.Clicks, Viewable: row.Viewable}
+		if kv.Impressions > 0 {
+			kv.CTR = float64(kv.Clicks) / float64(kv.Impressions) * 100
+		}
+		stats = append(stats, kv)
+		return nil
+	})
+	return stats, err
+}
+
+// GetLineItemReport returns stats grouped by line item, optionally only for
+// an advertiser's line items. Like the Postgres report, every line item is
+// listed, including those without delivery.
+func (s *ClickHouseStore) GetLineItemReport(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, creativeSize string, advertiserID int) ([]LineItemStats, error) {
+	names, err := s.meta.GetLineItemNames(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := rangeParams(networkID, startDate, endDate)
+	statement := `SELECT line_item_id, ` + countSums + ` FROM ` + countsTable(startDate, endDate) + ` WHERE ` + hourlyRange
+	if adUnit != "" {
+		statement += ` AND ad_unit = {ad_unit:String}`
+		params.Set("param_ad_unit", adUnit)
+	}
+	if w, h, ok := parseCreativeSize(creativeSize); ok {
+		ids, err := s.meta.GetCreativeIDsBySize(ctx, networkID, w, h)
+		if err != nil {
+			return nil, err
+		}
+		statement += ` AND creative_id IN {creatives:Array(UInt32)}`
+		params.Set("param_creatives", idArray(ids))
+	}
+	statement += ` GROUP BY line_item_id`
+
+	counts := make(map[int]chCounts)
+	err = s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		var row struct {
+			LineItemID int `json:"line_item_id"`
+			chCounts
+		}
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		counts[row.LineItemID] = row.chCounts
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]LineItemStats, 0, len(names))
+	for id, n := range names {
+		if advertiserID > 0 && n.AdvertiserID != advertiserID {
+			continue
+		}
+		c := counts[id]
+		ls := LineItemStats{
+			LineItemID:     id,
+			LineItemName:   n.Name,
+			CampaignName:   n.CampaignName,
+			AdvertiserID:   n.AdvertiserID,
+			AdvertiserName: n.AdvertiserName,
+			Impressions:    c.Impressions,
+			Clicks:         c.Clicks,
+			Viewable:       c.Viewable,
+		}
+		if ls.Impressions > 0 {
+			ls.CTR = float64(ls.Clicks) / float64(ls.Impressions) * 100
+		}
+		stats = append(stats, ls)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Impressions != stats[j].Impressions {
+			return stats[i].Impressions > stats[j].Impressions
+		}
+		return stats[i].LineItemID < stats[j].LineItemID
+	})
+	return stats, nil
+}
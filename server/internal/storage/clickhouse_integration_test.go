@@ -0,0 +1,153 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// newClickHouseTestStore creates a scratch database from clickhouse/init.sql
+// on the server at CLICKHOUSE_URL and returns a store over it. The test is
+// skipped when CLICKHOUSE_URL is not set.
+func newClickHouseTestStore(t *testing.T) *ClickHouseStore {
+	t.Helper()
+	baseURL := os.Getenv("CLICKHOUSE_URL")
+	if baseURL == "" {
+		t.Skip("CLICKHOUSE_URL is not set")
+	}
+	user, password := os.Getenv("CLICKHOUSE_USER"), os.Getenv("CLICKHOUSE_PASSWORD")
+	ctx := context.Background()
+
+	schema, err := os.ReadFile("../../clickhouse/init.sql")
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	database := fmt.Sprintf("mimsads_test_%d", time.Now().UnixNano())
+	admin := NewClickHouseStore(baseURL, "default", user, password, nil)
+	exec := func(statement string) {
+		resp, err := admin.do(ctx, statement, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+		resp.Body.Close()
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(schema), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		statement = strings.TrimSpace(strings.ReplaceAll(statement, "mimsads", database))
+		if statement != "" {
+			exec(statement)
+		}
+	}
+	t.Cleanup(func() { exec("DROP DATABASE IF EXISTS " + database) })
+
+	return NewClickHouseStore(baseURL, database, user, password, nil)
+}
+
+// insertEvents inserts events synchronously, so they and their hourly counts
+// can be read back at once
+func insertEvents(t *testing.T, s *ClickHouseStore, events []chEvent) {
+	t.Helper()
+	var rows []string
+	for _, e := range events {
+		row, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, string(row))
+	}
+	resp, err := s.do(context.Background(), `INSERT INTO events FORMAT JSONEachRow`, nil, strings.NewReader(strings.Join(rows, "\n")))
+	if err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClickHouseReportQuery(t *testing.T) {
+	s := newClickHouseTestStore(t)
+
+	event := func(eventType, createdAt, adUnit, country, userID, brand string) chEvent {
+		return chEvent{
+			NetworkID: 3, EventType: eventType, LineItemID: 7, CreativeID: 9, UserID: userID,
+			AdUnit: adUnit, Country: country, Targeting: map[string]string{"brand": brand}, CreatedAt: createdAt,
+		}
+	}
+	insertEvents(t, s, []chEvent{
+		event("impression", "2026-03-02 10:15:00", "home_top", "US", "u1", "acme"),
+		event("impression", "2026-03-02 10:20:00", "home_top", "US", "u2", "acme"),
+		event("click", "2026-03-02 10:21:00", "home_top", "US", "u2", "acme"),
+		event("impression", "2026-03-03 08:00:00", "article", "CA", "u1", "zen"),
+		// Another network's event is never counted
+		{NetworkID: 4, EventType: "impression", LineItemID: 7, CreativeID: 9, AdUnit: "home_top", Country: "US", CreatedAt: "2026-03-02 10:30:00"},
+	})
+
+	tests := []struct {
+		name string
+		req  models.ReportQueryRequest
+		want string
+	}{
+		{
+			name: "line items by date",
+			req:  models.ReportQueryRequest{Dimensions: []string{"date", "line_item"}, Metrics: []string{"impressions", "clicks"}},
+			want: "[[2026-03-02 7 Spring 2 1] [2026-03-03 7 Spring 1 0]]",
+		},
+		{
+			name: "advertiser and size",
+			req:  models.ReportQueryRequest{Dimensions: []string{"advertiser", "size"}, Metrics: []string{"impressions", "ctr"}},
+			want: "[[2 Acme 300x250 3 33.333333333333336]]",
+		},
+		{
+			name: "placements",
+			req:  models.ReportQueryRequest{Dimensions: []string{"placement"}, Metrics: []string{"impressions"}},
+			want: "[[5 Homepage 2]]",
+		},
+		{
+			name: "outside a placement",
+			req: models.ReportQueryRequest{
+				Dimensions: []string{"country"},
+				Metrics:    []string{"impressions"},
+				Filters:    []models.ReportFilter{{Dimension: "placement", Operator: "NOT_IN", Values: []string{"5"}}},
+			},
+			want: "[[CA 1]]",
+		},
+		{
+			name: "key and unique users",
+			req: models.ReportQueryRequest{
+				Dimensions: []string{"key:brand"},
+				Metrics:    []string{"impressions", "unique_users"},
+				Filters:    []models.ReportFilter{{Dimension: "country", Values: []string{"US"}}},
+			},
+			want: "[[acme 2 2]]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := reportQuery(t, tt.req)
+			var rows [][]interface{}
+			err := s.streamReportQuery(context.Background(), q, &fakeNames{}, func(values []interface{}) error {
+				rows = append(rows, values)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("streamReportQuery: %v", err)
+			}
+			if got := fmt.Sprint(rows); got != tt.want {
+				t.Errorf("rows %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// chDimensionSelects are the select expressions of each dimension. ClickHouse
+// has no campaign or creative metadata, so names, advertiser, agency and
+// campaign IDs and sizes are mapped from the line item and creative IDs with transform() over arrays
+// loaded from Postgres and bound as parameters. Placements are looked up the
+// same way from the ad unit code; arrayJoin repeats a row for every
+// placement that contains its ad unit.
+var chDimensionSelects = map[string][]string{
+	"date":       {"toString(toDate(ts, {tz:String})) AS date"},
+	"hour":       {"toHour(ts, {tz:String}) AS hour"},
+	"advertiser": {chLineItemAdvertiserID + " AS advertiser_id", "transform(line_item_id, {li_ids:Array(UInt32)}, {li_advertisers:Array(String)}, '') AS advertiser"},
+	"agency":     {chLineItemAgencyID + " AS agency_id", "transform(line_item_id, {li_ids:Array(UInt32)}, {li_agencies:Array(String)}, '') AS agency"},
+	"campaign":   {chLineItemCampaignID + " AS campaign_id", "transform(line_item_id, {li_ids:Array(UInt32)}, {li_campaigns:Array(String)}, '') AS campaign"},
+	"line_item":  {"line_item_id", "transform(line_item_id, {li_ids:Array(UInt32)}, {li_names:Array(String)}, '') AS line_item"},
+	"creative":   {"creative_id", "transform(creative_id, {cr_ids:Array(UInt32)}, {cr_names:Array(String)}, '') AS creative"},
+	"size":       {chCreativeSize + " AS size"},
+	"ad_unit":    {"ad_unit"},
+	"placement":  {"arrayJoin(" + chPlacementIndexes + ") AS placement_idx", "{pl_ids:Array(UInt32)}[placement_idx] AS placement_id", "{pl_names:Array(String)}[placement_idx] AS placement"},
+	"country":    {"country"},
+	"section":    {"section"},
+	"platform":   {"platform"},
+}
+
+const (
+	chLineItemAdvertiserID = "transform(line_item_id, {li_ids:Array(UInt32)}, {li_advertiser_ids:Array(UInt32)}, toUInt32(0))"
+	chLineItemAgencyID     = "transform(line_item_id, {li_ids:Array(UInt32)}, {li_agency_ids:Array(UInt32)}, toUInt32(0))"
+	chLineItemCampaignID   = "transform(line_item_id, {li_ids:Array(UInt32)}, {li_campaign_ids:Array(UInt32)}, toUInt32(0))"
+	chCreativeSize         = "transform(creative_id, {cr_ids:Array(UInt32)}, {cr_sizes:Array(String)}, '')"
+	// chPlacementIndexes are the (1-based) indexes into the placement arrays
+	// of the placements that contain the row's ad unit
+	chPlacementIndexes = "arrayFilter(i -> has({pl_units:Array(Array(String))}[i], ad_unit), arrayEnumerate({pl_ids:Array(UInt32)}))"
+)
+
+// chDimensionFilters are the expressions each dimension is filtered on,
+// compared as strings
+var chDimensionFilters = map[string]string{
+	"date":       "toString(toDate(ts, {tz:String}))",
+	"hour":       "toString(toHour(ts, {tz:String}))",
+	"advertiser": "toString(" + chLineItemAdvertiserID + ")",
+	"agency":     "toString(" + chLineItemAgencyID + ")",
+	"campaign":   "toString(" + chLineItemCampaignID + ")",
+	"line_item":  "toString(line_item_id)",
+	"creative":   "toString(creative_id)",
+	"size":       chCreativeSize,
+	"ad_unit":    "toString(ad_unit)",
+	"placement":  "toString(placement_id)",
+	"country":    "toString(country)",
+	"section":    "toString(section)",
+	"platform":   "toString(platform)",
+}
+
+var chMetricExprs = map[string]string{
+	"impressions":  "sum(imp)",
+	"clicks":       "sum(clk)",
+	"viewable":     "sum(vw)",
+	"ctr":          "if(sum(imp) > 0, sum(clk) * 100 / sum(imp), 0)",
+	"viewability":  "if(sum(imp) > 0, sum(vw) * 100 / sum(imp), 0)",
+	"unique_users": "uniqExactIf(user_id, imp = 1 AND user_id != '')",
+}
+
+// The report sources rename their time and count columns (ts, imp, clk, vw)
+// so they never clash with the output aliases
+const (
+	chCountsSource = `(
+		SELECT hour AS ts, line_item_id, creative_id, ad_unit, country, section, platform,
+			impressions AS imp, clicks AS clk, viewable AS vw
+		FROM %s
+		WHERE ` + hourlyRange + `
+	) AS f`
+	chEventSource = `(
+		SELECT created_at AS ts, line_item_id, creative_id, ad_unit, country, section, platform,
+			toUInt64(event_type = 'impression') AS imp, toUInt64(event_type = 'click') AS clk,
+			toUInt64(event_type = 'viewable') AS vw, user_id, targeting
+		FROM events
+		WHERE created_at >= {start:DateTime('UTC')} AND created_at < {end:DateTime('UTC')}
+			AND ({network:UInt32} = 0 OR network_id = {network:UInt32})
+	) AS f`
+)
+
+// RunReportQuery runs a report builder query
+func (s *ClickHouseStore) RunReportQuery(ctx context.Context, q *ReportQuery) (*ReportResult, error) {
+	result := &ReportResult{Columns: q.Columns()}
+	err := s.StreamReportQuery(ctx, q, func(values []interface{}) error {
+		result.Rows = append(result.Rows, values)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StreamReportQuery runs a report builder query and calls fn with each row
+// as it is decoded from the response. An error from fn stops the query.
+func (s *ClickHouseStore) StreamReportQuery(ctx context.Context, q *ReportQuery, fn func(values []interface{}) error) error {
+	return s.streamReportQuery(ctx, q, s.meta, fn)
+}
+
+// streamReportQuery is StreamReportQuery with the names looked up in names
+func (s *ClickHouseStore) streamReportQuery(ctx context.Context, q *ReportQuery, names reportNames, fn func(values []interface{}) error) error {
+	statement, params := chReportStatement(q)
+	if err := bindNames(ctx, q, names, params); err != nil {
+		return err
+	}
+
+	columns := q.Columns()
+	aliases := make([]string, len(columns))
+	for i, column := range columns {
+		aliases[i] = q.alias(column)
+	}
+	return s.query(ctx, statement, params, func(dec *json.Decoder) error {
+		dec.UseNumber()
+		var row map[string]interface{}
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = row[aliases[i]]
+			if n, ok := values[i].(json.Number); ok {
+				if IsRateMetric(column) {
+					values[i], _ = n.Float64()
+				} else {
+					values[i], _ = n.Int64()
+				}
+			}
+		}
+		return fn(values)
+	})
+}
+
+// chReportStatement returns the statement of a report builder query and its
+// parameters, less the name lookups bindNames adds. Queries with unique
+// users or a key read raw events; the rest read the hourly counts.
+func chReportStatement(q *ReportQuery) (string, url.Values) {
+	params := rangeParams(q.NetworkID, q.StartDate, q.EndDate)
+	params.Set("param_tz", q.Timezone)
+
+	// Key dimensions read the key, bound as a parameter, from the raw
+	// events' key-values; the hourly table has none
+	keys := q.Keys()
+	keyExprs := make(map[string]string, len(keys))
+	for i, key := range keys {
+		name := fmt.Sprintf("key_%d", i)
+		params.Set("param_"+name, key)
+		keyExprs[key] = fmt.Sprintf("targeting[{%s:String}]", name)
+	}
+
+	var selects, groups []string
+	for _, d := range q.Dimensions {
+		if key, ok := reportKey(d); ok {
+			selects = append(selects, keyExprs[key]+" AS "+q.alias(d))
+			groups = append(groups, q.alias(d))
+			continue
+		}
+		selects = append(selects, chDimensionSelects[d]...)
+		groups = append(groups, reportDimensionColumns[d]...)
+	}
+	for _, m := range q.Metrics {
+		selects = append(selects, chMetricExprs[m]+" AS "+m)
+	}
+
+	source := fmt.Sprintf(chCountsSource, countsTable(q.StartDate, q.EndDate))
+	if q.needsUsers() || len(keys) > 0 {
+		source = chEventSource
+	}
+
+	statement := `SELECT ` + strings.Join(selects, ", ") + ` FROM ` + source + ` WHERE 1`
+	for i, f := range q.Filters {
+		name := fmt.Sprintf("filter%d", i)
+		params.Set("param_"+name, stringArray(f.Values))
+		expr := chDimensionFilters[f.Dimension]
+		if key, ok := reportKey(f.Dimension); ok {
+			expr = keyExprs[key]
+		}
+		cond := fmt.Sprintf("%s IN {%s:Array(String)}", expr, name)
+		if f.Dimension == "placement" && !q.groups("placement") {
+			// Without the arrayJoin, match rows in any of the placements
+			cond = fmt.Sprintf("hasAny(arrayMap(i -> toString({pl_ids:Array(UInt32)}[i]), %s), {%s:Array(String)})", chPlacementIndexes, name)
+		}
+		if f.Operator == "NOT_IN" {
+			cond = "NOT (" + cond + ")"
+		}
+		statement += " AND " + cond
+	}
+	if len(groups) > 0 {
+		statement += " GROUP BY " + strings.Join(groups, ", ")
+	}
+	if order := q.orderBy(); order != "" {
+		statement += " ORDER BY " + order
+	}
+	if q.Limit > 0 {
+		statement += " LIMIT {limit:UInt32}"
+		params.Set("param_limit", strconv.Itoa(q.Limit))
+	}
+	return statement, params
+}
+
+// reportNames looks up the names and placements a report query shows.
+// PostgresStore implements it.
+type reportNames interface {
+	GetLineItemNames(ctx context.Context, networkID int) (map[int]LineItemName, error)
+	GetCreativeNames(ctx context.Context, networkID int) (map[int]CreativeName, error)
+	GetPlacementCodes(ctx context.Context, networkID int) ([]PlacementCodes, error)
+}
+
+var _ reportNames = (*PostgresStore)(nil)
+
+// bindNames binds the line item, creative and placement lookup arrays the
+// query's dimensions and filters refer to
+func bindNames(ctx context.Context, q *ReportQuery, names reportNames, params url.Values) error {
+	if q.uses("advertiser") || q.uses("agency") || q.uses("campaign") || q.uses("line_item") {
+		names, err := names.GetLineItemNames(ctx, q.NetworkID)
+		if err != nil {
+			return err
+		}
+		var ids, advertiserIDs, agencyIDs, campaignIDs []int
+		var lineItems, advertisers, agencies, campaigns []string
+		for id, n := range names {
+			ids = append(ids, id)
+			advertiserIDs = append(advertiserIDs, n.AdvertiserID)
+			agencyIDs = append(agencyIDs, n.AgencyID)
+			campaignIDs = append(campaignIDs, n.CampaignID)
+			lineItems = append(lineItems, n.Name)
+			advertisers = append(advertisers, n.AdvertiserName)
+			agencies = append(agencies, n.AgencyName)
+			campaigns = append(campaigns, n.CampaignName)
+		}
+		params.Set("param_li_ids", idArray(ids))
+		params.Set("param_li_advertiser_ids", idArray(advertiserIDs))
+		params.Set("param_li_agency_ids", idArray(agencyIDs))
+		params.Set("param_li_campaign_ids", idArray(campaignIDs))
+		params.Set("param_li_names", stringArray(lineItems))
+		params.Set("param_li_advertisers", stringArray(advertisers))
+		params.Set("param_li_agencies", stringArray(agencies))
+		params.Set("param_li_campaigns", stringArray(campaigns))
+	}
+
+	if q.uses("creative") || q.uses("size") {
+		names, err := names.GetCreativeNames(ctx, q.NetworkID)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		var creatives, sizes []string
+		for id, n := range names {
+			ids = append(ids, id)
+			creatives = append(creatives, n.Name)
+			sizes = append(sizes, fmt.Sprintf("%dx%d", n.Width, n.Height))
+		}
+		params.Set("param_cr_ids", idArray(ids))
+		params.Set("param_cr_names", stringArray(creatives))
+		params.Set("param_cr_sizes", stringArray(sizes))
+	}
+
+	if q.uses("placement") {
+		placements, err := names.GetPlacementCodes(ctx, q.NetworkID)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		var names, units []string
+		for _, p := range placements {
+			ids = append(ids, p.ID)
+			names = append(names, p.Name)
+			units = append(units, stringArray(p.Codes))
+		}
+		params.Set("param_pl_ids", idArray(ids))
+		params.Set("param_pl_names", stringArray(names))
+		params.Set("param_pl_units", "["+strings.Join(units, ",")+"]")
+	}
+
+	return nil
+}
+
+// stringArray formats strings as a ClickHouse Array(String) parameter
+func stringArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		v = strings.ReplaceAll(v, `'`, `\'`)
+		quoted[i] = "'" + v + "'"
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// fakeNames is one line item, one creative and one placement of two ad
+// units. It counts the lookups made.
+type fakeNames struct {
+	lookups int
+}
+
+func (n *fakeNames) GetLineItemNames(ctx context.Context, networkID int) (map[int]LineItemName, error) {
+	n.lookups++
+	return map[int]LineItemName{
+		7: {Name: "Spring", CampaignID: 4, CampaignName: "Launch", AdvertiserID: 2, AdvertiserName: "Acme"},
+	}, nil
+}
+
+func (n *fakeNames) GetCreativeNames(ctx context.Context, networkID int) (map[int]CreativeName, error) {
+	n.lookups++
+	return map[int]CreativeName{9: {Name: "Banner", Width: 300, Height: 250}}, nil
+}
+
+func (n *fakeNames) GetPlacementCodes(ctx context.Context, networkID int) ([]PlacementCodes, error) {
+	n.lookups++
+	return []PlacementCodes{{ID: 5, Name: "Homepage", Codes: []string{"home_side", "home_top"}}}, nil
+}
+
+// assertParams fails for each parameter whose value differs from want
+func assertParams(t *testing.T, params url.Values, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		if got := params.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestChReportStatementHourlyCounts(t *testing.T) {
+	q := reportQuery(t, models.ReportQueryRequest{
+		Dimensions: []string{"date", "line_item"},
+		Metrics:    []string{"impressions", "ctr"},
+		Filters:    []models.ReportFilter{{Dimension: "country", Values: []string{"US", "CA"}}},
+		Sort:       []models.ReportSort{{Field: "impressions"}},
+		Limit:      50,
+	})
+
+	statement, params := chReportStatement(q)
+	assertContains(t, statement,
+		"FROM events_hourly",
+		"impressions AS imp, clicks AS clk, viewable AS vw",
+		"toString(toDate(ts, {tz:String})) AS date",
+		"line_item_id, transform(line_item_id, {li_ids:Array(UInt32)}, {li_names:Array(String)}, '') AS line_item",
+		"sum(imp) AS impressions",
+		"if(sum(imp) > 0, sum(clk) * 100 / sum(imp), 0) AS ctr",
+		"AND toString(country) IN {filter0:Array(String)}",
+		"GROUP BY date, line_item_id, line_item",
+		"ORDER BY impressions DESC",
+		"LIMIT {limit:UInt32}",
+	)
+	assertNotContains(t, statement, "FROM events\n", "targeting", "arrayJoin")
+	assertParams(t, params, map[string]string{
+		"param_network": "3",
+		"param_start":   "2026-03-01 00:00:00",
+		"param_end":     "2026-03-08 00:00:00",
+		"param_tz":      "UTC",
+		"param_filter0": "['US','CA']",
+		"param_limit":   "50",
+	})
+}
+
+func TestChReportStatementRawEvents(t *testing.T) {
+	t.Run("range off the hour", func(t *testing.T) {
+		start := time.Date(2026, 3, 1, 18, 30, 0, 0, time.UTC)
+		req := models.ReportQueryRequest{Dimensions: []string{"date"}}
+		q, err := NewReportQuery(3, &req, start, start.AddDate(0, 0, 1), "Asia/Kolkata")
+		if err != nil {
+			t.Fatalf("NewReportQuery: %v", err)
+		}
+		statement, params := chReportStatement(q)
+		assertContains(t, statement, "SELECT created_at AS hour, network_id")
+		assertNotContains(t, statement, "events_hourly")
+		assertParams(t, params, map[string]string{"param_start": "2026-03-01 18:30:00", "param_tz": "Asia/Kolkata"})
+	})
+
+	t.Run("unique users", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{Dimensions: []string{"country"}, Metrics: []string{"unique_users"}})
+		statement, _ := chReportStatement(q)
+		assertContains(t, statement,
+			"toUInt64(event_type = 'viewable') AS vw, user_id, targeting",
+			"uniqExactIf(user_id, imp = 1 AND user_id != '') AS unique_users",
+			"GROUP BY country",
+		)
+		assertNotContains(t, statement, "events_hourly")
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"key:brand"},
+			Metrics:    []string{"impressions"},
+			Filters: []models.ReportFilter{
+				{Dimension: "key:brand", Values: []string{"acme"}},
+				{Dimension: "key:color", Operator: "NOT_IN", Values: []string{"red"}},
+			},
+		})
+		statement, params := chReportStatement(q)
+		assertContains(t, statement,
+			"targeting[{key_0:String}] AS key_0",
+			"AND targeting[{key_0:String}] IN {filter0:Array(String)}",
+			"AND NOT (targeting[{key_1:String}] IN {filter1:Array(String)})",
+			"GROUP BY key_0",
+			"ORDER BY key_0",
+		)
+		assertNotContains(t, statement, "events_hourly")
+		assertParams(t, params, map[string]string{
+			"param_key_0":   "brand",
+			"param_key_1":   "color",
+			"param_filter0": "['acme']",
+			"param_filter1": "['red']",
+			"param_limit":   "1000",
+		})
+	})
+}
+
+func TestChReportStatementPlacements(t *testing.T) {
+	t.Run("grouped", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"placement"},
+			Metrics:    []string{"impressions"},
+			Filters:    []models.ReportFilter{{Dimension: "placement", Values: []string{"5"}}},
+		})
+		statement, _ := chReportStatement(q)
+		assertContains(t, statement,
+			"arrayJoin(arrayFilter(i -> has({pl_units:Array(Array(String))}[i], ad_unit), arrayEnumerate({pl_ids:Array(UInt32)}))) AS placement_idx",
+			"{pl_ids:Array(UInt32)}[placement_idx] AS placement_id",
+			"{pl_names:Array(String)}[placement_idx] AS placement",
+			"AND toString(placement_id) IN {filter0:Array(String)}",
+			"GROUP BY placement_id, placement",
+		)
+		assertNotContains(t, statement, "hasAny")
+	})
+
+	t.Run("filtered only", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"ad_unit"},
+			Metrics:    []string{"impressions"},
+			Filters:    []models.ReportFilter{{Dimension: "placement", Operator: "NOT_IN", Values: []string{"5"}}},
+		})
+		statement, params := chReportStatement(q)
+		assertContains(t, statement,
+			"AND NOT (hasAny(arrayMap(i -> toString({pl_ids:Array(UInt32)}[i]), arrayFilter(i -> has({pl_units:Array(Array(String))}[i], ad_unit), arrayEnumerate({pl_ids:Array(UInt32)}))), {filter0:Array(String)}))",
+			"GROUP BY ad_unit",
+		)
+		assertNotContains(t, statement, "arrayJoin")
+		assertParams(t, params, map[string]string{"param_filter0": "['5']"})
+	})
+}
+
+func TestBindNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         models.ReportQueryRequest
+		wantLookups int
+		want        map[string]string
+	}{
+		{
+			name: "none used",
+			req:  models.ReportQueryRequest{Dimensions: []string{"date", "country"}},
+			want: map[string]string{"param_li_ids": "", "param_cr_ids": "", "param_pl_ids": ""},
+		},
+		{
+			name:        "line items",
+			req:         models.ReportQueryRequest{Dimensions: []string{"advertiser", "agency"}},
+			wantLookups: 1,
+			want: map[string]string{
+				"param_li_ids":            "[7]",
+				"param_li_names":          "['Spring']",
+				"param_li_campaign_ids":   "[4]",
+				"param_li_campaigns":      "['Launch']",
+				"param_li_advertiser_ids": "[2]",
+				"param_li_advertisers":    "['Acme']",
+				"param_li_agency_ids":     "[0]",
+				"param_li_agencies":       "['']",
+				"param_cr_ids":            "",
+			},
+		},
+		{
+			name:        "filter on campaign",
+			req:         models.ReportQueryRequest{Filters: []models.ReportFilter{{Dimension: "campaign", Values: []string{"4"}}}},
+			wantLookups: 1,
+			want:        map[string]string{"param_li_ids": "[7]", "param_li_campaign_ids": "[4]"},
+		},
+		{
+			name:        "sizes",
+			req:         models.ReportQueryRequest{Dimensions: []string{"size"}},
+			wantLookups: 1,
+			want:        map[string]string{"param_cr_ids": "[9]", "param_cr_names": "['Banner']", "param_cr_sizes": "['300x250']", "param_li_ids": ""},
+		},
+		{
+			name:        "placements",
+			req:         models.ReportQueryRequest{Dimensions: []string{"placement", "creative"}},
+			wantLookups: 2,
+			want: map[string]string{
+				"param_pl_ids":   "[5]",
+				"param_pl_names": "['Homepage']",
+				"param_pl_units": "[['home_side','home_top']]",
+				"param_cr_ids":   "[9]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := reportQuery(t, tt.req)
+			names := &fakeNames{}
+			params := url.Values{}
+			if err := bindNames(context.Background(), q, names, params); err != nil {
+				t.Fatalf("bindNames: %v", err)
+			}
+			if names.lookups != tt.wantLookups {
+				t.Errorf("%d lookups, want %d", names.lookups, tt.wantLookups)
+			}
+			assertParams(t, params, tt.want)
+		})
+	}
+}
+
+func TestStringArray(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, "[]"},
+		{[]string{"US"}, "['US']"},
+		{[]string{"O'Brien", `C:\ads`}, `['O\'Brien','C:\\ads']`},
+	}
+	for _, tt := range tests {
+		if got := stringArray(tt.values); got != tt.want {
+			t.Errorf("stringArray(%q) = %s, want %s", tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// EventWriter records tracking events
+type EventWriter interface {
+	RecordEvent(ctx context.Context, event *models.Event) error
+}
+
+// ReportReader serves the event-based delivery reports of a network.
+// PostgresStore and ClickHouseStore both implement it.
+type ReportReader interface {
+	GetReportSummary(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, advertiserID int) (*ReportSummary, error)
+	GetDailyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, adUnit string, advertiserID int) ([]DailyStats, error)
+	GetCampaignReport(ctx context.Context, networkID, campaignID int, startDate, endDate time.Time) (*CampaignReport, error)
+	GetHourlyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, campaignID, lineItemID int, adUnit string, advertiserID int) ([]HourlyStats, error)
+	GetKeyValueReport(ctx context.Context, networkID int, key string, startDate, endDate time.Time, adUnit string, advertiserID int) ([]KeyValueStats, error)
+	GetLineItemReport(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, creativeSize string, advertiserID int) ([]LineItemStats, error)
+	RunReportQuery(ctx context.Context, q *ReportQuery) (*ReportResult, error)
+	StreamReportQuery(ctx context.Context, q *ReportQuery, fn func(values []interface{}) error) error
+}
+
+var (
+	_ EventWriter  = (*PostgresStore)(nil)
+	_ EventWriter  = (*ClickHouseStore)(nil)
+	_ EventWriter  = (*DualWriter)(nil)
+	_ ReportReader = (*PostgresStore)(nil)
+	_ ReportReader = (*ClickHouseStore)(nil)
+)
+
+// secondaryWriteTimeout bounds a background write to the secondary store
+const secondaryWriteTimeout = 5 * time.Second
+
+// DualWriter records events in a primary store and copies them to a
+// secondary one. Only the primary write is waited for; the secondary write
+// runs in the background and its failures are logged, so a slow or down
+// analytics sink never affects tracking.
+type DualWriter struct {
+	primary   EventWriter
+	secondary EventWriter
+}
+
+// NewDualWriter creates a new DualWriter
+func NewDualWriter(primary, secondary EventWriter) *DualWriter {
+	return &DualWriter{primary: primary, secondary: secondary}
+}
+
+// RecordEvent records the event in both stores
+func (w *DualWriter) RecordEvent(ctx context.Context, event *models.Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	copied := *event
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), secondaryWriteTimeout)
+		defer cancel()
+		if err := w.secondary.RecordEvent(ctx, &copied); err != nil {
+			log.Printf("Warning: Failed to copy %s event to secondary store: %v", copied.EventType, err)
+		}
+	}()
+
+	return w.primary.RecordEvent(ctx, event)
+}
+
+// keyValueColumn maps a key-value report key to its event column, falling
+// back to country for keys that are not recorded with events
+func keyValueColumn(key string) string {
+	switch key {
+	case "country", "section", "platform", "ad_unit":
+		return key
+	default:
+		return "country"
+	}
+}
+
+// parseCreativeSize parses a "300x250" creative size filter
+func parseCreativeSize(size string) (width, height int, ok bool) {
+	parts := strings.SplitN(size, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	w, errW := strconv.Atoi(parts[0])
+	h, errH := strconv.Atoi(parts[1])
+	if errW != nil || errH != nil {
+		return 0, 0, false
+	}
+	return w, h, true
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const exportJobColumns = `id, network_id, status, format, query, start_at, end_at, timezone, row_count, file_size, file_path, error, created_at, started_at, finished_at, expires_at`
+
+func scanExportJob(row pgx.Row) (*models.ExportJob, error) {
+	var j models.ExportJob
+	var queryJSON []byte
+	if err := row.Scan(&j.ID, &j.NetworkID, &j.Status, &j.Format, &queryJSON, &j.StartDate, &j.EndDate, &j.Timezone, &j.RowCount, &j.FileSize, &j.FilePath, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt, &j.ExpiresAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(queryJSON, &j.Query)
+	return &j, nil
+}
+
+// CreateExportJob queues an export job
+func (s *PostgresStore) CreateExportJob(ctx context.Context, job *models.ExportJob) (*models.ExportJob, error) {
+	queryJSON, _ := json.Marshal(job.Query)
+	return scanExportJob(s.pool.QueryRow(ctx, `
+		INSERT INTO export_jobs (id, network_id, status, format, query, start_at, end_at, timezone, created_at)
+		VALUES ($1, $2, 'pending', $3, $4, $5, $6, $7, NOW())
+		RETURNING `+exportJobColumns,
+		job.ID, job.NetworkID, job.Format, queryJSON, job.StartDate, job.EndDate, job.Timezone))
+}
+
+// GetExportJob returns an export job by ID
+func (s *PostgresStore) GetExportJob(ctx context.Context, networkID int, id string) (*models.ExportJob, error) {
+	j, err := scanExportJob(s.pool.QueryRow(ctx, `
+		SELECT `+exportJobColumns+` FROM export_jobs WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+// ClaimExportJob marks the oldest pending export job as running and returns
+// it, or nil if there is none. A job left running by a server that stopped
+// is claimed again after staleRunTimeout.
+func (s *PostgresStore) ClaimExportJob(ctx context.Context) (*models.ExportJob, error) {
+	j, err := scanExportJob(s.pool.QueryRow(ctx, `
+		UPDATE export_jobs
+		SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM export_jobs
+			WHERE status = 'pending'
+			   OR (status = 'running' AND started_at < NOW() - make_interval(secs => $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+exportJobColumns,
+		staleRunTimeout.Seconds()))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+// CompleteExportJob records a finished export and when its file expires
+func (s *PostgresStore) CompleteExportJob(ctx context.Context, id, filePath string, rowCount int, fileSize int64, expiresAt time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE export_jobs
+		SET status = 'done', file_path = $2, row_count = $3, file_size = $4, expires_at = $5, finished_at = NOW()
+		WHERE id = $1
+	`, id, filePath, rowCount, fileSize, expiresAt)
+	return err
+}
+
+// FailExportJob records a failed export, kept until expiresAt
+func (s *PostgresStore) FailExportJob(ctx context.Context, id, message string, expiresAt time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE export_jobs SET status = 'failed', error = $2, expires_at = $3, finished_at = NOW()
+		WHERE id = $1
+	`, id, message, expiresAt)
+	return err
+}
+
+// DeleteExpiredExportJobs deletes export jobs that expired before now and
+// returns their file paths
+func (s *PostgresStore) DeleteExpiredExportJobs(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `
+		DELETE FROM export_jobs WHERE expires_at < $1 RETURNING file_path
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const networkColumns = `id, code, name, status, COALESCE(timezone, 'UTC'), created_at, updated_at`
+
+func scanNetwork(row pgx.Row) (*models.Network, error) {
+	var n models.Network
+	if err := row.Scan(&n.ID, &n.Code, &n.Name, &n.Status, &n.Timezone, &n.CreatedAt, &n.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ListNetworks returns all networks
+func (s *PostgresStore) ListNetworks(ctx context.Context) ([]models.Network, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+networkColumns+` FROM networks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var networks []models.Network
+	for rows.Next() {
+		n, err := scanNetwork(rows)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, *n)
+	}
+	return networks, nil
+}
+
+// GetNetwork returns a network by ID
+func (s *PostgresStore) GetNetwork(ctx context.Context, id int) (*models.Network, error) {
+	n, err := scanNetwork(s.pool.QueryRow(ctx, `SELECT `+networkColumns+` FROM networks WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return n, err
+}
+
+// GetNetworkByCode returns a network by its code (publisher ID)
+func (s *PostgresStore) GetNetworkByCode(ctx context.Context, code string) (*models.Network, error) {
+	n, err := scanNetwork(s.pool.QueryRow(ctx, `SELECT `+networkColumns+` FROM networks WHERE code = $1`, code))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return n, err
+}
+
+// GetNetworkTimezone returns a network's timezone, or UTC if the network
+// doesn't exist
+func (s *PostgresStore) GetNetworkTimezone(ctx context.Context, id int) (string, error) {
+	var tz string
+	err := s.pool.QueryRow(ctx, `SELECT COALESCE(timezone, 'UTC') FROM networks WHERE id = $1`, id).Scan(&tz)
+	if err == pgx.ErrNoRows {
+		return "UTC", nil
+	}
+	return tz, err
+}
+
+// SeedNetworkTimezone sets a network's timezone if it has none yet, and
+// reports whether it did
+func (s *PostgresStore) SeedNetworkTimezone(ctx context.Context, id int, timezone string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE networks SET timezone = $2, updated_at = NOW()
+		WHERE id = $1 AND timezone IS NULL`, id, timezone)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CreateNetwork creates a new active network, in UTC unless the request
+// names a timezone
+func (s *PostgresStore) CreateNetwork(ctx context.Context, req *models.CreateNetworkRequest) (*models.Network, error) {
+	return scanNetwork(s.pool.QueryRow(ctx, `
+		INSERT INTO networks (code, name, status, timezone, created_at, updated_at)
+		VALUES ($1, $2, 'active', COALESCE(NULLIF($3, ''), 'UTC'), NOW(), NOW())
+		RETURNING `+networkColumns,
+		req.Code, req.Name, req.Timezone))
+}
+
+// UpdateNetwork updates a network's name, status and timezone
+func (s *PostgresStore) UpdateNetwork(ctx context.Context, id int, req *models.UpdateNetworkRequest) (*models.Network, error) {
+	n, err := scanNetwork(s.pool.QueryRow(ctx, `
+		UPDATE networks SET
+			name = COALESCE(NULLIF($2, ''), name),
+			status = COALESCE(NULLIF($3, ''), status),
+			timezone = COALESCE(NULLIF($4, ''), timezone),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+networkColumns,
+		id, req.Name, req.Status, req.Timezone))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return n, err
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// placementColumns selects a placement p with its ad unit IDs
+const placementColumns = `p.id, p.network_id, p.name, p.description,
+		COALESCE((SELECT array_agg(pa.ad_unit_id ORDER BY pa.ad_unit_id) FROM placement_ad_units pa WHERE pa.placement_id = p.id), '{}'),
+		p.created_at, p.updated_at`
+
+func scanPlacement(row pgx.Row) (*models.Placement, error) {
+	var p models.Placement
+	if err := row.Scan(&p.ID, &p.NetworkID, &p.Name, &p.Description, &p.AdUnitIDs, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListPlacements returns all placements by name
+func (s *PostgresStore) ListPlacements(ctx context.Context, networkID int) ([]models.Placement, error) {
+	args := []interface{}{}
+	query := `SELECT ` + placementColumns + ` FROM placements p WHERE true` + networkFilter(&args, "p.network_id", networkID) + ` ORDER BY p.name, p.id`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var placements []models.Placement
+	for rows.Next() {
+		p, err := scanPlacement(rows)
+		if err != nil {
+			return nil, err
+		}
+		placements = append(placements, *p)
+	}
+	return placements, rows.Err()
+}
+
+// GetPlacement returns a placement by ID
+func (s *PostgresStore) GetPlacement(ctx context.Context, networkID, id int) (*models.Placement, error) {
+	p, err := scanPlacement(s.pool.QueryRow(ctx, `
+		SELECT `+placementColumns+` FROM placements p WHERE p.id = $1 AND ($2 = 0 OR p.network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPlacementByName returns a network's placement by name
+func (s *PostgresStore) GetPlacementByName(ctx context.Context, networkID int, name string) (*models.Placement, error) {
+	p, err := scanPlacement(s.pool.QueryRow(ctx, `
+		SELECT `+placementColumns+` FROM placements p WHERE p.network_id = $1 AND p.name = $2
+	`, networkID, name))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// CreatePlacement creates a placement with its ad units
+func (s *PostgresStore) CreatePlacement(ctx context.Context, networkID int, req *models.PlacementRequest) (*models.Placement, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO placements (network_id, name, description, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		RETURNING id
+	`, networkID, req.Name, req.Description).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	if err := setPlacementAdUnits(ctx, tx, id, req.AdUnitIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetPlacement(ctx, networkID, id)
+}
+
+// UpdatePlacement replaces a placement's name, description and ad units
+func (s *PostgresStore) UpdatePlacement(ctx context.Context, networkID, id int, req *models.PlacementRequest) (*models.Placement, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE placements SET name = $2, description = $3, updated_at = NOW()
+		WHERE id = $1 AND ($4 = 0 OR network_id = $4)
+	`, id, req.Name, req.Description, networkID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+	if err := setPlacementAdUnits(ctx, tx, id, req.AdUnitIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetPlacement(ctx, networkID, id)
+}
+
+// setPlacementAdUnits replaces a placement's ad units, skipping ad units of
+// another network
+func setPlacementAdUnits(ctx context.Context, tx pgx.Tx, placementID int, adUnitIDs []int) error {
+	_, err := tx.Exec(ctx, `DELETE FROM placement_ad_units WHERE placement_id = $1`, placementID)
+	if err != nil {
+		return err
+	}
+	for _, adUnitID := range adUnitIDs {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO placement_ad_units (placement_id, ad_unit_id)
+			SELECT p.id, au.id FROM placements p JOIN ad_units au ON au.network_id = p.network_id
+			WHERE p.id = $1 AND au.id = $2
+			ON CONFLICT DO NOTHING
+		`, placementID, adUnitID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePlacement deletes a placement
+func (s *PostgresStore) DeletePlacement(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM placements WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// CountPlacementLineItems returns how many line items target a placement
+func (s *PostgresStore) CountPlacementLineItems(ctx context.Context, id int) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM line_item_placements WHERE placement_id = $1`, id).Scan(&n)
+	return n, err
+}
+
+// GetLineItemPlacements returns the IDs of the placements a line item targets
+func (s *PostgresStore) GetLineItemPlacements(ctx context.Context, lineItemID int) ([]int, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT placement_id FROM line_item_placements WHERE line_item_id = $1 ORDER BY placement_id
+	`, lineItemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SetLineItemPlacements sets the placements a line item targets
+func (s *PostgresStore) SetLineItemPlacements(ctx context.Context, lineItemID int, placementIDs []int) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM line_item_placements WHERE line_item_id = $1`, lineItemID)
+	if err != nil {
+		return err
+	}
+
+	// Insert new, skipping placements of another network
+	for _, placementID := range placementIDs {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO line_item_placements (line_item_id, placement_id)
+			SELECT li.id, p.id FROM line_items li JOIN placements p ON p.network_id = li.network_id
+			WHERE li.id = $1 AND p.id = $2
+			ON CONFLICT DO NOTHING
+		`, lineItemID, placementID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// PlacementCodes holds what a report needs of a placement: its name and the
+// codes of its ad units, which events are recorded with
+type PlacementCodes struct {
+	ID    int
+	Name  string
+	Codes []string
+}
+
+// GetPlacementCodes returns every placement of a network with its ad unit
+// codes
+func (s *PostgresStore) GetPlacementCodes(ctx context.Context, networkID int) ([]PlacementCodes, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT p.id, p.name, COALESCE(array_agg(au.code ORDER BY au.code) FILTER (WHERE au.code IS NOT NULL), '{}')
+		FROM placements p
+		LEFT JOIN placement_ad_units pa ON pa.placement_id = p.id
+		LEFT JOIN ad_units au ON au.id = pa.ad_unit_id
+		WHERE $1 = 0 OR p.network_id = $1
+		GROUP BY p.id, p.name
+		ORDER BY p.id
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var placements []PlacementCodes
+	for rows.Next() {
+		var p PlacementCodes
+		if err := rows.Scan(&p.ID, &p.Name, &p.Codes); err != nil {
+			return nil, err
+		}
+		placements = append(placements, p)
+	}
+	return placements, rows.Err()
+}
@@ -4,19 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/mims/ad-manager/internal/models"
 )
 
-// PostgresStore handles database operations
+// PostgresStore handles database operations.
+//
+// Methods that take a networkID only see and change the rows of that network
+// (tenant). Background jobs that work across every network pass 0.
 type PostgresStore struct {
-	pool *pgxpool.Pool
+	pool db
+}
+
+// db is what a store runs its queries on: the connection pool, or the
+// transaction of a store passed to InTx's fn
+type db interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
 }
 
 // NewPostgresStore creates a new PostgresStore
@@ -24,15 +37,60 @@ func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
 	return &PostgresStore{pool: pool}
 }
 
+// InTx calls fn with a store whose queries all run in one transaction,
+// which is committed if fn returns nil and rolled back otherwise. Methods
+// that use a transaction of their own run in a savepoint of this one.
+func (s *PostgresStore) InTx(ctx context.Context, fn func(tx *PostgresStore) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&PostgresStore{pool: tx}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// networkFilter returns the condition restricting column to a network,
+// binding networkID to the next placeholder, or "" when networkID is 0
+func networkFilter(args *[]interface{}, column string, networkID int) string {
+	if networkID == 0 {
+		return ""
+	}
+	*args = append(*args, networkID)
+	return fmt.Sprintf(" AND %s = $%d", column, len(*args))
+}
+
 // Campaign operations
 
-// ListCampaigns returns all campaigns
-func (s *PostgresStore) ListCampaigns(ctx context.Context) ([]models.Campaign, error) {
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, name, status, created_at, updated_at
-		FROM campaigns
-		ORDER BY created_at DESC
-	`)
+const campaignColumns = `id, network_id, name, status, COALESCE(timezone, ''), advertiser_id, agency_id, order_number, created_at, updated_at`
+
+func scanCampaign(row pgx.Row) (*models.Campaign, error) {
+	var c models.Campaign
+	if err := row.Scan(&c.ID, &c.NetworkID, &c.Name, &c.Status, &c.Timezone, &c.AdvertiserID, &c.AgencyID, &c.OrderNumber, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListCampaigns returns all campaigns, or those of an advertiser or agency
+// when their ID is given
+func (s *PostgresStore) ListCampaigns(ctx context.Context, networkID, advertiserID, agencyID int) ([]models.Campaign, error) {
+	args := []interface{}{}
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE true` + networkFilter(&args, "network_id", networkID)
+	if advertiserID != 0 {
+		args = append(args, advertiserID)
+		query += fmt.Sprintf(" AND advertiser_id = $%d", len(args))
+	}
+	if agencyID != 0 {
+		args = append(args, agencyID)
+		query += fmt.Sprintf(" AND agency_id = $%d", len(args))
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,85 +98,91 @@ func (s *PostgresStore) ListCampaigns(ctx context.Context) ([]models.Campaign, e
 
 	var campaigns []models.Campaign
 	for rows.Next() {
-		var c models.Campaign
-		if err := rows.Scan(&c.ID, &c.Name, &c.Status, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		c, err := scanCampaign(rows)
+		if err != nil {
 			return nil, err
 		}
-		campaigns = append(campaigns, c)
+		campaigns = append(campaigns, *c)
 	}
 	return campaigns, nil
 }
 
 // GetCampaign returns a campaign by ID
-func (s *PostgresStore) GetCampaign(ctx context.Context, id int) (*models.Campaign, error) {
-	var c models.Campaign
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, name, status, created_at, updated_at
-		FROM campaigns WHERE id = $1
-	`, id).Scan(&c.ID, &c.Name, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+func (s *PostgresStore) GetCampaign(ctx context.Context, networkID, id int) (*models.Campaign, error) {
+	c, err := scanCampaign(s.pool.QueryRow(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
 }
 
 // CreateCampaign creates a new campaign
-func (s *PostgresStore) CreateCampaign(ctx context.Context, req *models.CreateCampaignRequest) (*models.Campaign, error) {
+func (s *PostgresStore) CreateCampaign(ctx context.Context, networkID int, req *models.CreateCampaignRequest) (*models.Campaign, error) {
 	status := req.Status
 	if status == "" {
 		status = "active"
 	}
 
-	var c models.Campaign
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO campaigns (name, status, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
-		RETURNING id, name, status, created_at, updated_at
-	`, req.Name, status).Scan(&c.ID, &c.Name, &c.Status, &c.CreatedAt, &c.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &c, nil
+	return scanCampaign(s.pool.QueryRow(ctx, `
+		INSERT INTO campaigns (network_id, name, status, timezone, advertiser_id, agency_id, order_number, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, 0), $7, NOW(), NOW())
+		RETURNING `+campaignColumns,
+		networkID, req.Name, status, req.Timezone, req.AdvertiserID, req.AgencyID, req.OrderNumber))
 }
 
 // UpdateCampaign updates an existing campaign
-func (s *PostgresStore) UpdateCampaign(ctx context.Context, id int, req *models.UpdateCampaignRequest) (*models.Campaign, error) {
-	var c models.Campaign
-	err := s.pool.QueryRow(ctx, `
+func (s *PostgresStore) UpdateCampaign(ctx context.Context, networkID, id int, req *models.UpdateCampaignRequest) (*models.Campaign, error) {
+	c, err := scanCampaign(s.pool.QueryRow(ctx, `
 		UPDATE campaigns
 		SET name = COALESCE(NULLIF($2, ''), name),
 		    status = COALESCE(NULLIF($3, ''), status),
+		    timezone = CASE WHEN $4::text IS NULL THEN timezone ELSE NULLIF($4, '') END,
+		    advertiser_id = CASE WHEN $5::int IS NULL THEN advertiser_id ELSE NULLIF($5, 0) END,
+		    agency_id = CASE WHEN $6::int IS NULL THEN agency_id ELSE NULLIF($6, 0) END,
+		    order_number = COALESCE($7, order_number),
 		    updated_at = NOW()
-		WHERE id = $1
-		RETURNING id, name, status, created_at, updated_at
-	`, id, req.Name, req.Status).Scan(&c.ID, &c.Name, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+		WHERE id = $1 AND ($8 = 0 OR network_id = $8)
+		RETURNING `+campaignColumns,
+		id, req.Name, req.Status, req.Timezone, req.AdvertiserID, req.AgencyID, req.OrderNumber, networkID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
 }
 
 // DeleteCampaign deletes a campaign
-func (s *PostgresStore) DeleteCampaign(ctx context.Context, id int) error {
-	_, err := s.pool.Exec(ctx, `DELETE FROM campaigns WHERE id = $1`, id)
+func (s *PostgresStore) DeleteCampaign(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM campaigns WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
 	return err
 }
 
 // Ad Unit operations
 
+const adUnitColumns = `id, network_id, parent_id, code, name, description, platform, sizes, status, fallback_mode, passback_url, passback_html, created_at, updated_at`
+
+func scanAdUnit(row pgx.Row) (*models.AdUnit, error) {
+	var u models.AdUnit
+	var sizesJSON []byte
+	if err := row.Scan(&u.ID, &u.NetworkID, &u.ParentID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesJSON, &u.Status, &u.FallbackMode, &u.PassbackURL, &u.PassbackHTML, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(sizesJSON, &u.Sizes)
+	return &u, nil
+}
+
 // ListAdUnits returns all ad units
-func (s *PostgresStore) ListAdUnits(ctx context.Context) ([]models.AdUnit, error) {
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, code, name, description, platform, sizes, status, created_at, updated_at
-		FROM ad_units
-		ORDER BY name
-	`)
+func (s *PostgresStore) ListAdUnits(ctx context.Context, networkID int) ([]models.AdUnit, error) {
+	args := []interface{}{}
+	query := `SELECT ` + adUnitColumns + ` FROM ad_units WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY name`
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -126,81 +190,61 @@ func (s *PostgresStore) ListAdUnits(ctx context.Context) ([]models.AdUnit, error
 
 	var units []models.AdUnit
 	for rows.Next() {
-		var u models.AdUnit
-		var sizesJSON []byte
-		if err := rows.Scan(&u.ID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesJSON, &u.Status, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		u, err := scanAdUnit(rows)
+		if err != nil {
 			return nil, err
 		}
-		json.Unmarshal(sizesJSON, &u.Sizes)
-		units = append(units, u)
+		units = append(units, *u)
 	}
 	return units, nil
 }
 
 // GetAdUnit returns an ad unit by ID
-func (s *PostgresStore) GetAdUnit(ctx context.Context, id int) (*models.AdUnit, error) {
-	var u models.AdUnit
-	var sizesJSON []byte
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, code, name, description, platform, sizes, status, created_at, updated_at
-		FROM ad_units WHERE id = $1
-	`, id).Scan(&u.ID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesJSON, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+func (s *PostgresStore) GetAdUnit(ctx context.Context, networkID, id int) (*models.AdUnit, error) {
+	u, err := scanAdUnit(s.pool.QueryRow(ctx, `
+		SELECT `+adUnitColumns+` FROM ad_units WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(sizesJSON, &u.Sizes)
-	return &u, nil
+	return u, nil
 }
 
-// GetAdUnitByCode returns an ad unit by code
-func (s *PostgresStore) GetAdUnitByCode(ctx context.Context, code string) (*models.AdUnit, error) {
-	var u models.AdUnit
-	var sizesJSON []byte
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, code, name, description, platform, sizes, status, created_at, updated_at
-		FROM ad_units WHERE code = $1
-	`, code).Scan(&u.ID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesJSON, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+// GetAdUnitByCode returns an ad unit of a network by code
+func (s *PostgresStore) GetAdUnitByCode(ctx context.Context, networkID int, code string) (*models.AdUnit, error) {
+	u, err := scanAdUnit(s.pool.QueryRow(ctx, `
+		SELECT `+adUnitColumns+` FROM ad_units WHERE network_id = $1 AND code = $2
+	`, networkID, code))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(sizesJSON, &u.Sizes)
-	return &u, nil
+	return u, nil
 }
 
 // CreateAdUnit creates a new ad unit
-func (s *PostgresStore) CreateAdUnit(ctx context.Context, req *models.CreateAdUnitRequest) (*models.AdUnit, error) {
+func (s *PostgresStore) CreateAdUnit(ctx context.Context, networkID int, req *models.CreateAdUnitRequest) (*models.AdUnit, error) {
 	sizesJSON, _ := json.Marshal(req.Sizes)
 	platform := req.Platform
 	if platform == "" {
 		platform = "web"
 	}
-	var u models.AdUnit
-	var sizesOut []byte
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO ad_units (code, name, description, platform, sizes, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, 'active', NOW(), NOW())
-		RETURNING id, code, name, description, platform, sizes, status, created_at, updated_at
-	`, req.Code, req.Name, req.Description, platform, sizesJSON).Scan(
-		&u.ID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesOut, &u.Status, &u.CreatedAt, &u.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	json.Unmarshal(sizesOut, &u.Sizes)
-	return &u, nil
+	return scanAdUnit(s.pool.QueryRow(ctx, `
+		INSERT INTO ad_units (network_id, parent_id, code, name, description, platform, sizes, status, fallback_mode, passback_url, passback_html, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'active', $8, $9, $10, NOW(), NOW())
+		RETURNING `+adUnitColumns,
+		networkID, req.ParentID, req.Code, req.Name, req.Description, platform, sizesJSON, req.FallbackMode, req.PassbackURL, req.PassbackHTML))
 }
 
 // UpdateAdUnit updates an ad unit
-func (s *PostgresStore) UpdateAdUnit(ctx context.Context, id int, req *models.UpdateAdUnitRequest) (*models.AdUnit, error) {
+func (s *PostgresStore) UpdateAdUnit(ctx context.Context, networkID, id int, req *models.UpdateAdUnitRequest) (*models.AdUnit, error) {
 	sizesJSON, _ := json.Marshal(req.Sizes)
-	var u models.AdUnit
-	var sizesOut []byte
-	err := s.pool.QueryRow(ctx, `
+	u, err := scanAdUnit(s.pool.QueryRow(ctx, `
 		UPDATE ad_units
 		SET code = COALESCE(NULLIF($2, ''), code),
 		    name = COALESCE(NULLIF($3, ''), name),
@@ -208,78 +252,112 @@ func (s *PostgresStore) UpdateAdUnit(ctx context.Context, id int, req *models.Up
 		    platform = COALESCE(NULLIF($5, ''), platform),
 		    sizes = COALESCE($6, sizes),
 		    status = COALESCE(NULLIF($7, ''), status),
+		    fallback_mode = COALESCE($8, fallback_mode),
+		    passback_url = COALESCE($9, passback_url),
+		    passback_html = COALESCE($10, passback_html),
+		    parent_id = CASE WHEN $12::int IS NULL THEN parent_id ELSE NULLIF($12, 0) END,
 		    updated_at = NOW()
-		WHERE id = $1
-		RETURNING id, code, name, description, platform, sizes, status, created_at, updated_at
-	`, id, req.Code, req.Name, req.Description, req.Platform, sizesJSON, req.Status).Scan(
-		&u.ID, &u.Code, &u.Name, &u.Description, &u.Platform, &sizesOut, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+		WHERE id = $1 AND ($11 = 0 OR network_id = $11)
+		RETURNING `+adUnitColumns,
+		id, req.Code, req.Name, req.Description, req.Platform, sizesJSON, req.Status, req.FallbackMode, req.PassbackURL, req.PassbackHTML, networkID, req.ParentID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(sizesOut, &u.Sizes)
-	return &u, nil
+	return u, nil
 }
 
 // DeleteAdUnit deletes an ad unit
-func (s *PostgresStore) DeleteAdUnit(ctx context.Context, id int) error {
-	_, err := s.pool.Exec(ctx, `DELETE FROM ad_units WHERE id = $1`, id)
+func (s *PostgresStore) DeleteAdUnit(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM ad_units WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
 	return err
 }
 
-// GetLineItemAdUnits returns ad unit IDs for a line item
-func (s *PostgresStore) GetLineItemAdUnits(ctx context.Context, lineItemID int) ([]int, error) {
+// CountAdUnitChildren returns how many ad units have the ad unit as parent
+func (s *PostgresStore) CountAdUnitChildren(ctx context.Context, id int) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM ad_units WHERE parent_id = $1`, id).Scan(&n)
+	return n, err
+}
+
+// GetLineItemAdUnits returns the ad units a line item targets and excludes
+func (s *PostgresStore) GetLineItemAdUnits(ctx context.Context, lineItemID int) ([]models.AdUnitTarget, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT ad_unit_id FROM line_item_ad_units WHERE line_item_id = $1
+		SELECT ad_unit_id, include_descendants, excluded FROM line_item_ad_units
+		WHERE line_item_id = $1
+		ORDER BY ad_unit_id
 	`, lineItemID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ids []int
+	var targets []models.AdUnitTarget
 	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
+		var t models.AdUnitTarget
+		if err := rows.Scan(&t.AdUnitID, &t.IncludeDescendants, &t.Excluded); err != nil {
 			return nil, err
 		}
-		ids = append(ids, id)
+		targets = append(targets, t)
 	}
-	return ids, nil
+	return targets, nil
 }
 
-// SetLineItemAdUnits sets ad unit targeting for a line item
-func (s *PostgresStore) SetLineItemAdUnits(ctx context.Context, lineItemID int, adUnitIDs []int) error {
-	// Delete existing
-	_, err := s.pool.Exec(ctx, `DELETE FROM line_item_ad_units WHERE line_item_id = $1`, lineItemID)
+// SetLineItemAdUnits replaces the ad units a line item targets and
+// excludes. Every ad unit must be in the line item's network; otherwise
+// nothing is changed and an error is returned.
+func (s *PostgresStore) SetLineItemAdUnits(ctx context.Context, lineItemID int, targets []models.AdUnitTarget) error {
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	// Insert new
-	for _, adUnitID := range adUnitIDs {
-		_, err := s.pool.Exec(ctx, `
-			INSERT INTO line_item_ad_units (line_item_id, ad_unit_id) VALUES ($1, $2)
-		`, lineItemID, adUnitID)
+	_, err = tx.Exec(ctx, `DELETE FROM line_item_ad_units WHERE line_item_id = $1`, lineItemID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO line_item_ad_units (line_item_id, ad_unit_id, include_descendants, excluded)
+			SELECT li.id, au.id, $3, $4 FROM line_items li JOIN ad_units au ON au.network_id = li.network_id
+			WHERE li.id = $1 AND au.id = $2
+		`, lineItemID, t.AdUnitID, t.IncludeDescendants, t.Excluded)
 		if err != nil {
 			return err
 		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("ad unit %d is not in the network of line item %d", t.AdUnitID, lineItemID)
+		}
 	}
-	return nil
+
+	return tx.Commit(ctx)
 }
 
 // Line Item operations
 
+const lineItemColumns = `id, network_id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, advertiser, competitive_exclusions, roadblock_mode, creative_rotation, line_type, start_date, end_date, impression_goal, created_at, updated_at`
+
+func scanLineItem(row pgx.Row, extra ...interface{}) (*models.LineItem, error) {
+	var li models.LineItem
+	dest := []interface{}{&li.ID, &li.NetworkID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.Advertiser, &li.CompetitiveExclusions, &li.RoadblockMode, &li.CreativeRotation, &li.LineType, &li.StartDate, &li.EndDate, &li.ImpressionGoal, &li.CreatedAt, &li.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &li, nil
+}
+
 // ListLineItems returns line items for a campaign
-func (s *PostgresStore) ListLineItems(ctx context.Context, campaignID int) ([]models.LineItem, error) {
+func (s *PostgresStore) ListLineItems(ctx context.Context, networkID, campaignID int) ([]models.LineItem, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, created_at, updated_at
+		SELECT `+lineItemColumns+`
 		FROM line_items
-		WHERE campaign_id = $1
+		WHERE campaign_id = $1 AND ($2 = 0 OR network_id = $2)
 		ORDER BY priority DESC, created_at DESC
-	`, campaignID)
+	`, campaignID, networkID)
 	if err != nil {
 		return nil, err
 	}
@@ -287,33 +365,32 @@ func (s *PostgresStore) ListLineItems(ctx context.Context, campaignID int) ([]mo
 
 	var items []models.LineItem
 	for rows.Next() {
-		var li models.LineItem
-		if err := rows.Scan(&li.ID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.CreatedAt, &li.UpdatedAt); err != nil {
+		li, err := scanLineItem(rows)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, li)
+		items = append(items, *li)
 	}
 	return items, nil
 }
 
 // GetLineItem returns a line item by ID
-func (s *PostgresStore) GetLineItem(ctx context.Context, id int) (*models.LineItem, error) {
-	var li models.LineItem
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, created_at, updated_at
-		FROM line_items WHERE id = $1
-	`, id).Scan(&li.ID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.CreatedAt, &li.UpdatedAt)
+func (s *PostgresStore) GetLineItem(ctx context.Context, networkID, id int) (*models.LineItem, error) {
+	li, err := scanLineItem(s.pool.QueryRow(ctx, `
+		SELECT `+lineItemColumns+` FROM line_items WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &li, nil
+	return li, nil
 }
 
-// CreateLineItem creates a new line item
-func (s *PostgresStore) CreateLineItem(ctx context.Context, req *models.CreateLineItemRequest) (*models.LineItem, error) {
+// CreateLineItem creates a new line item in its campaign's network. It
+// returns nil if the campaign isn't in the network.
+func (s *PostgresStore) CreateLineItem(ctx context.Context, networkID int, req *models.CreateLineItemRequest) (*models.LineItem, error) {
 	status := req.Status
 	if status == "" {
 		status = "active"
@@ -326,22 +403,33 @@ func (s *PostgresStore) CreateLineItem(ctx context.Context, req *models.CreateLi
 	if period == "" {
 		period = "day"
 	}
+	exclusions := req.CompetitiveExclusions
+	if exclusions == nil {
+		exclusions = []string{}
+	}
+	rotation := req.CreativeRotation
+	if rotation == "" {
+		rotation = models.CreativeRotationEven
+	}
+	lineType := req.LineType
+	if lineType == "" {
+		lineType = models.LineTypeStandard
+	}
 
-	var li models.LineItem
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO line_items (campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, created_at, updated_at)
-		VALUES ($1, $2, $3, 100, $4, $5, $6, $7, NOW(), NOW())
-		RETURNING id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, created_at, updated_at
-	`, req.CampaignID, req.Name, priority, req.SOVPercentage, req.FrequencyCap, period, status).Scan(
-		&li.ID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.CreatedAt, &li.UpdatedAt)
-	if err != nil {
+	campaign, err := s.GetCampaign(ctx, networkID, req.CampaignID)
+	if err != nil || campaign == nil {
 		return nil, err
 	}
-	return &li, nil
+
+	return scanLineItem(s.pool.QueryRow(ctx, `
+		INSERT INTO line_items (network_id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, advertiser, competitive_exclusions, roadblock_mode, creative_rotation, line_type, start_date, end_date, impression_goal, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 100, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NOW(), NOW())
+		RETURNING `+lineItemColumns,
+		campaign.NetworkID, req.CampaignID, req.Name, priority, req.SOVPercentage, req.FrequencyCap, period, status, req.Advertiser, exclusions, req.RoadblockMode, rotation, lineType, req.StartDate, req.EndDate, req.ImpressionGoal))
 }
 
 // UpdateLineItem updates an existing line item
-func (s *PostgresStore) UpdateLineItem(ctx context.Context, id int, req *models.UpdateLineItemRequest) (*models.LineItem, error) {
+func (s *PostgresStore) UpdateLineItem(ctx context.Context, networkID, id int, req *models.UpdateLineItemRequest) (*models.LineItem, error) {
 	// Use -1 sentinel for pointer fields when nil (not sent)
 	weightVal := -1
 	if req.Weight != nil {
@@ -352,8 +440,7 @@ func (s *PostgresStore) UpdateLineItem(ctx context.Context, id int, req *models.
 		sovVal = *req.SOVPercentage
 	}
 
-	var li models.LineItem
-	err := s.pool.QueryRow(ctx, `
+	li, err := scanLineItem(s.pool.QueryRow(ctx, `
 		UPDATE line_items
 		SET name = COALESCE(NULLIF($2, ''), name),
 		    priority = CASE WHEN $3 > 0 THEN $3 ELSE priority END,
@@ -362,23 +449,30 @@ func (s *PostgresStore) UpdateLineItem(ctx context.Context, id int, req *models.
 		    frequency_cap = CASE WHEN $6 >= 0 THEN $6 ELSE frequency_cap END,
 		    frequency_cap_period = COALESCE(NULLIF($7, ''), frequency_cap_period),
 		    status = COALESCE(NULLIF($8, ''), status),
+		    advertiser = COALESCE($9, advertiser),
+		    competitive_exclusions = COALESCE($10, competitive_exclusions),
+		    roadblock_mode = COALESCE($11, roadblock_mode),
+		    creative_rotation = COALESCE(NULLIF($12, ''), creative_rotation),
+		    line_type = COALESCE(NULLIF($13, ''), line_type),
+		    start_date = CASE WHEN $18 THEN NULL ELSE COALESCE($14, start_date) END,
+		    end_date = CASE WHEN $19 THEN NULL ELSE COALESCE($15, end_date) END,
+		    impression_goal = COALESCE($16, impression_goal),
 		    updated_at = NOW()
-		WHERE id = $1
-		RETURNING id, campaign_id, name, priority, weight, sov_percentage, frequency_cap, frequency_cap_period, status, created_at, updated_at
-	`, id, req.Name, req.Priority, weightVal, sovVal, req.FrequencyCap, req.FrequencyCapPeriod, req.Status).Scan(
-		&li.ID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.CreatedAt, &li.UpdatedAt)
+		WHERE id = $1 AND ($17 = 0 OR network_id = $17)
+		RETURNING `+lineItemColumns,
+		id, req.Name, req.Priority, weightVal, sovVal, req.FrequencyCap, req.FrequencyCapPeriod, req.Status, req.Advertiser, req.CompetitiveExclusions, req.RoadblockMode, req.CreativeRotation, req.LineType, req.StartDate, req.EndDate, req.ImpressionGoal, networkID, req.ClearStartDate, req.ClearEndDate))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &li, nil
+	return li, nil
 }
 
 // DeleteLineItem deletes a line item
-func (s *PostgresStore) DeleteLineItem(ctx context.Context, id int) error {
-	_, err := s.pool.Exec(ctx, `DELETE FROM line_items WHERE id = $1`, id)
+func (s *PostgresStore) DeleteLineItem(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM line_items WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
 	return err
 }
 
@@ -447,16 +541,80 @@ func (s *PostgresStore) SetTargetingRules(ctx context.Context, lineItemID int, r
 	return tx.Commit(ctx)
 }
 
+// Daypart operations
+
+// GetDayparts returns the dayparting schedule for a line item
+func (s *PostgresStore) GetDayparts(ctx context.Context, lineItemID int) ([]models.Daypart, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, line_item_id, day_of_week, start_time, end_time
+		FROM line_item_dayparts
+		WHERE line_item_id = $1
+		ORDER BY day_of_week, start_time
+	`, lineItemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dayparts []models.Daypart
+	for rows.Next() {
+		var dp models.Daypart
+		if err := rows.Scan(&dp.ID, &dp.LineItemID, &dp.DayOfWeek, &dp.StartTime, &dp.EndTime); err != nil {
+			return nil, err
+		}
+		dayparts = append(dayparts, dp)
+	}
+	return dayparts, nil
+}
+
+// SetDayparts replaces the dayparting schedule for a line item
+func (s *PostgresStore) SetDayparts(ctx context.Context, lineItemID int, dayparts []models.DaypartInput) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM line_item_dayparts WHERE line_item_id = $1`, lineItemID)
+	if err != nil {
+		return err
+	}
+
+	for _, dp := range dayparts {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO line_item_dayparts (line_item_id, day_of_week, start_time, end_time)
+			VALUES ($1, $2, $3, $4)
+		`, lineItemID, dp.DayOfWeek, dp.StartTime, dp.EndTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
 // Creative operations
 
+const creativeColumns = `id, network_id, line_item_id, name, width, height, image_url, click_url, status, weight, created_at, updated_at,
+	submitted_at, reviewed_by, reviewed_at, rejection_reason`
+
+func scanCreative(row pgx.Row) (*models.Creative, error) {
+	var c models.Creative
+	if err := row.Scan(&c.ID, &c.NetworkID, &c.LineItemID, &c.Name, &c.Width, &c.Height, &c.ImageURL, &c.ClickURL, &c.Status, &c.Weight, &c.CreatedAt, &c.UpdatedAt,
+		&c.SubmittedAt, &c.ReviewedBy, &c.ReviewedAt, &c.RejectionReason); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
 // ListCreatives returns creatives for a line item
-func (s *PostgresStore) ListCreatives(ctx context.Context, lineItemID int) ([]models.Creative, error) {
+func (s *PostgresStore) ListCreatives(ctx context.Context, networkID, lineItemID int) ([]models.Creative, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, line_item_id, name, width, height, image_url, click_url, status, created_at, updated_at
+		SELECT `+creativeColumns+`
 		FROM creatives
-		WHERE line_item_id = $1
+		WHERE line_item_id = $1 AND ($2 = 0 OR network_id = $2)
 		ORDER BY created_at DESC
-	`, lineItemID)
+	`, lineItemID, networkID)
 	if err != nil {
 		return nil, err
 	}
@@ -464,55 +622,63 @@ func (s *PostgresStore) ListCreatives(ctx context.Context, lineItemID int) ([]mo
 
 	var creatives []models.Creative
 	for rows.Next() {
-		var c models.Creative
-		if err := rows.Scan(&c.ID, &c.LineItemID, &c.Name, &c.Width, &c.Height, &c.ImageURL, &c.ClickURL, &c.Status, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		c, err := scanCreative(rows)
+		if err != nil {
 			return nil, err
 		}
-		creatives = append(creatives, c)
+		creatives = append(creatives, *c)
 	}
 	return creatives, nil
 }
 
 // GetCreative returns a creative by ID
-func (s *PostgresStore) GetCreative(ctx context.Context, id int) (*models.Creative, error) {
-	var c models.Creative
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, line_item_id, name, width, height, image_url, click_url, status, created_at, updated_at
-		FROM creatives WHERE id = $1
-	`, id).Scan(&c.ID, &c.LineItemID, &c.Name, &c.Width, &c.Height, &c.ImageURL, &c.ClickURL, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+func (s *PostgresStore) GetCreative(ctx context.Context, networkID, id int) (*models.Creative, error) {
+	c, err := scanCreative(s.pool.QueryRow(ctx, `
+		SELECT `+creativeColumns+` FROM creatives WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
 }
 
-// CreateCreative creates a new creative
-func (s *PostgresStore) CreateCreative(ctx context.Context, req *models.CreateCreativeRequest) (*models.Creative, error) {
+// CreateCreative creates a new creative in its line item's network, as a
+// draft unless it is sent straight for review. It returns nil if the line
+// item isn't in the network.
+func (s *PostgresStore) CreateCreative(ctx context.Context, networkID int, req *models.CreateCreativeRequest) (*models.Creative, error) {
 	status := req.Status
 	if status == "" {
-		status = "active"
+		status = models.CreativeDraft
+	}
+	weight := req.Weight
+	if weight <= 0 {
+		weight = 100
 	}
 
-	var c models.Creative
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO creatives (line_item_id, name, width, height, image_url, click_url, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
-		RETURNING id, line_item_id, name, width, height, image_url, click_url, status, created_at, updated_at
-	`, req.LineItemID, req.Name, req.Width, req.Height, req.ImageURL, req.ClickURL, status).Scan(
-		&c.ID, &c.LineItemID, &c.Name, &c.Width, &c.Height, &c.ImageURL, &c.ClickURL, &c.Status, &c.CreatedAt, &c.UpdatedAt)
-	if err != nil {
+	li, err := s.GetLineItem(ctx, networkID, req.LineItemID)
+	if err != nil || li == nil {
 		return nil, err
 	}
-	return &c, nil
+
+	return scanCreative(s.pool.QueryRow(ctx, `
+		INSERT INTO creatives (network_id, line_item_id, name, width, height, image_url, click_url, status, weight, created_at, updated_at, submitted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW(), CASE WHEN $8 = 'pending_review' THEN NOW() END)
+		RETURNING `+creativeColumns,
+		li.NetworkID, req.LineItemID, req.Name, req.Width, req.Height, req.ImageURL, req.ClickURL, status, weight))
 }
 
-// UpdateCreative updates an existing creative
-func (s *PostgresStore) UpdateCreative(ctx context.Context, id int, req *models.UpdateCreativeRequest) (*models.Creative, error) {
-	var c models.Creative
-	err := s.pool.QueryRow(ctx, `
+// UpdateCreative updates an existing creative whose status is still from,
+// changing its status too if req has one, in one statement. Sending it for
+// review stamps submitted_at. Approving and rejecting go through
+// TransitionCreative. It returns nil if the creative isn't in the network or
+// its status is no longer from, and then nothing is changed, so an edit can't
+// land on a creative that was approved in the meantime without going back
+// for review.
+func (s *PostgresStore) UpdateCreative(ctx context.Context, networkID, id int, from string, req *models.UpdateCreativeRequest) (*models.Creative, error) {
+	c, err := scanCreative(s.pool.QueryRow(ctx, `
 		UPDATE creatives
 		SET name = COALESCE(NULLIF($2, ''), name),
 		    width = CASE WHEN $3 > 0 THEN $3 ELSE width END,
@@ -520,45 +686,295 @@ func (s *PostgresStore) UpdateCreative(ctx context.Context, id int, req *models.
 		    image_url = COALESCE(NULLIF($5, ''), image_url),
 		    click_url = COALESCE(NULLIF($6, ''), click_url),
 		    status = COALESCE(NULLIF($7, ''), status),
+		    submitted_at = CASE WHEN $7 = 'pending_review' THEN NOW() ELSE submitted_at END,
+		    weight = CASE WHEN $8 > 0 THEN $8 ELSE weight END,
 		    updated_at = NOW()
-		WHERE id = $1
-		RETURNING id, line_item_id, name, width, height, image_url, click_url, status, created_at, updated_at
-	`, id, req.Name, req.Width, req.Height, req.ImageURL, req.ClickURL, req.Status).Scan(
-		&c.ID, &c.LineItemID, &c.Name, &c.Width, &c.Height, &c.ImageURL, &c.ClickURL, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+		WHERE id = $1 AND ($9 = 0 OR network_id = $9) AND status = $10
+		RETURNING `+creativeColumns,
+		id, req.Name, req.Width, req.Height, req.ImageURL, req.ClickURL, req.Status, req.Weight, networkID, from))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
+}
+
+// TransitionCreative changes a creative's status from one status to another.
+// Sending it for review stamps submitted_at; approving or rejecting it
+// records the reviewer, and the reason of a rejection. It returns nil if the
+// creative isn't in the network or its status is no longer from, so
+// concurrent changes can't skip a step.
+func (s *PostgresStore) TransitionCreative(ctx context.Context, networkID, id int, from, to, reviewer, reason string) (*models.Creative, error) {
+	c, err := scanCreative(s.pool.QueryRow(ctx, `
+		UPDATE creatives
+		SET status = $4,
+		    submitted_at = CASE WHEN $4 = 'pending_review' THEN NOW() ELSE submitted_at END,
+		    reviewed_by = CASE WHEN $4 IN ('approved', 'rejected') THEN $5 ELSE reviewed_by END,
+		    reviewed_at = CASE WHEN $4 IN ('approved', 'rejected') THEN NOW() ELSE reviewed_at END,
+		    rejection_reason = CASE WHEN $4 = 'rejected' THEN $6 WHEN $4 = 'approved' THEN '' ELSE rejection_reason END,
+		    updated_at = NOW()
+		WHERE id = $1 AND ($2 = 0 OR network_id = $2) AND status = $3
+		RETURNING `+creativeColumns,
+		id, networkID, from, to, reviewer, reason))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// ListPendingCreatives returns the creatives waiting for review, longest
+// waiting first
+func (s *PostgresStore) ListPendingCreatives(ctx context.Context, networkID int) ([]models.Creative, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+creativeColumns+`
+		FROM creatives
+		WHERE status = 'pending_review' AND ($1 = 0 OR network_id = $1)
+		ORDER BY submitted_at, id
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creatives []models.Creative
+	for rows.Next() {
+		c, err := scanCreative(rows)
+		if err != nil {
+			return nil, err
+		}
+		creatives = append(creatives, *c)
+	}
+	return creatives, rows.Err()
 }
 
 // DeleteCreative deletes a creative
-func (s *PostgresStore) DeleteCreative(ctx context.Context, id int) error {
-	_, err := s.pool.Exec(ctx, `DELETE FROM creatives WHERE id = $1`, id)
+func (s *PostgresStore) DeleteCreative(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM creatives WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
 	return err
 }
 
 // Event operations
 
-// RecordEvent records a tracking event
+// RecordEvent records a tracking event. An event landing in an hour that
+// has already been rolled up marks the hour for the aggregator to roll up
+// again.
 func (s *PostgresStore) RecordEvent(ctx context.Context, event *models.Event) error {
-	_, err := s.pool.Exec(ctx, `
-		INSERT INTO events (event_type, impression_id, line_item_id, creative_id, user_id, country, platform, ad_unit, section, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
-	`, event.EventType, event.ImpressionID, event.LineItemID, event.CreativeID, event.UserID, event.Country, event.Platform, event.AdUnit, event.Section)
+	targeting := event.Targeting
+	if targeting == nil {
+		targeting = map[string]string{}
+	}
+	targetingJSON, err := json.Marshal(targeting)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+		WITH e AS (
+			INSERT INTO events (network_id, event_type, impression_id, line_item_id, creative_id, user_id, country, platform, ad_unit, section, targeting, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+			RETURNING created_at
+		)
+		INSERT INTO rollup_dirty_hours (hour)
+		SELECT date_trunc('hour', e.created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+		FROM e
+		JOIN rollup_state r ON r.name = $12 AND e.created_at < r.rolled_until
+		ON CONFLICT (hour) DO NOTHING
+	`, event.NetworkID, event.EventType, event.ImpressionID, event.LineItemID, event.CreativeID, event.UserID, event.Country, event.Platform, event.AdUnit, event.Section, targetingJSON, rollupName)
 	return err
 }
 
-// GetActiveLineItemsWithCreatives returns all active line items with their targeting rules and creatives
+// InsertAdOpportunities writes a batch of ad slot opportunities
+func (s *PostgresStore) InsertAdOpportunities(ctx context.Context, opportunities []models.AdOpportunity) error {
+	rows := make([][]interface{}, 0, len(opportunities))
+	for _, o := range opportunities {
+		targetingJSON, err := json.Marshal(o.Targeting)
+		if err != nil {
+			return err
+		}
+		var lineItemID *int
+		if o.LineItemID > 0 {
+			id := o.LineItemID
+			lineItemID = &id
+		}
+		rows = append(rows, []interface{}{
+			o.NetworkID, o.AdUnit, o.Width, o.Height, targetingJSON, o.Platform, o.Country,
+			o.Filled, o.Reason, lineItemID, o.SampleRate, o.CreatedAt,
+		})
+	}
+
+	_, err := s.pool.CopyFrom(ctx,
+		pgx.Identifier{"ad_opportunities"},
+		[]string{"network_id", "ad_unit", "width", "height", "targeting", "platform", "country", "filled", "reason", "line_item_id", "sample_rate", "created_at"},
+		pgx.CopyFromRows(rows),
+	)
+	return err
+}
+
+// CreativeStats holds delivery counts for a creative, used by CTR-optimized rotation
+type CreativeStats struct {
+	Impressions int
+	Clicks      int
+}
+
+// GetCreativeStats returns impressions and clicks per creative since the
+// given time. It reads the hourly rollups only, so hours not yet rolled up
+// are left out.
+func (s *PostgresStore) GetCreativeStats(ctx context.Context, since time.Time) (map[int]CreativeStats, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT creative_id, COALESCE(SUM(impressions), 0), COALESCE(SUM(clicks), 0)
+		FROM event_rollups_hourly
+		WHERE hour >= $1 AND creative_id > 0
+		GROUP BY creative_id
+	`, since.Truncate(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int]CreativeStats)
+	for rows.Next() {
+		var id int
+		var cs CreativeStats
+		if err := rows.Scan(&id, &cs.Impressions, &cs.Clicks); err != nil {
+			return nil, err
+		}
+		stats[id] = cs
+	}
+	return stats, nil
+}
+
+// TrafficSegment is the ad request volume for one ad unit and key-value
+// combination, used as the traffic model for forecasting
+type TrafficSegment struct {
+	AdUnit   string
+	Country  string
+	Section  string
+	Platform string
+	Requests int
+}
+
+// GetTrafficSegments returns a network's ad slot requests, filled or not,
+// grouped by ad unit, country, section and platform between the given times.
+// Requests come from the sampled request log and are scaled back up by its
+// sample rate.
+func (s *PostgresStore) GetTrafficSegments(ctx context.Context, networkID int, since, until time.Time) ([]TrafficSegment, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT ad_unit, country, COALESCE(targeting->>'section', ''), platform,
+			ROUND(SUM(1.0 / sample_rate))::int
+		FROM ad_opportunities
+		WHERE ($1 = 0 OR network_id = $1) AND created_at >= $2 AND created_at < $3
+		GROUP BY 1, 2, 3, 4
+	`, networkID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []TrafficSegment
+	for rows.Next() {
+		var ts TrafficSegment
+		if err := rows.Scan(&ts.AdUnit, &ts.Country, &ts.Section, &ts.Platform, &ts.Requests); err != nil {
+			return nil, err
+		}
+		segments = append(segments, ts)
+	}
+	return segments, rows.Err()
+}
+
+// GetFirstOpportunity returns the time of a network's oldest logged ad slot
+// request. ok is false if none has been logged.
+func (s *PostgresStore) GetFirstOpportunity(ctx context.Context, networkID int) (time.Time, bool, error) {
+	var first *time.Time
+	err := s.pool.QueryRow(ctx, `
+		SELECT MIN(created_at) FROM ad_opportunities WHERE $1 = 0 OR network_id = $1
+	`, networkID).Scan(&first)
+	if err != nil || first == nil {
+		return time.Time{}, false, err
+	}
+	return *first, true, nil
+}
+
+// GetLineItemDelivery returns impressions delivered by each of a network's
+// line items with an impression goal (every network's for networkID 0),
+// counted from the hour its flight starts. Completed hours come from the
+// rollups and the rest from raw events.
+func (s *PostgresStore) GetLineItemDelivery(ctx context.Context, networkID int) (map[int]int, error) {
+	rows, err := s.pool.Query(ctx, `
+		WITH watermark AS (
+			SELECT COALESCE((SELECT rolled_until FROM rollup_state WHERE name = $2), '-infinity'::timestamptz) AS t
+		),
+		delivered AS (
+			SELECT r.line_item_id, r.hour, r.impressions
+			FROM event_rollups_hourly r, watermark w
+			WHERE r.hour < w.t AND r.impressions > 0
+			UNION ALL
+			SELECT e.line_item_id, e.created_at, 1
+			FROM events e, watermark w
+			WHERE e.created_at >= w.t AND e.event_type = 'impression'
+		)
+		SELECT li.id, COALESCE(SUM(d.impressions), 0)
+		FROM line_items li
+		JOIN delivered d ON d.line_item_id = li.id
+			AND d.hour >= date_trunc('hour', COALESCE(li.start_date, li.created_at))
+		WHERE li.impression_goal > 0 AND ($1 = 0 OR li.network_id = $1)
+		GROUP BY li.id
+	`, networkID, rollupName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery := make(map[int]int)
+	for rows.Next() {
+		var id, impressions int
+		if err := rows.Scan(&id, &impressions); err != nil {
+			return nil, err
+		}
+		delivery[id] = impressions
+	}
+	return delivery, rows.Err()
+}
+
+// GetLineItemOwners returns the network of every line item, in any status,
+// and the line item of every creative, for resolving tracking events
+func (s *PostgresStore) GetLineItemOwners(ctx context.Context) (lineItemNetworks map[int]int, creativeLineItems map[int]int, err error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT li.id, li.network_id, cr.id
+		FROM line_items li
+		LEFT JOIN creatives cr ON cr.line_item_id = li.id
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	lineItemNetworks = make(map[int]int)
+	creativeLineItems = make(map[int]int)
+	for rows.Next() {
+		var lineItemID, networkID int
+		var creativeID *int
+		if err := rows.Scan(&lineItemID, &networkID, &creativeID); err != nil {
+			return nil, nil, err
+		}
+		lineItemNetworks[lineItemID] = networkID
+		if creativeID != nil {
+			creativeLineItems[*creativeID] = lineItemID
+		}
+	}
+	return lineItemNetworks, creativeLineItems, rows.Err()
+}
+
+// GetActiveLineItemsWithCreatives returns all active line items of every active network with their targeting rules and creatives
 func (s *PostgresStore) GetActiveLineItemsWithCreatives(ctx context.Context) ([]models.LineItem, error) {
 	// Get active line items
 	rows, err := s.pool.Query(ctx, `
-		SELECT li.id, li.campaign_id, li.name, li.priority, li.weight, li.sov_percentage, li.frequency_cap, li.frequency_cap_period, li.status, li.created_at, li.updated_at
+		SELECT li.id, li.network_id, li.campaign_id, li.name, li.priority, li.weight, li.sov_percentage, li.frequency_cap, li.frequency_cap_period, li.status, li.advertiser, li.competitive_exclusions, li.roadblock_mode, li.creative_rotation, li.line_type, li.start_date, li.end_date, li.impression_goal, li.created_at, li.updated_at,
+		       COALESCE(c.timezone, ''), COALESCE(c.advertiser_id, 0)
 		FROM line_items li
 		JOIN campaigns c ON li.campaign_id = c.id
-		WHERE li.status = 'active' AND c.status = 'active'
+		JOIN networks n ON n.id = li.network_id
+		WHERE li.status = 'active' AND c.status = 'active' AND n.status = 'active'
 		ORDER BY li.priority DESC
 	`)
 	if err != nil {
@@ -568,11 +984,15 @@ func (s *PostgresStore) GetActiveLineItemsWithCreatives(ctx context.Context) ([]
 
 	var items []models.LineItem
 	for rows.Next() {
-		var li models.LineItem
-		if err := rows.Scan(&li.ID, &li.CampaignID, &li.Name, &li.Priority, &li.Weight, &li.SOVPercentage, &li.FrequencyCap, &li.FrequencyCapPeriod, &li.Status, &li.CreatedAt, &li.UpdatedAt); err != nil {
+		var timezone string
+		var advertiserID int
+		li, err := scanLineItem(rows, &timezone, &advertiserID)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, li)
+		li.Timezone = timezone
+		li.AdvertiserID = advertiserID
+		items = append(items, *li)
 	}
 
 	// Get targeting rules, creatives, and ad unit IDs for each line item
@@ -583,28 +1003,30 @@ func (s *PostgresStore) GetActiveLineItemsWithCreatives(ctx context.Context) ([]
 		}
 		items[i].TargetingRules = rules
 
-		creatives, err := s.ListCreatives(ctx, items[i].ID)
+		creatives, err := s.ListCreatives(ctx, items[i].NetworkID, items[i].ID)
 		if err != nil {
 			return nil, err
 		}
 		items[i].Creatives = creatives
 
-		// Get ad unit IDs
-		adUnitRows, err := s.pool.Query(ctx, `
-			SELECT ad_unit_id FROM line_item_ad_units WHERE line_item_id = $1
-		`, items[i].ID)
+		dayparts, err := s.GetDayparts(ctx, items[i].ID)
 		if err != nil {
 			return nil, err
 		}
-		for adUnitRows.Next() {
-			var adUnitID int
-			if err := adUnitRows.Scan(&adUnitID); err != nil {
-				adUnitRows.Close()
-				return nil, err
-			}
-			items[i].AdUnitIDs = append(items[i].AdUnitIDs, adUnitID)
+		items[i].Dayparts = dayparts
+
+		// Get ad unit targets; the cache resolves them into ad unit IDs
+		targets, err := s.GetLineItemAdUnits(ctx, items[i].ID)
+		if err != nil {
+			return nil, err
 		}
-		adUnitRows.Close()
+		items[i].AdUnitTargets = targets
+
+		placements, err := s.GetLineItemPlacements(ctx, items[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		items[i].PlacementIDs = placements
 	}
 
 	return items, nil
@@ -635,22 +1057,36 @@ type CampaignReport struct {
 	CTR          float64 `json:"ctr"`
 }
 
-// GetReportSummary returns overall stats
-func (s *PostgresStore) GetReportSummary(ctx context.Context, startDate, endDate time.Time, adUnit string) (*ReportSummary, error) {
+// advertiserFilter returns the condition restricting column, a line item ID,
+// to the line items of an advertiser's campaigns, or "" when advertiserID is 0
+func advertiserFilter(args *[]interface{}, column string, advertiserID int) string {
+	if advertiserID <= 0 {
+		return ""
+	}
+	*args = append(*args, advertiserID)
+	return fmt.Sprintf(` AND %s IN (
+			SELECT li.id FROM line_items li JOIN campaigns c ON c.id = li.campaign_id
+			WHERE c.advertiser_id = $%d)`, column, len(*args))
+}
+
+// GetReportSummary returns overall stats, optionally only for an
+// advertiser's line items
+func (s *PostgresStore) GetReportSummary(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, advertiserID int) (*ReportSummary, error) {
 	var summary ReportSummary
 
-	query := `
+	var args []interface{}
+	query := factsCTE(&args, networkID, startDate, endDate) + `
 		SELECT
-			COALESCE(SUM(CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM events
-		WHERE created_at >= $1 AND created_at < $2`
-	args := []interface{}{startDate, endDate}
+			COALESCE(SUM(impressions), 0) as impressions,
+			COALESCE(SUM(clicks), 0) as clicks,
+			COALESCE(SUM(viewable), 0) as viewable
+		FROM facts
+		WHERE TRUE`
 	if adUnit != "" {
 		query += fmt.Sprintf(" AND ad_unit = $%d", len(args)+1)
 		args = append(args, adUnit)
 	}
+	query += advertiserFilter(&args, "line_item_id", advertiserID)
 
 	err := s.pool.QueryRow(ctx, query, args...).Scan(&summary.TotalImpressions, &summary.TotalClicks, &summary.TotalViewable)
 	if err != nil {
@@ -665,23 +1101,25 @@ func (s *PostgresStore) GetReportSummary(ctx context.Context, startDate, endDate
 	return &summary, nil
 }
 
-// GetDailyReport returns daily stats
-func (s *PostgresStore) GetDailyReport(ctx context.Context, startDate, endDate time.Time, adUnit string) ([]DailyStats, error) {
-	query := `
+// GetDailyReport returns daily stats, with days in the given timezone,
+// optionally only for an advertiser's line items
+func (s *PostgresStore) GetDailyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, adUnit string, advertiserID int) ([]DailyStats, error) {
+	args := []interface{}{timezone}
+	query := factsCTE(&args, networkID, startDate, endDate) + `
 		SELECT
-			DATE(created_at) as date,
-			COALESCE(SUM(CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM events
-		WHERE created_at >= $1 AND created_at < $2`
-	args := []interface{}{startDate, endDate}
+			DATE(hour AT TIME ZONE $1) as date,
+			COALESCE(SUM(impressions), 0) as impressions,
+			COALESCE(SUM(clicks), 0) as clicks,
+			COALESCE(SUM(viewable), 0) as viewable
+		FROM facts
+		WHERE TRUE`
 	if adUnit != "" {
 		query += fmt.Sprintf(" AND ad_unit = $%d", len(args)+1)
 		args = append(args, adUnit)
 	}
+	query += advertiserFilter(&args, "line_item_id", advertiserID)
 	query += `
-		GROUP BY DATE(created_at)
+		GROUP BY 1
 		ORDER BY date DESC`
 
 	rows, err := s.pool.Query(ctx, query, args...)
@@ -704,12 +1142,12 @@ func (s *PostgresStore) GetDailyReport(ctx context.Context, startDate, endDate t
 }
 
 // GetCampaignReport returns stats for a specific campaign
-func (s *PostgresStore) GetCampaignReport(ctx context.Context, campaignID int, startDate, endDate time.Time) (*CampaignReport, error) {
+func (s *PostgresStore) GetCampaignReport(ctx context.Context, networkID, campaignID int, startDate, endDate time.Time) (*CampaignReport, error) {
 	var report CampaignReport
 	report.CampaignID = campaignID
 
 	// Get campaign name
-	campaign, err := s.GetCampaign(ctx, campaignID)
+	campaign, err := s.GetCampaign(ctx, networkID, campaignID)
 	if err != nil {
 		return nil, err
 	}
@@ -718,15 +1156,16 @@ func (s *PostgresStore) GetCampaignReport(ctx context.Context, campaignID int, s
 	}
 	report.CampaignName = campaign.Name
 
-	err = s.pool.QueryRow(ctx, `
+	args := []interface{}{campaignID}
+	query := factsCTE(&args, networkID, startDate, endDate) + `
 		SELECT
-			COALESCE(SUM(CASE WHEN e.event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN e.event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN e.event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM events e
-		JOIN line_items li ON e.line_item_id = li.id
-		WHERE li.campaign_id = $1 AND e.created_at >= $2 AND e.created_at < $3
-	`, campaignID, startDate, endDate).Scan(&report.Impressions, &report.Clicks, &report.Viewable)
+			COALESCE(SUM(f.impressions), 0) as impressions,
+			COALESCE(SUM(f.clicks), 0) as clicks,
+			COALESCE(SUM(f.viewable), 0) as viewable
+		FROM facts f
+		JOIN line_items li ON f.line_item_id = li.id
+		WHERE li.campaign_id = $1`
+	err = s.pool.QueryRow(ctx, query, args...).Scan(&report.Impressions, &report.Clicks, &report.Viewable)
 	if err != nil {
 		return nil, err
 	}
@@ -738,6 +1177,66 @@ func (s *PostgresStore) GetCampaignReport(ctx context.Context, campaignID int, s
 	return &report, nil
 }
 
+// HourlyStats represents stats for an hour of the day
+type HourlyStats struct {
+	Hour        int     `json:"hour"`
+	Impressions int     `json:"impressions"`
+	Clicks      int     `json:"clicks"`
+	Viewable    int     `json:"viewable"`
+	CTR         float64 `json:"ctr"`
+}
+
+// GetHourlyReport returns stats grouped by hour of day in the given timezone,
+// optionally restricted to an advertiser, campaign or line item
+func (s *PostgresStore) GetHourlyReport(ctx context.Context, networkID int, startDate, endDate time.Time, timezone string, campaignID, lineItemID int, adUnit string, advertiserID int) ([]HourlyStats, error) {
+	args := []interface{}{timezone}
+	cte := factsCTE(&args, networkID, startDate, endDate)
+	whereExtra := ""
+	if campaignID > 0 {
+		whereExtra += fmt.Sprintf(" AND f.line_item_id IN (SELECT id FROM line_items WHERE campaign_id = $%d)", len(args)+1)
+		args = append(args, campaignID)
+	}
+	if lineItemID > 0 {
+		whereExtra += fmt.Sprintf(" AND f.line_item_id = $%d", len(args)+1)
+		args = append(args, lineItemID)
+	}
+	if adUnit != "" {
+		whereExtra += fmt.Sprintf(" AND f.ad_unit = $%d", len(args)+1)
+		args = append(args, adUnit)
+	}
+	whereExtra += advertiserFilter(&args, "f.line_item_id", advertiserID)
+
+	query := cte + `
+		SELECT
+			EXTRACT(HOUR FROM f.hour AT TIME ZONE $1)::int as hour_of_day,
+			COALESCE(SUM(f.impressions), 0) as impressions,
+			COALESCE(SUM(f.clicks), 0) as clicks,
+			COALESCE(SUM(f.viewable), 0) as viewable
+		FROM facts f
+		WHERE TRUE` + whereExtra + `
+		GROUP BY hour_of_day
+		ORDER BY hour_of_day`
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []HourlyStats
+	for rows.Next() {
+		var s HourlyStats
+		if err := rows.Scan(&s.Hour, &s.Impressions, &s.Clicks, &s.Viewable); err != nil {
+			return nil, err
+		}
+		if s.Impressions > 0 {
+			s.CTR = float64(s.Clicks) / float64(s.Impressions) * 100
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
 // KeyValueStats represents stats for a key-value combination
 type KeyValueStats struct {
 	Key         string  `json:"key"`
@@ -748,49 +1247,61 @@ type KeyValueStats struct {
 	CTR         float64 `json:"ctr"`
 }
 
+// FillRateStats represents ad request and fill counts for one value of a
+// grouping (ad unit or key-value). Counts are scaled up by the sample rate.
+type FillRateStats struct {
+	Key      string         `json:"key"`
+	Value    string         `json:"value"`
+	Requests int            `json:"requests"`
+	Filled   int            `json:"filled"`
+	Unfilled int            `json:"unfilled"`
+	FillRate float64        `json:"fill_rate"`
+	Reasons  map[string]int `json:"unfilled_reasons"`
+}
+
+// RequestKeyStats represents how often pages send a targeting key
+type RequestKeyStats struct {
+	Key            string `json:"key"`
+	Requests       int    `json:"requests"`
+	DistinctValues int    `json:"distinct_values"`
+}
+
 // LineItemStats represents stats for a line item
 type LineItemStats struct {
-	LineItemID   int     `json:"line_item_id"`
-	LineItemName string  `json:"line_item_name"`
-	CampaignName string  `json:"campaign_name"`
-	Impressions  int     `json:"impressions"`
-	Clicks       int     `json:"clicks"`
-	Viewable     int     `json:"viewable"`
-	CTR          float64 `json:"ctr"`
+	LineItemID     int     `json:"line_item_id"`
+	LineItemName   string  `json:"line_item_name"`
+	CampaignName   string  `json:"campaign_name"`
+	AdvertiserID   int     `json:"advertiser_id"`
+	AdvertiserName string  `json:"advertiser_name"`
+	Impressions    int     `json:"impressions"`
+	Clicks         int     `json:"clicks"`
+	Viewable       int     `json:"viewable"`
+	CTR            float64 `json:"ctr"`
 }
 
-// GetKeyValueReport returns stats grouped by a specific key
-func (s *PostgresStore) GetKeyValueReport(ctx context.Context, key string, startDate, endDate time.Time, adUnit string) ([]KeyValueStats, error) {
-	var columnName string
-	switch key {
-	case "country":
-		columnName = "country"
-	case "section":
-		columnName = "section"
-	case "platform":
-		columnName = "platform"
-	case "ad_unit":
-		columnName = "ad_unit"
-	default:
-		columnName = "country"
-	}
+// GetKeyValueReport returns stats grouped by a specific key, optionally only
+// for an advertiser's line items
+func (s *PostgresStore) GetKeyValueReport(ctx context.Context, networkID int, key string, startDate, endDate time.Time, adUnit string, advertiserID int) ([]KeyValueStats, error) {
+	columnName := keyValueColumn(key)
 
-	args := []interface{}{startDate, endDate}
+	var args []interface{}
+	cte := factsCTE(&args, networkID, startDate, endDate)
 	whereExtra := ""
 	if adUnit != "" {
 		whereExtra = fmt.Sprintf(" AND ad_unit = $%d", len(args)+1)
 		args = append(args, adUnit)
 	}
+	whereExtra += advertiserFilter(&args, "line_item_id", advertiserID)
 
-	query := `
+	query := cte + `
 		SELECT
-			COALESCE(` + columnName + `, 'unknown') as value,
-			COALESCE(SUM(CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM events
-		WHERE created_at >= $1 AND created_at < $2` + whereExtra + `
-		GROUP BY ` + columnName + `
+			COALESCE(NULLIF(` + columnName + `, ''), 'unknown') as value,
+			COALESCE(SUM(impressions), 0) as impressions,
+			COALESCE(SUM(clicks), 0) as clicks,
+			COALESCE(SUM(viewable), 0) as viewable
+		FROM facts
+		WHERE TRUE` + whereExtra + `
+		GROUP BY 1
 		ORDER BY impressions DESC
 	`
 
@@ -815,42 +1326,31 @@ func (s *PostgresStore) GetKeyValueReport(ctx context.Context, key string, start
 	return stats, nil
 }
 
-// GetLineItemReport returns stats grouped by line item
-func (s *PostgresStore) GetLineItemReport(ctx context.Context, startDate, endDate time.Time, adUnit string, creativeSize string) ([]LineItemStats, error) {
-	args := []interface{}{startDate, endDate}
-	eventExtra := ""
-	joinExtra := ""
-
+// GetFillRateReport returns slot requests and fill rate grouped by ad unit
+// (key "ad_unit") or by the value pages sent for a targeting key
+func (s *PostgresStore) GetFillRateReport(ctx context.Context, networkID int, key string, startDate, endDate time.Time, adUnit string) ([]FillRateStats, error) {
+	args := []interface{}{startDate, endDate, networkID}
+	valueExpr := "ad_unit"
+	if key != "ad_unit" {
+		args = append(args, key)
+		valueExpr = fmt.Sprintf("targeting->>$%d", len(args))
+	}
+	whereExtra := ""
 	if adUnit != "" {
-		eventExtra += fmt.Sprintf(" AND e.ad_unit = $%d", len(args)+1)
+		whereExtra = fmt.Sprintf(" AND ad_unit = $%d", len(args)+1)
 		args = append(args, adUnit)
 	}
-	if creativeSize != "" {
-		parts := strings.SplitN(creativeSize, "x", 2)
-		if len(parts) == 2 {
-			w, errW := strconv.Atoi(parts[0])
-			h, errH := strconv.Atoi(parts[1])
-			if errW == nil && errH == nil {
-				joinExtra = fmt.Sprintf(" JOIN creatives cr ON e.creative_id = cr.id AND cr.width = $%d AND cr.height = $%d", len(args)+1, len(args)+2)
-				args = append(args, w, h)
-			}
-		}
-	}
 
 	query := `
 		SELECT
-			li.id,
-			li.name,
-			c.name as campaign_name,
-			COALESCE(SUM(CASE WHEN e.event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN e.event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN e.event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM line_items li
-		JOIN campaigns c ON li.campaign_id = c.id
-		LEFT JOIN events e ON e.line_item_id = li.id AND e.created_at >= $1 AND e.created_at < $2` + eventExtra + `
-		` + joinExtra + `
-		GROUP BY li.id, li.name, c.name
-		ORDER BY impressions DESC`
+			COALESCE(NULLIF(` + valueExpr + `, ''), 'unknown') as value,
+			filled,
+			reason,
+			ROUND(SUM(1.0 / sample_rate))::int as requests
+		FROM ad_opportunities
+		WHERE created_at >= $1 AND created_at < $2 AND ($3 = 0 OR network_id = $3)` + whereExtra + `
+		GROUP BY 1, 2, 3
+	`
 
 	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
@@ -858,101 +1358,162 @@ func (s *PostgresStore) GetLineItemReport(ctx context.Context, startDate, endDat
 	}
 	defer rows.Close()
 
-	var stats []LineItemStats
+	byValue := make(map[string]*FillRateStats)
+	var order []string
 	for rows.Next() {
-		var s LineItemStats
-		if err := rows.Scan(&s.LineItemID, &s.LineItemName, &s.CampaignName, &s.Impressions, &s.Clicks, &s.Viewable); err != nil {
+		var value, reason string
+		var filled bool
+		var requests int
+		if err := rows.Scan(&value, &filled, &reason, &requests); err != nil {
 			return nil, err
 		}
-		if s.Impressions > 0 {
-			s.CTR = float64(s.Clicks) / float64(s.Impressions) * 100
+		fs, ok := byValue[value]
+		if !ok {
+			fs = &FillRateStats{Key: key, Value: value, Reasons: make(map[string]int)}
+			byValue[value] = fs
+			order = append(order, value)
 		}
-		stats = append(stats, s)
+		fs.Requests += requests
+		if filled {
+			fs.Filled += requests
+		} else {
+			fs.Unfilled += requests
+			fs.Reasons[reason] += requests
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make([]FillRateStats, 0, len(order))
+	for _, value := range order {
+		fs := byValue[value]
+		if fs.Requests > 0 {
+			fs.FillRate = float64(fs.Filled) / float64(fs.Requests) * 100
+		}
+		stats = append(stats, *fs)
 	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Requests > stats[j].Requests })
 	return stats, nil
 }
 
-// ExportRow represents a row in the export
-type ExportRow struct {
-	Date         string `json:"date"`
-	CampaignName string `json:"campaign_name"`
-	LineItemName string `json:"line_item_name"`
-	Country      string `json:"country"`
-	Section      string `json:"section"`
-	Platform     string `json:"platform"`
-	Impressions  int    `json:"impressions"`
-	Clicks       int    `json:"clicks"`
-	Viewable     int    `json:"viewable"`
-	CTR          float64 `json:"ctr"`
+// GetRequestKeysReport returns the targeting keys pages send, with request
+// counts and the number of distinct values seen
+func (s *PostgresStore) GetRequestKeysReport(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string) ([]RequestKeyStats, error) {
+	args := []interface{}{startDate, endDate, networkID}
+	whereExtra := ""
+	if adUnit != "" {
+		whereExtra = fmt.Sprintf(" AND ad_unit = $%d", len(args)+1)
+		args = append(args, adUnit)
+	}
+
+	query := `
+		SELECT
+			k.key,
+			ROUND(SUM(1.0 / o.sample_rate))::int as requests,
+			COUNT(DISTINCT o.targeting->>k.key) as distinct_values
+		FROM ad_opportunities o, jsonb_object_keys(o.targeting) AS k(key)
+		WHERE o.created_at >= $1 AND o.created_at < $2 AND ($3 = 0 OR o.network_id = $3)` + whereExtra + `
+		GROUP BY k.key
+		ORDER BY requests DESC
+	`
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []RequestKeyStats
+	for rows.Next() {
+		var ks RequestKeyStats
+		if err := rows.Scan(&ks.Key, &ks.Requests, &ks.DistinctValues); err != nil {
+			return nil, err
+		}
+		stats = append(stats, ks)
+	}
+	return stats, nil
 }
 
-// GetExportData returns detailed data for export
-func (s *PostgresStore) GetExportData(ctx context.Context, startDate, endDate time.Time, groupBy string) ([]ExportRow, error) {
-	var groupColumns, selectColumns string
-
-	switch groupBy {
-	case "daily":
-		groupColumns = "DATE(e.created_at), c.name, li.name"
-		selectColumns = "DATE(e.created_at) as date, c.name as campaign_name, li.name as line_item_name, '' as country, '' as section, '' as platform"
-	case "country":
-		groupColumns = "DATE(e.created_at), c.name, li.name, e.country"
-		selectColumns = "DATE(e.created_at) as date, c.name as campaign_name, li.name as line_item_name, COALESCE(e.country, '') as country, '' as section, '' as platform"
-	case "section":
-		groupColumns = "DATE(e.created_at), c.name, li.name, e.section"
-		selectColumns = "DATE(e.created_at) as date, c.name as campaign_name, li.name as line_item_name, '' as country, COALESCE(e.section, '') as section, '' as platform"
-	case "full":
-		groupColumns = "DATE(e.created_at), c.name, li.name, e.country, e.section, e.platform"
-		selectColumns = "DATE(e.created_at) as date, c.name as campaign_name, li.name as line_item_name, COALESCE(e.country, '') as country, COALESCE(e.section, '') as section, COALESCE(e.platform, '') as platform"
-	default:
-		groupColumns = "DATE(e.created_at), c.name, li.name"
-		selectColumns = "DATE(e.created_at) as date, c.name as campaign_name, li.name as line_item_name, '' as country, '' as section, '' as platform"
+// GetLineItemReport returns stats grouped by line item, optionally only for
+// an advertiser's line items
+func (s *PostgresStore) GetLineItemReport(ctx context.Context, networkID int, startDate, endDate time.Time, adUnit string, creativeSize string, advertiserID int) ([]LineItemStats, error) {
+	var args []interface{}
+	cte := factsCTE(&args, networkID, startDate, endDate)
+	eventExtra := ""
+	joinExtra := ""
+
+	if adUnit != "" {
+		eventExtra += fmt.Sprintf(" AND f.ad_unit = $%d", len(args)+1)
+		args = append(args, adUnit)
+	}
+	if w, h, ok := parseCreativeSize(creativeSize); ok {
+		joinExtra = fmt.Sprintf(" JOIN creatives cr ON f.creative_id = cr.id AND cr.width = $%d AND cr.height = $%d", len(args)+1, len(args)+2)
+		args = append(args, w, h)
+	}
+	whereExtra := " WHERE true" + networkFilter(&args, "li.network_id", networkID)
+	if advertiserID > 0 {
+		whereExtra += fmt.Sprintf(" AND c.advertiser_id = $%d", len(args)+1)
+		args = append(args, advertiserID)
 	}
 
-	query := `
+	query := cte + `
 		SELECT
-			` + selectColumns + `,
-			COALESCE(SUM(CASE WHEN e.event_type = 'impression' THEN 1 ELSE 0 END), 0) as impressions,
-			COALESCE(SUM(CASE WHEN e.event_type = 'click' THEN 1 ELSE 0 END), 0) as clicks,
-			COALESCE(SUM(CASE WHEN e.event_type = 'viewable' THEN 1 ELSE 0 END), 0) as viewable
-		FROM events e
-		JOIN line_items li ON e.line_item_id = li.id
+			li.id,
+			li.name,
+			c.name as campaign_name,
+			COALESCE(c.advertiser_id, 0) as advertiser_id,
+			COALESCE(adv.name, '') as advertiser_name,
+			COALESCE(SUM(f.impressions), 0) as impressions,
+			COALESCE(SUM(f.clicks), 0) as clicks,
+			COALESCE(SUM(f.viewable), 0) as viewable
+		FROM line_items li
 		JOIN campaigns c ON li.campaign_id = c.id
-		WHERE e.created_at >= $1 AND e.created_at < $2
-		GROUP BY ` + groupColumns + `
-		ORDER BY date DESC, impressions DESC
-	`
+		LEFT JOIN advertisers adv ON adv.id = c.advertiser_id
+		LEFT JOIN facts f ON f.line_item_id = li.id` + eventExtra + `
+		` + joinExtra + whereExtra + `
+		GROUP BY li.id, li.name, c.name, c.advertiser_id, adv.name
+		ORDER BY impressions DESC`
 
-	rows, err := s.pool.Query(ctx, query, startDate, endDate)
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var data []ExportRow
+	var stats []LineItemStats
 	for rows.Next() {
-		var r ExportRow
-		var date time.Time
-		if err := rows.Scan(&date, &r.CampaignName, &r.LineItemName, &r.Country, &r.Section, &r.Platform, &r.Impressions, &r.Clicks, &r.Viewable); err != nil {
+		var s LineItemStats
+		if err := rows.Scan(&s.LineItemID, &s.LineItemName, &s.CampaignName, &s.AdvertiserID, &s.AdvertiserName, &s.Impressions, &s.Clicks, &s.Viewable); err != nil {
 			return nil, err
 		}
-		r.Date = date.Format("2006-01-02")
-		if r.Impressions > 0 {
-			r.CTR = float64(r.Clicks) / float64(r.Impressions) * 100
+		if s.Impressions > 0 {
+			s.CTR = float64(s.Clicks) / float64(s.Impressions) * 100
 		}
-		data = append(data, r)
+		stats = append(stats, s)
 	}
-	return data, nil
+	return stats, nil
 }
 
 // Targeting Keys operations
 
+const targetingKeyColumns = `id, network_id, key, values, created_at, updated_at`
+
+func scanTargetingKey(row pgx.Row) (*models.TargetingKey, error) {
+	var k models.TargetingKey
+	var valuesJSON []byte
+	if err := row.Scan(&k.ID, &k.NetworkID, &k.Key, &valuesJSON, &k.CreatedAt, &k.UpdatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(valuesJSON, &k.Values)
+	return &k, nil
+}
+
 // ListTargetingKeys returns all targeting keys
-func (s *PostgresStore) ListTargetingKeys(ctx context.Context) ([]models.TargetingKey, error) {
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, key, values, created_at, updated_at
-		FROM targeting_keys
-		ORDER BY key
-	`)
+func (s *PostgresStore) ListTargetingKeys(ctx context.Context, networkID int) ([]models.TargetingKey, error) {
+	args := []interface{}{}
+	query := `SELECT ` + targetingKeyColumns + ` FROM targeting_keys WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY key`
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -960,92 +1521,110 @@ func (s *PostgresStore) ListTargetingKeys(ctx context.Context) ([]models.Targeti
 
 	var keys []models.TargetingKey
 	for rows.Next() {
-		var k models.TargetingKey
-		var valuesJSON []byte
-		if err := rows.Scan(&k.ID, &k.Key, &valuesJSON, &k.CreatedAt, &k.UpdatedAt); err != nil {
+		k, err := scanTargetingKey(rows)
+		if err != nil {
 			return nil, err
 		}
-		json.Unmarshal(valuesJSON, &k.Values)
-		keys = append(keys, k)
+		keys = append(keys, *k)
 	}
 	return keys, nil
 }
 
-// GetTargetingKey returns a targeting key by key name
-func (s *PostgresStore) GetTargetingKey(ctx context.Context, key string) (*models.TargetingKey, error) {
-	var k models.TargetingKey
-	var valuesJSON []byte
-	err := s.pool.QueryRow(ctx, `
-		SELECT id, key, values, created_at, updated_at
-		FROM targeting_keys WHERE key = $1
-	`, key).Scan(&k.ID, &k.Key, &valuesJSON, &k.CreatedAt, &k.UpdatedAt)
+// GetTargetingKey returns a network's targeting key by key name
+func (s *PostgresStore) GetTargetingKey(ctx context.Context, networkID int, key string) (*models.TargetingKey, error) {
+	k, err := scanTargetingKey(s.pool.QueryRow(ctx, `
+		SELECT `+targetingKeyColumns+` FROM targeting_keys WHERE network_id = $1 AND key = $2
+	`, networkID, key))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(valuesJSON, &k.Values)
-	return &k, nil
+	return k, nil
 }
 
-// UpsertTargetingKey creates or updates a targeting key
-func (s *PostgresStore) UpsertTargetingKey(ctx context.Context, key string, values []string) (*models.TargetingKey, error) {
+// UnknownTargetingKey returns the first of the keys that is not one of the
+// network's targeting keys (any network's for networkID 0), or an empty
+// string if they all are
+func (s *PostgresStore) UnknownTargetingKey(ctx context.Context, networkID int, keys []string) (string, error) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT key FROM targeting_keys WHERE ($1 = 0 OR network_id = $1) AND key = ANY($2)
+	`, networkID, keys)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return "", err
+		}
+		known[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		if !known[key] {
+			return key, nil
+		}
+	}
+	return "", nil
+}
+
+// UpsertTargetingKey creates or updates a network's targeting key
+func (s *PostgresStore) UpsertTargetingKey(ctx context.Context, networkID int, key string, values []string) (*models.TargetingKey, error) {
 	valuesJSON, _ := json.Marshal(values)
-	var k models.TargetingKey
-	var valuesOut []byte
-	err := s.pool.QueryRow(ctx, `
-		INSERT INTO targeting_keys (key, values, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
-		ON CONFLICT (key) DO UPDATE SET
+	return scanTargetingKey(s.pool.QueryRow(ctx, `
+		INSERT INTO targeting_keys (network_id, key, values, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (network_id, key) DO UPDATE SET
 			values = (
 				SELECT jsonb_agg(DISTINCT val)
 				FROM (
 					SELECT jsonb_array_elements_text(targeting_keys.values) AS val
 					UNION
-					SELECT jsonb_array_elements_text($2::jsonb) AS val
+					SELECT jsonb_array_elements_text($3::jsonb) AS val
 				) combined
 			),
 			updated_at = NOW()
-		RETURNING id, key, values, created_at, updated_at
-	`, key, valuesJSON).Scan(&k.ID, &k.Key, &valuesOut, &k.CreatedAt, &k.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	json.Unmarshal(valuesOut, &k.Values)
-	return &k, nil
+		RETURNING `+targetingKeyColumns,
+		networkID, key, valuesJSON))
 }
 
 // AddTargetingKeyValues adds values to a targeting key (creates if not exists)
-func (s *PostgresStore) AddTargetingKeyValues(ctx context.Context, key string, values []string) error {
-	_, err := s.UpsertTargetingKey(ctx, key, values)
+func (s *PostgresStore) AddTargetingKeyValues(ctx context.Context, networkID int, key string, values []string) error {
+	_, err := s.UpsertTargetingKey(ctx, networkID, key, values)
 	return err
 }
 
 // UpdateTargetingKeyValues replaces all values for a targeting key
-func (s *PostgresStore) UpdateTargetingKeyValues(ctx context.Context, key string, values []string) (*models.TargetingKey, error) {
+func (s *PostgresStore) UpdateTargetingKeyValues(ctx context.Context, networkID int, key string, values []string) (*models.TargetingKey, error) {
 	valuesJSON, _ := json.Marshal(values)
-	var k models.TargetingKey
-	var valuesOut []byte
-	err := s.pool.QueryRow(ctx, `
+	k, err := scanTargetingKey(s.pool.QueryRow(ctx, `
 		UPDATE targeting_keys
-		SET values = $2, updated_at = NOW()
-		WHERE key = $1
-		RETURNING id, key, values, created_at, updated_at
-	`, key, valuesJSON).Scan(&k.ID, &k.Key, &valuesOut, &k.CreatedAt, &k.UpdatedAt)
+		SET values = $3, updated_at = NOW()
+		WHERE network_id = $1 AND key = $2
+		RETURNING `+targetingKeyColumns,
+		networkID, key, valuesJSON))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(valuesOut, &k.Values)
-	return &k, nil
+	return k, nil
 }
 
 // DeleteTargetingKey deletes a targeting key
-func (s *PostgresStore) DeleteTargetingKey(ctx context.Context, key string) error {
-	_, err := s.pool.Exec(ctx, `DELETE FROM targeting_keys WHERE key = $1`, key)
+func (s *PostgresStore) DeleteTargetingKey(ctx context.Context, networkID int, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM targeting_keys WHERE network_id = $1 AND key = $2`, networkID, key)
 	return err
 }
 
@@ -1056,10 +1635,12 @@ type CreativeSize struct {
 }
 
 // GetCreativeSizes returns distinct creative sizes
-func (s *PostgresStore) GetCreativeSizes(ctx context.Context) ([]CreativeSize, error) {
+func (s *PostgresStore) GetCreativeSizes(ctx context.Context, networkID int) ([]CreativeSize, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT DISTINCT width, height FROM creatives WHERE status = 'active' ORDER BY width, height
-	`)
+		SELECT DISTINCT width, height FROM creatives
+		WHERE status = 'active' AND ($1 = 0 OR network_id = $1)
+		ORDER BY width, height
+	`, networkID)
 	if err != nil {
 		return nil, err
 	}
@@ -1075,3 +1656,96 @@ func (s *PostgresStore) GetCreativeSizes(ctx context.Context) ([]CreativeSize, e
 	}
 	return sizes, nil
 }
+
+// GetCreativeIDsBySize returns the IDs of all creatives of a size
+func (s *PostgresStore) GetCreativeIDsBySize(ctx context.Context, networkID, width, height int) ([]int, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id FROM creatives WHERE width = $1 AND height = $2 AND ($3 = 0 OR network_id = $3)
+	`, width, height, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// LineItemName holds the names a report shows for a line item. Advertiser
+// and agency IDs are 0 and their names empty when the campaign has none.
+type LineItemName struct {
+	Name           string
+	CampaignID     int
+	CampaignName   string
+	AdvertiserID   int
+	AdvertiserName string
+	AgencyID       int
+	AgencyName     string
+}
+
+// GetLineItemNames returns the name, campaign, advertiser and agency of every
+// line item of a network, keyed by line item ID
+func (s *PostgresStore) GetLineItemNames(ctx context.Context, networkID int) (map[int]LineItemName, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT li.id, li.name, c.id, c.name,
+		       COALESCE(c.advertiser_id, 0), COALESCE(adv.name, ''),
+		       COALESCE(c.agency_id, 0), COALESCE(ag.name, '')
+		FROM line_items li
+		JOIN campaigns c ON li.campaign_id = c.id
+		LEFT JOIN advertisers adv ON adv.id = c.advertiser_id
+		LEFT JOIN agencies ag ON ag.id = c.agency_id
+		WHERE $1 = 0 OR li.network_id = $1
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int]LineItemName)
+	for rows.Next() {
+		var id int
+		var n LineItemName
+		if err := rows.Scan(&id, &n.Name, &n.CampaignID, &n.CampaignName, &n.AdvertiserID, &n.AdvertiserName, &n.AgencyID, &n.AgencyName); err != nil {
+			return nil, err
+		}
+		names[id] = n
+	}
+	return names, nil
+}
+
+// CreativeName holds what a report shows for a creative
+type CreativeName struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// GetCreativeNames returns the name and size of every creative of a
+// network, keyed by creative ID
+func (s *PostgresStore) GetCreativeNames(ctx context.Context, networkID int) (map[int]CreativeName, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, name, width, height FROM creatives WHERE $1 = 0 OR network_id = $1
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int]CreativeName)
+	for rows.Next() {
+		var id int
+		var n CreativeName
+		if err := rows.Scan(&id, &n.Name, &n.Width, &n.Height); err != nil {
+			return nil, err
+		}
+		names[id] = n
+	}
+	return names, nil
+}
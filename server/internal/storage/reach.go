@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/hll"
+)
+
+// ReachStats represents unique reach for an advertiser, campaign or line
+// item. Reach is estimated from HyperLogLog sketches (about 1.6% standard
+// error).
+type ReachStats struct {
+	AdvertiserID   int     `json:"advertiser_id"`
+	AdvertiserName string  `json:"advertiser_name"`
+	CampaignID     int     `json:"campaign_id,omitempty"`
+	CampaignName   string  `json:"campaign_name,omitempty"`
+	LineItemID     int     `json:"line_item_id,omitempty"`
+	LineItemName   string  `json:"line_item_name,omitempty"`
+	Impressions    int     `json:"impressions"`
+	Reach          int64   `json:"reach"`
+	AvgFrequency   float64 `json:"avg_frequency"`
+}
+
+// FrequencyBucket represents how many users saw an ad a given number of times
+type FrequencyBucket struct {
+	Bucket      string  `json:"bucket"`
+	Users       int     `json:"users"`
+	Impressions int     `json:"impressions"`
+	Share       float64 `json:"share"` // percentage of users
+}
+
+// frequencyBuckets are the histogram buckets in order
+var frequencyBuckets = []string{"1", "2", "3", "4-5", "6+"}
+
+// rollupReach replaces the reach sketches of an hour: one per line item over
+// the users it served impressions to
+func rollupReach(ctx context.Context, tx pgx.Tx, hour time.Time) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM reach_rollups_hourly WHERE hour = $1`, hour); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT line_item_id, user_id
+		FROM events
+		WHERE event_type = 'impression' AND line_item_id IS NOT NULL AND user_id <> ''
+			AND created_at >= $1 AND created_at < $1::timestamptz + INTERVAL '1 hour'
+	`, hour)
+	if err != nil {
+		return err
+	}
+	sketches := make(map[int]*hll.Sketch)
+	for rows.Next() {
+		var lineItemID int
+		var userID string
+		if err := rows.Scan(&lineItemID, &userID); err != nil {
+			rows.Close()
+			return err
+		}
+		addToSketch(sketches, lineItemID, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	copyRows := make([][]interface{}, 0, len(sketches))
+	for lineItemID, sketch := range sketches {
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		copyRows = append(copyRows, []interface{}{hour, lineItemID, data})
+	}
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"reach_rollups_hourly"},
+		[]string{"hour", "line_item_id", "sketch"},
+		pgx.CopyFromRows(copyRows),
+	)
+	return err
+}
+
+func addToSketch(sketches map[int]*hll.Sketch, lineItemID int, userID string) {
+	sketch, ok := sketches[lineItemID]
+	if !ok {
+		sketch = hll.New()
+		sketches[lineItemID] = sketch
+	}
+	sketch.Add(userID)
+}
+
+// reachSketches returns a sketch of the users each line item reached between
+// start and end: hourly sketches merged up to the rollup watermark, plus the
+// users of raw events after it
+func (s *PostgresStore) reachSketches(ctx context.Context, lineItemIDs []int, start, end time.Time) (map[int]*hll.Sketch, error) {
+	watermark := rollupWatermark(start, end)
+	sketches := make(map[int]*hll.Sketch)
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT line_item_id, sketch
+		FROM reach_rollups_hourly
+		WHERE line_item_id = ANY($1) AND hour >= $2 AND hour < $3 AND hour < `+watermark,
+		lineItemIDs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var lineItemID int
+		var data []byte
+		if err := rows.Scan(&lineItemID, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		hourly := hll.New()
+		if err := hourly.UnmarshalBinary(data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("line item %d: %w", lineItemID, err)
+		}
+		if sketch, ok := sketches[lineItemID]; ok {
+			sketch.Merge(hourly)
+		} else {
+			sketches[lineItemID] = hourly
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.pool.Query(ctx, `
+		SELECT DISTINCT line_item_id, user_id
+		FROM events
+		WHERE event_type = 'impression' AND line_item_id = ANY($1) AND user_id <> ''
+			AND created_at >= $2 AND created_at < $3 AND created_at >= `+watermark,
+		lineItemIDs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lineItemID int
+		var userID string
+		if err := rows.Scan(&lineItemID, &userID); err != nil {
+			return nil, err
+		}
+		addToSketch(sketches, lineItemID, userID)
+	}
+	return sketches, rows.Err()
+}
+
+// GetReachReport returns impressions, unique reach and average frequency per
+// advertiser (groupBy "advertiser"), campaign (groupBy "campaign") or line
+// item (groupBy "line_item"), optionally restricted to one advertiser,
+// campaign or line item. Advertiser and campaign reach merge the sketches of
+// their line items, so a user reached by several of them counts once.
+func (s *PostgresStore) GetReachReport(ctx context.Context, networkID int, startDate, endDate time.Time, groupBy string, advertiserID, campaignID, lineItemID int) ([]ReachStats, error) {
+	names, err := s.GetLineItemNames(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for id, n := range names {
+		if (advertiserID == 0 || n.AdvertiserID == advertiserID) && (campaignID == 0 || n.CampaignID == campaignID) && (lineItemID == 0 || id == lineItemID) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{ids}
+	query := factsCTE(&args, networkID, startDate, endDate) + `
+		SELECT line_item_id, COALESCE(SUM(impressions), 0)
+		FROM facts
+		WHERE line_item_id = ANY($1)
+		GROUP BY 1`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	impressions := make(map[int]int)
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		impressions[id] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sketches, err := s.reachSketches(ctx, ids, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[int]*ReachStats)
+	groupSketches := make(map[int]*hll.Sketch)
+	for _, id := range ids {
+		n := names[id]
+		key := n.CampaignID
+		switch groupBy {
+		case "advertiser":
+			key = n.AdvertiserID
+		case "line_item":
+			key = id
+		}
+
+		stats, ok := groups[key]
+		if !ok {
+			stats = &ReachStats{AdvertiserID: n.AdvertiserID, AdvertiserName: n.AdvertiserName}
+			if groupBy != "advertiser" {
+				stats.CampaignID = n.CampaignID
+				stats.CampaignName = n.CampaignName
+			}
+			if groupBy == "line_item" {
+				stats.LineItemID = id
+				stats.LineItemName = n.Name
+			}
+			groups[key] = stats
+			groupSketches[key] = hll.New()
+		}
+		stats.Impressions += impressions[id]
+		if sketch, ok := sketches[id]; ok {
+			groupSketches[key].Merge(sketch)
+		}
+	}
+
+	result := make([]ReachStats, 0, len(groups))
+	for key, stats := range groups {
+		stats.Reach = groupSketches[key].Estimate()
+		if stats.Reach > 0 {
+			stats.AvgFrequency = float64(stats.Impressions) / float64(stats.Reach)
+		}
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Impressions != result[j].Impressions {
+			return result[i].Impressions > result[j].Impressions
+		}
+		if result[i].AdvertiserID != result[j].AdvertiserID {
+			return result[i].AdvertiserID < result[j].AdvertiserID
+		}
+		return result[i].CampaignID < result[j].CampaignID || (result[i].CampaignID == result[j].CampaignID && result[i].LineItemID < result[j].LineItemID)
+	})
+	return result, nil
+}
+
+// GetFrequencyReport returns how many users saw 1, 2, 3, 4-5 and 6+
+// impressions between the given times, optionally restricted to an
+// advertiser, campaign or line item. Counts are exact, from the raw events.
+func (s *PostgresStore) GetFrequencyReport(ctx context.Context, networkID int, startDate, endDate time.Time, advertiserID, campaignID, lineItemID int) ([]FrequencyBucket, error) {
+	args := []interface{}{startDate, endDate}
+	whereExtra := networkFilter(&args, "network_id", networkID)
+	if campaignID > 0 {
+		whereExtra += fmt.Sprintf(" AND line_item_id IN (SELECT id FROM line_items WHERE campaign_id = $%d)", len(args)+1)
+		args = append(args, campaignID)
+	}
+	if lineItemID > 0 {
+		whereExtra += fmt.Sprintf(" AND line_item_id = $%d", len(args)+1)
+		args = append(args, lineItemID)
+	}
+	whereExtra += advertiserFilter(&args, "line_item_id", advertiserID)
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT
+			CASE WHEN n = 1 THEN '1' WHEN n = 2 THEN '2' WHEN n = 3 THEN '3' WHEN n <= 5 THEN '4-5' ELSE '6+' END AS bucket,
+			COUNT(*) AS users,
+			SUM(n) AS impressions
+		FROM (
+			SELECT user_id, COUNT(*) AS n
+			FROM events
+			WHERE event_type = 'impression' AND user_id <> ''
+				AND created_at >= $1 AND created_at < $2`+whereExtra+`
+			GROUP BY user_id
+		) per_user
+		GROUP BY 1
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]FrequencyBucket)
+	totalUsers := 0
+	for rows.Next() {
+		var b FrequencyBucket
+		if err := rows.Scan(&b.Bucket, &b.Users, &b.Impressions); err != nil {
+			return nil, err
+		}
+		counts[b.Bucket] = b
+		totalUsers += b.Users
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]FrequencyBucket, 0, len(frequencyBuckets))
+	for _, name := range frequencyBuckets {
+		b := counts[name]
+		b.Bucket = name
+		if totalUsers > 0 {
+			b.Share = float64(b.Users) / float64(totalUsers) * 100
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,520 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const (
+	// DefaultReportLimit is the row limit of a report query that sets none
+	DefaultReportLimit = 1000
+	// MaxReportLimit is the most rows a report query returns
+	MaxReportLimit = 100000
+)
+
+// reportDimensionColumns lists the columns each report dimension adds.
+// Advertiser, agency, campaign, line item, creative and placement add their
+// ID and name.
+var reportDimensionColumns = map[string][]string{
+	"date":       {"date"},
+	"hour":       {"hour"},
+	"advertiser": {"advertiser_id", "advertiser"},
+	"agency":     {"agency_id", "agency"},
+	"campaign":   {"campaign_id", "campaign"},
+	"line_item":  {"line_item_id", "line_item"},
+	"creative":   {"creative_id", "creative"},
+	"size":       {"size"},
+	"ad_unit":    {"ad_unit"},
+	"placement":  {"placement_id", "placement"},
+	"country":    {"country"},
+	"section":    {"section"},
+	"platform":   {"platform"},
+}
+
+// ReportKeyPrefix marks a dimension on a targeting key recorded with events,
+// e.g. "key:brand". Its column has the dimension's name.
+const ReportKeyPrefix = "key:"
+
+// reportKey returns the targeting key of a key dimension
+func reportKey(dimension string) (string, bool) {
+	key, ok := strings.CutPrefix(dimension, ReportKeyPrefix)
+	return key, ok && key != ""
+}
+
+// isReportDimension reports whether a dimension is known or a key dimension
+func isReportDimension(dimension string) bool {
+	if _, ok := reportKey(dimension); ok {
+		return true
+	}
+	_, ok := reportDimensionColumns[dimension]
+	return ok
+}
+
+// dimensionColumns returns the columns a dimension adds
+func dimensionColumns(dimension string) []string {
+	if _, ok := reportKey(dimension); ok {
+		return []string{dimension}
+	}
+	return reportDimensionColumns[dimension]
+}
+
+// reportIDDimensions are filtered by ID rather than name
+var reportIDDimensions = map[string]bool{"advertiser": true, "agency": true, "campaign": true, "line_item": true, "creative": true, "placement": true}
+
+// reportMetrics are the metrics a report query can ask for. Rate metrics are
+// percentages.
+var reportMetrics = map[string]bool{
+	"impressions":  true,
+	"clicks":       true,
+	"viewable":     true,
+	"ctr":          true,
+	"viewability":  true,
+	"unique_users": true,
+}
+
+// defaultReportMetrics are used when a query names none
+var defaultReportMetrics = []string{"impressions", "clicks", "viewable", "ctr"}
+
+// ReportQuery is a validated report builder query. Every name in it is one of
+// the known dimensions, columns or metrics, so backends can use them as SQL
+// identifiers, except key dimensions: their columns are aliased key_<n> and
+// their keys bound as parameters like filter values. Whether the keys exist
+// is checked separately, with UnknownTargetingKey.
+type ReportQuery struct {
+	// NetworkID is the network reported on
+	NetworkID  int
+	Dimensions []string
+	Metrics    []string
+	Filters    []models.ReportFilter
+	Sort       []models.ReportSort
+	// Limit is the row limit. Streaming exports set it to 0 after
+	// validation, for no limit.
+	Limit     int
+	StartDate time.Time
+	EndDate   time.Time
+	// Timezone is the zone date and hour are bucketed in
+	Timezone string
+}
+
+// ReportResult holds the rows of a report query, each with one value per
+// column
+type ReportResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// NewReportQuery validates a report builder request over a network's data
+// for the given date range and timezone
+func NewReportQuery(networkID int, req *models.ReportQueryRequest, startDate, endDate time.Time, timezone string) (*ReportQuery, error) {
+	q := &ReportQuery{NetworkID: networkID, StartDate: startDate, EndDate: endDate, Limit: req.Limit, Timezone: timezone}
+
+	seen := make(map[string]bool)
+	for _, d := range req.Dimensions {
+		if !isReportDimension(d) {
+			return nil, fmt.Errorf("unknown dimension %q", d)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("dimension %q given twice", d)
+		}
+		seen[d] = true
+		q.Dimensions = append(q.Dimensions, d)
+	}
+
+	for _, m := range req.Metrics {
+		if !reportMetrics[m] {
+			return nil, fmt.Errorf("unknown metric %q", m)
+		}
+		if seen[m] {
+			return nil, fmt.Errorf("metric %q given twice", m)
+		}
+		seen[m] = true
+		q.Metrics = append(q.Metrics, m)
+	}
+	if len(q.Metrics) == 0 {
+		q.Metrics = defaultReportMetrics
+	}
+
+	for _, f := range req.Filters {
+		if !isReportDimension(f.Dimension) {
+			return nil, fmt.Errorf("unknown filter dimension %q", f.Dimension)
+		}
+		if f.Operator == "" {
+			f.Operator = "IN"
+		}
+		if f.Operator != "EQ" && f.Operator != "IN" && f.Operator != "NOT_IN" {
+			return nil, fmt.Errorf("filter operator must be EQ, IN or NOT_IN")
+		}
+		if len(f.Values) == 0 {
+			return nil, fmt.Errorf("filter on %q has no values", f.Dimension)
+		}
+		if reportIDDimensions[f.Dimension] {
+			for _, v := range f.Values {
+				if _, err := strconv.Atoi(v); err != nil {
+					return nil, fmt.Errorf("filter on %q takes IDs, got %q", f.Dimension, v)
+				}
+			}
+		}
+		q.Filters = append(q.Filters, f)
+	}
+
+	columns := q.Columns()
+	for _, s := range req.Sort {
+		if !containsColumn(columns, s.Field) {
+			return nil, fmt.Errorf("cannot sort by %q: not a requested dimension or metric", s.Field)
+		}
+		if s.Direction == "" {
+			s.Direction = "desc"
+		}
+		if s.Direction != "asc" && s.Direction != "desc" {
+			return nil, fmt.Errorf("sort direction must be asc or desc")
+		}
+		q.Sort = append(q.Sort, s)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultReportLimit
+	}
+	if q.Limit > MaxReportLimit {
+		q.Limit = MaxReportLimit
+	}
+
+	return q, nil
+}
+
+// Columns returns the result columns: dimension columns, then metrics
+func (q *ReportQuery) Columns() []string {
+	var columns []string
+	for _, d := range q.Dimensions {
+		columns = append(columns, dimensionColumns(d)...)
+	}
+	return append(columns, q.Metrics...)
+}
+
+// Keys returns the targeting keys the query groups or filters by, each once
+func (q *ReportQuery) Keys() []string {
+	var keys []string
+	add := func(dimension string) {
+		if key, ok := reportKey(dimension); ok && !containsColumn(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, d := range q.Dimensions {
+		add(d)
+	}
+	for _, f := range q.Filters {
+		add(f.Dimension)
+	}
+	return keys
+}
+
+// alias returns the SQL alias of a result column: key dimension columns are
+// key_<n> after the key's index in Keys, every other column its name
+func (q *ReportQuery) alias(column string) string {
+	key, ok := reportKey(column)
+	if !ok {
+		return column
+	}
+	for i, k := range q.Keys() {
+		if k == key {
+			return fmt.Sprintf("key_%d", i)
+		}
+	}
+	return column
+}
+
+// IsRateMetric reports whether a column is a percentage metric
+func IsRateMetric(column string) bool {
+	return column == "ctr" || column == "viewability"
+}
+
+// needsUsers reports whether the query has a metric the hourly rollups
+// cannot answer, so it has to read raw events
+func (q *ReportQuery) needsUsers() bool {
+	for _, m := range q.Metrics {
+		if m == "unique_users" {
+			return true
+		}
+	}
+	return false
+}
+
+// groups reports whether the query groups by a dimension
+func (q *ReportQuery) groups(dimension string) bool {
+	for _, d := range q.Dimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// uses reports whether the query groups or filters by a dimension
+func (q *ReportQuery) uses(dimension string) bool {
+	if q.groups(dimension) {
+		return true
+	}
+	for _, f := range q.Filters {
+		if f.Dimension == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// orderBy returns the ORDER BY list: the requested sort, or the dimension
+// columns in order
+func (q *ReportQuery) orderBy() string {
+	var terms []string
+	for _, s := range q.Sort {
+		terms = append(terms, q.alias(s.Field)+" "+strings.ToUpper(s.Direction))
+	}
+	if len(terms) == 0 {
+		for _, d := range q.Dimensions {
+			for _, column := range dimensionColumns(d) {
+				terms = append(terms, q.alias(column))
+			}
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+func containsColumn(columns []string, name string) bool {
+	for _, c := range columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pgDimensionSelects are the select expressions of each dimension, over
+// facts f joined to line_items li, campaigns c, advertisers adv, agencies ag
+// and creatives cr, and, when grouping by placement, to the placement
+// memberships pm of the facts' ad unit. The timezone is always bound to $1.
+// Campaigns without an advertiser or agency report ID 0.
+var pgDimensionSelects = map[string][]string{
+	"date":       {"to_char(f.hour AT TIME ZONE $1, 'YYYY-MM-DD') AS date"},
+	"hour":       {"EXTRACT(HOUR FROM f.hour AT TIME ZONE $1)::int AS hour"},
+	"advertiser": {"COALESCE(c.advertiser_id, 0) AS advertiser_id", "COALESCE(adv.name, '') AS advertiser"},
+	"agency":     {"COALESCE(c.agency_id, 0) AS agency_id", "COALESCE(ag.name, '') AS agency"},
+	"campaign":   {"li.campaign_id AS campaign_id", "COALESCE(c.name, '') AS campaign"},
+	"line_item":  {"f.line_item_id AS line_item_id", "COALESCE(li.name, '') AS line_item"},
+	"creative":   {"f.creative_id AS creative_id", "COALESCE(cr.name, '') AS creative"},
+	"size":       {"COALESCE(cr.width || 'x' || cr.height, '') AS size"},
+	"ad_unit":    {"f.ad_unit AS ad_unit"},
+	"placement":  {"pm.placement_id AS placement_id", "pm.placement AS placement"},
+	"country":    {"f.country AS country"},
+	"section":    {"f.section AS section"},
+	"platform":   {"f.platform AS platform"},
+}
+
+// pgDimensionFilters are the text expressions each dimension is filtered on
+var pgDimensionFilters = map[string]string{
+	"date":       "to_char(f.hour AT TIME ZONE $1, 'YYYY-MM-DD')",
+	"hour":       "EXTRACT(HOUR FROM f.hour AT TIME ZONE $1)::int::text",
+	"advertiser": "COALESCE(c.advertiser_id, 0)::text",
+	"agency":     "COALESCE(c.agency_id, 0)::text",
+	"campaign":   "li.campaign_id::text",
+	"line_item":  "f.line_item_id::text",
+	"creative":   "f.creative_id::text",
+	"size":       "COALESCE(cr.width || 'x' || cr.height, '')",
+	"ad_unit":    "f.ad_unit",
+	"placement":  "pm.placement_id::text",
+	"country":    "f.country",
+	"section":    "f.section",
+	"platform":   "f.platform",
+}
+
+// pgPlacementJoin joins each fact to the placements of its ad unit, so a
+// fact counts once for every placement that contains its ad unit. The
+// network is bound to $%[1]d.
+const pgPlacementJoin = `
+		JOIN (
+			SELECT pl.id AS placement_id, pl.name AS placement, au.code AS ad_unit_code
+			FROM placements pl
+			JOIN placement_ad_units pa ON pa.placement_id = pl.id
+			JOIN ad_units au ON au.id = pa.ad_unit_id
+			WHERE $%[1]d = 0 OR pl.network_id = $%[1]d
+		) pm ON pm.ad_unit_code = f.ad_unit`
+
+// pgPlacementFilter keeps the facts whose ad unit is in one of the placements
+// bound to $%[1]d, for queries that filter by placement without grouping by
+// it. The network is bound to $%[2]d.
+const pgPlacementFilter = `EXISTS (
+			SELECT 1 FROM placement_ad_units pa
+			JOIN placements pl ON pl.id = pa.placement_id
+			JOIN ad_units au ON au.id = pa.ad_unit_id
+			WHERE au.code = f.ad_unit AND pa.placement_id::text = ANY($%[1]d)
+				AND ($%[2]d = 0 OR pl.network_id = $%[2]d)
+		)`
+
+var pgMetricExprs = map[string]string{
+	"impressions":  "COALESCE(SUM(f.impressions), 0)",
+	"clicks":       "COALESCE(SUM(f.clicks), 0)",
+	"viewable":     "COALESCE(SUM(f.viewable), 0)",
+	"ctr":          "COALESCE(SUM(f.clicks) * 100.0 / NULLIF(SUM(f.impressions), 0), 0)::float8",
+	"viewability":  "COALESCE(SUM(f.viewable) * 100.0 / NULLIF(SUM(f.impressions), 0), 0)::float8",
+	"unique_users": "COUNT(DISTINCT f.user_id) FILTER (WHERE f.impressions = 1 AND f.user_id <> '')",
+}
+
+// userFactsCTE is like factsCTE but reads raw events only and adds user_id
+// and targeting, for metrics and key combinations the hourly rollups cannot
+// answer
+func userFactsCTE(args *[]interface{}, networkID int, start, end time.Time) string {
+	*args = append(*args, networkID, start, end)
+	return fmt.Sprintf(`
+		WITH facts AS (
+			SELECT
+				created_at AS hour,
+				COALESCE(line_item_id, 0) AS line_item_id, COALESCE(creative_id, 0) AS creative_id,
+				COALESCE(ad_unit, '') AS ad_unit, COALESCE(country, '') AS country,
+				COALESCE(section, '') AS section, COALESCE(platform, '') AS platform,
+				CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END AS impressions,
+				CASE WHEN event_type = 'click' THEN 1 ELSE 0 END AS clicks,
+				CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END AS viewable,
+				COALESCE(user_id, '') AS user_id, targeting
+			FROM events
+			WHERE ($%[1]d = 0 OR network_id = $%[1]d) AND created_at >= $%[2]d AND created_at < $%[3]d
+		)`, len(*args)-2, len(*args)-1, len(*args))
+}
+
+// RunReportQuery runs a report builder query
+func (s *PostgresStore) RunReportQuery(ctx context.Context, q *ReportQuery) (*ReportResult, error) {
+	result := &ReportResult{Columns: q.Columns()}
+	err := s.StreamReportQuery(ctx, q, func(values []interface{}) error {
+		result.Rows = append(result.Rows, values)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StreamReportQuery runs a report builder query and calls fn with each row
+// as it is read from the database, so large results are never held in
+// memory. An error from fn stops the query.
+func (s *PostgresStore) StreamReportQuery(ctx context.Context, q *ReportQuery, fn func(values []interface{}) error) error {
+	query, args := pgReportStatement(q)
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// pgReportStatement returns the SQL of a report builder query and its
+// arguments. Queries with unique users or several keys read raw events; a
+// single key reads the key-value rollups.
+func pgReportStatement(q *ReportQuery) (string, []interface{}) {
+	args := []interface{}{q.Timezone}
+	keys := q.Keys()
+	keyExprs := make(map[string]string, len(keys))
+	keyRollups := false
+	var cte string
+	switch {
+	case q.needsUsers() || len(keys) > 1:
+		// The key-value rollups hold each key on its own, so several keys
+		// together are read from raw events
+		cte = userFactsCTE(&args, q.NetworkID, q.StartDate, q.EndDate)
+	case len(keys) == 1:
+		cte = keyFactsCTE(&args, q.NetworkID, q.StartDate, q.EndDate, keys[0])
+		keyExprs[keys[0]] = "f.key_value"
+		keyRollups = true
+	default:
+		cte = factsCTE(&args, q.NetworkID, q.StartDate, q.EndDate)
+	}
+
+	// Over raw events, key dimensions read the key, bound as a parameter,
+	// from the facts' key-values
+	for _, key := range keys {
+		if _, ok := keyExprs[key]; ok {
+			continue
+		}
+		args = append(args, key)
+		keyExprs[key] = fmt.Sprintf("COALESCE(f.targeting->>$%d::text, '')", len(args))
+	}
+
+	var selects []string
+	for _, d := range q.Dimensions {
+		if key, ok := reportKey(d); ok {
+			selects = append(selects, keyExprs[key]+" AS "+q.alias(d))
+			continue
+		}
+		selects = append(selects, pgDimensionSelects[d]...)
+	}
+	groupColumns := len(selects)
+	for _, m := range q.Metrics {
+		selects = append(selects, pgMetricExprs[m]+" AS "+m)
+	}
+
+	query := cte + `
+		SELECT ` + strings.Join(selects, ", ") + `
+		FROM facts f
+		LEFT JOIN line_items li ON li.id = f.line_item_id
+		LEFT JOIN campaigns c ON c.id = li.campaign_id
+		LEFT JOIN advertisers adv ON adv.id = c.advertiser_id
+		LEFT JOIN agencies ag ON ag.id = c.agency_id
+		LEFT JOIN creatives cr ON cr.id = f.creative_id`
+	var networkArg int
+	if q.uses("placement") {
+		args = append(args, q.NetworkID)
+		networkArg = len(args)
+	}
+	if q.groups("placement") {
+		query += fmt.Sprintf(pgPlacementJoin, networkArg)
+	}
+	query += `
+		WHERE TRUE`
+	for _, f := range q.Filters {
+		args = append(args, f.Values)
+		expr := pgDimensionFilters[f.Dimension]
+		if key, ok := reportKey(f.Dimension); ok {
+			expr = keyExprs[key]
+		}
+		cond := fmt.Sprintf("%s = ANY($%d)", expr, len(args))
+		if f.Dimension == "placement" && !q.groups("placement") {
+			cond = fmt.Sprintf(pgPlacementFilter, len(args), networkArg)
+		}
+		if f.Operator == "NOT_IN" {
+			cond = "NOT (" + cond + ")"
+		}
+		query += " AND " + cond
+	}
+	if groupColumns > 0 {
+		positions := make([]string, groupColumns)
+		for i := range positions {
+			positions[i] = strconv.Itoa(i + 1)
+		}
+		query += " GROUP BY " + strings.Join(positions, ", ")
+		// The key's "" rows are totals less the key's rows, which leave
+		// groups that net out to nothing
+		if keyRollups {
+			query += " HAVING SUM(f.impressions) <> 0 OR SUM(f.clicks) <> 0 OR SUM(f.viewable) <> 0"
+		}
+	}
+	if order := q.orderBy(); order != "" {
+		query += " ORDER BY " + order
+	}
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return query, args
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+var (
+	reportStart = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	reportEnd   = time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+)
+
+// reportQuery validates a request over network 3 for the first week of
+// March 2026, in UTC
+func reportQuery(t *testing.T, req models.ReportQueryRequest) *ReportQuery {
+	t.Helper()
+	q, err := NewReportQuery(3, &req, reportStart, reportEnd, "UTC")
+	if err != nil {
+		t.Fatalf("NewReportQuery: %v", err)
+	}
+	return q
+}
+
+// assertContains fails for each part missing from statement
+func assertContains(t *testing.T, statement string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(statement, part) {
+			t.Errorf("statement has no %q:\n%s", part, statement)
+		}
+	}
+}
+
+// assertNotContains fails for each part found in statement
+func assertNotContains(t *testing.T, statement string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if strings.Contains(statement, part) {
+			t.Errorf("statement has %q:\n%s", part, statement)
+		}
+	}
+}
+
+func TestNewReportQuery(t *testing.T) {
+	q := reportQuery(t, models.ReportQueryRequest{
+		Dimensions: []string{"date", "key:brand", "line_item"},
+		Filters:    []models.ReportFilter{{Dimension: "key:color", Values: []string{"red"}}},
+		Sort:       []models.ReportSort{{Field: "line_item"}},
+		Limit:      MaxReportLimit + 1,
+	})
+
+	if !reflect.DeepEqual(q.Metrics, defaultReportMetrics) {
+		t.Errorf("metrics %v, want the defaults %v", q.Metrics, defaultReportMetrics)
+	}
+	if q.Filters[0].Operator != "IN" {
+		t.Errorf("filter operator %q, want IN by default", q.Filters[0].Operator)
+	}
+	if q.Sort[0].Direction != "desc" {
+		t.Errorf("sort direction %q, want desc by default", q.Sort[0].Direction)
+	}
+	if q.Limit != MaxReportLimit {
+		t.Errorf("limit %d, want it capped at %d", q.Limit, MaxReportLimit)
+	}
+	wantColumns := []string{"date", "key:brand", "line_item_id", "line_item", "impressions", "clicks", "viewable", "ctr"}
+	if !reflect.DeepEqual(q.Columns(), wantColumns) {
+		t.Errorf("columns %v, want %v", q.Columns(), wantColumns)
+	}
+	if !reflect.DeepEqual(q.Keys(), []string{"brand", "color"}) {
+		t.Errorf("keys %v, want [brand color]", q.Keys())
+	}
+	if q.alias("key:brand") != "key_0" || q.alias("key:color") != "key_1" || q.alias("date") != "date" {
+		t.Errorf("aliases %q %q %q, want key_0 key_1 date", q.alias("key:brand"), q.alias("key:color"), q.alias("date"))
+	}
+
+	q = reportQuery(t, models.ReportQueryRequest{})
+	if q.Limit != DefaultReportLimit {
+		t.Errorf("limit %d, want the default %d", q.Limit, DefaultReportLimit)
+	}
+}
+
+func TestNewReportQueryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		req  models.ReportQueryRequest
+	}{
+		{"unknown dimension", models.ReportQueryRequest{Dimensions: []string{"city"}}},
+		{"empty key", models.ReportQueryRequest{Dimensions: []string{"key:"}}},
+		{"dimension twice", models.ReportQueryRequest{Dimensions: []string{"date", "date"}}},
+		{"unknown metric", models.ReportQueryRequest{Metrics: []string{"revenue"}}},
+		{"metric twice", models.ReportQueryRequest{Metrics: []string{"clicks", "clicks"}}},
+		{"unknown filter dimension", models.ReportQueryRequest{Filters: []models.ReportFilter{{Dimension: "city", Values: []string{"Paris"}}}}},
+		{"bad operator", models.ReportQueryRequest{Filters: []models.ReportFilter{{Dimension: "country", Operator: "LIKE", Values: []string{"U%"}}}}},
+		{"no filter values", models.ReportQueryRequest{Filters: []models.ReportFilter{{Dimension: "country"}}}},
+		{"non-numeric ID", models.ReportQueryRequest{Filters: []models.ReportFilter{{Dimension: "campaign", Values: []string{"Launch"}}}}},
+		{"sort by unrequested column", models.ReportQueryRequest{Dimensions: []string{"date"}, Sort: []models.ReportSort{{Field: "country"}}}},
+		{"bad sort direction", models.ReportQueryRequest{Dimensions: []string{"date"}, Sort: []models.ReportSort{{Field: "date", Direction: "up"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReportQuery(3, &tt.req, reportStart, reportEnd, "UTC"); err == nil {
+				t.Error("NewReportQuery succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestPgReportStatementRollups(t *testing.T) {
+	q := reportQuery(t, models.ReportQueryRequest{
+		Dimensions: []string{"date", "country"},
+		Metrics:    []string{"impressions", "ctr"},
+		Filters:    []models.ReportFilter{{Dimension: "country", Values: []string{"US", "CA"}}},
+		Sort:       []models.ReportSort{{Field: "impressions"}},
+		Limit:      50,
+	})
+
+	query, args := pgReportStatement(q)
+	wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, []string{"US", "CA"}, 50}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args %v, want %v", args, wantArgs)
+	}
+	assertContains(t, query,
+		"FROM event_rollups_hourly",
+		"SELECT rolled_until FROM rollup_state WHERE name = 'events_hourly'",
+		"to_char(f.hour AT TIME ZONE $1, 'YYYY-MM-DD') AS date",
+		"f.country AS country",
+		"COALESCE(SUM(f.impressions), 0) AS impressions",
+		"AND f.country = ANY($5)",
+		"GROUP BY 1, 2",
+		"ORDER BY impressions DESC",
+		"LIMIT $6",
+	)
+	assertNotContains(t, query, "event_key_rollups_hourly", "HAVING", "placement_ad_units")
+}
+
+func TestPgReportStatementRawEvents(t *testing.T) {
+	t.Run("range off the hour", func(t *testing.T) {
+		// A day in a zone with a half-hour offset cannot use rollup hours
+		start := time.Date(2026, 3, 1, 18, 30, 0, 0, time.UTC)
+		req := models.ReportQueryRequest{Dimensions: []string{"date"}}
+		q, err := NewReportQuery(3, &req, start, start.AddDate(0, 0, 1), "Asia/Kolkata")
+		if err != nil {
+			t.Fatalf("NewReportQuery: %v", err)
+		}
+		query, _ := pgReportStatement(q)
+		assertContains(t, query, "hour < '-infinity'::timestamptz", "created_at >= '-infinity'::timestamptz")
+		assertNotContains(t, query, "rollup_state")
+	})
+
+	t.Run("unique users", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{Dimensions: []string{"country"}, Metrics: []string{"unique_users"}})
+		query, args := pgReportStatement(q)
+		wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, DefaultReportLimit}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("args %v, want %v", args, wantArgs)
+		}
+		assertContains(t, query,
+			"COALESCE(user_id, '') AS user_id, targeting",
+			"FROM events",
+			"COUNT(DISTINCT f.user_id) FILTER (WHERE f.impressions = 1 AND f.user_id <> '') AS unique_users",
+		)
+		assertNotContains(t, query, "event_rollups_hourly", "HAVING")
+	})
+
+	t.Run("several keys", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"key:brand"},
+			Metrics:    []string{"impressions"},
+			Filters:    []models.ReportFilter{{Dimension: "key:color", Operator: "NOT_IN", Values: []string{"red"}}},
+		})
+		query, args := pgReportStatement(q)
+		wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, "brand", "color", []string{"red"}, DefaultReportLimit}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("args %v, want %v", args, wantArgs)
+		}
+		assertContains(t, query,
+			"COALESCE(f.targeting->>$5::text, '') AS key_0",
+			"AND NOT (COALESCE(f.targeting->>$6::text, '') = ANY($7))",
+			"GROUP BY 1",
+			"ORDER BY key_0",
+			"LIMIT $8",
+		)
+		assertNotContains(t, query, "event_key_rollups_hourly", "HAVING")
+	})
+}
+
+func TestPgReportStatementKeyRollups(t *testing.T) {
+	q := reportQuery(t, models.ReportQueryRequest{
+		Dimensions: []string{"key:brand"},
+		Metrics:    []string{"impressions"},
+		Filters:    []models.ReportFilter{{Dimension: "key:brand", Operator: "NOT_IN", Values: []string{"acme"}}},
+	})
+
+	query, args := pgReportStatement(q)
+	wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, "brand", []string{"acme"}, DefaultReportLimit}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args %v, want %v", args, wantArgs)
+	}
+	assertContains(t, query,
+		"FROM event_key_rollups_hourly",
+		"target_key = $5::text",
+		"COALESCE(targeting->>$5::text, '')",
+		"f.key_value AS key_0",
+		"AND NOT (f.key_value = ANY($6))",
+		"GROUP BY 1 HAVING SUM(f.impressions) <> 0 OR SUM(f.clicks) <> 0 OR SUM(f.viewable) <> 0",
+	)
+
+	// Without a dimension there is nothing to group, so nothing to drop
+	q = reportQuery(t, models.ReportQueryRequest{
+		Metrics: []string{"impressions"},
+		Filters: []models.ReportFilter{{Dimension: "key:brand", Values: []string{"acme"}}},
+	})
+	query, _ = pgReportStatement(q)
+	assertContains(t, query, "FROM event_key_rollups_hourly", "AND f.key_value = ANY($6)")
+	assertNotContains(t, query, "GROUP BY", "HAVING")
+}
+
+func TestPgReportStatementPlacements(t *testing.T) {
+	t.Run("grouped", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"placement"},
+			Metrics:    []string{"impressions"},
+			Filters:    []models.ReportFilter{{Dimension: "placement", Values: []string{"5"}}},
+		})
+		query, args := pgReportStatement(q)
+		wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, 3, []string{"5"}, DefaultReportLimit}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("args %v, want %v", args, wantArgs)
+		}
+		assertContains(t, query,
+			"pm.placement_id AS placement_id, pm.placement AS placement",
+			"WHERE $5 = 0 OR pl.network_id = $5",
+			") pm ON pm.ad_unit_code = f.ad_unit",
+			"AND pm.placement_id::text = ANY($6)",
+		)
+		assertNotContains(t, query, "EXISTS")
+	})
+
+	t.Run("filtered only", func(t *testing.T) {
+		q := reportQuery(t, models.ReportQueryRequest{
+			Dimensions: []string{"ad_unit"},
+			Metrics:    []string{"impressions"},
+			Filters:    []models.ReportFilter{{Dimension: "placement", Operator: "NOT_IN", Values: []string{"5", "6"}}},
+		})
+		query, args := pgReportStatement(q)
+		wantArgs := []interface{}{"UTC", 3, reportStart, reportEnd, 3, []string{"5", "6"}, DefaultReportLimit}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("args %v, want %v", args, wantArgs)
+		}
+		assertContains(t, query,
+			"AND NOT (EXISTS (",
+			"WHERE au.code = f.ad_unit AND pa.placement_id::text = ANY($6)",
+			"AND ($5 = 0 OR pl.network_id = $5)",
+		)
+		assertNotContains(t, query, ") pm ON")
+	})
+}
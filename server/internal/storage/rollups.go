@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// rollupName identifies the hourly event rollup in rollup_state
+const rollupName = "events_hourly"
+
+// factsCTE returns a "WITH facts AS (...)" clause yielding a network's event
+// counts between start and end (every network's for networkID 0):
+// pre-aggregated hourly rollups for hours before the
+// rollup watermark, and the raw events table for anything after it (the
+// current hour, or more if the aggregator is behind). The network and range
+// are bound to the next three placeholders, which are appended to args.
+//
+// Rollup hours cannot be split, so a range that does not start and end on a
+// whole UTC hour (days in a zone with a half-hour offset) counts raw events
+// only. Raw rows keep their exact time in hour.
+//
+// facts columns: hour, line_item_id, creative_id, ad_unit, country, section,
+// platform, impressions, clicks, viewable. Missing dimensions are 0 or empty.
+func factsCTE(args *[]interface{}, networkID int, start, end time.Time) string {
+	hour, network, rawTime := factsBounds(args, networkID, start, end)
+
+	return `
+		WITH facts AS (
+			SELECT ` + rollupFactColumns + `
+			FROM event_rollups_hourly
+			WHERE ` + hour + ` AND ` + network + `
+			UNION ALL
+			SELECT ` + rawFactColumns + `
+			FROM events
+			WHERE ` + rawTime + ` AND ` + network + `
+		)`
+}
+
+// keyFactsCTE is like factsCTE but adds the key_value column: the value of
+// one targeting key, bound to the placeholder after the range, or "" for
+// events without it. Rollup hours come from the per key-value rollups; the
+// events without the key are the hour's total less the key's rows.
+func keyFactsCTE(args *[]interface{}, networkID int, start, end time.Time, key string) string {
+	hour, network, rawTime := factsBounds(args, networkID, start, end)
+	*args = append(*args, key)
+	keyArg := fmt.Sprintf("$%d::text", len(*args))
+
+	return `
+		WITH facts AS (
+			SELECT ` + rollupFactColumns + `, target_value AS key_value
+			FROM event_key_rollups_hourly
+			WHERE ` + hour + ` AND ` + network + ` AND target_key = ` + keyArg + `
+			UNION ALL
+			SELECT ` + rollupFactColumns + `, ''
+			FROM event_rollups_hourly
+			WHERE ` + hour + ` AND ` + network + `
+			UNION ALL
+			SELECT hour, line_item_id, creative_id, ad_unit, country, section, platform, -impressions, -clicks, -viewable, ''
+			FROM event_key_rollups_hourly
+			WHERE ` + hour + ` AND ` + network + ` AND target_key = ` + keyArg + `
+			UNION ALL
+			SELECT ` + rawFactColumns + `, COALESCE(targeting->>` + keyArg + `, '')
+			FROM events
+			WHERE ` + rawTime + ` AND ` + network + `
+		)`
+}
+
+// rollupFactColumns and rawFactColumns select the facts columns from a
+// rollup table and from events
+const (
+	rollupFactColumns = `hour, line_item_id, creative_id, ad_unit, country, section, platform, impressions, clicks, viewable`
+	rawFactColumns    = `
+				created_at,
+				COALESCE(line_item_id, 0), COALESCE(creative_id, 0), COALESCE(ad_unit, ''),
+				COALESCE(country, ''), COALESCE(section, ''), COALESCE(platform, ''),
+				CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END,
+				CASE WHEN event_type = 'click' THEN 1 ELSE 0 END,
+				CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END`
+)
+
+// factsBounds appends the network and range to args and returns the
+// conditions the facts CTEs use: rollup hours before the watermark, the
+// network, and raw events from the watermark on
+func factsBounds(args *[]interface{}, networkID int, start, end time.Time) (hour, network, rawTime string) {
+	*args = append(*args, networkID, start, end)
+	networkArg := fmt.Sprintf("$%d", len(*args)-2)
+	startArg := fmt.Sprintf("$%d", len(*args)-1)
+	endArg := fmt.Sprintf("$%d", len(*args))
+	watermark := rollupWatermark(start, end)
+
+	hour = `hour >= ` + startArg + ` AND hour < ` + endArg + ` AND hour < ` + watermark
+	network = `(` + networkArg + ` = 0 OR network_id = ` + networkArg + `)`
+	rawTime = `created_at >= ` + startArg + ` AND created_at < ` + endArg + ` AND created_at >= ` + watermark
+	return hour, network, rawTime
+}
+
+// rollupWatermark returns the SQL expression for the time up to which a
+// query over [start, end) reads rollups rather than raw events
+func rollupWatermark(start, end time.Time) string {
+	if !onHour(start) || !onHour(end) {
+		return `'-infinity'::timestamptz`
+	}
+	return `COALESCE((SELECT rolled_until FROM rollup_state WHERE name = '` + rollupName + `'), '-infinity'::timestamptz)`
+}
+
+// onHour reports whether t is on a whole UTC hour
+func onHour(t time.Time) bool {
+	return t.Equal(t.Truncate(time.Hour))
+}
+
+// GetRollupWatermark returns the hour up to which events have been rolled
+// up. ok is false if the aggregator has never run.
+func (s *PostgresStore) GetRollupWatermark(ctx context.Context) (time.Time, bool, error) {
+	var rolledUntil time.Time
+	err := s.pool.QueryRow(ctx, `
+		SELECT rolled_until FROM rollup_state WHERE name = $1
+	`, rollupName).Scan(&rolledUntil)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return rolledUntil, true, nil
+}
+
+// SetRollupWatermark records the hour up to which events have been rolled up
+func (s *PostgresStore) SetRollupWatermark(ctx context.Context, rolledUntil time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO rollup_state (name, rolled_until, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET rolled_until = EXCLUDED.rolled_until, updated_at = NOW()
+	`, rollupName, rolledUntil)
+	return err
+}
+
+// ClaimRollupDirtyHours removes and returns the hours before the watermark
+// that received events after they were rolled up, oldest first. Events
+// recorded after the claim mark their hour again.
+func (s *PostgresStore) ClaimRollupDirtyHours(ctx context.Context) ([]time.Time, error) {
+	rows, err := s.pool.Query(ctx, `DELETE FROM rollup_dirty_hours RETURNING hour`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hours []time.Time
+	for rows.Next() {
+		var hour time.Time
+		if err := rows.Scan(&hour); err != nil {
+			return nil, err
+		}
+		hours = append(hours, hour)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+	return hours, nil
+}
+
+// MarkRollupHoursDirty marks hours to be rolled up again, e.g. claimed
+// hours whose roll-up failed
+func (s *PostgresStore) MarkRollupHoursDirty(ctx context.Context, hours []time.Time) error {
+	if len(hours) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO rollup_dirty_hours (hour)
+		SELECT unnest($1::timestamptz[])
+		ON CONFLICT (hour) DO NOTHING
+	`, hours)
+	return err
+}
+
+// GetEarliestEventTime returns the time of the oldest event. ok is false if
+// there are no events.
+func (s *PostgresStore) GetEarliestEventTime(ctx context.Context) (time.Time, bool, error) {
+	var earliest *time.Time
+	if err := s.pool.QueryRow(ctx, `SELECT MIN(created_at) FROM events`).Scan(&earliest); err != nil {
+		return time.Time{}, false, err
+	}
+	if earliest == nil {
+		return time.Time{}, false, nil
+	}
+	return *earliest, true, nil
+}
+
+// RollupHour recomputes the rollup rows and reach sketches for one hour from
+// the raw events. It replaces whatever was there, so it is safe to run again
+// for the same hour, e.g. to pick up late events.
+func (s *PostgresStore) RollupHour(ctx context.Context, hour time.Time) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize aggregators running on several servers
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, rollupName); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM event_rollups_hourly WHERE hour = $1`, hour); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM event_key_rollups_hourly WHERE hour = $1`, hour); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO event_rollups_hourly (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, impressions, clicks, viewable)
+		SELECT
+			$1::timestamptz, network_id,
+			COALESCE(line_item_id, 0), COALESCE(creative_id, 0), COALESCE(ad_unit, ''),
+			COALESCE(country, ''), COALESCE(section, ''), COALESCE(platform, ''),
+			COALESCE(SUM(CASE WHEN event_type = 'impression' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN event_type = 'click' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN event_type = 'viewable' THEN 1 ELSE 0 END), 0)
+		FROM events
+		WHERE created_at >= $1 AND created_at < $1::timestamptz + INTERVAL '1 hour'
+		GROUP BY 2, 3, 4, 5, 6, 7, 8
+	`, hour)
+	if err != nil {
+		return err
+	}
+
+	// The same counts once per key-value pair of the request, for reports on
+	// a targeting key
+	_, err = tx.Exec(ctx, `
+		INSERT INTO event_key_rollups_hourly (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, target_key, target_value, impressions, clicks, viewable)
+		SELECT
+			$1::timestamptz, e.network_id,
+			COALESCE(e.line_item_id, 0), COALESCE(e.creative_id, 0), COALESCE(e.ad_unit, ''),
+			COALESCE(e.country, ''), COALESCE(e.section, ''), COALESCE(e.platform, ''),
+			kv.key, COALESCE(kv.value, ''),
+			COALESCE(SUM(CASE WHEN e.event_type = 'impression' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN e.event_type = 'click' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN e.event_type = 'viewable' THEN 1 ELSE 0 END), 0)
+		FROM events e
+		CROSS JOIN LATERAL jsonb_each_text(e.targeting) kv
+		WHERE e.created_at >= $1 AND e.created_at < $1::timestamptz + INTERVAL '1 hour'
+		GROUP BY 2, 3, 4, 5, 6, 7, 8, 9, 10
+	`, hour)
+	if err != nil {
+		return err
+	}
+
+	if err := rollupReach(ctx, tx, hour); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// staleRunTimeout is how long a run can stay "running" before it is assumed
+// lost (the server stopped mid-run) and claimed again
+const staleRunTimeout = 30 * time.Minute
+
+const scheduledReportColumns = `id, network_id, name, query, date_range, schedule, format, delivery, recipients, webhook_url, status, next_run_at, last_run_at, created_at, updated_at`
+
+const scheduledReportRunColumns = `id, report_id, scheduled_for, status, attempts,
+	CASE WHEN status IN ('pending', 'retrying') THEN next_attempt_at END,
+	COALESCE(to_char(start_date, 'YYYY-MM-DD'), ''), COALESCE(to_char(end_date, 'YYYY-MM-DD'), ''),
+	row_count, error, started_at, finished_at, created_at`
+
+func scanScheduledReport(row pgx.Row) (*models.ScheduledReport, error) {
+	var r models.ScheduledReport
+	var queryJSON []byte
+	if err := row.Scan(&r.ID, &r.NetworkID, &r.Name, &queryJSON, &r.DateRange, &r.Schedule, &r.Format, &r.Delivery, &r.Recipients, &r.WebhookURL, &r.Status, &r.NextRunAt, &r.LastRunAt, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(queryJSON, &r.Query)
+	return &r, nil
+}
+
+func scanScheduledReportRun(row pgx.Row) (*models.ScheduledReportRun, error) {
+	var r models.ScheduledReportRun
+	if err := row.Scan(&r.ID, &r.ReportID, &r.ScheduledFor, &r.Status, &r.Attempts, &r.NextAttempt, &r.StartDate, &r.EndDate, &r.RowCount, &r.Error, &r.StartedAt, &r.FinishedAt, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListScheduledReports returns a network's scheduled reports
+func (s *PostgresStore) ListScheduledReports(ctx context.Context, networkID int) ([]models.ScheduledReport, error) {
+	args := []interface{}{}
+	query := `SELECT ` + scheduledReportColumns + ` FROM scheduled_reports WHERE true` + networkFilter(&args, "network_id", networkID) + ` ORDER BY name`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []models.ScheduledReport
+	for rows.Next() {
+		r, err := scanScheduledReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
+
+// GetScheduledReport returns a scheduled report by ID
+func (s *PostgresStore) GetScheduledReport(ctx context.Context, networkID, id int) (*models.ScheduledReport, error) {
+	r, err := scanScheduledReport(s.pool.QueryRow(ctx, `
+		SELECT `+scheduledReportColumns+` FROM scheduled_reports WHERE id = $1 AND ($2 = 0 OR network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// CreateScheduledReport creates a scheduled report that first runs at
+// nextRunAt
+func (s *PostgresStore) CreateScheduledReport(ctx context.Context, networkID int, req *models.ScheduledReportRequest, nextRunAt *time.Time) (*models.ScheduledReport, error) {
+	queryJSON, _ := json.Marshal(req.Query)
+	recipients := req.Recipients
+	if recipients == nil {
+		recipients = []string{}
+	}
+	status := req.Status
+	if status == "" {
+		status = "active"
+	}
+
+	return scanScheduledReport(s.pool.QueryRow(ctx, `
+		INSERT INTO scheduled_reports (network_id, name, query, date_range, schedule, format, delivery, recipients, webhook_url, status, next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), NOW())
+		RETURNING `+scheduledReportColumns,
+		networkID, req.Name, queryJSON, req.DateRange, req.Schedule, req.Format, req.Delivery, recipients, req.WebhookURL, status, nextRunAt))
+}
+
+// UpdateScheduledReport replaces a scheduled report's definition and its
+// next run time
+func (s *PostgresStore) UpdateScheduledReport(ctx context.Context, networkID, id int, req *models.ScheduledReportRequest, nextRunAt *time.Time) (*models.ScheduledReport, error) {
+	queryJSON, _ := json.Marshal(req.Query)
+	recipients := req.Recipients
+	if recipients == nil {
+		recipients = []string{}
+	}
+
+	r, err := scanScheduledReport(s.pool.QueryRow(ctx, `
+		UPDATE scheduled_reports
+		SET name = $2, query = $3, date_range = $4, schedule = $5, format = $6, delivery = $7,
+		    recipients = $8, webhook_url = $9, status = COALESCE(NULLIF($10, ''), status),
+		    next_run_at = $11, updated_at = NOW()
+		WHERE id = $1 AND ($12 = 0 OR network_id = $12)
+		RETURNING `+scheduledReportColumns,
+		id, req.Name, queryJSON, req.DateRange, req.Schedule, req.Format, req.Delivery, recipients, req.WebhookURL, req.Status, nextRunAt, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// DeleteScheduledReport deletes a scheduled report and its run history
+func (s *PostgresStore) DeleteScheduledReport(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM scheduled_reports WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// ListDueScheduledReports returns the active scheduled reports whose next
+// run time has passed
+func (s *PostgresStore) ListDueScheduledReports(ctx context.Context, now time.Time) ([]models.ScheduledReport, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+scheduledReportColumns+`
+		FROM scheduled_reports
+		WHERE status = 'active' AND next_run_at <= $1
+		ORDER BY next_run_at
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []models.ScheduledReport
+	for rows.Next() {
+		r, err := scanScheduledReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
+
+// QueueScheduledReportRun queues the run of a report due at scheduledFor and
+// moves the report's next run time on. It returns false if the report's next
+// run time is no longer scheduledFor, i.e. another server queued that run
+// first.
+func (s *PostgresStore) QueueScheduledReportRun(ctx context.Context, reportID int, scheduledFor time.Time, nextRunAt *time.Time) (bool, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE scheduled_reports SET next_run_at = $3, last_run_at = $2
+		WHERE id = $1 AND next_run_at = $2
+	`, reportID, scheduledFor, nextRunAt)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO scheduled_report_runs (report_id, scheduled_for, status, next_attempt_at, created_at)
+		VALUES ($1, $2, 'pending', NOW(), NOW())
+	`, reportID, scheduledFor); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// CreateScheduledReportRun queues a run of a report outside its schedule
+func (s *PostgresStore) CreateScheduledReportRun(ctx context.Context, reportID int, scheduledFor time.Time) (*models.ScheduledReportRun, error) {
+	return scanScheduledReportRun(s.pool.QueryRow(ctx, `
+		INSERT INTO scheduled_report_runs (report_id, scheduled_for, status, next_attempt_at, created_at)
+		VALUES ($1, $2, 'pending', NOW(), NOW())
+		RETURNING `+scheduledReportRunColumns,
+		reportID, scheduledFor))
+}
+
+// ClaimScheduledReportRuns marks up to limit runs that are due (pending, or
+// retrying and past their backoff) as running and returns them. Rows are
+// locked with SKIP LOCKED so several servers never claim the same run.
+func (s *PostgresStore) ClaimScheduledReportRuns(ctx context.Context, limit int) ([]models.ScheduledReportRun, error) {
+	rows, err := s.pool.Query(ctx, `
+		UPDATE scheduled_report_runs
+		SET status = 'running', attempts = attempts + 1, started_at = NOW(), error = ''
+		WHERE id IN (
+			SELECT id FROM scheduled_report_runs
+			WHERE (status IN ('pending', 'retrying') AND next_attempt_at <= NOW())
+			   OR (status = 'running' AND started_at < NOW() - make_interval(secs => $2))
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+scheduledReportRunColumns,
+		limit, staleRunTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.ScheduledReportRun
+	for rows.Next() {
+		r, err := scanScheduledReportRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *r)
+	}
+	return runs, rows.Err()
+}
+
+// CompleteScheduledReportRun records a delivered run
+func (s *PostgresStore) CompleteScheduledReportRun(ctx context.Context, id int, startDate, endDate time.Time, rowCount int) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE scheduled_report_runs
+		SET status = 'success', start_date = $2, end_date = $3, row_count = $4, error = '', finished_at = NOW()
+		WHERE id = $1
+	`, id, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), rowCount)
+	return err
+}
+
+// FailScheduledReportRun records a failed attempt. The run is retried at
+// retryAt, or marked failed for good when retryAt is nil.
+func (s *PostgresStore) FailScheduledReportRun(ctx context.Context, id int, message string, retryAt *time.Time) error {
+	status := models.RunFailed
+	if retryAt != nil {
+		status = models.RunRetrying
+	}
+	_, err := s.pool.Exec(ctx, `
+		UPDATE scheduled_report_runs
+		SET status = $2, error = $3, next_attempt_at = COALESCE($4, next_attempt_at),
+		    finished_at = CASE WHEN $4::timestamptz IS NULL THEN NOW() END
+		WHERE id = $1
+	`, id, status, message, retryAt)
+	return err
+}
+
+// ListScheduledReportRuns returns the most recent runs of a scheduled report
+func (s *PostgresStore) ListScheduledReportRuns(ctx context.Context, reportID, limit int) ([]models.ScheduledReportRun, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+scheduledReportRunColumns+`
+		FROM scheduled_report_runs
+		WHERE report_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, reportID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.ScheduledReportRun
+	for rows.Next() {
+		r, err := scanScheduledReportRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+const userColumns = `u.id, u.network_id, u.email, u.name, u.role, u.status, u.last_login_at, u.created_at, u.updated_at`
+
+func scanUser(row pgx.Row, extra ...interface{}) (*models.User, error) {
+	var u models.User
+	dest := []interface{}{&u.ID, &u.NetworkID, &u.Email, &u.Name, &u.Role, &u.Status, &u.LastLoginAt, &u.CreatedAt, &u.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns a network's users
+func (s *PostgresStore) ListUsers(ctx context.Context, networkID int) ([]models.User, error) {
+	args := []interface{}{}
+	query := `SELECT ` + userColumns + ` FROM users u WHERE true` + networkFilter(&args, "u.network_id", networkID) + ` ORDER BY u.email`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// CountUsers returns how many users there are, in every network
+func (s *PostgresStore) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&n)
+	return n, err
+}
+
+// GetUser returns a user by ID
+func (s *PostgresStore) GetUser(ctx context.Context, networkID, id int) (*models.User, error) {
+	u, err := scanUser(s.pool.QueryRow(ctx, `
+		SELECT `+userColumns+` FROM users u WHERE u.id = $1 AND ($2 = 0 OR u.network_id = $2)
+	`, id, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// GetUserByEmail returns a user of an active network by email, with its
+// password hash, for signing in. Emails are matched case-insensitively and
+// are unique across networks.
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var hash string
+	u, err := scanUser(s.pool.QueryRow(ctx, `
+		SELECT `+userColumns+`, u.password_hash
+		FROM users u
+		JOIN networks n ON n.id = u.network_id
+		WHERE lower(u.email) = lower($1) AND n.status = 'active'
+	`, email), &hash)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.PasswordHash = hash
+	return u, nil
+}
+
+// EmailInUse reports whether any user, in any network and whatever the
+// network's status, already has an email, case-insensitively
+func (s *PostgresStore) EmailInUse(ctx context.Context, email string) (bool, error) {
+	var inUse bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))`, email).Scan(&inUse)
+	return inUse, err
+}
+
+// CreateUser creates a user of a network with a hashed password
+func (s *PostgresStore) CreateUser(ctx context.Context, networkID int, req *models.CreateUserRequest, passwordHash string) (*models.User, error) {
+	return scanUser(s.pool.QueryRow(ctx, `
+		INSERT INTO users AS u (network_id, email, name, password_hash, role, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 'active', NOW(), NOW())
+		RETURNING `+userColumns,
+		networkID, req.Email, req.Name, passwordHash, req.Role))
+}
+
+// UpdateUser updates a user; empty fields, and an empty password hash, are
+// left unchanged
+func (s *PostgresStore) UpdateUser(ctx context.Context, networkID, id int, req *models.UpdateUserRequest, passwordHash string) (*models.User, error) {
+	u, err := scanUser(s.pool.QueryRow(ctx, `
+		UPDATE users AS u
+		SET name = COALESCE(NULLIF($2, ''), name),
+		    password_hash = COALESCE(NULLIF($3, ''), password_hash),
+		    role = COALESCE(NULLIF($4, ''), role),
+		    status = COALESCE(NULLIF($5, ''), status),
+		    updated_at = NOW()
+		WHERE id = $1 AND ($6 = 0 OR network_id = $6)
+		RETURNING `+userColumns,
+		id, req.Name, passwordHash, req.Role, req.Status, networkID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// DeleteUser deletes a user and its sessions
+func (s *PostgresStore) DeleteUser(ctx context.Context, networkID, id int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM users WHERE id = $1 AND ($2 = 0 OR network_id = $2)`, id, networkID)
+	return err
+}
+
+// CreateSession stores a session for a user under its token hash and
+// records the login
+func (s *PostgresStore) CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO sessions (token_hash, user_id, expires_at, created_at) VALUES ($1, $2, $3, NOW())
+	`, tokenHash, userID, expiresAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET last_login_at = NOW() WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	// Expired sessions are cleaned up as new ones are made
+	if _, err := tx.Exec(ctx, `DELETE FROM sessions WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetSessionUser returns the active user a session token hash belongs to,
+// or nil if the session doesn't exist or has expired or the user's network
+// is inactive
+func (s *PostgresStore) GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	u, err := scanUser(s.pool.QueryRow(ctx, `
+		SELECT `+userColumns+`
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		JOIN networks n ON n.id = u.network_id
+		WHERE s.token_hash = $1 AND s.expires_at > NOW() AND u.status = 'active' AND n.status = 'active'
+	`, tokenHash))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// DeleteSession ends a session
+func (s *PostgresStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+// DeleteUserSessions ends all of a user's sessions
+func (s *PostgresStore) DeleteUserSessions(ctx context.Context, userID int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,136 @@
+package targeting
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// ParseClock converts an "HH:MM" string into minutes since midnight.
+// "24:00" is accepted so that a window can run to the end of the day.
+func ParseClock(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	hour, errH := strconv.Atoi(parts[0])
+	minute, errM := strconv.Atoi(parts[1])
+	if errH != nil || errM != nil || hour < 0 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	if hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, must be between 00:00 and 24:00", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// ValidateDayparts checks that every daypart has a valid weekday and time
+// range, and that no two dayparts on the same weekday overlap.
+func ValidateDayparts(dayparts []models.DaypartInput) error {
+	type window struct{ start, end int }
+	byDay := make(map[int][]window)
+
+	for _, dp := range dayparts {
+		if dp.DayOfWeek < 0 || dp.DayOfWeek > 6 {
+			return fmt.Errorf("invalid day_of_week %d, must be 0 (Sunday) to 6 (Saturday)", dp.DayOfWeek)
+		}
+		start, err := ParseClock(dp.StartTime)
+		if err != nil {
+			return err
+		}
+		end, err := ParseClock(dp.EndTime)
+		if err != nil {
+			return err
+		}
+		if start >= end {
+			return fmt.Errorf("start_time %s must be before end_time %s", dp.StartTime, dp.EndTime)
+		}
+		byDay[dp.DayOfWeek] = append(byDay[dp.DayOfWeek], window{start, end})
+	}
+
+	for day, windows := range byDay {
+		sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+		for i := 1; i < len(windows); i++ {
+			if windows[i].start < windows[i-1].end {
+				return fmt.Errorf("overlapping dayparts on %s", time.Weekday(day))
+			}
+		}
+	}
+
+	return nil
+}
+
+// inFlight checks if now falls between the line item's start and end dates.
+// A missing date leaves that side of the flight open.
+func inFlight(li models.LineItem, now time.Time) bool {
+	if li.StartDate != nil && now.Before(*li.StartDate) {
+		return false
+	}
+	if li.EndDate != nil && !now.Before(*li.EndDate) {
+		return false
+	}
+	return true
+}
+
+// matchesDaypart checks if now falls inside one of the line item's dayparts,
+// evaluated in the line item's timezone. No dayparts means always eligible.
+func (m *Matcher) matchesDaypart(li models.LineItem, now time.Time) bool {
+	if len(li.Dayparts) == 0 {
+		return true
+	}
+
+	local := now.In(m.location(li))
+	weekday := int(local.Weekday())
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, dp := range li.Dayparts {
+		if dp.DayOfWeek != weekday {
+			continue
+		}
+		start, err := ParseClock(dp.StartTime)
+		if err != nil {
+			continue
+		}
+		end, err := ParseClock(dp.EndTime)
+		if err != nil {
+			continue
+		}
+		if minute >= start && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// location resolves the timezone a line item's dayparts are evaluated in:
+// its campaign's, else its network's, else UTC
+func (m *Matcher) location(li models.LineItem) *time.Location {
+	name := li.Timezone
+	if name == "" {
+		name = m.networkTimezone(li.NetworkID)
+	}
+	if name == "" {
+		return time.UTC
+	}
+
+	m.mu.RLock()
+	loc, ok := m.locations[name]
+	m.mu.RUnlock()
+	if ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	m.mu.Lock()
+	m.locations[name] = loc
+	m.mu.Unlock()
+	return loc
+}
@@ -0,0 +1,52 @@
+package targeting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// Explain reports why a line item would not match a fixed-size slot, as the
+// stage it fails at and a human readable reason. An empty stage means it matches.
+func (m *Matcher) Explain(targeting map[string]string, li models.LineItem, width, height int) (string, string) {
+	if len(sizeCandidates(li, width, height)) == 0 {
+		return models.DebugStageSize, fmt.Sprintf("no active %dx%d creative", width, height)
+	}
+	return m.explainSchedule(targeting, li)
+}
+
+// ExplainResponsive is Explain for responsive slots
+func (m *Matcher) ExplainResponsive(targeting map[string]string, li models.LineItem, maxWidth int, allowedSizes [][]int) (string, string) {
+	if len(m.responsiveCandidates(li, maxWidth, allowedSizes)) == 0 {
+		if len(allowedSizes) > 0 {
+			return models.DebugStageSize, fmt.Sprintf("no active creative up to %dpx wide in the ad unit's sizes", maxWidth)
+		}
+		return models.DebugStageSize, fmt.Sprintf("no active creative up to %dpx wide", maxWidth)
+	}
+	return m.explainSchedule(targeting, li)
+}
+
+// explainSchedule checks flight dates, dayparting and targeting rules, in the same order as Match
+func (m *Matcher) explainSchedule(targeting map[string]string, li models.LineItem) (string, string) {
+	if !inFlight(li, m.now()) {
+		return models.DebugStageFlight, "outside the line item's start and end dates"
+	}
+	if !m.matchesDaypart(li, m.now()) {
+		loc := m.location(li)
+		return models.DebugStageDaypart, fmt.Sprintf("outside dayparting schedule (%s, %s)",
+			m.now().In(loc).Format("Mon 15:04"), loc)
+	}
+
+	rule := failedRule(targeting, li.TargetingRules)
+	if rule == nil {
+		return "", ""
+	}
+	value, ok := targeting[rule.Key]
+	if !ok {
+		return models.DebugStageTargeting, fmt.Sprintf("rule %s %s [%s]: key not in request",
+			rule.Key, rule.Operator, strings.Join(rule.Values, ", "))
+	}
+	return models.DebugStageTargeting, fmt.Sprintf("rule %s %s [%s]: request has %q",
+		rule.Key, rule.Operator, strings.Join(rule.Values, ", "), value)
+}
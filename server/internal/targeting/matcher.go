@@ -1,26 +1,48 @@
 package targeting
 
 import (
+	"sync"
+	"time"
+
 	"github.com/mims/ad-manager/internal/models"
 )
 
 // Matcher handles targeting logic
-type Matcher struct{}
+type Matcher struct {
+	mu              sync.RWMutex
+	locations       map[string]*time.Location
+	networkTimezone func(networkID int) string
+	now             func() time.Time
+	sequences       *sequenceTracker
+}
 
 // NewMatcher creates a new Matcher
 func NewMatcher() *Matcher {
-	return &Matcher{}
+	return &Matcher{
+		locations:       make(map[string]*time.Location),
+		networkTimezone: func(int) string { return "" },
+		now:             time.Now,
+		sequences:       newSequenceTracker(),
+	}
+}
+
+// SetNetworkTimezones sets how a network's timezone is looked up. It is used
+// for dayparting when a line item's campaign has no timezone of its own; an
+// unknown network or timezone falls back to UTC.
+func (m *Matcher) SetNetworkTimezones(lookup func(networkID int) string) {
+	m.networkTimezone = lookup
 }
 
 // Match filters line items based on targeting rules and slot dimensions
 func (m *Matcher) Match(targeting map[string]string, lineItems []models.LineItem, width, height int) []models.LineItem {
 	var matched []models.LineItem
+	now := m.now()
 
 	for _, li := range lineItems {
 		// Check if line item has any creatives matching the size
 		hasMatchingCreative := false
 		for _, creative := range li.Creatives {
-			if creative.Width == width && creative.Height == height && creative.Status == "active" {
+			if creative.Width == width && creative.Height == height && creative.IsServable() {
 				hasMatchingCreative = true
 				break
 			}
@@ -29,6 +51,11 @@ func (m *Matcher) Match(targeting map[string]string, lineItems []models.LineItem
 			continue
 		}
 
+		// Check flight dates and dayparting schedule
+		if !inFlight(li, now) || !m.matchesDaypart(li, now) {
+			continue
+		}
+
 		// Check targeting rules
 		if m.matchesTargeting(targeting, li.TargetingRules) {
 			matched = append(matched, li)
@@ -40,17 +67,23 @@ func (m *Matcher) Match(targeting map[string]string, lineItems []models.LineItem
 
 // matchesTargeting checks if the request targeting matches all line item rules
 func (m *Matcher) matchesTargeting(targeting map[string]string, rules []models.TargetingRule) bool {
-	// No rules means match all
-	if len(rules) == 0 {
-		return true
-	}
+	return failedRule(targeting, rules) == nil
+}
 
+// MatchesRules checks if a set of key-values satisfies all targeting rules
+func MatchesRules(targeting map[string]string, rules []models.TargetingRule) bool {
+	return failedRule(targeting, rules) == nil
+}
+
+// failedRule returns the first rule the request targeting does not satisfy,
+// or nil if all rules match. No rules means match all.
+func failedRule(targeting map[string]string, rules []models.TargetingRule) *models.TargetingRule {
 	// All rules must match (AND logic)
-	for _, rule := range rules {
+	for i, rule := range rules {
 		requestValue, exists := targeting[rule.Key]
 		if !exists {
 			// Key not provided in request - rule doesn't match
-			return false
+			return &rules[i]
 		}
 
 		matched := false
@@ -91,32 +124,47 @@ func (m *Matcher) matchesTargeting(targeting map[string]string, rules []models.T
 		}
 
 		if !matched {
-			return false
+			return &rules[i]
 		}
 	}
 
-	return true
+	return nil
+}
+
+// SelectCreative selects a creative for the given dimensions, rotating
+// between matching creatives according to the line item's rotation mode
+func (m *Matcher) SelectCreative(lineItem models.LineItem, width, height int, userID string) *models.Creative {
+	return m.rotate(lineItem, sizeCandidates(lineItem, width, height), userID, true)
+}
+
+// PreviewCreative returns the creative SelectCreative would pick, without
+// advancing the user's position in a sequential rotation
+func (m *Matcher) PreviewCreative(lineItem models.LineItem, width, height int, userID string) *models.Creative {
+	return m.rotate(lineItem, sizeCandidates(lineItem, width, height), userID, false)
 }
 
-// SelectCreative selects the best creative for the given dimensions
-func (m *Matcher) SelectCreative(lineItem models.LineItem, width, height int) *models.Creative {
+// sizeCandidates returns the line item's servable (approved and active) creatives
+// of the given size
+func sizeCandidates(lineItem models.LineItem, width, height int) []models.Creative {
+	var candidates []models.Creative
 	for _, creative := range lineItem.Creatives {
-		if creative.Width == width && creative.Height == height && creative.Status == "active" {
-			return &creative
+		if creative.Width == width && creative.Height == height && creative.IsServable() {
+			candidates = append(candidates, creative)
 		}
 	}
-	return nil
+	return candidates
 }
 
 // MatchResponsive filters line items that have at least one active creative with width <= maxWidth
 // If allowedSizes is non-empty, only creatives matching one of those sizes are considered.
 func (m *Matcher) MatchResponsive(targeting map[string]string, lineItems []models.LineItem, maxWidth int, allowedSizes [][]int) []models.LineItem {
 	var matched []models.LineItem
+	now := m.now()
 
 	for _, li := range lineItems {
 		hasMatchingCreative := false
 		for _, creative := range li.Creatives {
-			if creative.Width <= maxWidth && creative.Status == "active" && m.sizeAllowed(creative.Width, creative.Height, allowedSizes) {
+			if creative.Width <= maxWidth && creative.IsServable() && m.sizeAllowed(creative.Width, creative.Height, allowedSizes) {
 				hasMatchingCreative = true
 				break
 			}
@@ -125,6 +173,10 @@ func (m *Matcher) MatchResponsive(targeting map[string]string, lineItems []model
 			continue
 		}
 
+		if !inFlight(li, now) || !m.matchesDaypart(li, now) {
+			continue
+		}
+
 		if m.matchesTargeting(targeting, li.TargetingRules) {
 			matched = append(matched, li)
 		}
@@ -133,22 +185,38 @@ func (m *Matcher) MatchResponsive(targeting map[string]string, lineItems []model
 	return matched
 }
 
-// SelectCreativeResponsive picks the largest-area creative that fits within maxWidth
+// SelectCreativeResponsive picks a creative of the largest-area size that fits within maxWidth,
+// rotating between creatives of that size according to the line item's rotation mode.
 // If allowedSizes is non-empty, only creatives matching one of those sizes are considered.
-func (m *Matcher) SelectCreativeResponsive(lineItem models.LineItem, maxWidth int, allowedSizes [][]int) *models.Creative {
-	var best *models.Creative
+func (m *Matcher) SelectCreativeResponsive(lineItem models.LineItem, maxWidth int, allowedSizes [][]int, userID string) *models.Creative {
+	return m.rotate(lineItem, m.responsiveCandidates(lineItem, maxWidth, allowedSizes), userID, true)
+}
+
+// PreviewCreativeResponsive returns the creative SelectCreativeResponsive
+// would pick, without advancing the user's position in a sequential rotation
+func (m *Matcher) PreviewCreativeResponsive(lineItem models.LineItem, maxWidth int, allowedSizes [][]int, userID string) *models.Creative {
+	return m.rotate(lineItem, m.responsiveCandidates(lineItem, maxWidth, allowedSizes), userID, false)
+}
+
+// responsiveCandidates returns the line item's active creatives of the
+// largest-area size that fits within maxWidth
+func (m *Matcher) responsiveCandidates(lineItem models.LineItem, maxWidth int, allowedSizes [][]int) []models.Creative {
+	var candidates []models.Creative
 	bestArea := 0
 
-	for i, creative := range lineItem.Creatives {
-		if creative.Width <= maxWidth && creative.Status == "active" && m.sizeAllowed(creative.Width, creative.Height, allowedSizes) {
+	for _, creative := range lineItem.Creatives {
+		if creative.Width <= maxWidth && creative.IsServable() && m.sizeAllowed(creative.Width, creative.Height, allowedSizes) {
 			area := creative.Width * creative.Height
 			if area > bestArea {
 				bestArea = area
-				best = &lineItem.Creatives[i]
+				candidates = nil
+			}
+			if area == bestArea {
+				candidates = append(candidates, creative)
 			}
 		}
 	}
-	return best
+	return candidates
 }
 
 // sizeAllowed checks if a creative size matches the ad unit's allowed sizes.
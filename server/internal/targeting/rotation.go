@@ -0,0 +1,172 @@
+package targeting
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+// optimizedExploreRate is the share of requests where CTR-optimized rotation
+// ignores past performance and picks a creative at random, so that newer
+// creatives keep collecting impressions
+const optimizedExploreRate = 0.1
+
+// CTR prior used to smooth creatives with little delivery (1 click per 100 impressions)
+const (
+	ctrPriorClicks      = 1.0
+	ctrPriorImpressions = 100.0
+)
+
+// sequenceTracker remembers how far each user is into a line item's
+// sequential (storyboard) rotation
+type sequenceTracker struct {
+	mu       sync.Mutex
+	views    map[string]int // key: "lineItemId:userId"
+	lastDate string
+}
+
+// newSequenceTracker creates a sequenceTracker that resets daily
+func newSequenceTracker() *sequenceTracker {
+	t := &sequenceTracker{
+		views:    make(map[string]int),
+		lastDate: time.Now().Format("2006-01-02"),
+	}
+
+	// Start goroutine to reset sequences at midnight
+	go t.startMidnightReset()
+
+	return t
+}
+
+// next returns the user's position in the sequence and advances it
+func (t *sequenceTracker) next(lineItemID int, userID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", lineItemID, userID)
+	pos := t.views[key]
+	t.views[key]++
+	return pos
+}
+
+// peek returns the user's position in the sequence without advancing it
+func (t *sequenceTracker) peek(lineItemID int, userID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.views[fmt.Sprintf("%d:%s", lineItemID, userID)]
+}
+
+// startMidnightReset clears sequence positions when the day changes
+func (t *sequenceTracker) startMidnightReset() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.resetIfNewDay(now)
+	}
+}
+
+// resetIfNewDay clears sequence positions if now is on a later day than the
+// last reset
+func (t *sequenceTracker) resetIfNewDay(now time.Time) {
+	currentDate := now.Format("2006-01-02")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if currentDate != t.lastDate {
+		t.views = make(map[string]int)
+		t.lastDate = currentDate
+	}
+}
+
+// rotate picks one of the candidate creatives according to the line item's
+// creative rotation mode. advance is false for previews, which must not move
+// the user along a sequential rotation.
+func (m *Matcher) rotate(lineItem models.LineItem, candidates []models.Creative, userID string, advance bool) *models.Creative {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(candidates) == 1 {
+		return &candidates[0]
+	}
+
+	var i int
+	switch lineItem.CreativeRotation {
+	case models.CreativeRotationWeighted:
+		i = pickWeighted(candidates, rand.Intn)
+	case models.CreativeRotationOptimized:
+		i = pickOptimized(candidates, rand.Float64, rand.Intn)
+	case models.CreativeRotationSequential:
+		position := m.sequences.peek(lineItem.ID, userID)
+		if advance {
+			position = m.sequences.next(lineItem.ID, userID)
+		}
+		i = pickSequential(candidates, position)
+	default:
+		i = rand.Intn(len(candidates))
+	}
+	return &candidates[i]
+}
+
+// pickWeighted returns the index of a creative chosen proportionally to its weight
+func pickWeighted(creatives []models.Creative, intn func(int) int) int {
+	totalWeight := 0
+	for _, c := range creatives {
+		totalWeight += creativeWeight(c)
+	}
+
+	r := intn(totalWeight)
+	cumulative := 0
+	for i, c := range creatives {
+		cumulative += creativeWeight(c)
+		if r < cumulative {
+			return i
+		}
+	}
+	return 0
+}
+
+// pickOptimized returns the index of the creative with the best smoothed CTR,
+// exploring a random creative on a fixed share of requests (epsilon-greedy)
+func pickOptimized(creatives []models.Creative, float64n func() float64, intn func(int) int) int {
+	if float64n() < optimizedExploreRate {
+		return intn(len(creatives))
+	}
+
+	best := 0
+	bestCTR := -1.0
+	for i, c := range creatives {
+		ctr := (float64(c.Clicks) + ctrPriorClicks) / (float64(c.Impressions) + ctrPriorImpressions)
+		if ctr > bestCTR {
+			bestCTR = ctr
+			best = i
+		}
+	}
+	return best
+}
+
+// pickSequential returns the index of the creative at the given position of
+// the storyboard. Creatives are shown in creation order, looping at the end.
+func pickSequential(creatives []models.Creative, position int) int {
+	order := make([]int, len(creatives))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return creatives[order[a]].ID < creatives[order[b]].ID
+	})
+	return order[position%len(order)]
+}
+
+// creativeWeight returns the creative's rotation weight (default 100)
+func creativeWeight(c models.Creative) int {
+	if c.Weight <= 0 {
+		return 100
+	}
+	return c.Weight
+}
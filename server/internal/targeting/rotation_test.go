@@ -0,0 +1,190 @@
+package targeting
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mims/ad-manager/internal/models"
+)
+
+func TestPickWeightedDistribution(t *testing.T) {
+	creatives := []models.Creative{
+		{ID: 1, Weight: 10},
+		{ID: 2, Weight: 30},
+		{ID: 3, Weight: 60},
+	}
+
+	// Walking every value intn can return hits each creative exactly its
+	// weight's number of times
+	counts := make([]int, len(creatives))
+	for r := 0; r < 100; r++ {
+		counts[pickWeighted(creatives, func(n int) int {
+			if n != 100 {
+				t.Fatalf("intn called with %d, want the total weight 100", n)
+			}
+			return r
+		})]++
+	}
+	for i, c := range creatives {
+		if counts[i] != c.Weight {
+			t.Errorf("creative %d picked %d times out of 100, want %d", c.ID, counts[i], c.Weight)
+		}
+	}
+
+	// A seeded source lands close to the weights
+	rng := rand.New(rand.NewSource(1))
+	const draws = 100000
+	counts = make([]int, len(creatives))
+	for i := 0; i < draws; i++ {
+		counts[pickWeighted(creatives, rng.Intn)]++
+	}
+	for i, c := range creatives {
+		share := float64(counts[i]) / draws
+		want := float64(c.Weight) / 100
+		if math.Abs(share-want) > 0.01 {
+			t.Errorf("creative %d share %.3f, want %.2f", c.ID, share, want)
+		}
+	}
+}
+
+func TestPickWeightedDefaultWeight(t *testing.T) {
+	// Creatives without a weight count as 100
+	creatives := []models.Creative{{ID: 1}, {ID: 2, Weight: 100}}
+	counts := make([]int, len(creatives))
+	for r := 0; r < 200; r++ {
+		counts[pickWeighted(creatives, func(int) int { return r })]++
+	}
+	if counts[0] != 100 || counts[1] != 100 {
+		t.Errorf("counts = %v, want [100 100]", counts)
+	}
+}
+
+func TestPickOptimizedExploits(t *testing.T) {
+	creatives := []models.Creative{
+		{ID: 1, Impressions: 10000, Clicks: 50},  // 0.5%
+		{ID: 2, Impressions: 10000, Clicks: 200}, // 2%
+		{ID: 3, Impressions: 0, Clicks: 0},       // prior only, 1%
+	}
+
+	explore := func(int) int {
+		t.Fatal("explored when the draw was above the explore rate")
+		return 0
+	}
+	for _, f := range []float64{optimizedExploreRate, 0.5, 0.99} {
+		if got := pickOptimized(creatives, func() float64 { return f }, explore); got != 1 {
+			t.Errorf("draw %.2f picked index %d, want 1 (best CTR)", f, got)
+		}
+	}
+}
+
+func TestPickOptimizedSmoothsSmallSamples(t *testing.T) {
+	// One click from two impressions is a 50% raw CTR, but the prior pulls
+	// it below a creative with a steady 2% over a large sample
+	creatives := []models.Creative{
+		{ID: 1, Impressions: 2, Clicks: 1},
+		{ID: 2, Impressions: 10000, Clicks: 200},
+	}
+	if got := pickOptimized(creatives, func() float64 { return 0.5 }, rand.Intn); got != 1 {
+		t.Errorf("picked index %d, want 1", got)
+	}
+}
+
+func TestPickOptimizedExploreRate(t *testing.T) {
+	creatives := []models.Creative{
+		{ID: 1, Impressions: 10000, Clicks: 500},
+		{ID: 2, Impressions: 10000, Clicks: 10},
+		{ID: 3, Impressions: 10000, Clicks: 10},
+	}
+
+	// Below the explore rate the pick comes from intn over every creative
+	for _, f := range []float64{0, optimizedExploreRate / 2, math.Nextafter(optimizedExploreRate, 0)} {
+		got := pickOptimized(creatives, func() float64 { return f }, func(n int) int {
+			if n != len(creatives) {
+				t.Fatalf("intn called with %d, want %d", n, len(creatives))
+			}
+			return 2
+		})
+		if got != 2 {
+			t.Errorf("draw %v picked index %d, want the explored index 2", f, got)
+		}
+	}
+
+	// With a seeded source, the worse creatives together get about the
+	// explore rate times their share of exploration
+	rng := rand.New(rand.NewSource(1))
+	const draws = 100000
+	others := 0
+	for i := 0; i < draws; i++ {
+		if pickOptimized(creatives, rng.Float64, rng.Intn) != 0 {
+			others++
+		}
+	}
+	share := float64(others) / draws
+	want := optimizedExploreRate * 2 / 3
+	if math.Abs(share-want) > 0.005 {
+		t.Errorf("non-best share %.4f, want %.4f", share, want)
+	}
+}
+
+func TestPickSequentialOrderAndWrap(t *testing.T) {
+	// Listed out of order; the storyboard follows creative IDs
+	creatives := []models.Creative{{ID: 30}, {ID: 10}, {ID: 20}}
+	want := []int{10, 20, 30, 10, 20, 30, 10}
+	for position, id := range want {
+		if got := creatives[pickSequential(creatives, position)].ID; got != id {
+			t.Errorf("position %d showed creative %d, want %d", position, got, id)
+		}
+	}
+}
+
+func TestSequenceTrackerResetsDaily(t *testing.T) {
+	day := time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC)
+	tracker := &sequenceTracker{
+		views:    make(map[string]int),
+		lastDate: day.Format("2006-01-02"),
+	}
+	creatives := []models.Creative{{ID: 2}, {ID: 1}}
+
+	var shown []int
+	for i := 0; i < 3; i++ {
+		shown = append(shown, creatives[pickSequential(creatives, tracker.next(7, "user"))].ID)
+	}
+	if want := []int{1, 2, 1}; !equalInts(shown, want) {
+		t.Fatalf("first day showed %v, want %v", shown, want)
+	}
+	if got := tracker.peek(7, "user"); got != 3 {
+		t.Errorf("peek = %d, want 3", got)
+	}
+	if got := tracker.next(8, "user"); got != 0 {
+		t.Errorf("another line item starts at %d, want 0", got)
+	}
+
+	// Same day: nothing changes
+	tracker.resetIfNewDay(day.Add(30 * time.Second))
+	if got := tracker.peek(7, "user"); got != 3 {
+		t.Errorf("peek after same-day check = %d, want 3", got)
+	}
+
+	// Next day: every user starts the storyboard again
+	tracker.resetIfNewDay(day.Add(2 * time.Minute))
+	if got := tracker.peek(7, "user"); got != 0 {
+		t.Errorf("peek after midnight = %d, want 0", got)
+	}
+	if got := creatives[pickSequential(creatives, tracker.next(7, "user"))].ID; got != 1 {
+		t.Errorf("first creative after midnight = %d, want 1", got)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
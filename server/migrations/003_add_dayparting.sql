@@ -0,0 +1,12 @@
+-- Dayparting: per-weekday serving windows evaluated in the campaign timezone
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS line_item_dayparts (
+    id SERIAL PRIMARY KEY,
+    line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+    start_time VARCHAR(5) NOT NULL,
+    end_time VARCHAR(5) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_line_item_dayparts_line_item ON line_item_dayparts(line_item_id);
@@ -0,0 +1,3 @@
+-- Competitive exclusions: advertiser and category labels kept apart on a page
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS advertiser VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS competitive_exclusions TEXT[] NOT NULL DEFAULT '{}';
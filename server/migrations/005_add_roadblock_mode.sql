@@ -0,0 +1,2 @@
+-- Roadblocks: place a winning line item across every compatible slot of a request
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS roadblock_mode VARCHAR(30) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Creative rotation: per-line-item rotation mode and per-creative weights
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS creative_rotation VARCHAR(20) NOT NULL DEFAULT 'even';
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS weight INTEGER NOT NULL DEFAULT 100;
+CREATE INDEX IF NOT EXISTS idx_events_creative ON events(creative_id, created_at);
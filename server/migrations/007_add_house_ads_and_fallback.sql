@@ -0,0 +1,5 @@
+-- House ads and unfilled-slot fallback
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS line_type VARCHAR(20) NOT NULL DEFAULT 'standard';
+ALTER TABLE ad_units ADD COLUMN IF NOT EXISTS fallback_mode VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE ad_units ADD COLUMN IF NOT EXISTS passback_url VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE ad_units ADD COLUMN IF NOT EXISTS passback_html TEXT NOT NULL DEFAULT '';
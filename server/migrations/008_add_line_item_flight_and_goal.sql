@@ -0,0 +1,4 @@
+-- Line item flight dates and impression goals (used by serving and forecasting)
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS start_date TIMESTAMP;
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS end_date TIMESTAMP;
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS impression_goal INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,17 @@
+-- Sampled log of ad slot opportunities (filled and unfilled) for fill-rate reporting
+CREATE TABLE IF NOT EXISTS ad_opportunities (
+    id BIGSERIAL PRIMARY KEY,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    targeting JSONB NOT NULL DEFAULT '{}',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    filled BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL DEFAULT '',
+    line_item_id INTEGER,
+    sample_rate DOUBLE PRECISION NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_ad_opportunities_created ON ad_opportunities(created_at);
+CREATE INDEX IF NOT EXISTS idx_ad_opportunities_ad_unit ON ad_opportunities(ad_unit, created_at);
@@ -0,0 +1,23 @@
+-- Hourly event counts per line item, creative, ad unit and key-value, kept
+-- up to date by the rollup aggregator and read by the reports
+CREATE TABLE IF NOT EXISTS event_rollups_hourly (
+    hour TIMESTAMP NOT NULL,
+    line_item_id INTEGER NOT NULL DEFAULT 0,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    section VARCHAR(100) NOT NULL DEFAULT '',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    viewable INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, line_item_id, creative_id, ad_unit, country, section, platform)
+);
+CREATE INDEX IF NOT EXISTS idx_event_rollups_line_item ON event_rollups_hourly(line_item_id, hour);
+
+-- Watermark of each rollup: hours before rolled_until are served from the rollup
+CREATE TABLE IF NOT EXISTS rollup_state (
+    name VARCHAR(50) PRIMARY KEY,
+    rolled_until TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
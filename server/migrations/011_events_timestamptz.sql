@@ -0,0 +1,6 @@
+-- Store event times as absolute instants so reports can bucket days and
+-- hours in any timezone. Existing values were written in UTC.
+ALTER TABLE events ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE ad_opportunities ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE event_rollups_hourly ALTER COLUMN hour TYPE TIMESTAMPTZ USING hour AT TIME ZONE 'UTC';
+ALTER TABLE rollup_state ALTER COLUMN rolled_until TYPE TIMESTAMPTZ USING rolled_until AT TIME ZONE 'UTC';
@@ -0,0 +1,13 @@
+-- Hourly HyperLogLog sketches of the users each line item reached, merged
+-- at query time for unique reach over any range
+CREATE TABLE IF NOT EXISTS reach_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    line_item_id INTEGER NOT NULL,
+    sketch BYTEA NOT NULL,
+    PRIMARY KEY (hour, line_item_id)
+);
+CREATE INDEX IF NOT EXISTS idx_reach_rollups_line_item ON reach_rollups_hourly(line_item_id, hour);
+
+-- Reset the rollup watermark so the aggregator re-rolls existing hours and
+-- builds their sketches
+DELETE FROM rollup_state WHERE name = 'events_hourly';
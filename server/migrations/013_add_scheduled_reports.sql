@@ -0,0 +1,39 @@
+-- Saved report builder queries delivered on a cron schedule by email or
+-- webhook
+CREATE TABLE IF NOT EXISTS scheduled_reports (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    query JSONB NOT NULL DEFAULT '{}',
+    date_range VARCHAR(20) NOT NULL DEFAULT 'last_7_days',
+    schedule VARCHAR(100) NOT NULL,
+    format VARCHAR(10) NOT NULL DEFAULT 'csv',
+    delivery VARCHAR(10) NOT NULL,
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_reports_due ON scheduled_reports(next_run_at) WHERE status = 'active';
+
+-- Run history of scheduled reports; failed runs are retried until their
+-- attempts run out
+CREATE TABLE IF NOT EXISTS scheduled_report_runs (
+    id SERIAL PRIMARY KEY,
+    report_id INTEGER NOT NULL REFERENCES scheduled_reports(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    start_date DATE,
+    end_date DATE,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_report_runs_report ON scheduled_report_runs(report_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_report_runs_due ON scheduled_report_runs(next_attempt_at) WHERE status IN ('pending', 'retrying', 'running');
@@ -0,0 +1,29 @@
+-- Delivery problems raised by the monitor; at most one open alert per type,
+-- line item and creative
+CREATE TABLE IF NOT EXISTS alerts (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(30) NOT NULL,
+    severity VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    line_item_id INTEGER NOT NULL REFERENCES line_items(id) ON DELETE CASCADE,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    message TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    acknowledged_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_open ON alerts(type, line_item_id, creative_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_alerts_created ON alerts(created_at);
+
+-- URLs alerts are pushed to
+CREATE TABLE IF NOT EXISTS alert_webhooks (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    alert_types TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
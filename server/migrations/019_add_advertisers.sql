@@ -0,0 +1,37 @@
+-- Advertisers and the agencies that book campaigns for them, with contact
+-- and billing details
+CREATE TABLE IF NOT EXISTS advertisers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL DEFAULT '',
+    contact_email VARCHAR(255) NOT NULL DEFAULT '',
+    contact_phone VARCHAR(50) NOT NULL DEFAULT '',
+    billing_address TEXT NOT NULL DEFAULT '',
+    billing_email VARCHAR(255) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS agencies (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL DEFAULT '',
+    contact_email VARCHAR(255) NOT NULL DEFAULT '',
+    contact_phone VARCHAR(50) NOT NULL DEFAULT '',
+    billing_address TEXT NOT NULL DEFAULT '',
+    billing_email VARCHAR(255) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- A campaign belongs to an advertiser and may be booked through an agency
+-- under an order (IO) number
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS advertiser_id INTEGER REFERENCES advertisers(id);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS agency_id INTEGER REFERENCES agencies(id);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS order_number VARCHAR(100) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_campaigns_advertiser ON campaigns(advertiser_id);
+CREATE INDEX IF NOT EXISTS idx_campaigns_agency ON campaigns(agency_id);
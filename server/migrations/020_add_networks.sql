@@ -0,0 +1,49 @@
+-- Networks (tenants): each publisher served by this deployment has its own
+-- inventory, campaigns, reporting and users. Existing data moves to the
+-- default network, which is the one that manages the others.
+CREATE TABLE IF NOT EXISTS networks (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO networks (id, code, name) VALUES (1, 'default', 'Default Network')
+    ON CONFLICT (id) DO NOTHING;
+SELECT setval('networks_id_seq', GREATEST((SELECT MAX(id) FROM networks), 1));
+
+ALTER TABLE targeting_keys ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE ad_units ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE advertisers ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE agencies ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE line_items ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE ad_opportunities ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE event_rollups_hourly ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE scheduled_reports ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE alert_webhooks ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS network_id INTEGER NOT NULL DEFAULT 1;
+
+-- Ad unit codes and targeting keys are unique within a network; two
+-- publishers may both have a "homepage_leaderboard"
+ALTER TABLE ad_units DROP CONSTRAINT IF EXISTS ad_units_code_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ad_units_network_code ON ad_units(network_id, code);
+ALTER TABLE targeting_keys DROP CONSTRAINT IF EXISTS targeting_keys_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_targeting_keys_network_key ON targeting_keys(network_id, key);
+
+-- Rollup rows are per network
+ALTER TABLE event_rollups_hourly DROP CONSTRAINT IF EXISTS event_rollups_hourly_pkey;
+ALTER TABLE event_rollups_hourly ADD PRIMARY KEY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform);
+
+CREATE INDEX IF NOT EXISTS idx_events_network ON events(network_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ad_opportunities_network ON ad_opportunities(network_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_campaigns_network ON campaigns(network_id);
+CREATE INDEX IF NOT EXISTS idx_line_items_network ON line_items(network_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_network ON audit_log(network_id, id);
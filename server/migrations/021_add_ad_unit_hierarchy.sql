@@ -0,0 +1,7 @@
+-- Ad units form a tree (site > news > article_sidebar). Line items can target
+-- a node with its descendants and exclude nodes below it.
+ALTER TABLE ad_units ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES ad_units(id);
+CREATE INDEX IF NOT EXISTS idx_ad_units_parent ON ad_units(parent_id);
+
+ALTER TABLE line_item_ad_units ADD COLUMN IF NOT EXISTS include_descendants BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE line_item_ad_units ADD COLUMN IF NOT EXISTS excluded BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,25 @@
+-- Placements are named groups of ad units ("Homepage Takeover", "All
+-- Leaderboards") that line items can target instead of listing ad units
+CREATE TABLE IF NOT EXISTS placements (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(network_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS placement_ad_units (
+    placement_id INTEGER REFERENCES placements(id) ON DELETE CASCADE,
+    ad_unit_id INTEGER REFERENCES ad_units(id) ON DELETE CASCADE,
+    PRIMARY KEY (placement_id, ad_unit_id)
+);
+CREATE INDEX IF NOT EXISTS idx_placement_ad_units_ad_unit ON placement_ad_units(ad_unit_id);
+
+CREATE TABLE IF NOT EXISTS line_item_placements (
+    line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
+    placement_id INTEGER REFERENCES placements(id),
+    PRIMARY KEY (line_item_id, placement_id)
+);
+CREATE INDEX IF NOT EXISTS idx_line_item_placements_placement ON line_item_placements(placement_id);
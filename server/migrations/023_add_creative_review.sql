@@ -0,0 +1,20 @@
+-- Creatives go through review before they can serve:
+-- draft -> pending_review -> approved / rejected -> active / paused -> archived.
+-- Creatives that were already live are treated as approved.
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS submitted_at TIMESTAMP;
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS reviewed_by VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;
+ALTER TABLE creatives ADD COLUMN IF NOT EXISTS rejection_reason TEXT NOT NULL DEFAULT '';
+
+UPDATE creatives SET status = 'paused' WHERE status = 'inactive';
+UPDATE creatives SET status = 'draft'
+    WHERE status IS NULL OR status NOT IN ('draft', 'pending_review', 'approved', 'rejected', 'active', 'paused', 'archived');
+UPDATE creatives SET reviewed_at = created_at WHERE status IN ('approved', 'active', 'paused') AND reviewed_at IS NULL;
+
+ALTER TABLE creatives ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE creatives ALTER COLUMN status SET NOT NULL;
+ALTER TABLE creatives DROP CONSTRAINT IF EXISTS creatives_status_check;
+ALTER TABLE creatives ADD CONSTRAINT creatives_status_check
+    CHECK (status IN ('draft', 'pending_review', 'approved', 'rejected', 'active', 'paused', 'archived'));
+
+CREATE INDEX IF NOT EXISTS idx_creatives_pending_review ON creatives(network_id, submitted_at) WHERE status = 'pending_review';
@@ -0,0 +1,21 @@
+-- Key-values of the ad request each event came from, so the report builder
+-- can group by any targeting key, not just country, section and platform
+ALTER TABLE events ADD COLUMN IF NOT EXISTS targeting JSONB NOT NULL DEFAULT '{}';
+
+-- Hourly event counts like event_rollups_hourly, split further by the
+-- request's key-values. Only read by report queries on a targeting key.
+CREATE TABLE IF NOT EXISTS event_key_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    line_item_id INTEGER NOT NULL DEFAULT 0,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    section VARCHAR(100) NOT NULL DEFAULT '',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    targeting JSONB NOT NULL DEFAULT '{}',
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    viewable INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, targeting)
+);
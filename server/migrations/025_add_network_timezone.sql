@@ -0,0 +1,5 @@
+-- Each network has its own timezone, used for dayparting when a campaign
+-- has none and for reports that don't ask for one. The default network's
+-- stays NULL until the server seeds it from NETWORK_TIMEZONE.
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+UPDATE networks SET timezone = 'UTC' WHERE timezone IS NULL AND id <> 1;
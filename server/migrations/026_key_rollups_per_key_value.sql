@@ -0,0 +1,32 @@
+-- Key-value rollups hold one row per (key, value) pair instead of per full
+-- key-value set, so their size grows with the values of each key rather
+-- than with every combination of them. Existing rows are split into pairs.
+ALTER TABLE event_key_rollups_hourly RENAME TO event_key_rollups_hourly_old;
+
+CREATE TABLE IF NOT EXISTS event_key_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    line_item_id INTEGER NOT NULL DEFAULT 0,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    section VARCHAR(100) NOT NULL DEFAULT '',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    target_key TEXT NOT NULL,
+    target_value TEXT NOT NULL DEFAULT '',
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    viewable INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, target_key, target_value)
+);
+
+INSERT INTO event_key_rollups_hourly (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, target_key, target_value, impressions, clicks, viewable)
+SELECT r.hour, r.network_id, r.line_item_id, r.creative_id, r.ad_unit, r.country, r.section, r.platform,
+       kv.key, COALESCE(kv.value, ''), SUM(r.impressions), SUM(r.clicks), SUM(r.viewable)
+FROM event_key_rollups_hourly_old r
+CROSS JOIN LATERAL jsonb_each_text(r.targeting) kv
+GROUP BY 1, 2, 3, 4, 5, 6, 7, 8, 9, 10;
+
+DROP TABLE event_key_rollups_hourly_old;
+
+CREATE INDEX IF NOT EXISTS idx_event_key_rollups_network_key ON event_key_rollups_hourly(network_id, target_key, hour);
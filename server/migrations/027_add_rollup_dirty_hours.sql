@@ -0,0 +1,7 @@
+-- Hours before the rollup watermark that received events after they were
+-- rolled up. The aggregator re-rolls them instead of re-rolling a fixed
+-- window of recent hours on every run.
+CREATE TABLE IF NOT EXISTS rollup_dirty_hours (
+    hour TIMESTAMPTZ PRIMARY KEY,
+    marked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
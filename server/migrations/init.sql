@@ -1,24 +1,76 @@
 -- MIMS Ad Manager - Database Schema
 -- POC Version
 
+-- Networks (tenants): each publisher has its own inventory, campaigns,
+-- reporting and users. The default network manages the others.
+CREATE TABLE networks (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) DEFAULT 'active',
+    -- Dayparting and report default; NULL until seeded from NETWORK_TIMEZONE
+    timezone VARCHAR(64),
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
 -- Targeting Keys (for auto-suggest)
 CREATE TABLE targeting_keys (
     id SERIAL PRIMARY KEY,
-    key VARCHAR(100) NOT NULL UNIQUE,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    key VARCHAR(100) NOT NULL,
     values JSONB DEFAULT '[]',
     created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW()
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(network_id, key)
 );
 
 -- Ad Units (inventory hierarchy like GAM)
 CREATE TABLE ad_units (
     id SERIAL PRIMARY KEY,
-    code VARCHAR(100) NOT NULL UNIQUE,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    parent_id INTEGER REFERENCES ad_units(id),
+    code VARCHAR(100) NOT NULL,
     name VARCHAR(255) NOT NULL,
     description TEXT,
     platform VARCHAR(20) DEFAULT 'web',
     sizes JSONB DEFAULT '[]',
     status VARCHAR(20) DEFAULT 'active',
+    fallback_mode VARCHAR(20) NOT NULL DEFAULT '',
+    passback_url VARCHAR(500) NOT NULL DEFAULT '',
+    passback_html TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(network_id, code)
+);
+
+-- Advertisers and the agencies that book campaigns for them
+CREATE TABLE advertisers (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL DEFAULT '',
+    contact_email VARCHAR(255) NOT NULL DEFAULT '',
+    contact_phone VARCHAR(50) NOT NULL DEFAULT '',
+    billing_address TEXT NOT NULL DEFAULT '',
+    billing_email VARCHAR(255) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE agencies (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL DEFAULT '',
+    contact_email VARCHAR(255) NOT NULL DEFAULT '',
+    contact_phone VARCHAR(50) NOT NULL DEFAULT '',
+    billing_address TEXT NOT NULL DEFAULT '',
+    billing_email VARCHAR(255) NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW()
 );
@@ -26,8 +78,13 @@ CREATE TABLE ad_units (
 -- Campaigns
 CREATE TABLE campaigns (
     id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
     name VARCHAR(255) NOT NULL,
     status VARCHAR(20) DEFAULT 'active',
+    timezone VARCHAR(64),
+    advertiser_id INTEGER REFERENCES advertisers(id),
+    agency_id INTEGER REFERENCES agencies(id),
+    order_number VARCHAR(100) NOT NULL DEFAULT '',
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW()
 );
@@ -35,6 +92,7 @@ CREATE TABLE campaigns (
 -- Line Items
 CREATE TABLE line_items (
     id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
     campaign_id INTEGER REFERENCES campaigns(id) ON DELETE CASCADE,
     name VARCHAR(255) NOT NULL,
     priority INTEGER DEFAULT 5,
@@ -43,6 +101,14 @@ CREATE TABLE line_items (
     frequency_cap INTEGER DEFAULT 0,
     frequency_cap_period VARCHAR(20) DEFAULT 'day',
     status VARCHAR(20) DEFAULT 'active',
+    advertiser VARCHAR(255) NOT NULL DEFAULT '',
+    competitive_exclusions TEXT[] NOT NULL DEFAULT '{}',
+    roadblock_mode VARCHAR(30) NOT NULL DEFAULT '',
+    creative_rotation VARCHAR(20) NOT NULL DEFAULT 'even',
+    line_type VARCHAR(20) NOT NULL DEFAULT 'standard',
+    start_date TIMESTAMP,
+    end_date TIMESTAMP,
+    impression_goal INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW()
 );
@@ -52,9 +118,46 @@ CREATE TABLE line_item_ad_units (
     id SERIAL PRIMARY KEY,
     line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
     ad_unit_id INTEGER REFERENCES ad_units(id) ON DELETE CASCADE,
+    -- include_descendants also targets the ad units below it; excluded
+    -- removes the ad unit and its descendants from the line item
+    include_descendants BOOLEAN NOT NULL DEFAULT false,
+    excluded BOOLEAN NOT NULL DEFAULT false,
     UNIQUE(line_item_id, ad_unit_id)
 );
 
+-- Placements: named groups of ad units line items can target
+CREATE TABLE placements (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(network_id, name)
+);
+
+CREATE TABLE placement_ad_units (
+    placement_id INTEGER REFERENCES placements(id) ON DELETE CASCADE,
+    ad_unit_id INTEGER REFERENCES ad_units(id) ON DELETE CASCADE,
+    PRIMARY KEY (placement_id, ad_unit_id)
+);
+
+-- Line Item to Placement targeting (many-to-many)
+CREATE TABLE line_item_placements (
+    line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
+    placement_id INTEGER REFERENCES placements(id),
+    PRIMARY KEY (line_item_id, placement_id)
+);
+
+-- Dayparting (weekly serving windows, evaluated in the campaign timezone)
+CREATE TABLE line_item_dayparts (
+    id SERIAL PRIMARY KEY,
+    line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+    start_time VARCHAR(5) NOT NULL,
+    end_time VARCHAR(5) NOT NULL
+);
+
 -- Targeting Rules (key-value targeting)
 CREATE TABLE targeting_rules (
     id SERIAL PRIMARY KEY,
@@ -67,20 +170,30 @@ CREATE TABLE targeting_rules (
 -- Creatives
 CREATE TABLE creatives (
     id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
     line_item_id INTEGER REFERENCES line_items(id) ON DELETE CASCADE,
     name VARCHAR(255),
     width INTEGER NOT NULL,
     height INTEGER NOT NULL,
     image_url VARCHAR(500) NOT NULL,
     click_url VARCHAR(500) NOT NULL,
-    status VARCHAR(20) DEFAULT 'active',
+    -- draft -> pending_review -> approved / rejected -> active / paused -> archived;
+    -- only active creatives serve
+    status VARCHAR(20) NOT NULL DEFAULT 'draft'
+        CHECK (status IN ('draft', 'pending_review', 'approved', 'rejected', 'active', 'paused', 'archived')),
+    weight INTEGER NOT NULL DEFAULT 100,
     created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW()
+    updated_at TIMESTAMP DEFAULT NOW(),
+    submitted_at TIMESTAMP,
+    reviewed_by VARCHAR(255) NOT NULL DEFAULT '',
+    reviewed_at TIMESTAMP,
+    rejection_reason TEXT NOT NULL DEFAULT ''
 );
 
 -- Events (impressions, clicks, viewable)
 CREATE TABLE events (
     id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1,
     event_type VARCHAR(20) NOT NULL,
     impression_id VARCHAR(50),
     line_item_id INTEGER,
@@ -90,9 +203,210 @@ CREATE TABLE events (
     platform VARCHAR(20),
     ad_unit VARCHAR(100),
     section VARCHAR(100),
+    targeting JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Ad slot opportunities (sampled log of filled and unfilled slots)
+CREATE TABLE ad_opportunities (
+    id BIGSERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    targeting JSONB NOT NULL DEFAULT '{}',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    filled BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL DEFAULT '',
+    line_item_id INTEGER,
+    sample_rate DOUBLE PRECISION NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Hourly event rollups (maintained by the rollup aggregator)
+CREATE TABLE event_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    line_item_id INTEGER NOT NULL DEFAULT 0,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    section VARCHAR(100) NOT NULL DEFAULT '',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    viewable INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform)
+);
+
+-- Hourly event rollups with one row per key-value pair of the requests,
+-- for report queries on any targeting key
+CREATE TABLE event_key_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    line_item_id INTEGER NOT NULL DEFAULT 0,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    ad_unit VARCHAR(100) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    section VARCHAR(100) NOT NULL DEFAULT '',
+    platform VARCHAR(20) NOT NULL DEFAULT '',
+    target_key TEXT NOT NULL,
+    target_value TEXT NOT NULL DEFAULT '',
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    viewable INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, network_id, line_item_id, creative_id, ad_unit, country, section, platform, target_key, target_value)
+);
+
+-- Hourly reach sketches (HyperLogLog) per line item
+CREATE TABLE reach_rollups_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    line_item_id INTEGER NOT NULL,
+    sketch BYTEA NOT NULL,
+    PRIMARY KEY (hour, line_item_id)
+);
+
+CREATE TABLE rollup_state (
+    name VARCHAR(50) PRIMARY KEY,
+    rolled_until TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Rolled-up hours that received events since, waiting to be rolled up again
+CREATE TABLE rollup_dirty_hours (
+    hour TIMESTAMPTZ PRIMARY KEY,
+    marked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Scheduled reports (run by the report scheduler)
+CREATE TABLE scheduled_reports (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    query JSONB NOT NULL DEFAULT '{}',
+    date_range VARCHAR(20) NOT NULL DEFAULT 'last_7_days',
+    schedule VARCHAR(100) NOT NULL,
+    format VARCHAR(10) NOT NULL DEFAULT 'csv',
+    delivery VARCHAR(10) NOT NULL,
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) DEFAULT 'active',
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE scheduled_report_runs (
+    id SERIAL PRIMARY KEY,
+    report_id INTEGER NOT NULL REFERENCES scheduled_reports(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    start_date DATE,
+    end_date DATE,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE export_jobs (
+    id UUID PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    format VARCHAR(10) NOT NULL,
+    query JSONB NOT NULL DEFAULT '{}',
+    start_at TIMESTAMPTZ NOT NULL,
+    end_at TIMESTAMPTZ NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    row_count INTEGER NOT NULL DEFAULT 0,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    file_path TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE TABLE alerts (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(30) NOT NULL,
+    severity VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    line_item_id INTEGER NOT NULL REFERENCES line_items(id) ON DELETE CASCADE,
+    creative_id INTEGER NOT NULL DEFAULT 0,
+    message TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    acknowledged_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE alert_webhooks (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    alert_types TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    email VARCHAR(255) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    password_hash TEXT NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    status VARCHAR(20) DEFAULT 'active',
+    last_login_at TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE sessions (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1 REFERENCES networks(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    last_used_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
     created_at TIMESTAMP DEFAULT NOW()
 );
 
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    network_id INTEGER NOT NULL DEFAULT 1,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id INTEGER NOT NULL DEFAULT 0,
+    actor_name VARCHAR(255) NOT NULL DEFAULT '',
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
 -- Indexes for reporting
 CREATE INDEX idx_events_type_created ON events(event_type, created_at);
 CREATE INDEX idx_events_line_item ON events(line_item_id, created_at);
@@ -100,11 +414,53 @@ CREATE INDEX idx_events_impression_id ON events(impression_id);
 CREATE INDEX idx_events_country ON events(country, created_at);
 CREATE INDEX idx_events_section ON events(section, created_at);
 CREATE INDEX idx_events_ad_unit ON events(ad_unit, created_at);
+CREATE INDEX idx_events_creative ON events(creative_id, created_at);
+CREATE INDEX idx_events_network ON events(network_id, created_at);
+CREATE INDEX idx_campaigns_network ON campaigns(network_id);
+CREATE INDEX idx_campaigns_advertiser ON campaigns(advertiser_id);
+CREATE INDEX idx_campaigns_agency ON campaigns(agency_id);
+CREATE INDEX idx_line_items_network ON line_items(network_id);
 CREATE INDEX idx_line_items_campaign ON line_items(campaign_id);
 CREATE INDEX idx_line_items_status ON line_items(status);
 CREATE INDEX idx_creatives_line_item ON creatives(line_item_id);
+CREATE INDEX idx_creatives_pending_review ON creatives(network_id, submitted_at) WHERE status = 'pending_review';
 CREATE INDEX idx_targeting_rules_line_item ON targeting_rules(line_item_id);
+CREATE INDEX idx_line_item_dayparts_line_item ON line_item_dayparts(line_item_id);
 CREATE INDEX idx_ad_units_code ON ad_units(code);
+CREATE INDEX idx_ad_units_parent ON ad_units(parent_id);
+CREATE INDEX idx_placement_ad_units_ad_unit ON placement_ad_units(ad_unit_id);
+CREATE INDEX idx_line_item_placements_placement ON line_item_placements(placement_id);
+CREATE INDEX idx_ad_opportunities_created ON ad_opportunities(created_at);
+CREATE INDEX idx_ad_opportunities_ad_unit ON ad_opportunities(ad_unit, created_at);
+CREATE INDEX idx_ad_opportunities_network ON ad_opportunities(network_id, created_at);
+CREATE INDEX idx_event_rollups_line_item ON event_rollups_hourly(line_item_id, hour);
+CREATE INDEX idx_event_key_rollups_network_key ON event_key_rollups_hourly(network_id, target_key, hour);
+CREATE INDEX idx_reach_rollups_line_item ON reach_rollups_hourly(line_item_id, hour);
+CREATE INDEX idx_scheduled_reports_due ON scheduled_reports(next_run_at) WHERE status = 'active';
+CREATE INDEX idx_scheduled_report_runs_report ON scheduled_report_runs(report_id, created_at);
+CREATE INDEX idx_scheduled_report_runs_due ON scheduled_report_runs(next_attempt_at) WHERE status IN ('pending', 'retrying', 'running');
+CREATE INDEX idx_export_jobs_pending ON export_jobs(created_at) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_export_jobs_expires ON export_jobs(expires_at);
+CREATE UNIQUE INDEX idx_alerts_open ON alerts(type, line_item_id, creative_id) WHERE status = 'open';
+CREATE INDEX idx_alerts_created ON alerts(created_at);
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+CREATE INDEX idx_sessions_expires ON sessions(expires_at);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id, id);
+CREATE INDEX idx_audit_log_created ON audit_log(created_at);
+CREATE INDEX idx_audit_log_network ON audit_log(network_id, id);
+
+-- Audit entries can't be changed or deleted
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+-- The default network
+INSERT INTO networks (code, name) VALUES ('default', 'Default Network');
 
 -- Insert sample Targeting Keys
 INSERT INTO targeting_keys (key, values) VALUES
@@ -173,3 +529,6 @@ INSERT INTO creatives (line_item_id, name, width, height, image_url, click_url)
     (3, 'Sports Rectangle', 300, 250, 'https://picsum.photos/300/250?random=6', 'https://example.com/landing3'),
     (4, 'Article Only Leaderboard', 728, 90, 'https://picsum.photos/728/90?random=7', 'https://example.com/landing4'),
     (4, 'Article Only Rectangle', 300, 250, 'https://picsum.photos/300/250?random=8', 'https://example.com/landing4');
+
+-- The sample creatives are live, as if ad ops had approved them
+UPDATE creatives SET status = 'active', reviewed_by = 'seed', reviewed_at = NOW();
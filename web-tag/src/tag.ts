@@ -30,6 +30,10 @@ interface AdResult {
     viewable: string;
     click: string;
   };
+  fallback?: 'none' | 'house' | 'passback' | 'collapse';
+  reason?: string;
+  passback_url?: string;
+  passback_html?: string;
 }
 
 interface Config {
@@ -50,6 +54,11 @@ function generateUserId(): string {
   return id;
 }
 
+// Generate an ID for the current page view
+function generatePageViewId(): string {
+  return 'pv_' + Date.now().toString(36) + Math.random().toString(36).substring(2, 10);
+}
+
 // Main MIMSAds object
 const MIMSAds = (function() {
   let config: Config = {
@@ -59,6 +68,7 @@ const MIMSAds = (function() {
     country: '',
   };
 
+  let pageViewId = generatePageViewId();
   const slots: Map<string, SlotConfig> = new Map();
   const targeting: Map<string, string> = new Map();
   const displayedAds: Map<string, AdResult> = new Map();
@@ -173,6 +183,7 @@ const MIMSAds = (function() {
           user_id: config.userId,
           platform: config.platform,
           country: config.country,
+          page_view_id: pageViewId,
         }),
       });
 
@@ -205,6 +216,12 @@ const MIMSAds = (function() {
       return;
     }
 
+    // Unfilled slot: follow the ad unit's fallback instruction
+    if (!ad.image_url) {
+      renderFallback(ad, container);
+      return;
+    }
+
     // Store the ad for reference
     displayedAds.set(ad.slot_id, ad);
 
@@ -260,8 +277,9 @@ const MIMSAds = (function() {
     link.appendChild(img);
     adContainer.appendChild(link);
 
-    // Clear container and add ad
+    // Clear container and add ad (un-collapsing it if an earlier request collapsed it)
     container.innerHTML = '';
+    container.style.display = '';
     container.appendChild(adContainer);
 
     // Fire impression pixel
@@ -271,6 +289,38 @@ const MIMSAds = (function() {
     setupViewabilityTracking(ad, adContainer);
   }
 
+  /**
+   * Handle a slot that no line item filled (collapse, passback or leave empty)
+   */
+  function renderFallback(ad: AdResult, container: HTMLElement): void {
+    displayedAds.delete(ad.slot_id);
+    container.innerHTML = '';
+
+    if (ad.fallback === 'collapse') {
+      container.style.display = 'none';
+      return;
+    }
+
+    if (ad.fallback === 'passback' && (ad.passback_url || ad.passback_html)) {
+      const slotConfig = slots.get(ad.slot_id);
+      const iframe = document.createElement('iframe');
+      if (ad.passback_url) {
+        iframe.src = ad.passback_url;
+      } else if (ad.passback_html) {
+        iframe.srcdoc = ad.passback_html;
+      }
+      iframe.setAttribute('frameborder', '0');
+      iframe.setAttribute('scrolling', 'no');
+      iframe.style.cssText = `
+        width: ${slotConfig?.width ? `${slotConfig.width}px` : '100%'};
+        height: ${slotConfig?.height ? `${slotConfig.height}px` : 'auto'};
+        border: none;
+        display: block;
+      `;
+      container.appendChild(iframe);
+    }
+  }
+
   /**
    * Fire a tracking pixel
    */
@@ -346,6 +396,7 @@ const MIMSAds = (function() {
    */
   async function refresh(): Promise<void> {
     destroyAll();
+    pageViewId = generatePageViewId();
     await display();
   }
 